@@ -0,0 +1,30 @@
+package common
+
+import "context"
+
+type batchLineContextKey struct{}
+
+// BatchLine identifies one line of a batch executed in-process by the batch worker.
+// It only travels through the request context, so it cannot be forged by clients.
+type BatchLine struct {
+	BatchId  string
+	CustomId string
+	TokenId  int
+	// ChannelId and UpstreamResponse are set when the line was already executed by the
+	// channel's native Batch API; the relay then only settles billing for the given response.
+	ChannelId        int
+	UpstreamResponse []byte
+}
+
+func WithBatchLine(ctx context.Context, line *BatchLine) context.Context {
+	return context.WithValue(ctx, batchLineContextKey{}, line)
+}
+
+// GetBatchLine returns the batch line of an in-process batch request, or nil for normal requests.
+func GetBatchLine(ctx context.Context) *BatchLine {
+	if ctx == nil {
+		return nil
+	}
+	line, _ := ctx.Value(batchLineContextKey{}).(*BatchLine)
+	return line
+}
@@ -15,6 +15,9 @@ type DiskCacheType string
 const (
 	DiskCacheTypeBody DiskCacheType = "body" // 请求体缓存
 	DiskCacheTypeFile DiskCacheType = "file" // 文件数据缓存
+
+	DiskCacheTypePayload   DiskCacheType = "payload"    // 请求/响应载荷留存，保存在独立子目录
+	DiskCacheTypeLogExport DiskCacheType = "log_export" // 日志导出文件，保存在独立子目录
 )
 
 // 统一的缓存目录名
@@ -79,6 +82,70 @@ func WriteDiskCacheFile(cacheType DiskCacheType, data []byte) (string, error) {
 	return filePath, nil
 }
 
+// GetPersistentDiskCacheDir 获取持久化缓存子目录，子目录中的文件不参与临时文件的定时清理
+func GetPersistentDiskCacheDir(cacheType DiskCacheType) string {
+	return filepath.Join(GetDiskCacheDir(), string(cacheType))
+}
+
+// WritePersistentDiskCacheFile 写入持久化缓存文件，由调用方负责过期删除
+func WritePersistentDiskCacheFile(cacheType DiskCacheType, data []byte) (string, error) {
+	dir := GetPersistentDiskCacheDir(cacheType)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	filename := fmt.Sprintf("%s-%s-%d", cacheType, uuid.New().String()[:8], time.Now().UnixNano())
+	filePath := filepath.Join(dir, filename)
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
+		os.Remove(filePath)
+		return "", fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return filePath, nil
+}
+
+// CreatePersistentDiskCacheFile 创建持久化缓存文件用于流式写入，ext 为文件扩展名（如 .csv）
+func CreatePersistentDiskCacheFile(cacheType DiskCacheType, ext string) (string, *os.File, error) {
+	dir := GetPersistentDiskCacheDir(cacheType)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	filename := fmt.Sprintf("%s-%s-%d%s", cacheType, uuid.New().String()[:8], time.Now().UnixNano(), ext)
+	filePath := filepath.Join(dir, filename)
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create cache file: %w", err)
+	}
+	return filePath, file, nil
+}
+
+// CleanupPersistentDiskCacheFiles 删除持久化缓存子目录中超过 maxAge 的文件
+func CleanupPersistentDiskCacheFiles(cacheType DiskCacheType, maxAge time.Duration) (int, error) {
+	dir := GetPersistentDiskCacheDir(cacheType)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	removed := 0
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > maxAge {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
 // WriteDiskCacheFileString 写入字符串到磁盘缓存文件
 func WriteDiskCacheFileString(cacheType DiskCacheType, data string) (string, error) {
 	return WriteDiskCacheFile(cacheType, []byte(data))
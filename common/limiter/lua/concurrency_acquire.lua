@@ -0,0 +1,17 @@
+-- 并发数限制：未达上限时占用一个并发名额
+-- KEYS[1]: 并发计数 key
+-- ARGV[1]: 最大并发数
+-- ARGV[2]: key 过期时间（秒），防止进程异常退出导致计数泄漏
+
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+
+local current = tonumber(redis.call('GET', key) or '0')
+if current >= limit then
+    return -1
+end
+
+current = redis.call('INCR', key)
+redis.call('EXPIRE', key, ttl)
+return current
@@ -0,0 +1,190 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/go-redis/redis/v8"
+)
+
+//go:embed lua/concurrency_acquire.lua
+var concurrencyAcquireScript string
+
+var concurrencyAcquire = redis.NewScript(concurrencyAcquireScript)
+
+const (
+	// concurrencyKeyTTL bounds how long a leaked concurrency slot (e.g. after a crash) survives in Redis
+	concurrencyKeyTTL = 10 * time.Minute
+	tpmWindow         = time.Minute
+)
+
+// UsageLimiter 并发数与每分钟 token 数（TPM）计数器，启用 Redis 时跨实例共享，否则仅在本进程内生效。
+//
+// TPM 使用滑动窗口计数：当前分钟的用量加上上一分钟用量按剩余比例折算。
+type UsageLimiter struct {
+	mu          sync.Mutex
+	concurrency map[string]int64
+	tpm         map[string]*tpmCounter
+	lastSweep   time.Time
+}
+
+type tpmCounter struct {
+	minute   int64
+	current  int64
+	previous int64
+}
+
+var usageLimiter = &UsageLimiter{
+	concurrency: make(map[string]int64),
+	tpm:         make(map[string]*tpmCounter),
+}
+
+func GetUsageLimiter() *UsageLimiter {
+	return usageLimiter
+}
+
+// AcquireConcurrency 尝试占用一个并发名额，返回是否成功以及占用后的并发数
+func (l *UsageLimiter) AcquireConcurrency(ctx context.Context, key string, limit int) (bool, int64, error) {
+	if limit <= 0 {
+		return true, 0, nil
+	}
+	if common.RedisEnabled {
+		return l.redisAcquireConcurrency(ctx, key, limit)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	current := l.concurrency[key]
+	if current >= int64(limit) {
+		return false, current, nil
+	}
+	l.concurrency[key] = current + 1
+	return true, current + 1, nil
+}
+
+// ReleaseConcurrency 释放 AcquireConcurrency 占用的名额
+func (l *UsageLimiter) ReleaseConcurrency(ctx context.Context, key string) error {
+	if common.RedisEnabled {
+		remain, err := common.RDB.Decr(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if remain <= 0 {
+			return common.RDB.Del(ctx, key).Err()
+		}
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.concurrency[key] <= 1 {
+		delete(l.concurrency, key)
+	} else {
+		l.concurrency[key]--
+	}
+	return nil
+}
+
+func (l *UsageLimiter) redisAcquireConcurrency(ctx context.Context, key string, limit int) (bool, int64, error) {
+	result, err := concurrencyAcquire.Run(ctx, common.RDB, []string{key}, limit, int64(concurrencyKeyTTL.Seconds())).Int64()
+	if err != nil {
+		return false, 0, fmt.Errorf("concurrency limit failed: %w", err)
+	}
+	if result < 0 {
+		return false, int64(limit), nil
+	}
+	return true, result, nil
+}
+
+// TPMUsage 返回滑动窗口内已使用的 token 数，以及当前分钟窗口结束前的剩余时间
+func (l *UsageLimiter) TPMUsage(ctx context.Context, key string) (int64, time.Duration, error) {
+	now := time.Now()
+	minute := now.Unix() / 60
+	elapsed := now.Sub(time.Unix(minute*60, 0))
+	reset := tpmWindow - elapsed
+	var current, previous int64
+	if common.RedisEnabled {
+		values, err := common.RDB.MGet(ctx, tpmRedisKey(key, minute), tpmRedisKey(key, minute-1)).Result()
+		if err != nil {
+			return 0, reset, err
+		}
+		current = parseRedisInt(values[0])
+		previous = parseRedisInt(values[1])
+	} else {
+		l.mu.Lock()
+		if counter, ok := l.tpm[key]; ok {
+			current, previous = counter.at(minute)
+		}
+		l.mu.Unlock()
+	}
+	weight := 1 - elapsed.Seconds()/tpmWindow.Seconds()
+	return current + int64(float64(previous)*weight), reset, nil
+}
+
+// AddTPMUsage 记录一次请求实际消耗的 token 数
+func (l *UsageLimiter) AddTPMUsage(ctx context.Context, key string, tokens int64) error {
+	if tokens <= 0 {
+		return nil
+	}
+	now := time.Now()
+	minute := now.Unix() / 60
+	if common.RedisEnabled {
+		redisKey := tpmRedisKey(key, minute)
+		pipe := common.RDB.TxPipeline()
+		pipe.IncrBy(ctx, redisKey, tokens)
+		pipe.Expire(ctx, redisKey, 2*tpmWindow+time.Second)
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	counter, ok := l.tpm[key]
+	if !ok {
+		counter = &tpmCounter{minute: minute}
+		l.tpm[key] = counter
+	}
+	counter.current, counter.previous = counter.at(minute)
+	counter.minute = minute
+	counter.current += tokens
+	l.sweepLocked(now, minute)
+	return nil
+}
+
+// at returns the usage of the given minute and the minute before it.
+func (c *tpmCounter) at(minute int64) (int64, int64) {
+	switch minute - c.minute {
+	case 0:
+		return c.current, c.previous
+	case 1:
+		return 0, c.current
+	}
+	return 0, 0
+}
+
+func (l *UsageLimiter) sweepLocked(now time.Time, minute int64) {
+	if now.Sub(l.lastSweep) < tpmWindow {
+		return
+	}
+	l.lastSweep = now
+	for key, counter := range l.tpm {
+		if minute-counter.minute > 1 {
+			delete(l.tpm, key)
+		}
+	}
+}
+
+func tpmRedisKey(key string, minute int64) string {
+	return key + ":" + strconv.FormatInt(minute, 10)
+}
+
+func parseRedisInt(value interface{}) int64 {
+	s, ok := value.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
@@ -0,0 +1,74 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUsageLimiter(t *testing.T) *UsageLimiter {
+	t.Helper()
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() { common.RedisEnabled = redisEnabled })
+	return &UsageLimiter{
+		concurrency: make(map[string]int64),
+		tpm:         make(map[string]*tpmCounter),
+	}
+}
+
+func TestUsageLimiterConcurrencyMemory(t *testing.T) {
+	ctx := context.Background()
+	l := newTestUsageLimiter(t)
+
+	ok, current, err := l.AcquireConcurrency(ctx, "c", 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.EqualValues(t, 1, current)
+	ok, _, _ = l.AcquireConcurrency(ctx, "c", 2)
+	require.True(t, ok)
+	ok, _, _ = l.AcquireConcurrency(ctx, "c", 2)
+	require.False(t, ok, "third request exceeds the limit")
+
+	require.NoError(t, l.ReleaseConcurrency(ctx, "c"))
+	ok, _, _ = l.AcquireConcurrency(ctx, "c", 2)
+	require.True(t, ok)
+
+	require.NoError(t, l.ReleaseConcurrency(ctx, "c"))
+	require.NoError(t, l.ReleaseConcurrency(ctx, "c"))
+	require.NotContains(t, l.concurrency, "c", "released keys are dropped")
+}
+
+func TestUsageLimiterTPMMemory(t *testing.T) {
+	ctx := context.Background()
+	l := newTestUsageLimiter(t)
+
+	used, _, err := l.TPMUsage(ctx, "t")
+	require.NoError(t, err)
+	require.Zero(t, used)
+
+	require.NoError(t, l.AddTPMUsage(ctx, "t", 300))
+	require.NoError(t, l.AddTPMUsage(ctx, "t", 200))
+	used, reset, err := l.TPMUsage(ctx, "t")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, used, int64(500))
+	require.Positive(t, reset)
+}
+
+func TestTPMCounterSlidesWindow(t *testing.T) {
+	counter := &tpmCounter{minute: 10, current: 100, previous: 50}
+
+	current, previous := counter.at(10)
+	require.EqualValues(t, 100, current)
+	require.EqualValues(t, 50, previous)
+
+	current, previous = counter.at(11)
+	require.Zero(t, current)
+	require.EqualValues(t, 100, previous)
+
+	current, previous = counter.at(13)
+	require.Zero(t, current)
+	require.Zero(t, previous)
+}
@@ -0,0 +1,251 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 上游密钥使用信封加密存储：每个值随机生成数据密钥（DEK）并用 AES-256-GCM 加密，
+// DEK 再由主密钥（KEK）加密后与密文一起保存。更换主密钥时只需重新加密 DEK。
+//
+// 存储格式：enc:v1:<主密钥 ID>:<加密后的 DEK>:<密文>，不带前缀的值视为旧版本的明文。
+
+const secretCiphertextPrefix = "enc:v1:"
+
+type secretMasterKey struct {
+	id  string
+	kek []byte
+}
+
+var (
+	secretMasterKeysLock sync.RWMutex
+	// secretPrimaryKey 用于加密新值，为 nil 时不加密
+	secretPrimaryKey *secretMasterKey
+	// secretMasterKeys 按 ID 索引所有可用于解密的主密钥，包括轮换前的旧密钥
+	secretMasterKeys = map[string]*secretMasterKey{}
+)
+
+func newSecretMasterKey(material string) *secretMasterKey {
+	kek := sha256.Sum256([]byte(material))
+	id := sha256.Sum256(kek[:])
+	return &secretMasterKey{id: hex.EncodeToString(id[:4]), kek: kek[:]}
+}
+
+// InitSecretMasterKeys 从环境变量加载主密钥：
+// CHANNEL_KEY_MASTER_KEY 或 CHANNEL_KEY_MASTER_KEY_FILE 指定当前主密钥，
+// CHANNEL_KEY_PREVIOUS_MASTER_KEYS 以逗号分隔列出轮换前的旧主密钥，仅用于解密。
+func InitSecretMasterKeys() error {
+	primary := strings.TrimSpace(os.Getenv("CHANNEL_KEY_MASTER_KEY"))
+	if path := strings.TrimSpace(os.Getenv("CHANNEL_KEY_MASTER_KEY_FILE")); primary == "" && path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read channel key master key file: %w", err)
+		}
+		primary = strings.TrimSpace(string(content))
+	}
+	var previous []string
+	for _, material := range strings.Split(os.Getenv("CHANNEL_KEY_PREVIOUS_MASTER_KEYS"), ",") {
+		if material = strings.TrimSpace(material); material != "" {
+			previous = append(previous, material)
+		}
+	}
+	SetSecretMasterKeys(primary, previous...)
+	return nil
+}
+
+// SetSecretMasterKeys 设置当前主密钥与旧主密钥，primary 为空时新值以明文保存
+func SetSecretMasterKeys(primary string, previous ...string) {
+	secretMasterKeysLock.Lock()
+	defer secretMasterKeysLock.Unlock()
+	secretPrimaryKey = nil
+	secretMasterKeys = map[string]*secretMasterKey{}
+	for _, material := range previous {
+		key := newSecretMasterKey(material)
+		secretMasterKeys[key.id] = key
+	}
+	if primary != "" {
+		secretPrimaryKey = newSecretMasterKey(primary)
+		secretMasterKeys[secretPrimaryKey.id] = secretPrimaryKey
+	}
+}
+
+// SecretEncryptionEnabled 是否配置了当前主密钥
+func SecretEncryptionEnabled() bool {
+	secretMasterKeysLock.RLock()
+	defer secretMasterKeysLock.RUnlock()
+	return secretPrimaryKey != nil
+}
+
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretCiphertextPrefix)
+}
+
+func sealWithKey(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openWithKey(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+type encryptedSecret struct {
+	keyId      string
+	wrappedDEK []byte
+	ciphertext []byte
+}
+
+func parseEncryptedSecret(value string) (*encryptedSecret, error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretCiphertextPrefix), ":")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed encrypted secret")
+	}
+	wrappedDEK, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted secret: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted secret: %w", err)
+	}
+	return &encryptedSecret{keyId: parts[0], wrappedDEK: wrappedDEK, ciphertext: ciphertext}, nil
+}
+
+func (s *encryptedSecret) String() string {
+	return secretCiphertextPrefix + s.keyId + ":" +
+		base64.RawStdEncoding.EncodeToString(s.wrappedDEK) + ":" +
+		base64.RawStdEncoding.EncodeToString(s.ciphertext)
+}
+
+// unwrapDEK 使用对应的主密钥解出数据密钥
+func (s *encryptedSecret) unwrapDEK() ([]byte, error) {
+	secretMasterKeysLock.RLock()
+	key, ok := secretMasterKeys[s.keyId]
+	secretMasterKeysLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("master key %s is not configured", s.keyId)
+	}
+	dek, err := openWithKey(key.kek, s.wrappedDEK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with master key %s: %w", s.keyId, err)
+	}
+	return dek, nil
+}
+
+// EncryptSecret 使用当前主密钥加密，未配置主密钥或值已加密时原样返回
+func EncryptSecret(plaintext string) (string, error) {
+	secretMasterKeysLock.RLock()
+	primary := secretPrimaryKey
+	secretMasterKeysLock.RUnlock()
+	if primary == nil || plaintext == "" || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	ciphertext, err := sealWithKey(dek, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrappedDEK, err := sealWithKey(primary.kek, dek)
+	if err != nil {
+		return "", err
+	}
+	secret := &encryptedSecret{keyId: primary.id, wrappedDEK: wrappedDEK, ciphertext: ciphertext}
+	return secret.String(), nil
+}
+
+// DecryptSecret 解密 EncryptSecret 的结果，未加密的旧值原样返回
+func DecryptSecret(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+	secret, err := parseEncryptedSecret(value)
+	if err != nil {
+		return "", err
+	}
+	dek, err := secret.unwrapDEK()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := openWithKey(dek, secret.ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// SecretNeedsRewrap 值是否需要在当前主密钥下重新加密（明文或由旧主密钥加密）
+func SecretNeedsRewrap(value string) bool {
+	secretMasterKeysLock.RLock()
+	primary := secretPrimaryKey
+	secretMasterKeysLock.RUnlock()
+	if primary == nil || value == "" {
+		return false
+	}
+	if !IsEncryptedSecret(value) {
+		return true
+	}
+	return !strings.HasPrefix(value, secretCiphertextPrefix+primary.id+":")
+}
+
+// RewrapSecret 将值转换为当前主密钥加密的形式。已加密的值只重新加密数据密钥，密文保持不变。
+func RewrapSecret(value string) (string, error) {
+	if !SecretNeedsRewrap(value) {
+		return value, nil
+	}
+	if !IsEncryptedSecret(value) {
+		return EncryptSecret(value)
+	}
+	secret, err := parseEncryptedSecret(value)
+	if err != nil {
+		return "", err
+	}
+	dek, err := secret.unwrapDEK()
+	if err != nil {
+		return "", err
+	}
+	secretMasterKeysLock.RLock()
+	primary := secretPrimaryKey
+	secretMasterKeysLock.RUnlock()
+	wrappedDEK, err := sealWithKey(primary.kek, dek)
+	if err != nil {
+		return "", err
+	}
+	secret.keyId = primary.id
+	secret.wrappedDEK = wrappedDEK
+	return secret.String(), nil
+}
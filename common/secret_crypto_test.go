@@ -0,0 +1,61 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretEncryptionRoundTrip(t *testing.T) {
+	SetSecretMasterKeys("master-key-a")
+	t.Cleanup(func() { SetSecretMasterKeys("") })
+
+	encrypted, err := EncryptSecret("sk-upstream")
+	require.NoError(t, err)
+	assert.True(t, IsEncryptedSecret(encrypted))
+	assert.NotContains(t, encrypted, "sk-upstream")
+
+	again, err := EncryptSecret(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, encrypted, again, "already encrypted values are kept as is")
+
+	plaintext, err := DecryptSecret(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "sk-upstream", plaintext)
+
+	legacy, err := DecryptSecret("sk-legacy-plaintext")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-legacy-plaintext", legacy)
+}
+
+func TestSecretRewrapAfterMasterKeyRotation(t *testing.T) {
+	SetSecretMasterKeys("master-key-a")
+	t.Cleanup(func() { SetSecretMasterKeys("") })
+	encrypted, err := EncryptSecret("ak|sk|us-east-1")
+	require.NoError(t, err)
+
+	SetSecretMasterKeys("master-key-b", "master-key-a")
+	assert.True(t, SecretNeedsRewrap(encrypted))
+	rewrapped, err := RewrapSecret(encrypted)
+	require.NoError(t, err)
+	assert.False(t, SecretNeedsRewrap(rewrapped))
+	// 只重新加密数据密钥，密文部分不变
+	assert.Equal(t, encrypted[strings.LastIndex(encrypted, ":"):], rewrapped[strings.LastIndex(rewrapped, ":"):])
+
+	SetSecretMasterKeys("master-key-b")
+	_, err = DecryptSecret(encrypted)
+	assert.Error(t, err, "old master key is no longer available")
+	plaintext, err := DecryptSecret(rewrapped)
+	require.NoError(t, err)
+	assert.Equal(t, "ak|sk|us-east-1", plaintext)
+}
+
+func TestSecretEncryptionDisabled(t *testing.T) {
+	SetSecretMasterKeys("")
+	value, err := EncryptSecret("sk-upstream")
+	require.NoError(t, err)
+	assert.Equal(t, "sk-upstream", value)
+	assert.False(t, SecretNeedsRewrap(value))
+}
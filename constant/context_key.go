@@ -19,6 +19,14 @@ const (
 	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
 	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
 	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenTpmLimit          ContextKey = "token_tpm_limit"
+	ContextKeyTokenConcurrencyLimit  ContextKey = "token_concurrency_limit"
+	ContextKeyTokenResponseCache     ContextKey = "token_response_cache"
+	ContextKeyTokenBudget            ContextKey = "token_budget"
+	ContextKeyTokenOrgId             ContextKey = "token_org_id"
+	ContextKeyTokenPayloadCapture    ContextKey = "token_payload_capture"
+	ContextKeyTokenRestrictions      ContextKey = "token_restrictions"
+	ContextKeyTokenParamOverride     ContextKey = "token_param_override"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
@@ -51,6 +59,9 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+	ContextKeyUserBudget  ContextKey = "user_budget"
+	// ContextKeyUserCreditLimit 后付费用户的信用额度
+	ContextKeyUserCreditLimit ContextKey = "user_credit_limit"
 
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
@@ -66,4 +77,22 @@ const (
 	// ContextKeyLanguage stores the user's language preference for i18n
 	ContextKeyLanguage ContextKey = "language"
 	ContextKeyIsStream ContextKey = "is_stream"
+
+	// ContextKeyConsumedTokens stores prompt+completion tokens settled for the request, used by TPM limits
+	ContextKeyConsumedTokens ContextKey = "consumed_tokens"
+
+	// ContextKeyBatchId marks a request executed by the batch worker, used for batch discount billing
+	ContextKeyBatchId ContextKey = "batch_id"
+
+	// ContextKeyResponseCacheHit marks a request served from the response cache, used for cache hit billing
+	ContextKeyResponseCacheHit ContextKey = "response_cache_hit"
+
+	// ContextKeyVirtualModel stores the virtual model requested by the client
+	ContextKeyVirtualModel ContextKey = "virtual_model"
+	// ContextKeyVirtualModelChain stores the real models of the virtual model in fallback order
+	ContextKeyVirtualModelChain ContextKey = "virtual_model_chain"
+	// ContextKeyVirtualModelIndex stores the index of the real model currently in use
+	ContextKeyVirtualModelIndex ContextKey = "virtual_model_index"
+	// ContextKeyVirtualModelAttempts stores the real models dispatched so far, recorded in the consume log
+	ContextKeyVirtualModelAttempts ContextKey = "virtual_model_attempts"
 )
@@ -3,6 +3,13 @@ package constant
 type MultiKeyMode string
 
 const (
-	MultiKeyModeRandom  MultiKeyMode = "random"  // 随机
-	MultiKeyModePolling MultiKeyMode = "polling" // 轮询
+	MultiKeyModeRandom   MultiKeyMode = "random"   // 随机
+	MultiKeyModePolling  MultiKeyMode = "polling"  // 轮询
+	MultiKeyModeCooldown MultiKeyMode = "cooldown" // 轮询，跳过被上游限流冷却中的 key
+	MultiKeyModeHeadroom MultiKeyMode = "headroom" // 优先选择上游限流余量最多的 key，同样跳过冷却中的 key
 )
+
+// UsesRateLimitHeaders 该模式是否根据上游限流响应头调度 key
+func (m MultiKeyMode) UsesRateLimitHeaders() bool {
+	return m == MultiKeyModeCooldown || m == MultiKeyModeHeadroom
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAuditLogs(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	actorId, _ := strconv.Atoi(c.Query("actor_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	query := model.AuditLogQuery{
+		ActorId:        actorId,
+		TargetType:     c.Query("target_type"),
+		TargetId:       c.Query("target_id"),
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+	logs, total, err := model.GetAuditLogs(query, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// RollbackAuditOption 将选项恢复为审计记录中修改前的值，回滚操作本身也会记录审计日志
+func RollbackAuditOption(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	log, err := model.GetAuditLogById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	value, err := service.GetAuditOptionRollbackValue(log)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := validateOptionUpdate(log.TargetId, value); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.UpdateOption(log.TargetId, value); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"key":   log.TargetId,
+			"value": value,
+		},
+	})
+}
@@ -0,0 +1,335 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OpenAI 兼容的 Files / Batch API，批处理由 service.StartBatchWorker 异步执行
+
+func batchApiError(c *gin.Context, statusCode int, code string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
+			Type:    "invalid_request_error",
+			Code:    code,
+		},
+	})
+}
+
+func batchApiEnabled(c *gin.Context) bool {
+	if !operation_setting.GetBatchSetting().Enabled {
+		RelayNotImplemented(c)
+		return false
+	}
+	return true
+}
+
+func getListLimit(c *gin.Context, defaultLimit int, maxLimit int) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return defaultLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+func fileToDto(file *model.File) dto.OpenAIFile {
+	return dto.OpenAIFile{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+	}
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func optionalTimestamp(value int64) *int64 {
+	if value == 0 {
+		return nil
+	}
+	return &value
+}
+
+func batchToDto(batch *model.Batch) dto.OpenAIBatch {
+	result := dto.OpenAIBatch{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     optionalString(batch.OutputFileId),
+		ErrorFileId:      optionalString(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optionalTimestamp(batch.InProgressAt),
+		ExpiresAt:        optionalTimestamp(batch.ExpiresAt),
+		FinalizingAt:     optionalTimestamp(batch.FinalizingAt),
+		CompletedAt:      optionalTimestamp(batch.CompletedAt),
+		FailedAt:         optionalTimestamp(batch.FailedAt),
+		ExpiredAt:        optionalTimestamp(batch.ExpiredAt),
+		CancellingAt:     optionalTimestamp(batch.CancellingAt),
+		CancelledAt:      optionalTimestamp(batch.CancelledAt),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.TotalCount,
+			Completed: batch.CompletedCount,
+			Failed:    batch.FailedCount,
+		},
+	}
+	if batch.Errors != "" {
+		var batchErrors dto.BatchErrors
+		if err := common.UnmarshalJsonStr(batch.Errors, &batchErrors); err == nil {
+			result.Errors = &batchErrors
+		}
+	}
+	if batch.Metadata != "" {
+		_ = common.UnmarshalJsonStr(batch.Metadata, &result.Metadata)
+	}
+	return result
+}
+
+func UploadFile(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose != model.FilePurposeBatch {
+		batchApiError(c, http.StatusBadRequest, "invalid_purpose", fmt.Sprintf("Unsupported purpose %q, only %q is supported.", purpose, model.FilePurposeBatch))
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "missing_file", "The file field is required.")
+		return
+	}
+	maxBytes := int64(operation_setting.GetBatchSetting().MaxFileSizeMB) << 20
+	if maxBytes > 0 && fileHeader.Size > maxBytes {
+		batchApiError(c, http.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("The file exceeds the maximum size of %d MB.", maxBytes>>20))
+		return
+	}
+	reader, err := fileHeader.Open()
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_file", err.Error())
+		return
+	}
+	defer reader.Close()
+	file := &model.File{
+		FileId:    service.GenerateFileId(),
+		UserId:    c.GetInt("id"),
+		Purpose:   purpose,
+		Filename:  fileHeader.Filename,
+		CreatedAt: common.GetTimestamp(),
+	}
+	// 内容按块流式写入数据库，多节点均可读取，且不会整体载入内存
+	file.Bytes, err = model.SaveFileContent(file.FileId, reader)
+	if err == nil {
+		if err = file.Insert(); err != nil {
+			_ = model.DeleteFileChunks(file.FileId)
+		}
+	}
+	if err != nil {
+		common.SysLog("failed to save uploaded file: " + err.Error())
+		batchApiError(c, http.StatusInternalServerError, "internal_error", "Failed to save the file.")
+		return
+	}
+	c.JSON(http.StatusOK, fileToDto(file))
+}
+
+func ListFiles(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	limit := getListLimit(c, 100, 10000)
+	files, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"), c.Query("after"), limit+1)
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	list := dto.OpenAIList[dto.OpenAIFile]{Object: "list", Data: make([]dto.OpenAIFile, 0, len(files))}
+	if len(files) > limit {
+		files = files[:limit]
+		list.HasMore = true
+	}
+	for _, file := range files {
+		list.Data = append(list.Data, fileToDto(file))
+	}
+	if len(list.Data) > 0 {
+		list.FirstId = list.Data[0].Id
+		list.LastId = list.Data[len(list.Data)-1].Id
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func getUserFileOrAbort(c *gin.Context) *model.File {
+	file, err := model.GetUserFile(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			batchApiError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such File object: %s", c.Param("id")))
+		} else {
+			batchApiError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		}
+		return nil
+	}
+	return file
+}
+
+func GetFile(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	c.JSON(http.StatusOK, fileToDto(file))
+}
+
+func GetFileContent(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	file := getUserFileOrAbort(c)
+	if file == nil {
+		return
+	}
+	c.DataFromReader(http.StatusOK, file.Bytes, "application/octet-stream", model.OpenFileContent(file.FileId), map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", file.Filename),
+	})
+}
+
+func DeleteFile(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	fileId := c.Param("id")
+	deleted, err := model.DeleteUserFile(c.GetInt("id"), fileId)
+	if err != nil {
+		batchApiError(c, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	if !deleted {
+		batchApiError(c, http.StatusNotFound, "file_not_found", fmt.Sprintf("No such File object: %s", fileId))
+		return
+	}
+	c.JSON(http.StatusOK, dto.OpenAIFileDeleted{Id: fileId, Object: "file", Deleted: true})
+}
+
+func CreateBatch(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	var req dto.BatchCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if !service.IsBatchEndpointSupported(req.Endpoint) {
+		batchApiError(c, http.StatusBadRequest, "invalid_endpoint", fmt.Sprintf("Unsupported endpoint %q.", req.Endpoint))
+		return
+	}
+	if req.CompletionWindow != service.BatchCompletionWindow {
+		batchApiError(c, http.StatusBadRequest, "invalid_completion_window", fmt.Sprintf("completion_window must be %q.", service.BatchCompletionWindow))
+		return
+	}
+	inputFile, err := model.GetUserFile(c.GetInt("id"), req.InputFileId)
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_input_file", fmt.Sprintf("No such File object: %s", req.InputFileId))
+		return
+	}
+	if inputFile.Purpose != model.FilePurposeBatch {
+		batchApiError(c, http.StatusBadRequest, "invalid_input_file", fmt.Sprintf("The input file must have purpose %q.", model.FilePurposeBatch))
+		return
+	}
+	batch, err := service.NewBatch(c.GetInt("id"), c.GetInt("token_id"), &req)
+	if err != nil {
+		common.SysLog("failed to create batch: " + err.Error())
+		batchApiError(c, http.StatusInternalServerError, "internal_error", "Failed to create the batch.")
+		return
+	}
+	c.JSON(http.StatusOK, batchToDto(batch))
+}
+
+func getUserBatchOrAbort(c *gin.Context, batch *model.Batch, err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		batchApiError(c, http.StatusNotFound, "batch_not_found", fmt.Sprintf("No such Batch object: %s", c.Param("id")))
+	} else {
+		batchApiError(c, http.StatusInternalServerError, "internal_error", err.Error())
+	}
+	return false
+}
+
+func GetBatch(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	batch, err := model.GetUserBatch(c.GetInt("id"), c.Param("id"))
+	if !getUserBatchOrAbort(c, batch, err) {
+		return
+	}
+	c.JSON(http.StatusOK, batchToDto(batch))
+}
+
+func CancelBatch(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	batch, err := model.CancelUserBatch(c.GetInt("id"), c.Param("id"))
+	if !getUserBatchOrAbort(c, batch, err) {
+		return
+	}
+	if batch.IsFinished() {
+		batchApiError(c, http.StatusConflict, "batch_not_cancellable", fmt.Sprintf("Cannot cancel a batch with status %s.", batch.Status))
+		return
+	}
+	c.JSON(http.StatusOK, batchToDto(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	if !batchApiEnabled(c) {
+		return
+	}
+	limit := getListLimit(c, 20, 100)
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit+1)
+	if err != nil {
+		batchApiError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	list := dto.OpenAIList[dto.OpenAIBatch]{Object: "list", Data: make([]dto.OpenAIBatch, 0, len(batches))}
+	if len(batches) > limit {
+		batches = batches[:limit]
+		list.HasMore = true
+	}
+	for _, batch := range batches {
+		list.Data = append(list.Data, batchToDto(batch))
+	}
+	if len(list.Data) > 0 {
+		list.FirstId = list.Data[0].Id
+		list.LastId = list.Data[len(list.Data)-1].Id
+	}
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/relay"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BillingReplayRequest struct {
+	Model          string `json:"model"`
+	Expr           string `json:"expr"`
+	StartTimestamp int64  `json:"start_timestamp"`
+	EndTimestamp   int64  `json:"end_timestamp"`
+	Limit          int    `json:"limit"`
+}
+
+// SimulateBilling 按当前（或拟修改的）计费配置计算一次请求的价格明细，不扣费
+func SimulateBilling(c *gin.Context) {
+	var req relay.BillingSimulationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	result, err := relay.SimulateTextBilling(c, &req)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
+
+// ReplayBilling 用拟修改的表达式重算模型的历史消费日志，返回收入变化
+func ReplayBilling(c *gin.Context) {
+	var req BillingReplayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	result, err := service.ReplayConsumeLogs(req.Model, req.Expr, req.StartTimestamp, req.EndTimestamp, req.Limit)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/channel/gemini"
 	"github.com/QuantumNous/new-api/relay/channel/ollama"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/billing_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -245,6 +246,22 @@ func FixChannelsAbilities(c *gin.Context) {
 	})
 }
 
+// ReencryptChannelKeys 使用当前主密钥重新加密所有渠道密钥，用于轮换主密钥或加密存量明文密钥
+func ReencryptChannelKeys(c *gin.Context) {
+	updated, err := model.ReencryptChannelKeys()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"updated": updated,
+		},
+	})
+}
+
 func SearchChannels(c *gin.Context) {
 	keyword := c.Query("keyword")
 	group := c.Query("group")
@@ -438,6 +455,17 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 	if err := channel.ValidateSettings(); err != nil {
 		return fmt.Errorf("渠道额外设置[channel setting] 格式错误：%s", err.Error())
 	}
+	if channel.OtherSettings != "" {
+		otherSettings := dto.ChannelOtherSettings{}
+		if err := common.UnmarshalJsonStr(channel.OtherSettings, &otherSettings); err != nil {
+			return fmt.Errorf("渠道其他设置[settings] 格式错误：%s", err.Error())
+		}
+		if otherSettings.UpstreamCostExpr != "" {
+			if err := billing_setting.SmokeTestExpr(otherSettings.UpstreamCostExpr); err != nil {
+				return fmt.Errorf("上游成本表达式错误：%s", err.Error())
+			}
+		}
+	}
 
 	// 如果是添加操作，检查 channel 和 key 是否为空
 	if isAdd {
@@ -1231,11 +1259,13 @@ type MultiKeyStatusResponse struct {
 }
 
 type KeyStatus struct {
-	Index        int    `json:"index"`
-	Status       int    `json:"status"` // 1: enabled, 2: disabled
-	DisabledTime int64  `json:"disabled_time,omitempty"`
-	Reason       string `json:"reason,omitempty"`
-	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	Index         int      `json:"index"`
+	Status        int      `json:"status"` // 1: enabled, 2: disabled
+	DisabledTime  int64    `json:"disabled_time,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+	KeyPreview    string   `json:"key_preview"`              // first 10 chars of key for identification
+	CooldownUntil int64    `json:"cooldown_until,omitempty"` // upstream rate-limit cooldown, key is re-enabled automatically afterwards
+	Headroom      *float64 `json:"headroom,omitempty"`       // remaining rate-limit ratio reported by the last upstream response
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1323,13 +1353,20 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
-			allKeyStatusList = append(allKeyStatusList, KeyStatus{
+			keyStatus := KeyStatus{
 				Index:        i,
 				Status:       status,
 				DisabledTime: disabledTime,
 				Reason:       reason,
 				KeyPreview:   keyPreview,
-			})
+			}
+			if until := channel.ChannelInfo.MultiKeyCooldownUntil[i]; until > common.GetTimestamp() {
+				keyStatus.CooldownUntil = until
+			}
+			if headroom, ok := channel.ChannelInfo.MultiKeyHeadroom[i]; ok {
+				keyStatus.Headroom = &headroom
+			}
+			allKeyStatusList = append(allKeyStatusList, keyStatus)
 		}
 
 		// Apply status filter if specified
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+)
+
+func GetChannelBreakerStates(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	common.ApiSuccess(c, gin.H{
+		"setting":  operation_setting.GetChannelBreakerSetting(),
+		"breakers": service.GetChannelBreakerSnapshots(channelId),
+	})
+}
+
+func ResetChannelBreaker(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || channelId <= 0 {
+		common.ApiError(c, fmt.Errorf("invalid channel id: %s", c.Param("id")))
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"removed": service.ResetChannelBreaker(channelId),
+	})
+}
+
+func GetChannelBalanceStats(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	common.ApiSuccess(c, gin.H{
+		"strategies": setting.GroupChannelBalance2JsonString(),
+		"channels":   service.GetChannelBalanceSnapshots(channelId),
+	})
+}
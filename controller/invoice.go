@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminGenerateInvoicesRequest struct {
+	Year   int `json:"year"`
+	Month  int `json:"month"`
+	UserId int `json:"user_id"`
+}
+
+func getInvoiceWithItems(c *gin.Context, userId int) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	invoice, items, err := model.GetInvoiceWithItems(id, userId)
+	if err != nil {
+		if errors.Is(err, model.ErrInvoiceNotFound) {
+			common.ApiErrorMsg(c, "账单不存在")
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"invoice": invoice, "items": items})
+}
+
+// GetSelfInvoices 当前用户的账单列表
+func GetSelfInvoices(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	invoices, total, err := model.GetInvoices(c.GetInt("id"), c.Query("status"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(invoices)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetSelfInvoice 当前用户的账单明细
+func GetSelfInvoice(c *gin.Context) {
+	getInvoiceWithItems(c, c.GetInt("id"))
+}
+
+// AdminListInvoices 管理员查询账单，可按用户与状态过滤
+func AdminListInvoices(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	invoices, total, err := model.GetInvoices(userId, c.Query("status"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(invoices)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// AdminGetInvoice 管理员查询账单明细
+func AdminGetInvoice(c *gin.Context) {
+	getInvoiceWithItems(c, 0)
+}
+
+// AdminGenerateInvoices 手动生成指定月份的账单，user_id 为空时为所有后付费用户生成
+func AdminGenerateInvoices(c *gin.Context) {
+	var req AdminGenerateInvoicesRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Month < 1 || req.Month > 12 || req.Year < 2000 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	periodStart, periodEnd := service.InvoicePeriod(req.Year, time.Month(req.Month))
+	if periodEnd.After(time.Now()) {
+		common.ApiErrorMsg(c, "账期尚未结束")
+		return
+	}
+	var userIds []int
+	if req.UserId != 0 {
+		userIds = []int{req.UserId}
+	}
+	created, err := service.GenerateMonthlyInvoices(periodStart, periodEnd, userIds)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"created": created})
+}
+
+// AdminMarkInvoicePaid 标记账单已结清
+func AdminMarkInvoicePaid(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	invoice, err := model.MarkInvoicePaid(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvoiceNotFound):
+			common.ApiErrorMsg(c, "账单不存在")
+		case errors.Is(err, model.ErrInvoiceStatusInvalid):
+			common.ApiErrorMsg(c, "账单已结清")
+		default:
+			common.ApiError(c, err)
+		}
+		return
+	}
+	common.ApiSuccess(c, invoice)
+}
@@ -120,6 +120,29 @@ func GetLogsStat(c *gin.Context) {
 	return
 }
 
+// GetMarginReport 按渠道/模型/分组/天统计收入与上游成本
+func GetMarginReport(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	tzOffset, _ := strconv.Atoi(c.Query("tz_offset"))
+	dimension := c.DefaultQuery("dimension", model.MarginDimensionChannel)
+	items, err := model.GetMarginReport(model.MarginReportFilter{
+		Dimension:      dimension,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ChannelId:      channel,
+		ModelName:      c.Query("model_name"),
+		Group:          c.Query("group"),
+		TzOffset:       tzOffset,
+	})
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, items)
+}
+
 func GetLogsSelfStat(c *gin.Context) {
 	username := c.GetString("username")
 	logType, _ := strconv.Atoi(c.Query("type"))
@@ -169,3 +192,21 @@ func DeleteHistoryLogs(c *gin.Context) {
 	})
 	return
 }
+
+func GetPayloadCapture(c *gin.Context) {
+	requestId := c.Param("request_id")
+	if requestId == "" {
+		common.ApiErrorMsg(c, "request_id 不能为空")
+		return
+	}
+	captures, err := model.GetPayloadCapturesByRequestId(requestId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if len(captures) == 0 {
+		common.ApiErrorMsg(c, "未找到该请求的载荷记录")
+		return
+	}
+	common.ApiSuccess(c, captures)
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxActiveLogExportJobs 每个用户同时未完成的导出任务数上限
+const maxActiveLogExportJobs = 3
+
+type createLogExportRequest struct {
+	Format string `json:"format"`
+	model.LogQueryFilter
+}
+
+// CreateAllLogsExport 管理员导出全部用户的日志，筛选条件与 GetAllLogs 一致
+func CreateAllLogsExport(c *gin.Context) {
+	createLogExport(c, true)
+}
+
+// CreateUserLogsExport 用户导出自己的日志，筛选条件与 GetUserLogs 一致
+func CreateUserLogsExport(c *gin.Context) {
+	createLogExport(c, false)
+}
+
+func createLogExport(c *gin.Context, allUsers bool) {
+	var req createLogExportRequest
+	if err := common.DecodeJson(c.Request.Body, &req); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	if req.Format == "" {
+		req.Format = model.LogExportFormatCSV
+	}
+	userId := c.GetInt("id")
+	active, err := model.CountActiveLogExportJobs(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if active >= maxActiveLogExportJobs {
+		common.ApiErrorMsg(c, fmt.Sprintf("最多同时进行 %d 个导出任务，请等待已有任务完成", maxActiveLogExportJobs))
+		return
+	}
+	job, err := service.CreateLogExportJob(userId, allUsers, req.Format, req.LogQueryFilter)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, job)
+}
+
+func GetLogExportJobs(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	jobs, total, err := model.GetUserLogExportJobs(c.GetInt("id"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(jobs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetLogExportJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	job, err := model.GetLogExportJob(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, job)
+}
+
+// DownloadLogExport 下载导出文件，文件只存在于执行任务的节点上
+func DownloadLogExport(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	job, err := model.GetLogExportJob(id, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if job.Status != model.LogExportStatusSucceeded || job.StoragePath == "" {
+		common.ApiErrorMsg(c, "导出任务尚未完成")
+		return
+	}
+	if _, err := os.Stat(job.StoragePath); err != nil {
+		common.ApiErrorMsg(c, "导出文件已过期或不在当前节点上")
+		return
+	}
+	c.FileAttachment(job.StoragePath, fmt.Sprintf("logs-%d.%s", job.Id, job.Format))
+}
@@ -17,6 +17,7 @@ import (
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
@@ -133,6 +134,10 @@ func ListModels(c *gin.Context, modelType int) {
 			tokenModelLimit = map[string]bool{}
 		}
 		for allowModel, _ := range tokenModelLimit {
+			if chain := model_setting.GetVirtualModelChain(allowModel); len(chain) > 0 {
+				userOpenAiModels = append(userOpenAiModels, virtualOpenAIModel(allowModel, chain))
+				continue
+			}
 			if !acceptUnsetRatioModel {
 				if !helper.HasModelBillingConfig(allowModel) {
 					continue
@@ -198,6 +203,16 @@ func ListModels(c *gin.Context, modelType int) {
 				})
 			}
 		}
+		// 虚拟模型链中任一真实模型在当前分组可用时展示该虚拟模型
+		for _, virtualModel := range model_setting.GetVirtualModelNames() {
+			chain := model_setting.GetVirtualModelChain(virtualModel)
+			for _, realModel := range chain {
+				if common.StringsContains(models, realModel) {
+					userOpenAiModels = append(userOpenAiModels, virtualOpenAIModel(virtualModel, chain))
+					break
+				}
+			}
+		}
 	}
 
 	switch modelType {
@@ -238,6 +253,16 @@ func ListModels(c *gin.Context, modelType int) {
 	}
 }
 
+func virtualOpenAIModel(name string, chain []string) dto.OpenAIModels {
+	return dto.OpenAIModels{
+		Id:                     name,
+		Object:                 "model",
+		Created:                1626777600,
+		OwnedBy:                "virtual",
+		SupportedEndpointTypes: model.GetModelSupportEndpointTypes(chain[0]),
+	}
+}
+
 func ChannelListModels(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"success": true,
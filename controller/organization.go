@@ -0,0 +1,530 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/i18n"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const orgInvitationValidDays = 7
+
+// ---- Shared helpers ----
+
+// getOrgMemberContext 读取路径中的组织并校验当前用户的成员身份，requireManager 时要求 owner 或 admin
+func getOrgMemberContext(c *gin.Context, requireManager bool) (*model.Organization, *model.OrganizationMember, bool) {
+	orgId, err := strconv.Atoi(c.Param("id"))
+	if err != nil || orgId <= 0 {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return nil, nil, false
+	}
+	member, err := model.GetOrganizationMember(orgId, c.GetInt("id"))
+	if err != nil {
+		if errors.Is(err, model.ErrOrganizationMemberNotFound) {
+			common.ApiErrorI18n(c, i18n.MsgOrgNotFound)
+			return nil, nil, false
+		}
+		common.ApiError(c, err)
+		return nil, nil, false
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		if errors.Is(err, model.ErrOrganizationNotFound) {
+			common.ApiErrorI18n(c, i18n.MsgOrgNotFound)
+			return nil, nil, false
+		}
+		common.ApiError(c, err)
+		return nil, nil, false
+	}
+	if requireManager && !model.IsOrgManager(member.Role) {
+		common.ApiErrorI18n(c, i18n.MsgOrgAdminRequired)
+		return nil, nil, false
+	}
+	return org, member, true
+}
+
+// ---- User APIs ----
+
+func GetSelfOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, orgs)
+}
+
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+func CreateOrganization(c *gin.Context) {
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 64 {
+		common.ApiErrorI18n(c, i18n.MsgOrgNameInvalid)
+		return
+	}
+	org := &model.Organization{Name: name, OwnerId: c.GetInt("id")}
+	if err := model.CreateOrganization(org); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func GetOrganization(c *gin.Context) {
+	org, member, ok := getOrgMemberContext(c, false)
+	if !ok {
+		return
+	}
+	common.ApiSuccess(c, model.UserOrganization{
+		Organization: *org,
+		Role:         member.Role,
+		QuotaLimit:   member.QuotaLimit,
+		MemberUsed:   member.UsedQuota,
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	org, _, ok := getOrgMemberContext(c, true)
+	if !ok {
+		return
+	}
+	var req OrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 64 {
+		common.ApiErrorI18n(c, i18n.MsgOrgNameInvalid)
+		return
+	}
+	org.Name = name
+	if err := org.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
+
+func DeleteOrganization(c *gin.Context) {
+	org, member, ok := getOrgMemberContext(c, false)
+	if !ok {
+		return
+	}
+	if member.Role != model.OrgRoleOwner {
+		common.ApiErrorI18n(c, i18n.MsgOrgOwnerOnlyDelete)
+		return
+	}
+	if err := model.DeleteOrganization(org.Id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(member.UserId, model.LogTypeManage, fmt.Sprintf("删除组织 %s（ID %d），剩余额度 %s 退回钱包", org.Name, org.Id, logger.LogQuota(org.Quota)))
+	common.ApiSuccess(c, nil)
+}
+
+type OrganizationTransferRequest struct {
+	Quota int `json:"quota"`
+}
+
+// TransferQuotaToOrganization 管理员将个人钱包额度转入组织
+func TransferQuotaToOrganization(c *gin.Context) {
+	org, member, ok := getOrgMemberContext(c, true)
+	if !ok {
+		return
+	}
+	var req OrganizationTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Quota <= 0 {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	if err := model.TransferUserQuotaToOrganization(member.UserId, org.Id, req.Quota); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(member.UserId, model.LogTypeManage, fmt.Sprintf("向组织 %s（ID %d）转入额度 %s", org.Name, org.Id, logger.LogQuota(req.Quota)))
+	common.ApiSuccess(c, nil)
+}
+
+type OrganizationOwnerRequest struct {
+	UserId int `json:"user_id"`
+}
+
+func TransferOrganizationOwnership(c *gin.Context) {
+	org, member, ok := getOrgMemberContext(c, false)
+	if !ok {
+		return
+	}
+	if member.Role != model.OrgRoleOwner {
+		common.ApiErrorI18n(c, i18n.MsgOrgOwnerOnlyTransfer)
+		return
+	}
+	var req OrganizationOwnerRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserId <= 0 || req.UserId == member.UserId {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	if err := model.TransferOrganizationOwner(org.Id, member.UserId, req.UserId); err != nil {
+		if errors.Is(err, model.ErrOrganizationMemberNotFound) {
+			common.ApiErrorI18n(c, i18n.MsgOrgTargetNotMember)
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// ---- Members ----
+
+func GetOrganizationMembers(c *gin.Context) {
+	org, _, ok := getOrgMemberContext(c, true)
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(org.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, members)
+}
+
+type OrganizationMemberRequest struct {
+	Role       *string `json:"role"`
+	QuotaLimit *int    `json:"quota_limit"`
+}
+
+// UpdateOrganizationMember 修改成员角色或消费上限；只有 owner 可以修改角色，admin 只能管理普通成员
+func UpdateOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrgMemberContext(c, true)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	var req OrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	target, err := model.GetOrganizationMember(org.Id, userId)
+	if err != nil {
+		common.ApiErrorI18n(c, i18n.MsgOrgMemberNotFound)
+		return
+	}
+	if operator.Role != model.OrgRoleOwner && target.Role != model.OrgRoleMember {
+		common.ApiErrorI18n(c, i18n.MsgOrgAdminManageMembers)
+		return
+	}
+	if req.Role != nil && *req.Role != target.Role {
+		if operator.Role != model.OrgRoleOwner {
+			common.ApiErrorI18n(c, i18n.MsgOrgOwnerOnlyRole)
+			return
+		}
+		if target.Role == model.OrgRoleOwner || *req.Role == model.OrgRoleOwner || !model.IsValidOrgRole(*req.Role) {
+			common.ApiErrorI18n(c, i18n.MsgOrgRoleUseTransfer)
+			return
+		}
+		target.Role = *req.Role
+	}
+	if req.QuotaLimit != nil {
+		if *req.QuotaLimit < 0 {
+			common.ApiErrorI18n(c, i18n.MsgOrgMemberLimitNegative)
+			return
+		}
+		target.QuotaLimit = *req.QuotaLimit
+	}
+	if err := model.UpdateOrganizationMember(target); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, target)
+}
+
+// RemoveOrganizationMember 移除成员，成员也可以通过此接口退出组织
+func RemoveOrganizationMember(c *gin.Context) {
+	org, operator, ok := getOrgMemberContext(c, false)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	target, err := model.GetOrganizationMember(org.Id, userId)
+	if err != nil {
+		common.ApiErrorI18n(c, i18n.MsgOrgMemberNotFound)
+		return
+	}
+	if target.Role == model.OrgRoleOwner {
+		common.ApiErrorI18n(c, i18n.MsgOrgCannotRemoveOwner)
+		return
+	}
+	if target.UserId != operator.UserId {
+		if !model.IsOrgManager(operator.Role) {
+			common.ApiErrorI18n(c, i18n.MsgOrgAdminRequired)
+			return
+		}
+		if operator.Role != model.OrgRoleOwner && target.Role != model.OrgRoleMember {
+			common.ApiErrorI18n(c, i18n.MsgOrgAdminManageMembers)
+			return
+		}
+	}
+	if err := model.RemoveOrganizationMember(org.Id, target.UserId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// ---- Invitations ----
+
+func GetOrganizationInvitations(c *gin.Context) {
+	org, _, ok := getOrgMemberContext(c, true)
+	if !ok {
+		return
+	}
+	invitations, err := model.GetOrganizationInvitations(org.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, invitations)
+}
+
+type OrganizationInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func CreateOrganizationInvitation(c *gin.Context) {
+	org, operator, ok := getOrgMemberContext(c, true)
+	if !ok {
+		return
+	}
+	var req OrganizationInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if err := common.Validate.Var(req.Email, "required,email"); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgOrgInvalidEmail)
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrgRoleMember
+	}
+	if req.Role != model.OrgRoleMember && req.Role != model.OrgRoleAdmin {
+		common.ApiErrorI18n(c, i18n.MsgOrgInvalidRole)
+		return
+	}
+	if req.Role == model.OrgRoleAdmin && operator.Role != model.OrgRoleOwner {
+		common.ApiErrorI18n(c, i18n.MsgOrgOwnerOnlyInviteAdmin)
+		return
+	}
+	invitation := &model.OrganizationInvitation{
+		OrgId:     org.Id,
+		Email:     req.Email,
+		Role:      req.Role,
+		InviterId: operator.UserId,
+		ExpiresAt: time.Now().AddDate(0, 0, orgInvitationValidDays).Unix(),
+	}
+	if err := model.CreateOrganizationInvitation(invitation); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	link := fmt.Sprintf("%s/organization/invite?code=%s", system_setting.ServerAddress, invitation.Code)
+	subject := fmt.Sprintf("%s组织邀请", common.SystemName)
+	content := fmt.Sprintf("<p>您好，您被邀请加入%s上的组织 <strong>%s</strong>。</p>"+
+		"<p>登录后点击 <a href='%s'>此处</a> 接受邀请，或在组织页面输入邀请码: <strong>%s</strong></p>"+
+		"<p>邀请 %d 天内有效，如果不认识邀请人，请忽略。</p>", html.EscapeString(common.SystemName), html.EscapeString(org.Name), link, invitation.Code, orgInvitationValidDays)
+	emailSent := true
+	if err := common.SendEmail(subject, invitation.Email, content); err != nil {
+		emailSent = false
+		logger.LogError(c.Request.Context(), fmt.Sprintf("failed to send organization invitation email to %s: %s", invitation.Email, err.Error()))
+	}
+	common.ApiSuccess(c, gin.H{
+		"invitation": invitation,
+		"code":       invitation.Code,
+		"link":       link,
+		"email_sent": emailSent,
+	})
+}
+
+func RevokeOrganizationInvitation(c *gin.Context) {
+	org, _, ok := getOrgMemberContext(c, true)
+	if !ok {
+		return
+	}
+	invitationId, _ := strconv.Atoi(c.Param("invitation_id"))
+	if err := model.RevokeOrganizationInvitation(org.Id, invitationId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+type AcceptOrganizationInvitationRequest struct {
+	Code string `json:"code"`
+}
+
+func AcceptOrganizationInvitation(c *gin.Context) {
+	var req AcceptOrganizationInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	user, err := model.GetUserById(c.GetInt("id"), false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if user.Email == "" {
+		common.ApiErrorI18n(c, i18n.MsgOrgEmailRequired)
+		return
+	}
+	invitation, err := model.AcceptOrganizationInvitation(strings.TrimSpace(req.Code), user.Id, user.Email)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"org_id": invitation.OrgId, "role": invitation.Role})
+}
+
+// ---- Logs & usage ----
+
+// GetOrganizationLogs 组织管理员可查看所有成员的日志（可按 user_id 筛选），普通成员只能查看自己的日志
+func GetOrganizationLogs(c *gin.Context) {
+	org, member, ok := getOrgMemberContext(c, false)
+	if !ok {
+		return
+	}
+	userId := member.UserId
+	if model.IsOrgManager(member.Role) {
+		userId, _ = strconv.Atoi(c.Query("user_id"))
+	}
+	pageInfo := common.GetPageQuery(c)
+	logType, _ := strconv.Atoi(c.Query("type"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	tokenName := c.Query("token_name")
+	modelName := c.Query("model_name")
+	group := c.Query("group")
+	requestId := c.Query("request_id")
+	logs, total, err := model.GetOrganizationLogs(org.Id, userId, logType, startTimestamp, endTimestamp, modelName, tokenName, pageInfo.GetStartIdx(), pageInfo.GetPageSize(), group, requestId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(logs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+// GetOrganizationUsage 按成员汇总组织内的消费
+func GetOrganizationUsage(c *gin.Context) {
+	org, member, ok := getOrgMemberContext(c, false)
+	if !ok {
+		return
+	}
+	userId := member.UserId
+	if model.IsOrgManager(member.Role) {
+		userId, _ = strconv.Atoi(c.Query("user_id"))
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	usages, err := model.GetOrganizationUsage(org.Id, userId, startTimestamp, endTimestamp)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"quota":      org.Quota,
+		"used_quota": org.UsedQuota,
+		"members":    usages,
+	})
+}
+
+// ---- Admin APIs ----
+
+func AdminListOrganizations(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	orgs, total, err := model.GetAllOrganizations(pageInfo.GetStartIdx(), pageInfo.GetPageSize(), c.Query("keyword"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(orgs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+type AdminOrganizationQuotaRequest struct {
+	Delta int `json:"delta"`
+}
+
+// AdminAdjustOrganizationQuota 管理员增减组织额度，delta 为负数时扣减
+func AdminAdjustOrganizationQuota(c *gin.Context) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	var req AdminOrganizationQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Delta == 0 {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.AdminAdjustOrganizationQuota(org.Id, req.Delta); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLogWithAdminInfo(org.OwnerId, model.LogTypeManage,
+		fmt.Sprintf("管理员调整组织 %s（ID %d）额度 %s", org.Name, org.Id, logger.LogQuota(req.Delta)),
+		map[string]interface{}{
+			"admin_id":       c.GetInt("id"),
+			"admin_username": c.GetString("username"),
+		})
+	common.ApiSuccess(c, nil)
+}
+
+type AdminOrganizationStatusRequest struct {
+	Status int `json:"status"`
+}
+
+func AdminUpdateOrganizationStatus(c *gin.Context) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	var req AdminOrganizationStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil ||
+		(req.Status != model.OrganizationStatusEnabled && req.Status != model.OrganizationStatusDisabled) {
+		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
+		return
+	}
+	org, err := model.GetOrganizationById(orgId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	org.Status = req.Status
+	if err := org.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, org)
+}
@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/payment"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type PaymentTopUpRequest struct {
+	Amount        int64  `json:"amount"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+type PaymentSubscriptionRequest struct {
+	PlanId        int    `json:"plan_id"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+type AdminRefundTopUpRequest struct {
+	TradeNo string `json:"trade_no"`
+}
+
+func paymentNotifyURL(provider payment.Provider) string {
+	return service.GetCallbackAddress() + "/api/payment/" + provider.Name() + "/webhook"
+}
+
+func writePaymentWebhookResponse(c *gin.Context, provider payment.Provider, err error) {
+	if responder, ok := provider.(payment.WebhookResponder); ok {
+		responder.WriteWebhookResponse(c.Writer, err)
+		return
+	}
+	status, body := provider.WebhookResponse(err)
+	c.Data(status, "text/plain; charset=utf-8", []byte(body))
+}
+
+// PaymentWebhook 已注册支付网关的统一回调入口
+func PaymentWebhook(c *gin.Context) {
+	provider := payment.GetProvider(c.Param("provider"))
+	if provider == nil {
+		c.Data(http.StatusNotFound, "text/plain; charset=utf-8", []byte("fail"))
+		return
+	}
+	handlePaymentWebhook(c, provider)
+}
+
+func handlePaymentWebhook(c *gin.Context, provider payment.Provider) {
+	ctx := c.Request.Context()
+	if !provider.IsEnabled() {
+		logger.LogWarn(ctx, fmt.Sprintf("支付回调被拒绝 reason=provider_disabled provider=%s path=%q client_ip=%s", provider.Name(), c.Request.RequestURI, c.ClientIP()))
+		writePaymentWebhookResponse(c, provider, payment.ErrProviderDisabled)
+		return
+	}
+	event, err := provider.VerifyWebhook(c.Request)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("支付回调验签失败 provider=%s path=%q client_ip=%s error=%q", provider.Name(), c.Request.RequestURI, c.ClientIP(), err.Error()))
+		writePaymentWebhookResponse(c, provider, err)
+		return
+	}
+	if event == nil {
+		logger.LogInfo(ctx, fmt.Sprintf("支付回调忽略事件 provider=%s client_ip=%s", provider.Name(), c.ClientIP()))
+		writePaymentWebhookResponse(c, provider, nil)
+		return
+	}
+	if err := payment.HandleEvent(provider, event, c.ClientIP()); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("支付回调处理失败 provider=%s event=%s trade_no=%s client_ip=%s error=%q", provider.Name(), event.Type, event.TradeNo, c.ClientIP(), err.Error()))
+		writePaymentWebhookResponse(c, provider, err)
+		return
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("支付回调处理成功 provider=%s event=%s trade_no=%s payment_method=%s client_ip=%s", provider.Name(), event.Type, event.TradeNo, event.PaymentMethod, c.ClientIP()))
+	writePaymentWebhookResponse(c, provider, nil)
+}
+
+// RequestPaymentCheckout 通过已注册的支付网关发起充值
+func RequestPaymentCheckout(c *gin.Context) {
+	var req PaymentTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	requestTopUpCheckout(c, payment.GetCheckoutProvider(c.Param("provider")), req.Amount, req.PaymentMethod)
+}
+
+func requestTopUpCheckout(c *gin.Context, provider payment.Provider, requestAmount int64, paymentMethod string) {
+	if provider == nil || !provider.IsEnabled() {
+		c.JSON(http.StatusOK, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
+	if requestAmount < getMinTopup() {
+		c.JSON(http.StatusOK, gin.H{"message": "error", "data": fmt.Sprintf("充值数量不能小于 %d", getMinTopup())})
+		return
+	}
+
+	id := c.GetInt("id")
+	group, err := model.GetUserGroup(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "error", "data": "获取用户分组失败"})
+		return
+	}
+	payMoney := getPayMoney(requestAmount, group)
+	if payMoney < 0.01 {
+		c.JSON(http.StatusOK, gin.H{"message": "error", "data": "充值金额过低"})
+		return
+	}
+
+	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
+	tradeNo = fmt.Sprintf("USR%dNO%s", id, tradeNo)
+	amount := requestAmount
+	if operation_setting.GetQuotaDisplayType() == operation_setting.QuotaDisplayTypeTokens {
+		dAmount := decimal.NewFromInt(amount)
+		dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+		amount = dAmount.Div(dQuotaPerUnit).IntPart()
+	}
+	topUp := &model.TopUp{
+		UserId:          id,
+		Amount:          amount,
+		Money:           payMoney,
+		TradeNo:         tradeNo,
+		PaymentMethod:   paymentMethod,
+		PaymentProvider: provider.Name(),
+		CreateTime:      time.Now().Unix(),
+		Status:          common.TopUpStatusPending,
+	}
+	if err := topUp.Insert(); err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("创建充值订单失败 provider=%s user_id=%d trade_no=%s payment_method=%s amount=%d error=%q", provider.Name(), id, tradeNo, paymentMethod, requestAmount, err.Error()))
+		c.JSON(http.StatusOK, gin.H{"message": "error", "data": "创建订单失败"})
+		return
+	}
+
+	checkout, err := provider.CreateCheckout(c.Request.Context(), &payment.CheckoutRequest{
+		Kind:          payment.OrderKindTopUp,
+		TradeNo:       tradeNo,
+		UserId:        id,
+		Title:         fmt.Sprintf("TUC%d", requestAmount),
+		Money:         payMoney,
+		PaymentMethod: paymentMethod,
+		NotifyURL:     paymentNotifyURL(provider),
+		ReturnURL:     system_setting.ServerAddress + "/console/log",
+	})
+	if err != nil {
+		_ = model.UpdatePendingTopUpStatus(tradeNo, provider.Name(), common.TopUpStatusFailed)
+		if errors.Is(err, payment.ErrPaymentMethodUnsupported) {
+			c.JSON(http.StatusOK, gin.H{"message": "error", "data": "支付方式不存在"})
+			return
+		}
+		logger.LogError(c.Request.Context(), fmt.Sprintf("拉起支付失败 provider=%s user_id=%d trade_no=%s payment_method=%s amount=%d error=%q", provider.Name(), id, tradeNo, paymentMethod, requestAmount, err.Error()))
+		c.JSON(http.StatusOK, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+	logger.LogInfo(c.Request.Context(), fmt.Sprintf("充值订单创建成功 provider=%s user_id=%d trade_no=%s payment_method=%s amount=%d money=%.2f url=%q", provider.Name(), id, tradeNo, paymentMethod, requestAmount, payMoney, checkout.URL))
+	c.JSON(http.StatusOK, gin.H{"message": "success", "data": checkout.Params, "url": checkout.URL})
+}
+
+// SubscriptionRequestPaymentCheckout 通过已注册的支付网关购买订阅套餐
+func SubscriptionRequestPaymentCheckout(c *gin.Context) {
+	var req PaymentSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.PlanId <= 0 {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	requestSubscriptionCheckout(c, payment.GetCheckoutProvider(c.Param("provider")), req.PlanId, req.PaymentMethod, system_setting.ServerAddress+"/console/topup")
+}
+
+func requestSubscriptionCheckout(c *gin.Context, provider payment.Provider, planId int, paymentMethod string, returnURL string) {
+	if provider == nil || !provider.IsEnabled() {
+		common.ApiErrorMsg(c, "当前管理员未配置支付信息")
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(planId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if !plan.Enabled {
+		common.ApiErrorMsg(c, "套餐未启用")
+		return
+	}
+	if plan.PriceAmount < 0.01 {
+		common.ApiErrorMsg(c, "套餐金额过低")
+		return
+	}
+
+	userId := c.GetInt("id")
+	if plan.MaxPurchasePerUser > 0 {
+		count, err := model.CountUserSubscriptionsByPlan(userId, plan.Id)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		if count >= int64(plan.MaxPurchasePerUser) {
+			common.ApiErrorMsg(c, "已达到该套餐购买上限")
+			return
+		}
+	}
+
+	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
+	tradeNo = fmt.Sprintf("SUBUSR%dNO%s", userId, tradeNo)
+	order := &model.SubscriptionOrder{
+		UserId:          userId,
+		PlanId:          plan.Id,
+		Money:           plan.PriceAmount,
+		TradeNo:         tradeNo,
+		PaymentMethod:   paymentMethod,
+		PaymentProvider: provider.Name(),
+		CreateTime:      time.Now().Unix(),
+		Status:          common.TopUpStatusPending,
+	}
+	if err := order.Insert(); err != nil {
+		common.ApiErrorMsg(c, "创建订单失败")
+		return
+	}
+
+	checkout, err := provider.CreateCheckout(c.Request.Context(), &payment.CheckoutRequest{
+		Kind:          payment.OrderKindSubscription,
+		TradeNo:       tradeNo,
+		UserId:        userId,
+		Title:         fmt.Sprintf("SUB:%s", plan.Title),
+		Money:         plan.PriceAmount,
+		PaymentMethod: paymentMethod,
+		NotifyURL:     paymentNotifyURL(provider),
+		ReturnURL:     returnURL,
+	})
+	if err != nil {
+		_ = model.ExpireSubscriptionOrder(tradeNo, provider.Name())
+		if errors.Is(err, payment.ErrPaymentMethodUnsupported) {
+			common.ApiErrorMsg(c, "支付方式不存在")
+			return
+		}
+		logger.LogError(c.Request.Context(), fmt.Sprintf("订阅拉起支付失败 provider=%s user_id=%d trade_no=%s plan_id=%d error=%q", provider.Name(), userId, tradeNo, plan.Id, err.Error()))
+		common.ApiErrorMsg(c, "拉起支付失败")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "success", "data": checkout.Params, "url": checkout.URL})
+}
+
+// AdminRefundTopUp 管理员通过原支付网关为充值订单退款并扣回额度
+func AdminRefundTopUp(c *gin.Context) {
+	var req AdminRefundTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" {
+		common.ApiErrorMsg(c, "参数错误")
+		return
+	}
+	quota, err := payment.RefundTopUp(c.Request.Context(), req.TradeNo, c.ClientIP())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"quota": quota})
+}
@@ -3,18 +3,18 @@ package controller
 import (
 	"strings"
 
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/payment"
 	"github.com/QuantumNous/new-api/setting"
-	"github.com/QuantumNous/new-api/setting/operation_setting"
 )
 
-func isStripeTopUpEnabled() bool {
-	return strings.TrimSpace(setting.StripeApiSecret) != "" &&
-		strings.TrimSpace(setting.StripeWebhookSecret) != "" &&
-		strings.TrimSpace(setting.StripePriceId) != ""
+func isPaymentProviderEnabled(name string) bool {
+	provider := payment.GetProvider(name)
+	return provider != nil && provider.IsEnabled()
 }
 
-func isStripeWebhookConfigured() bool {
-	return strings.TrimSpace(setting.StripeWebhookSecret) != ""
+func isStripeTopUpEnabled() bool {
+	return isPaymentProviderEnabled(model.PaymentProviderStripe)
 }
 
 func isStripeWebhookEnabled() bool {
@@ -28,32 +28,12 @@ func isCreemTopUpEnabled() bool {
 		products != "[]"
 }
 
-func isCreemWebhookConfigured() bool {
-	return strings.TrimSpace(setting.CreemWebhookSecret) != ""
-}
-
 func isCreemWebhookEnabled() bool {
-	return isCreemTopUpEnabled() && isCreemWebhookConfigured()
+	return isPaymentProviderEnabled(model.PaymentProviderCreem)
 }
 
 func isWaffoTopUpEnabled() bool {
-	if !setting.WaffoEnabled {
-		return false
-	}
-
-	return isWaffoWebhookConfigured()
-}
-
-func isWaffoWebhookConfigured() bool {
-	if setting.WaffoSandbox {
-		return strings.TrimSpace(setting.WaffoSandboxApiKey) != "" &&
-			strings.TrimSpace(setting.WaffoSandboxPrivateKey) != "" &&
-			strings.TrimSpace(setting.WaffoSandboxPublicCert) != ""
-	}
-
-	return strings.TrimSpace(setting.WaffoApiKey) != "" &&
-		strings.TrimSpace(setting.WaffoPrivateKey) != "" &&
-		strings.TrimSpace(setting.WaffoPublicCert) != ""
+	return isPaymentProviderEnabled(model.PaymentProviderWaffo)
 }
 
 func isWaffoWebhookEnabled() bool {
@@ -61,24 +41,7 @@ func isWaffoWebhookEnabled() bool {
 }
 
 func isWaffoPancakeTopUpEnabled() bool {
-	if !setting.WaffoPancakeEnabled {
-		return false
-	}
-
-	return isWaffoPancakeWebhookConfigured() &&
-		strings.TrimSpace(setting.WaffoPancakeMerchantID) != "" &&
-		strings.TrimSpace(setting.WaffoPancakePrivateKey) != "" &&
-		strings.TrimSpace(setting.WaffoPancakeStoreID) != "" &&
-		strings.TrimSpace(setting.WaffoPancakeProductID) != ""
-}
-
-func isWaffoPancakeWebhookConfigured() bool {
-	currentWebhookKey := strings.TrimSpace(setting.WaffoPancakeWebhookPublicKey)
-	if setting.WaffoPancakeSandbox {
-		currentWebhookKey = strings.TrimSpace(setting.WaffoPancakeWebhookTestKey)
-	}
-
-	return currentWebhookKey != ""
+	return isPaymentProviderEnabled(model.PaymentProviderWaffoPancake)
 }
 
 func isWaffoPancakeWebhookEnabled() bool {
@@ -86,13 +49,7 @@ func isWaffoPancakeWebhookEnabled() bool {
 }
 
 func isEpayTopUpEnabled() bool {
-	return isEpayWebhookConfigured() && len(operation_setting.PayMethods) > 0
-}
-
-func isEpayWebhookConfigured() bool {
-	return strings.TrimSpace(operation_setting.PayAddress) != "" &&
-		strings.TrimSpace(operation_setting.EpayId) != "" &&
-		strings.TrimSpace(operation_setting.EpayKey) != ""
+	return isPaymentProviderEnabled(model.PaymentProviderEpay)
 }
 
 func isEpayWebhookEnabled() bool {
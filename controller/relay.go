@@ -15,6 +15,8 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/middleware"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/metrics"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	"github.com/QuantumNous/new-api/relay"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
@@ -29,6 +31,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func relayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
@@ -67,6 +70,15 @@ func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewA
 func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	requestId := c.GetString(common.RequestIdKey)
+	relayStartTime := time.Now()
+	// registered first so it runs after the error response below has been written
+	defer func() {
+		metrics.RecordRelayRequest(string(relayFormat),
+			common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+			common.GetContextKeyString(c, constant.ContextKeyUsingGroup),
+			common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+			c.Writer.Status(), time.Since(relayStartTime))
+	}()
 	//group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
 	//originalModel := common.GetContextKeyString(c, constant.ContextKeyOriginalModel)
 
@@ -105,7 +117,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
+	span := tracing.StartSpan(c, "get_and_validate_request")
 	request, err := helper.GetAndValidateRequest(c, relayFormat)
+	span.EndWithError(err)
 	if err != nil {
 		// Map "request body too large" to 413 so clients can handle it correctly
 		if common.IsRequestBodyTooLargeError(err) || errors.Is(err, common.ErrRequestBodyTooLarge) {
@@ -122,6 +136,13 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		return
 	}
 
+	// 载荷留存在错误响应写出前保存，错误内容由 newAPIError 生成
+	payloadCapture := service.NewPayloadCaptureSession(c)
+	payloadCapture.StartRecording(c)
+	defer func() {
+		payloadCapture.Save(c, relayInfo, newAPIError)
+	}()
+
 	needSensitiveCheck := setting.ShouldCheckPromptSensitive()
 	needCountToken := constant.CountToken
 	// Avoid building huge CombineText (strings.Join) when token counting and sensitive check are both disabled.
@@ -141,7 +162,10 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}
 
+	span = tracing.StartSpan(c, "estimate_request_token")
 	tokens, err := service.EstimateRequestToken(c, meta, relayInfo)
+	span.SetAttributes(attribute.Int("prompt_tokens", tokens))
+	span.EndWithError(err)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeCountTokenFailed)
 		return
@@ -149,7 +173,16 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	relayInfo.SetEstimatePromptTokens(tokens)
 
+	// 响应缓存需在计价前查找，命中时按缓存倍率计费
+	responseCache := newResponseCacheSession(c, relayInfo)
+	cachedResponse := responseCache.Lookup(c)
+	if cachedResponse != nil {
+		common.SetContextKey(c, constant.ContextKeyResponseCacheHit, true)
+	}
+
+	span = tracing.StartSpan(c, "model_price_helper")
 	priceData, err := helper.ModelPriceHelper(c, relayInfo, tokens, meta)
+	span.EndWithError(err)
 	if err != nil {
 		newAPIError = types.NewError(err, types.ErrorCodeModelPriceError, types.ErrOptionWithStatusCode(http.StatusBadRequest))
 		return
@@ -160,7 +193,9 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	if priceData.FreeModel {
 		logger.LogInfo(c, fmt.Sprintf("模型 %s 免费，跳过预扣费", relayInfo.OriginModelName))
 	} else {
+		span = tracing.StartSpan(c, "pre_consume_billing", attribute.Int("quota", priceData.QuotaToPreConsume))
 		newAPIError = service.PreConsumeBilling(c, priceData.QuotaToPreConsume, relayInfo)
+		span.EndWithAPIError(newAPIError)
 		if newAPIError != nil {
 			return
 		}
@@ -177,6 +212,16 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
+	if cachedResponse != nil {
+		replayResponseCache(c, relayInfo, cachedResponse)
+		return
+	}
+	if batchLine := common.GetBatchLine(c.Request.Context()); batchLine != nil && batchLine.UpstreamResponse != nil {
+		replayBatchUpstreamResponse(c, relayInfo, batchLine.UpstreamResponse)
+		return
+	}
+	responseCache.StartRecording(c)
+
 	retryParam := &service.RetryParam{
 		Ctx:        c,
 		TokenGroup: relayInfo.TokenGroup,
@@ -192,9 +237,17 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		if channelErr != nil {
 			logger.LogError(c, channelErr.Error())
 			newAPIError = channelErr
+			if fallbackErr, ok := fallbackVirtualModel(c, relayInfo, retryParam, meta); ok {
+				continue
+			} else if fallbackErr != nil {
+				newAPIError = fallbackErr
+			}
 			break
 		}
 
+		if retryParam.GetRetry() > 0 {
+			metrics.RecordRelayRetry(string(relayFormat), relayInfo.OriginModelName)
+		}
 		addUsedChannel(c, channel.Id)
 		bodyStorage, bodyErr := common.GetBodyStorage(c)
 		if bodyErr != nil {
@@ -208,19 +261,35 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 		c.Request.Body = io.NopCloser(bodyStorage)
 
-		switch relayFormat {
-		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
-		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
-		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
-		default:
-			newAPIError = relayHandler(c, relayInfo)
+		attemptStart := time.Now()
+		attemptSpan := tracing.StartSpan(c, "relay_attempt",
+			attribute.Int("channel.id", channel.Id),
+			attribute.Int("channel.type", channel.Type),
+			attribute.Int("retry.index", relayInfo.RetryIndex),
+		)
+		service.ChannelBalanceAcquire(channel.Id)
+		responseCache.ResetRecording()
+		payloadCapture.ResetRecording()
+		if hedgeDelay := getHedgeDelay(c, relayInfo, relayFormat, retryParam); hedgeDelay > 0 {
+			primaryChannelId := channel.Id
+			channel, attemptStart, newAPIError = relayWithHedge(c, relayInfo, relayFormat, channel, attemptStart, hedgeDelay)
+			if channel.Id != primaryChannelId {
+				attemptSpan.SetAttributes(attribute.Int("hedge.channel.id", channel.Id))
+			}
+		} else {
+			newAPIError = relayAttempt(c, relayInfo, relayFormat)
+		}
+		service.ChannelBalanceRelease(channel.Id, relayInfo, attemptStart, newAPIError)
+		observeUpstreamAttempt(channel.Id, relayInfo, attemptStart, newAPIError)
+		if relayInfo.ChannelMeta != nil {
+			attemptSpan.SetAttributes(attribute.String("upstream.model", relayInfo.UpstreamModelName))
 		}
+		attemptSpan.EndWithAPIError(newAPIError)
 
 		if newAPIError == nil {
 			relayInfo.LastError = nil
+			service.RecordChannelBreakerResult(c, channel.Id, nil)
+			responseCache.Save(c, relayInfo)
 			return
 		}
 
@@ -230,6 +299,14 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+			// 当前模型的重试次数用尽时，虚拟模型回退到链中的下一个模型
+			if shouldRetry(c, newAPIError, 1) {
+				if fallbackErr, ok := fallbackVirtualModel(c, relayInfo, retryParam, meta); ok {
+					continue
+				} else if fallbackErr != nil {
+					newAPIError = fallbackErr
+				}
+			}
 			break
 		}
 	}
@@ -241,6 +318,19 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	}
 }
 
+func relayAttempt(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat) *types.NewAPIError {
+	switch relayFormat {
+	case types.RelayFormatOpenAIRealtime:
+		return relay.WssHelper(c, relayInfo)
+	case types.RelayFormatClaude:
+		return relay.ClaudeHelper(c, relayInfo)
+	case types.RelayFormatGemini:
+		return geminiRelayHandler(c, relayInfo)
+	default:
+		return relayHandler(c, relayInfo)
+	}
+}
+
 var upgrader = websocket.Upgrader{
 	Subprotocols: []string{"realtime"}, // WS 握手支持的协议，如果有使用 Sec-WebSocket-Protocol，则必须在此声明对应的 Protocol TODO add other protocol
 	CheckOrigin: func(r *http.Request) bool {
@@ -248,6 +338,103 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+func observeUpstreamAttempt(channelId int, info *relaycommon.RelayInfo, attemptStart time.Time, err *types.NewAPIError) {
+	var ttft time.Duration
+	if info.IsStream && info.FirstResponseTime.After(attemptStart) {
+		ttft = info.FirstResponseTime.Sub(attemptStart)
+	}
+	metrics.ObserveUpstreamAttempt(channelId, info.OriginModelName, err == nil, time.Since(attemptStart), ttft)
+}
+
+// newResponseCacheSession 按分发中间件选中渠道的模型映射确定上游模型，构造响应缓存会话
+func newResponseCacheSession(c *gin.Context, relayInfo *relaycommon.RelayInfo) *service.ResponseCacheSession {
+	if !operation_setting.GetResponseCacheSetting().Enabled {
+		return nil
+	}
+	mappedInfo := &relaycommon.RelayInfo{
+		OriginModelName: relayInfo.OriginModelName,
+		RelayMode:       relayInfo.RelayMode,
+		ChannelMeta:     &relaycommon.ChannelMeta{UpstreamModelName: relayInfo.OriginModelName},
+	}
+	if err := helper.ModelMappedHelper(c, mappedInfo, nil); err != nil {
+		return nil
+	}
+	return service.NewResponseCacheSession(c, relayInfo, mappedInfo.UpstreamModelName)
+}
+
+// replayResponseCache 回放缓存的响应并按缓存倍率结算，命中缓存不经过任何渠道
+func replayResponseCache(c *gin.Context, relayInfo *relaycommon.RelayInfo, entry *service.ResponseCacheEntry) {
+	relayInfo.InitChannelMeta(c)
+	relayInfo.ChannelId = 0
+	relayInfo.UpstreamModelName = entry.UpstreamModel
+	relayInfo.IsModelMapped = entry.UpstreamModel != relayInfo.OriginModelName
+	relayInfo.IsStream = entry.IsStream
+	relayInfo.SetFirstResponseTime()
+
+	service.ReplayResponseCache(c, entry)
+	usage := entry.Usage
+	service.PostTextConsumeQuota(c, relayInfo, &usage, []string{"响应缓存命中"})
+}
+
+// replayBatchUpstreamResponse 输出上游原生批处理已执行的响应，并按响应中的用量结算
+func replayBatchUpstreamResponse(c *gin.Context, relayInfo *relaycommon.RelayInfo, body []byte) {
+	relayInfo.InitChannelMeta(c)
+	relayInfo.SetFirstResponseTime()
+
+	var response struct {
+		Usage dto.Usage `json:"usage"`
+	}
+	_ = common.Unmarshal(body, &response)
+	usage := response.Usage
+	// Responses API 的用量字段为 input_tokens/output_tokens
+	if usage.PromptTokens == 0 && usage.InputTokens > 0 {
+		usage.PromptTokens = usage.InputTokens
+		usage.CompletionTokens = usage.OutputTokens
+		if usage.InputTokensDetails != nil {
+			usage.PromptTokensDetails.CachedTokens = usage.InputTokensDetails.CachedTokens
+		}
+	}
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	c.Data(http.StatusOK, "application/json", body)
+	service.PostTextConsumeQuota(c, relayInfo, &usage, []string{"批处理由上游原生执行"})
+}
+
+// fallbackVirtualModel 切换到虚拟模型链中的下一个模型并按该模型重新计价，下一轮循环从第一个渠道开始重试。
+// 没有可回退的模型时返回 false；返回的错误不为空时表示无法继续回退。
+func fallbackVirtualModel(c *gin.Context, relayInfo *relaycommon.RelayInfo, retryParam *service.RetryParam, meta *types.TokenCountMeta) (*types.NewAPIError, bool) {
+	for {
+		nextModel, ok := service.NextVirtualModel(c)
+		if !ok {
+			return nil, false
+		}
+		previousModel := relayInfo.OriginModelName
+		relayInfo.OriginModelName = nextModel
+		common.SetContextKey(c, constant.ContextKeyOriginalModel, nextModel)
+		priceData, err := helper.ModelPriceHelper(c, relayInfo, relayInfo.GetEstimatePromptTokens(), meta)
+		if err != nil {
+			logger.LogWarn(c, fmt.Sprintf("virtual model fallback skips %s: %s", nextModel, err.Error()))
+			continue
+		}
+		if relayInfo.Billing == nil && !priceData.FreeModel {
+			if apiErr := service.PreConsumeBilling(c, priceData.QuotaToPreConsume, relayInfo); apiErr != nil {
+				return apiErr, false
+			}
+		}
+		logger.LogInfo(c, fmt.Sprintf("虚拟模型 %s 回退：%s -> %s", common.GetContextKeyString(c, constant.ContextKeyVirtualModel), previousModel, nextModel))
+		if relayInfo.ChannelMeta == nil {
+			// 确保下一轮按新模型重新选择渠道，而不是沿用分发时选中的渠道
+			relayInfo.ChannelMeta = &relaycommon.ChannelMeta{}
+		}
+		retryParam.ModelName = nextModel
+		retryParam.SetRetry(0)
+		retryParam.ResetRetryNextTry()
+		return nil, true
+	}
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
@@ -351,6 +538,7 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
+	service.RecordChannelBreakerResult(c, channelError.ChannelId, err)
 	if service.ShouldDisableChannel(err) && channelError.AutoBan {
 		gopool.Go(func() {
 			service.DisableChannel(channelError, err.ErrorWithStatusCode())
@@ -542,6 +730,7 @@ func RelayTask(c *gin.Context) {
 
 		result, taskErr = relay.RelayTaskSubmit(c, relayInfo)
 		if taskErr == nil {
+			service.RecordChannelBreakerResult(c, channel.Id, nil)
 			break
 		}
 
@@ -575,6 +764,7 @@ func RelayTask(c *gin.Context) {
 		task.PrivateData.BillingSource = relayInfo.BillingSource
 		task.PrivateData.SubscriptionId = relayInfo.SubscriptionId
 		task.PrivateData.TokenId = relayInfo.TokenId
+		task.PrivateData.OrgId = relayInfo.OrgId
 		task.PrivateData.BillingContext = &model.TaskBillingContext{
 			ModelPrice:      relayInfo.PriceData.ModelPrice,
 			GroupRatio:      relayInfo.PriceData.GroupRatioInfo.GroupRatio,
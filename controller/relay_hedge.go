@@ -0,0 +1,411 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/metrics"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	hedgePrimaryId int32 = 1
+	hedgeBackupId  int32 = 2
+
+	// hedgeSelectTries 同优先级内随机选择时，为避开首个渠道最多尝试的次数
+	hedgeSelectTries = 3
+)
+
+var errHedgeLost = errors.New("hedged attempt lost the race")
+
+// hedgeWriter 对冲尝试使用的 ResponseWriter。
+// 胜出前响应头与状态码只记录在本地，首次写出响应体时参与竞速，胜出后直通真实的 writer，落败则丢弃写入。
+type hedgeWriter struct {
+	gin.ResponseWriter
+	attempt *relaycommon.HedgeAttempt
+
+	mu     sync.Mutex
+	header http.Header
+	status int
+	won    bool
+}
+
+func newHedgeWriter(w gin.ResponseWriter, attempt *relaycommon.HedgeAttempt) *hedgeWriter {
+	return &hedgeWriter{
+		ResponseWriter: w,
+		attempt:        attempt,
+		header:         w.Header().Clone(),
+	}
+}
+
+// claim 需持有 mu 调用，胜出时把记录的响应头与状态码写到真实的 writer
+func (w *hedgeWriter) claim() bool {
+	if w.won {
+		return true
+	}
+	if !w.attempt.Race.Claim(w.attempt.Id) {
+		return false
+	}
+	w.won = true
+	header := w.ResponseWriter.Header()
+	for k, v := range w.header {
+		header[k] = v
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	return true
+}
+
+// commit 在尝试结束后调用，胜出但未写出响应体（例如通过结算胜出）时补写响应头
+func (w *hedgeWriter) commit() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.attempt.Race.Winner() == w.attempt.Id {
+		w.claim()
+	}
+}
+
+func (w *hedgeWriter) isWon() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.won
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.won {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.won {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.isWon() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	won := w.claim()
+	w.mu.Unlock()
+	if !won {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	won := w.claim()
+	w.mu.Unlock()
+	if !won {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.isWon() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *hedgeWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.won {
+		return w.ResponseWriter.Status()
+	}
+	if w.status != 0 {
+		return w.status
+	}
+	return http.StatusOK
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.isWon() {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Written() bool {
+	if w.isWon() {
+		return w.ResponseWriter.Written()
+	}
+	return false
+}
+
+// hedgeAttempt 对冲请求中的一个尝试，使用独立的 gin.Context、RelayInfo 与请求体
+type hedgeAttempt struct {
+	ctx       *gin.Context
+	info      *relaycommon.RelayInfo
+	channel   *model.Channel
+	writer    *hedgeWriter
+	storage   common.BodyStorage
+	cancel    context.CancelFunc
+	startTime time.Time
+	err       *types.NewAPIError
+	done      chan struct{}
+}
+
+func newHedgeAttempt(c *gin.Context, relayInfo *relaycommon.RelayInfo, race *relaycommon.HedgeRace, id int32, channel *model.Channel) (*hedgeAttempt, error) {
+	bodyStorage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return nil, err
+	}
+	body, err := bodyStorage.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	storage, err := common.CreateBodyStorage(body)
+	if err != nil {
+		return nil, err
+	}
+
+	hedge := &relaycommon.HedgeAttempt{Race: race, Id: id}
+	ctx := c.Copy()
+	requestCtx, cancel := context.WithCancel(c.Request.Context())
+	ctx.Request = c.Request.WithContext(requestCtx)
+	ctx.Request.Body = io.NopCloser(storage)
+	ctx.Set(common.KeyBodyStorage, storage)
+	writer := newHedgeWriter(c.Writer, hedge)
+	ctx.Writer = writer
+
+	return &hedgeAttempt{
+		ctx:     ctx,
+		info:    relayInfo.CloneForHedge(hedge),
+		channel: channel,
+		writer:  writer,
+		storage: storage,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func (a *hedgeAttempt) start(relayFormat types.RelayFormat) {
+	if a.startTime.IsZero() {
+		a.startTime = time.Now()
+	}
+	go func() {
+		defer close(a.done)
+		defer func() {
+			if r := recover(); r != nil {
+				logger.LogError(a.ctx, fmt.Sprintf("hedged attempt panic: %v\n%s", r, string(debug.Stack())))
+				a.err = types.NewError(fmt.Errorf("hedged attempt panic: %v", r), types.ErrorCodeDoRequestFailed, types.ErrOptionWithSkipRetry())
+			}
+		}()
+		a.err = relayAttempt(a.ctx, a.info, relayFormat)
+	}()
+}
+
+func (a *hedgeAttempt) lost() bool {
+	winner := a.info.Hedge.Race.Winner()
+	return winner != 0 && winner != a.info.Hedge.Id
+}
+
+func (a *hedgeAttempt) close() {
+	a.cancel()
+	_ = a.storage.Close()
+}
+
+// getHedgeDelay 返回首个渠道的对冲延迟，0 表示本次请求不对冲。
+// 仅首次尝试且为无副作用的接口时对冲，Responses（可能在上游保存状态）、图片、音频、实时接口不对冲。
+func getHedgeDelay(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat, retryParam *service.RetryParam) time.Duration {
+	if retryParam.GetRetry() != 0 {
+		return 0
+	}
+	switch relayFormat {
+	case types.RelayFormatClaude, types.RelayFormatGemini, types.RelayFormatEmbedding, types.RelayFormatRerank:
+	case types.RelayFormatOpenAI:
+		switch relayInfo.RelayMode {
+		case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions, relayconstant.RelayModeEmbeddings, relayconstant.RelayModeRerank:
+		default:
+			return 0
+		}
+	default:
+		return 0
+	}
+	if service.ShouldSkipRetryAfterChannelAffinityFailure(c) {
+		return 0
+	}
+	delayMs := operation_setting.GetHedgeSetting().GetDelayMs(hedgeGroup(c, relayInfo), relayInfo.OriginModelName)
+	return time.Duration(delayMs) * time.Millisecond
+}
+
+// hedgeGroup 返回首个渠道所在的分组，auto 分组时为实际选中的分组
+func hedgeGroup(c *gin.Context, relayInfo *relaycommon.RelayInfo) string {
+	if autoGroup := common.GetContextKeyString(c, constant.ContextKeyAutoGroup); autoGroup != "" {
+		return autoGroup
+	}
+	return relayInfo.UsingGroup
+}
+
+// selectHedgeChannel 在当前分组中选择与首个渠道不同的渠道，优先同优先级，其次下一优先级
+func selectHedgeChannel(c *gin.Context, relayInfo *relaycommon.RelayInfo, excludeChannelId int) *model.Channel {
+	group := hedgeGroup(c, relayInfo)
+	for priorityRetry := 0; priorityRetry <= 1; priorityRetry++ {
+		for i := 0; i < hedgeSelectTries; i++ {
+			channel, err := model.GetRandomSatisfiedChannel(group, relayInfo.OriginModelName, priorityRetry)
+			if err != nil || channel == nil {
+				break
+			}
+			if channel.Id != excludeChannelId {
+				return channel
+			}
+		}
+	}
+	return nil
+}
+
+func startBackupAttempt(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat, race *relaycommon.HedgeRace, primaryChannel *model.Channel) *hedgeAttempt {
+	channel := selectHedgeChannel(c, relayInfo, primaryChannel.Id)
+	if channel == nil {
+		return nil
+	}
+	attempt, err := newHedgeAttempt(c, relayInfo, race, hedgeBackupId, channel)
+	if err != nil {
+		logger.LogWarn(c, "failed to create hedged attempt: "+err.Error())
+		return nil
+	}
+	if apiErr := middleware.SetupContextForSelectedChannel(attempt.ctx, channel, relayInfo.OriginModelName); apiErr != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to setup hedged channel #%d: %s", channel.Id, apiErr.Error()))
+		attempt.close()
+		return nil
+	}
+	logger.LogInfo(c, fmt.Sprintf("渠道 #%d 首字节超时，对冲请求渠道 #%d", primaryChannel.Id, channel.Id))
+	race.MarkHedged()
+	service.ChannelBalanceAcquire(channel.Id)
+	attempt.start(relayFormat)
+	return attempt
+}
+
+// relayWithHedge 在首个渠道上发起请求，超过 delay 仍未返回首字节时向另一个渠道发起相同请求，
+// 先写出响应的一方胜出，另一方被取消且不计费。
+// 返回最终采用的渠道及其开始时间，由调用方完成该渠道的统计；另一渠道的统计在此处完成。
+func relayWithHedge(c *gin.Context, relayInfo *relaycommon.RelayInfo, relayFormat types.RelayFormat, primaryChannel *model.Channel, primaryStart time.Time, delay time.Duration) (*model.Channel, time.Time, *types.NewAPIError) {
+	wonCh := make(chan struct{})
+	race := relaycommon.NewHedgeRace(func(int32) {
+		close(wonCh)
+	})
+
+	primary, err := newHedgeAttempt(c, relayInfo, race, hedgePrimaryId, primaryChannel)
+	if err != nil {
+		return primaryChannel, primaryStart, types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	primary.startTime = primaryStart
+	primary.start(relayFormat)
+
+	var backup *hedgeAttempt
+	timer := time.NewTimer(delay)
+	select {
+	case <-primary.done:
+	case <-wonCh:
+	case <-timer.C:
+		backup = startBackupAttempt(c, relayInfo, relayFormat, race, primaryChannel)
+	}
+	timer.Stop()
+
+	if backup == nil {
+		<-primary.done
+		return finishHedge(c, relayInfo, primary), primaryStart, primary.err
+	}
+
+	primaryDone, backupDone := primary.done, backup.done
+	for primaryDone != nil || backupDone != nil {
+		select {
+		case <-wonCh:
+			wonCh = nil
+			if race.Winner() == hedgePrimaryId {
+				backup.cancel()
+			} else {
+				primary.cancel()
+			}
+		case <-primaryDone:
+			primaryDone = nil
+		case <-backupDone:
+			backupDone = nil
+		}
+	}
+
+	winner, loser := primary, backup
+	switch race.Winner() {
+	case hedgeBackupId:
+		winner, loser = backup, primary
+	case 0:
+		if primary.err != nil && backup.err == nil {
+			winner, loser = backup, primary
+		}
+	}
+
+	winnerLabel := "none"
+	if race.Winner() != 0 {
+		winnerLabel = "primary"
+		if winner == backup {
+			winnerLabel = "hedge"
+		}
+	}
+	metrics.RecordRelayHedge(string(relayFormat), relayInfo.OriginModelName, winnerLabel)
+
+	if loser.lost() {
+		// 落败的尝试是被主动取消的，不计入渠道的延迟与错误统计
+		service.ChannelBalanceRelease(loser.channel.Id, loser.info, loser.startTime,
+			types.NewError(errHedgeLost, types.ErrorCodeDoRequestFailed, types.ErrOptionWithSkipRetry()))
+	} else {
+		service.ChannelBalanceRelease(loser.channel.Id, loser.info, loser.startTime, loser.err)
+		observeUpstreamAttempt(loser.channel.Id, loser.info, loser.startTime, loser.err)
+		if loser.err != nil {
+			processChannelError(loser.ctx, *types.NewChannelError(loser.channel.Id, loser.channel.Type, loser.channel.Name, loser.channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(loser.ctx, constant.ContextKeyChannelKey), loser.channel.GetAutoBan()), loser.err)
+		}
+	}
+	loser.close()
+
+	channel := finishHedge(c, relayInfo, winner)
+	addUsedChannel(c, backup.channel.Id)
+	return channel, winner.startTime, winner.err
+}
+
+// finishHedge 将采用的尝试的状态同步回原始的 gin.Context 与 RelayInfo
+func finishHedge(c *gin.Context, relayInfo *relaycommon.RelayInfo, winner *hedgeAttempt) *model.Channel {
+	winner.writer.commit()
+	for k, v := range winner.ctx.Keys {
+		if k == common.KeyBodyStorage {
+			continue
+		}
+		c.Set(k, v)
+	}
+	*relayInfo = *winner.info
+	relayInfo.Hedge = nil
+	winner.close()
+	return winner.channel
+}
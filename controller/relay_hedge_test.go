@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgeWriterFirstWriteWins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	var winners []int32
+	race := relaycommon.NewHedgeRace(func(id int32) {
+		winners = append(winners, id)
+	})
+	primaryInfo := (&relaycommon.RelayInfo{}).CloneForHedge(&relaycommon.HedgeAttempt{Race: race, Id: hedgePrimaryId})
+	backupInfo := (&relaycommon.RelayInfo{}).CloneForHedge(&relaycommon.HedgeAttempt{Race: race, Id: hedgeBackupId})
+	primary := newHedgeWriter(c.Writer, primaryInfo.Hedge)
+	backup := newHedgeWriter(c.Writer, backupInfo.Hedge)
+
+	primary.Header().Set("X-Attempt", "primary")
+	primary.WriteHeader(http.StatusTeapot)
+	backup.Header().Set("X-Attempt", "backup")
+	require.Empty(t, recorder.Header().Get("X-Attempt"))
+	require.False(t, backup.Written())
+
+	_, err := backup.WriteString("backup")
+	require.NoError(t, err)
+	_, err = primary.WriteString("primary")
+	require.ErrorIs(t, err, errHedgeLost)
+
+	require.Equal(t, []int32{hedgeBackupId}, winners)
+	require.Equal(t, "backup", recorder.Header().Get("X-Attempt"))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "backup", recorder.Body.String())
+
+	// 只有胜出的尝试可以结算
+	require.True(t, backupInfo.ClaimHedgeWin())
+	require.False(t, primaryInfo.ClaimHedgeWin())
+	require.True(t, (&relaycommon.RelayInfo{}).ClaimHedgeWin())
+
+	// 发起过备用尝试的请求在消费日志中标记为对冲
+	require.False(t, backupInfo.IsHedged())
+	race.MarkHedged()
+	require.True(t, backupInfo.IsHedged())
+	require.False(t, (&relaycommon.RelayInfo{}).IsHedged())
+}
+
+func TestHedgeSettingDelay(t *testing.T) {
+	setting := operation_setting.GetHedgeSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+
+	setting.Enabled = true
+	setting.DefaultDelayMs = 0
+	setting.GroupDelayMs = map[string]int{"vip": 800}
+	setting.ModelDelayMs = map[string]int{"gpt-4o": 500, "o1": 0}
+
+	require.Equal(t, 0, setting.GetDelayMs("default", "gpt-4o-mini"))
+	require.Equal(t, 800, setting.GetDelayMs("vip", "gpt-4o-mini"))
+	require.Equal(t, 500, setting.GetDelayMs("default", "gpt-4o"))
+	require.Equal(t, 0, setting.GetDelayMs("vip", "o1"))
+
+	setting.Enabled = false
+	require.Equal(t, 0, setting.GetDelayMs("vip", "gpt-4o"))
+}
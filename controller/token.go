@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/i18n"
 	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
@@ -53,6 +55,10 @@ func SearchTokens(c *gin.Context) {
 	pageInfo := common.GetPageQuery(c)
 
 	tokens, total, err := model.SearchUserTokens(userId, keyword, token, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if errors.Is(err, model.ErrTokenKeyFuzzySearch) {
+		common.ApiErrorI18n(c, i18n.MsgTokenKeyExactSearchOnly)
+		return
+	}
 	if err != nil {
 		common.ApiError(c, err)
 		return
@@ -77,7 +83,9 @@ func GetToken(c *gin.Context) {
 	common.ApiSuccess(c, buildMaskedTokenResponse(token))
 }
 
-func GetTokenKey(c *gin.Context) {
+// RegenerateTokenKey 为令牌生成新的 key，原 key 立即失效。
+// 数据库中只保存 key 的哈希，新 key 只在本次响应中返回。
+func RegenerateTokenKey(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	userId := c.GetInt("id")
 	if err != nil {
@@ -89,8 +97,15 @@ func GetTokenKey(c *gin.Context) {
 		common.ApiError(c, err)
 		return
 	}
+	key, err := token.RegenerateKey()
+	if err != nil {
+		common.ApiErrorI18n(c, i18n.MsgTokenGenerateFailed)
+		common.SysLog("failed to regenerate token key: " + err.Error())
+		return
+	}
 	common.ApiSuccess(c, gin.H{
-		"key": token.GetFullKey(),
+		"id":  token.Id,
+		"key": key,
 	})
 }
 
@@ -147,6 +162,21 @@ func GetTokenUsage(c *gin.Context) {
 		expiredAt = 0
 	}
 
+	// 周期预算，未配置时 period_limit 为 0
+	var periodUsed int
+	var resetsAt int64
+	budget := token.GetBudgetWindow()
+	if budget.Enabled() {
+		periodUsed, resetsAt, err = model.GetBudgetStatus(model.BudgetOwnerToken, token.Id, budget)
+		if err != nil {
+			common.SysError("failed to get token budget status: " + err.Error())
+			common.ApiErrorI18n(c, i18n.MsgTokenGetInfoFailed)
+			return
+		}
+	} else {
+		budget.Limit = 0
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"code":    true,
 		"message": "ok",
@@ -160,6 +190,10 @@ func GetTokenUsage(c *gin.Context) {
 			"model_limits":         token.GetModelLimitsMap(),
 			"model_limits_enabled": token.ModelLimitsEnabled,
 			"expires_at":           expiredAt,
+			"period":               budget.Period,
+			"period_used":          periodUsed,
+			"period_limit":         budget.Limit,
+			"resets_at":            resetsAt,
 		},
 	})
 }
@@ -175,6 +209,36 @@ func AddToken(c *gin.Context) {
 		common.ApiErrorI18n(c, i18n.MsgTokenNameTooLong)
 		return
 	}
+	if token.TpmLimit < 0 || token.ConcurrencyLimit < 0 {
+		common.ApiErrorI18n(c, i18n.MsgTokenLimitNegative)
+		return
+	}
+	if err := token.GetBudgetWindow().Validate(); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgTokenBudgetInvalid, map[string]any{"Error": err.Error()})
+		return
+	}
+	restrictions := token.GetRestrictions()
+	if err := restrictions.Validate(); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgTokenRestrictionsInvalid, map[string]any{"Error": err.Error()})
+		return
+	}
+	token.Scopes = strings.Join(restrictions.Scopes, ",")
+	token.DisallowedFeatures = strings.Join(restrictions.DisallowedFeatures, ",")
+	if err := normalizeTokenParamOverride(&token, c.GetInt("role") >= common.RoleAdminUser); err != nil {
+		var notAllowed *tokenParamOverrideNotAllowedError
+		if errors.As(err, &notAllowed) {
+			common.ApiErrorI18n(c, i18n.MsgTokenParamOverrideNotAllowed, map[string]any{"Error": notAllowed.Error()})
+			return
+		}
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if token.OrgId != 0 {
+		if _, err := model.GetOrganizationMember(token.OrgId, c.GetInt("id")); err != nil {
+			common.ApiErrorI18n(c, i18n.MsgTokenOrgNotMember)
+			return
+		}
+	}
 	// 非无限额度时，检查额度值是否超出有效范围
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
@@ -210,7 +274,6 @@ func AddToken(c *gin.Context) {
 	cleanToken := model.Token{
 		UserId:             c.GetInt("id"),
 		Name:               token.Name,
-		Key:                key,
 		CreatedTime:        common.GetTimestamp(),
 		AccessedTime:       common.GetTimestamp(),
 		ExpiredTime:        token.ExpiredTime,
@@ -221,15 +284,36 @@ func AddToken(c *gin.Context) {
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
 		CrossGroupRetry:    token.CrossGroupRetry,
+		TpmLimit:           token.TpmLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		ResponseCache:      token.ResponseCache,
+		BudgetPeriod:       token.BudgetPeriod,
+		BudgetMode:         token.BudgetMode,
+		BudgetLimit:        token.BudgetLimit,
+		OrgId:              token.OrgId,
+		PayloadCapture:     token.PayloadCapture,
+		Scopes:             token.Scopes,
+		MaxTokens:          token.MaxTokens,
+		DisallowedFeatures: token.DisallowedFeatures,
+		ParamOverride:      token.ParamOverride,
+	}
+	if err := cleanToken.SetRawKey(key); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgTokenGenerateFailed)
+		common.SysLog("failed to hash token key: " + err.Error())
+		return
 	}
 	err = cleanToken.Insert()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	// 明文 key 只在创建时返回一次，之后只能重新生成
+	data := buildMaskedTokenResponse(&cleanToken)
+	data.Key = key
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
+		"data":    data,
 	})
 }
 
@@ -260,6 +344,36 @@ func UpdateToken(c *gin.Context) {
 		common.ApiErrorI18n(c, i18n.MsgTokenNameTooLong)
 		return
 	}
+	if token.TpmLimit < 0 || token.ConcurrencyLimit < 0 {
+		common.ApiErrorI18n(c, i18n.MsgTokenLimitNegative)
+		return
+	}
+	if err := token.GetBudgetWindow().Validate(); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgTokenBudgetInvalid, map[string]any{"Error": err.Error()})
+		return
+	}
+	restrictions := token.GetRestrictions()
+	if err := restrictions.Validate(); err != nil {
+		common.ApiErrorI18n(c, i18n.MsgTokenRestrictionsInvalid, map[string]any{"Error": err.Error()})
+		return
+	}
+	token.Scopes = strings.Join(restrictions.Scopes, ",")
+	token.DisallowedFeatures = strings.Join(restrictions.DisallowedFeatures, ",")
+	if err := normalizeTokenParamOverride(&token, c.GetInt("role") >= common.RoleAdminUser); err != nil {
+		var notAllowed *tokenParamOverrideNotAllowedError
+		if errors.As(err, &notAllowed) {
+			common.ApiErrorI18n(c, i18n.MsgTokenParamOverrideNotAllowed, map[string]any{"Error": notAllowed.Error()})
+			return
+		}
+		common.ApiErrorMsg(c, err.Error())
+		return
+	}
+	if token.OrgId != 0 {
+		if _, err := model.GetOrganizationMember(token.OrgId, c.GetInt("id")); err != nil {
+			common.ApiErrorI18n(c, i18n.MsgTokenOrgNotMember)
+			return
+		}
+	}
 	if !token.UnlimitedQuota {
 		if token.RemainQuota < 0 {
 			common.ApiErrorI18n(c, i18n.MsgTokenQuotaNegative)
@@ -299,6 +413,18 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
 		cleanToken.CrossGroupRetry = token.CrossGroupRetry
+		cleanToken.TpmLimit = token.TpmLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		cleanToken.ResponseCache = token.ResponseCache
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		cleanToken.BudgetMode = token.BudgetMode
+		cleanToken.BudgetLimit = token.BudgetLimit
+		cleanToken.OrgId = token.OrgId
+		cleanToken.PayloadCapture = token.PayloadCapture
+		cleanToken.Scopes = token.Scopes
+		cleanToken.MaxTokens = token.MaxTokens
+		cleanToken.DisallowedFeatures = token.DisallowedFeatures
+		cleanToken.ParamOverride = token.ParamOverride
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -335,7 +461,8 @@ func DeleteTokenBatch(c *gin.Context) {
 	})
 }
 
-func GetTokenKeysBatch(c *gin.Context) {
+// RegenerateTokenKeysBatch 批量重新生成令牌 key，返回 {id: key}，新 key 只展示这一次
+func RegenerateTokenKeysBatch(c *gin.Context) {
 	tokenBatch := TokenBatch{}
 	if err := c.ShouldBindJSON(&tokenBatch); err != nil || len(tokenBatch.Ids) == 0 {
 		common.ApiErrorI18n(c, i18n.MsgInvalidParams)
@@ -346,14 +473,48 @@ func GetTokenKeysBatch(c *gin.Context) {
 		return
 	}
 	userId := c.GetInt("id")
-	tokens, err := model.GetTokenKeysByIds(tokenBatch.Ids, userId)
-	if err != nil {
-		common.ApiError(c, err)
-		return
-	}
 	keysMap := make(map[int]string)
-	for _, t := range tokens {
-		keysMap[t.Id] = t.GetFullKey()
+	for _, id := range tokenBatch.Ids {
+		token, err := model.GetTokenByIds(id, userId)
+		if err != nil {
+			continue
+		}
+		key, err := token.RegenerateKey()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to regenerate key of token %d: %v", id, err))
+			continue
+		}
+		keysMap[token.Id] = key
 	}
 	common.ApiSuccess(c, gin.H{"keys": keysMap})
 }
+
+type tokenParamOverrideNotAllowedError struct {
+	err error
+}
+
+func (e *tokenParamOverrideNotAllowedError) Error() string {
+	return e.err.Error()
+}
+
+// normalizeTokenParamOverride 校验令牌参数覆盖为 JSON 对象，空白内容视为未配置；
+// 非管理员只能改写白名单内的字段，不能改写模型或请求头
+func normalizeTokenParamOverride(token *model.Token, isAdmin bool) error {
+	if token.ParamOverride == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*token.ParamOverride)
+	if trimmed != "" {
+		var paramOverride map[string]interface{}
+		if err := common.Unmarshal([]byte(trimmed), &paramOverride); err != nil {
+			return errors.New("参数覆盖必须是合法的 JSON 对象")
+		}
+		if !isAdmin {
+			if err := relaycommon.ValidateTokenParamOverride(paramOverride); err != nil {
+				return &tokenParamOverrideNotAllowedError{err: err}
+			}
+		}
+	}
+	token.ParamOverride = common.GetPointer[string](trimmed)
+	return nil
+}
@@ -48,21 +48,21 @@ type sqliteColumnInfo struct {
 }
 
 type legacyToken struct {
-	Id                 int            `gorm:"primaryKey"`
-	UserId             int            `gorm:"index"`
-	Key                string         `gorm:"column:key;type:char(48);uniqueIndex"`
-	Status             int            `gorm:"default:1"`
-	Name               string         `gorm:"index"`
-	CreatedTime        int64          `gorm:"bigint"`
-	AccessedTime       int64          `gorm:"bigint"`
-	ExpiredTime        int64          `gorm:"bigint;default:-1"`
-	RemainQuota        int            `gorm:"default:0"`
+	Id                 int    `gorm:"primaryKey"`
+	UserId             int    `gorm:"index"`
+	Key                string `gorm:"column:key;type:char(48);uniqueIndex"`
+	Status             int    `gorm:"default:1"`
+	Name               string `gorm:"index"`
+	CreatedTime        int64  `gorm:"bigint"`
+	AccessedTime       int64  `gorm:"bigint"`
+	ExpiredTime        int64  `gorm:"bigint;default:-1"`
+	RemainQuota        int    `gorm:"default:0"`
 	UnlimitedQuota     bool
 	ModelLimitsEnabled bool
-	ModelLimits        string         `gorm:"type:text"`
-	AllowIps           *string        `gorm:"default:''"`
-	UsedQuota          int            `gorm:"default:0"`
-	Group              string         `gorm:"column:group;default:''"`
+	ModelLimits        string  `gorm:"type:text"`
+	AllowIps           *string `gorm:"default:''"`
+	UsedQuota          int     `gorm:"default:0"`
+	Group              string  `gorm:"column:group;default:''"`
 	CrossGroupRetry    bool
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
@@ -101,7 +101,7 @@ func openTokenControllerTestDB(t *testing.T) *gorm.DB {
 func migrateTokenControllerTestDB(t *testing.T, db *gorm.DB) {
 	t.Helper()
 
-	if err := db.AutoMigrate(&model.Token{}); err != nil {
+	if err := db.AutoMigrate(&model.Token{}, &model.Option{}); err != nil {
 		t.Fatalf("failed to migrate token table: %v", err)
 	}
 }
@@ -506,36 +506,212 @@ func TestUpdateTokenMasksKeyInResponse(t *testing.T) {
 	}
 }
 
-func TestGetTokenKeyRequiresOwnershipAndReturnsFullKey(t *testing.T) {
+func TestRegenerateTokenKeyRequiresOwnershipAndReturnsNewKeyOnce(t *testing.T) {
 	db := setupTokenControllerTestDB(t)
 	token := seedToken(t, db, 1, "owned-token", "owner1234token5678")
 
-	authorizedCtx, authorizedRecorder := newAuthenticatedContext(t, http.MethodPost, "/api/token/"+strconv.Itoa(token.Id)+"/key", nil, 1)
+	unauthorizedCtx, unauthorizedRecorder := newAuthenticatedContext(t, http.MethodPost, "/api/token/"+strconv.Itoa(token.Id)+"/regenerate", nil, 2)
+	unauthorizedCtx.Params = gin.Params{{Key: "id", Value: strconv.Itoa(token.Id)}}
+	RegenerateTokenKey(unauthorizedCtx)
+
+	unauthorizedResponse := decodeAPIResponse(t, unauthorizedRecorder)
+	if unauthorizedResponse.Success {
+		t.Fatalf("expected unauthorized key regeneration to fail")
+	}
+	if _, err := model.ValidateUserToken("owner1234token5678"); err != nil {
+		t.Fatalf("expected original key to stay valid after unauthorized regeneration: %v", err)
+	}
+
+	authorizedCtx, authorizedRecorder := newAuthenticatedContext(t, http.MethodPost, "/api/token/"+strconv.Itoa(token.Id)+"/regenerate", nil, 1)
 	authorizedCtx.Params = gin.Params{{Key: "id", Value: strconv.Itoa(token.Id)}}
-	GetTokenKey(authorizedCtx)
+	RegenerateTokenKey(authorizedCtx)
 
 	authorizedResponse := decodeAPIResponse(t, authorizedRecorder)
 	if !authorizedResponse.Success {
-		t.Fatalf("expected authorized key fetch to succeed, got message: %s", authorizedResponse.Message)
+		t.Fatalf("expected authorized key regeneration to succeed, got message: %s", authorizedResponse.Message)
 	}
-
 	var keyData tokenKeyResponse
 	if err := common.Unmarshal(authorizedResponse.Data, &keyData); err != nil {
 		t.Fatalf("failed to decode token key response: %v", err)
 	}
-	if keyData.Key != token.GetFullKey() {
-		t.Fatalf("expected full key %q, got %q", token.GetFullKey(), keyData.Key)
+	if keyData.Key == "" || keyData.Key == "owner1234token5678" {
+		t.Fatalf("expected a new key, got %q", keyData.Key)
 	}
 
-	unauthorizedCtx, unauthorizedRecorder := newAuthenticatedContext(t, http.MethodPost, "/api/token/"+strconv.Itoa(token.Id)+"/key", nil, 2)
-	unauthorizedCtx.Params = gin.Params{{Key: "id", Value: strconv.Itoa(token.Id)}}
-	GetTokenKey(unauthorizedCtx)
+	var stored model.Token
+	if err := db.First(&stored, "id = ?", token.Id).Error; err != nil {
+		t.Fatalf("failed to load regenerated token: %v", err)
+	}
+	if stored.Key != model.HashTokenKey(keyData.Key) {
+		t.Fatalf("expected stored key to be the hash of the new key, got %q", stored.Key)
+	}
+	if stored.KeyPrefix != keyData.Key[:4] {
+		t.Fatalf("expected key prefix %q, got %q", keyData.Key[:4], stored.KeyPrefix)
+	}
+	if _, err := model.ValidateUserToken(keyData.Key); err != nil {
+		t.Fatalf("expected new key to be valid: %v", err)
+	}
+	if _, err := model.ValidateUserToken("owner1234token5678"); err == nil {
+		t.Fatalf("expected old key to be revoked")
+	}
 
-	unauthorizedResponse := decodeAPIResponse(t, unauthorizedRecorder)
-	if unauthorizedResponse.Success {
-		t.Fatalf("expected unauthorized key fetch to fail")
+	// 之后的查询只返回掩码
+	detailCtx, detailRecorder := newAuthenticatedContext(t, http.MethodGet, "/api/token/"+strconv.Itoa(token.Id), nil, 1)
+	detailCtx.Params = gin.Params{{Key: "id", Value: strconv.Itoa(token.Id)}}
+	GetToken(detailCtx)
+	if strings.Contains(detailRecorder.Body.String(), keyData.Key) || strings.Contains(detailRecorder.Body.String(), stored.Key) {
+		t.Fatalf("detail response leaked token key: %s", detailRecorder.Body.String())
+	}
+}
+
+func TestAddTokenReturnsKeyOnlyAtCreation(t *testing.T) {
+	db := setupTokenControllerTestDB(t)
+
+	body := map[string]any{
+		"name":            "new-token",
+		"expired_time":    -1,
+		"unlimited_quota": true,
+	}
+	ctx, recorder := newAuthenticatedContext(t, http.MethodPost, "/api/token/", body, 1)
+	AddToken(ctx)
+
+	response := decodeAPIResponse(t, recorder)
+	if !response.Success {
+		t.Fatalf("expected success response, got message: %s", response.Message)
+	}
+	var created tokenResponseItem
+	if err := common.Unmarshal(response.Data, &created); err != nil {
+		t.Fatalf("failed to decode created token: %v", err)
+	}
+
+	var stored model.Token
+	if err := db.First(&stored, "id = ?", created.ID).Error; err != nil {
+		t.Fatalf("failed to load created token: %v", err)
+	}
+	if stored.Key == created.Key || stored.Key != model.HashTokenKey(created.Key) {
+		t.Fatalf("expected only the key hash to be stored, got %q", stored.Key)
+	}
+
+	listCtx, listRecorder := newAuthenticatedContext(t, http.MethodGet, "/api/token/?p=1&size=10", nil, 1)
+	GetAllTokens(listCtx)
+	if strings.Contains(listRecorder.Body.String(), created.Key) || strings.Contains(listRecorder.Body.String(), stored.Key) {
+		t.Fatalf("list response leaked token key: %s", listRecorder.Body.String())
+	}
+	var page tokenPageResponse
+	if err := common.Unmarshal(decodeAPIResponse(t, listRecorder).Data, &page); err != nil {
+		t.Fatalf("failed to decode token page response: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Key != created.Key[:4]+"**********" {
+		t.Fatalf("expected masked key with display prefix, got %+v", page.Items)
+	}
+}
+
+func TestLegacyPlaintextTokenKeyIsHashedOnUse(t *testing.T) {
+	db := setupTokenControllerTestDB(t)
+	legacyKey := strings.Repeat("c", 48)
+	token := seedToken(t, db, 1, "legacy-token", legacyKey)
+
+	validated, err := model.ValidateUserToken(legacyKey)
+	if err != nil {
+		t.Fatalf("expected legacy key to stay valid: %v", err)
+	}
+	if validated.Id != token.Id || validated.Key != model.HashTokenKey(legacyKey) {
+		t.Fatalf("expected validated token to carry the key hash, got %+v", validated)
+	}
+
+	var stored model.Token
+	if err := db.First(&stored, "id = ?", token.Id).Error; err != nil {
+		t.Fatalf("failed to load legacy token: %v", err)
+	}
+	if stored.Key != model.HashTokenKey(legacyKey) || stored.KeyPrefix != "cccc" {
+		t.Fatalf("expected legacy key to be hashed in place, got key %q prefix %q", stored.Key, stored.KeyPrefix)
 	}
-	if strings.Contains(unauthorizedRecorder.Body.String(), token.Key) {
-		t.Fatalf("unauthorized key response leaked raw token key: %s", unauthorizedRecorder.Body.String())
+	if _, err := model.ValidateUserToken(legacyKey); err != nil {
+		t.Fatalf("expected legacy key to stay valid after hashing: %v", err)
+	}
+}
+
+func TestSearchTokensByExactKey(t *testing.T) {
+	db := setupTokenControllerTestDB(t)
+	token := &model.Token{
+		UserId:         1,
+		Name:           "hashed-token",
+		Status:         common.TokenStatusEnabled,
+		ExpiredTime:    -1,
+		UnlimitedQuota: true,
+	}
+	if err := token.SetRawKey("abcd1234efgh5678"); err != nil {
+		t.Fatalf("failed to hash token key: %v", err)
+	}
+	if err := db.Create(token).Error; err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	ctx, recorder := newAuthenticatedContext(t, http.MethodGet, "/api/token/search?token=sk-abcd1234efgh5678&p=1&size=10", nil, 1)
+	SearchTokens(ctx)
+
+	response := decodeAPIResponse(t, recorder)
+	if !response.Success {
+		t.Fatalf("expected success response, got message: %s", response.Message)
+	}
+	var page tokenPageResponse
+	if err := common.Unmarshal(response.Data, &page); err != nil {
+		t.Fatalf("failed to decode search response: %v", err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != token.Id {
+		t.Fatalf("expected the hashed token to match its sk- prefixed key, got %+v", page.Items)
+	}
+
+	ctx, recorder = newAuthenticatedContext(t, http.MethodGet, "/api/token/search?token=abcd%25&p=1&size=10", nil, 1)
+	SearchTokens(ctx)
+
+	response = decodeAPIResponse(t, recorder)
+	if response.Success {
+		t.Fatalf("expected fuzzy key search to be rejected")
+	}
+}
+
+func TestTokenParamOverrideCannotRewriteModelForNonAdmin(t *testing.T) {
+	db := setupTokenControllerTestDB(t)
+	token := seedToken(t, db, 1, "policy-token", "policy1234abcd5678")
+
+	modelOverride := `{"operations":[{"path":"model","mode":"set","value":"gpt-4o"}]}`
+	body := map[string]any{
+		"id":              token.Id,
+		"name":            token.Name,
+		"expired_time":    -1,
+		"unlimited_quota": true,
+		"group":           "default",
+		"param_override":  modelOverride,
+	}
+	ctx, recorder := newAuthenticatedContext(t, http.MethodPut, "/api/token/", body, 1)
+	ctx.Set("role", common.RoleCommonUser)
+	UpdateToken(ctx)
+	if response := decodeAPIResponse(t, recorder); response.Success {
+		t.Fatalf("expected non-admin model rewrite to be rejected")
+	}
+
+	var stored model.Token
+	if err := db.First(&stored, "id = ?", token.Id).Error; err != nil {
+		t.Fatalf("failed to load token: %v", err)
+	}
+	if stored.ParamOverride != nil && *stored.ParamOverride != "" {
+		t.Fatalf("expected param override to stay empty, got %q", *stored.ParamOverride)
+	}
+
+	body["param_override"] = `{"temperature":0.2,"operations":[{"path":"user","mode":"set","value":"{{token_id}}"}]}`
+	ctx, recorder = newAuthenticatedContext(t, http.MethodPut, "/api/token/", body, 1)
+	ctx.Set("role", common.RoleCommonUser)
+	UpdateToken(ctx)
+	if response := decodeAPIResponse(t, recorder); !response.Success {
+		t.Fatalf("expected allowed policy to be saved, got message: %s", response.Message)
+	}
+
+	body["param_override"] = modelOverride
+	ctx, recorder = newAuthenticatedContext(t, http.MethodPut, "/api/token/", body, 1)
+	ctx.Set("role", common.RoleAdminUser)
+	UpdateToken(ctx)
+	if response := decodeAPIResponse(t, recorder); !response.Success {
+		t.Fatalf("expected admin policy to be saved, got message: %s", response.Message)
 	}
 }
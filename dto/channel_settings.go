@@ -7,6 +7,12 @@ type ChannelSettings struct {
 	PassThroughBodyEnabled bool   `json:"pass_through_body_enabled,omitempty"`
 	SystemPrompt           string `json:"system_prompt,omitempty"`
 	SystemPromptOverride   bool   `json:"system_prompt_override,omitempty"`
+	// TraceContextPropagation 向上游请求注入 W3C traceparent 头
+	TraceContextPropagation bool `json:"trace_context_propagation,omitempty"`
+	// PayloadCapture 留存经该渠道的请求/响应载荷，需同时开启全局载荷留存
+	PayloadCapture bool `json:"payload_capture,omitempty"`
+	// NativeBatch 批处理提交到该渠道的上游原生 Batch API 执行（仅 OpenAI 类型渠道）
+	NativeBatch bool `json:"native_batch,omitempty"`
 }
 
 type VertexKeyType string
@@ -41,6 +47,7 @@ type ChannelOtherSettings struct {
 	UpstreamModelUpdateLastDetectedModels []string      `json:"upstream_model_update_last_detected_models,omitempty"` // 上次检测到的可加入模型
 	UpstreamModelUpdateLastRemovedModels  []string      `json:"upstream_model_update_last_removed_models,omitempty"`  // 上次检测到的可删除模型
 	UpstreamModelUpdateIgnoredModels      []string      `json:"upstream_model_update_ignored_models,omitempty"`       // 手动忽略的模型
+	UpstreamCostExpr                      string        `json:"upstream_cost_expr,omitempty"`                         // 上游成本表达式（计费表达式语法），结算时按实际用量计算采购成本
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
@@ -0,0 +1,60 @@
+package dto
+
+import "encoding/json"
+
+// ClaudeCountTokensRequest https://docs.anthropic.com/en/api/messages-count-tokens
+// 上游计数接口不接受 max_tokens 等生成参数，因此单独定义请求体
+type ClaudeCountTokensRequest struct {
+	Model      string          `json:"model"`
+	System     any             `json:"system,omitempty"`
+	Messages   []ClaudeMessage `json:"messages"`
+	Tools      any             `json:"tools,omitempty"`
+	ToolChoice any             `json:"tool_choice,omitempty"`
+	Thinking   *Thinking       `json:"thinking,omitempty"`
+	McpServers json.RawMessage `json:"mcp_servers,omitempty"`
+}
+
+func NewClaudeCountTokensRequest(request *ClaudeRequest, model string) *ClaudeCountTokensRequest {
+	return &ClaudeCountTokensRequest{
+		Model:      model,
+		System:     request.System,
+		Messages:   request.Messages,
+		Tools:      request.Tools,
+		ToolChoice: request.ToolChoice,
+		Thinking:   request.Thinking,
+		McpServers: request.McpServers,
+	}
+}
+
+type ClaudeCountTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// GeminiCountTokensRequest https://ai.google.dev/api/tokens#method:-models.counttokens
+// contents 与 generateContentRequest 二选一
+type GeminiCountTokensRequest struct {
+	Contents               []GeminiChatContent `json:"contents,omitempty"`
+	GenerateContentRequest *GeminiChatRequest  `json:"generateContentRequest,omitempty"`
+}
+
+// ToGeminiChatRequest 将计数请求统一为 GeminiChatRequest，便于本地估算和转发
+func (r *GeminiCountTokensRequest) ToGeminiChatRequest() *GeminiChatRequest {
+	if r.GenerateContentRequest != nil {
+		return r.GenerateContentRequest
+	}
+	return &GeminiChatRequest{Contents: r.Contents}
+}
+
+type GeminiCountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// GeminiCountTokensUpstreamRequest 转发给 Gemini API 的计数请求，generateContentRequest 中必须携带 model
+type GeminiCountTokensUpstreamRequest struct {
+	GenerateContentRequest GeminiGenerateContentCountRequest `json:"generateContentRequest"`
+}
+
+type GeminiGenerateContentCountRequest struct {
+	Model string `json:"model"`
+	*GeminiChatRequest
+}
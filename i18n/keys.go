@@ -42,19 +42,25 @@ const (
 
 // Token related messages
 const (
-	MsgTokenNameTooLong          = "token.name_too_long"
-	MsgTokenQuotaNegative        = "token.quota_negative"
-	MsgTokenQuotaExceedMax       = "token.quota_exceed_max"
-	MsgTokenGenerateFailed       = "token.generate_failed"
-	MsgTokenGetInfoFailed        = "token.get_info_failed"
-	MsgTokenExpiredCannotEnable  = "token.expired_cannot_enable"
-	MsgTokenExhaustedCannotEable = "token.exhausted_cannot_enable"
-	MsgTokenInvalid              = "token.invalid"
-	MsgTokenNotProvided          = "token.not_provided"
-	MsgTokenExpired              = "token.expired"
-	MsgTokenExhausted            = "token.exhausted"
-	MsgTokenStatusUnavailable    = "token.status_unavailable"
-	MsgTokenDbError              = "token.db_error"
+	MsgTokenNameTooLong             = "token.name_too_long"
+	MsgTokenQuotaNegative           = "token.quota_negative"
+	MsgTokenQuotaExceedMax          = "token.quota_exceed_max"
+	MsgTokenGenerateFailed          = "token.generate_failed"
+	MsgTokenGetInfoFailed           = "token.get_info_failed"
+	MsgTokenExpiredCannotEnable     = "token.expired_cannot_enable"
+	MsgTokenExhaustedCannotEable    = "token.exhausted_cannot_enable"
+	MsgTokenInvalid                 = "token.invalid"
+	MsgTokenNotProvided             = "token.not_provided"
+	MsgTokenExpired                 = "token.expired"
+	MsgTokenExhausted               = "token.exhausted"
+	MsgTokenStatusUnavailable       = "token.status_unavailable"
+	MsgTokenDbError                 = "token.db_error"
+	MsgTokenLimitNegative           = "token.limit_negative"
+	MsgTokenBudgetInvalid           = "token.budget_invalid"
+	MsgTokenOrgNotMember            = "token.org_not_member"
+	MsgTokenKeyExactSearchOnly      = "token.key_exact_search_only"
+	MsgTokenRestrictionsInvalid     = "token.restrictions_invalid"
+	MsgTokenParamOverrideNotAllowed = "token.param_override_not_allowed"
 )
 
 // Redemption related messages
@@ -329,3 +335,23 @@ const (
 	MsgCustomOAuthBindingNotFound   = "custom_oauth.binding_not_found"
 	MsgCustomOAuthProviderIdInvalid = "custom_oauth.provider_id_field_invalid"
 )
+
+// Organization related messages
+const (
+	MsgOrgNotFound             = "organization.not_found"
+	MsgOrgAdminRequired        = "organization.admin_required"
+	MsgOrgNameInvalid          = "organization.name_invalid"
+	MsgOrgOwnerOnlyDelete      = "organization.owner_only_delete"
+	MsgOrgOwnerOnlyTransfer    = "organization.owner_only_transfer"
+	MsgOrgTargetNotMember      = "organization.target_not_member"
+	MsgOrgMemberNotFound       = "organization.member_not_found"
+	MsgOrgAdminManageMembers   = "organization.admin_manage_members_only"
+	MsgOrgOwnerOnlyRole        = "organization.owner_only_role"
+	MsgOrgRoleUseTransfer      = "organization.role_use_transfer"
+	MsgOrgMemberLimitNegative  = "organization.member_limit_negative"
+	MsgOrgCannotRemoveOwner    = "organization.cannot_remove_owner"
+	MsgOrgInvalidEmail         = "organization.invalid_email"
+	MsgOrgInvalidRole          = "organization.invalid_role"
+	MsgOrgOwnerOnlyInviteAdmin = "organization.owner_only_invite_admin"
+	MsgOrgEmailRequired        = "organization.email_required"
+)
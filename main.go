@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -19,6 +20,7 @@ import (
 	"github.com/QuantumNous/new-api/middleware"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/oauth"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	"github.com/QuantumNous/new-api/relay"
 	"github.com/QuantumNous/new-api/router"
 	"github.com/QuantumNous/new-api/service"
@@ -49,6 +51,15 @@ func main() {
 		return
 	}
 
+	if *common.ReencryptChannelKeys {
+		updated, err := model.ReencryptChannelKeys()
+		if err != nil {
+			common.FatalLog("failed to re-encrypt channel keys: " + err.Error())
+		}
+		common.SysLog(fmt.Sprintf("re-encrypted %d channel keys", updated))
+		return
+	}
+
 	common.SysLog("New API " + common.Version + " started")
 	if os.Getenv("GIN_MODE") != "debug" {
 		gin.SetMode(gin.ReleaseMode)
@@ -112,6 +123,19 @@ func main() {
 	// Subscription quota reset task (daily/weekly/monthly/custom)
 	service.StartSubscriptionQuotaResetTask()
 
+	// Payload capture retention cleanup
+	service.StartPayloadCaptureCleanupTask()
+
+	// Log export file cleanup and external log sink shipping
+	service.StartLogExportCleanupTask()
+	service.StartLogSinkTask()
+
+	// Postpaid invoice generation, overdue marking and suspension
+	service.StartInvoiceTask()
+
+	// Propagate channel breaker resets issued on other nodes
+	service.StartChannelBreakerResetListener()
+
 	// Wire task polling adaptor factory (breaks service -> relay import cycle)
 	service.GetTaskAdaptorFunc = func(platform constant.TaskPlatform) service.TaskPollingAdaptor {
 		a := relay.GetTaskAdaptor(platform)
@@ -151,6 +175,14 @@ func main() {
 		common.SysError(fmt.Sprintf("start pyroscope error : %v", err))
 	}
 
+	shutdownTracing, err := tracing.Start()
+	if err != nil {
+		common.SysError(fmt.Sprintf("start tracing error : %v", err))
+	}
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
+
 	// Initialize HTTP server
 	server := gin.New()
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
@@ -184,6 +216,11 @@ func main() {
 
 	// 设置路由
 	router.SetRouter(server, buildFS, indexPage)
+
+	// Batch worker replays each batch line through the router in-process
+	service.BatchRelayHandler = server
+	service.StartBatchWorker()
+
 	var port = os.Getenv("PORT")
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
@@ -13,6 +13,8 @@ import (
 	"github.com/QuantumNous/new-api/i18n"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/tracing"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
@@ -153,6 +155,13 @@ func authHelper(c *gin.Context, minRole int) {
 	c.Set("user_group", session.Get("group"))
 	c.Set("use_access_token", useAccessToken)
 
+	// 记录管理员的修改类操作
+	if service.ShouldAuditRequest(c, role.(int)) {
+		audit := service.BeginAdminAudit(c)
+		c.Next()
+		audit.Finish(c)
+		return
+	}
 	c.Next()
 }
 
@@ -266,6 +275,15 @@ func TokenAuthReadOnly() func(c *gin.Context) {
 			return
 		}
 
+		if !token.GetRestrictions().AllowsScope(types.TokenScopeUsage) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"message": "该令牌无权查询用量",
+			})
+			c.Abort()
+			return
+		}
+
 		c.Set("id", token.UserId)
 		c.Set("token_id", token.Id)
 		c.Set("token_key", token.Key)
@@ -275,6 +293,8 @@ func TokenAuthReadOnly() func(c *gin.Context) {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span := tracing.StartSpan(c, "token_auth")
+		defer span.End()
 		// 先检测是否为ws
 		if c.Request.Header.Get("Sec-WebSocket-Protocol") != "" {
 			// Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1
@@ -329,7 +349,15 @@ func TokenAuth() func(c *gin.Context) {
 			parts = strings.Split(key, "-")
 			key = parts[0]
 		}
-		token, err := model.ValidateUserToken(key)
+		// 批处理 worker 在进程内执行的请求按令牌 id 鉴权，数据库中不保存明文 key
+		batchLine := common.GetBatchLine(c.Request.Context())
+		var token *model.Token
+		var err error
+		if batchLine != nil && batchLine.TokenId > 0 {
+			token, err = model.ValidateUserTokenById(batchLine.TokenId)
+		} else {
+			token, err = model.ValidateUserToken(key)
+		}
 		if token != nil {
 			id := c.GetInt("id")
 			if id == 0 {
@@ -348,8 +376,13 @@ func TokenAuth() func(c *gin.Context) {
 			return
 		}
 
+		// 批处理 worker 在进程内执行的请求没有客户端 IP，创建批处理时已校验过
+		if batchLine != nil {
+			common.SetContextKey(c, constant.ContextKeyBatchId, batchLine.BatchId)
+		}
+
 		allowIps := token.GetIpLimits()
-		if len(allowIps) > 0 {
+		if len(allowIps) > 0 && batchLine == nil {
 			clientIp := c.ClientIP()
 			logger.LogDebug(c, "Token has IP restrictions, checking client IP %s", clientIp)
 			ip := net.ParseIP(clientIp)
@@ -402,6 +435,14 @@ func TokenAuth() func(c *gin.Context) {
 		if err != nil {
 			return
 		}
+		// 上游原生批处理的结算请求固定使用执行该批处理的渠道
+		if batchLine != nil && batchLine.ChannelId > 0 {
+			c.Set("specific_channel_id", strconv.Itoa(batchLine.ChannelId))
+		}
+		if !checkTokenScope(c, token.GetRestrictions()) {
+			return
+		}
+		span.End()
 		c.Next()
 	}
 }
@@ -426,6 +467,22 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	common.SetContextKey(c, constant.ContextKeyTokenTpmLimit, token.TpmLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenConcurrencyLimit, token.ConcurrencyLimit)
+	common.SetContextKey(c, constant.ContextKeyTokenBudget, token.GetBudgetWindow())
+	common.SetContextKey(c, constant.ContextKeyTokenOrgId, token.OrgId)
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCache, token.ResponseCache)
+	common.SetContextKey(c, constant.ContextKeyTokenPayloadCapture, token.PayloadCapture)
+	common.SetContextKey(c, constant.ContextKeyTokenRestrictions, token.GetRestrictions())
+	if paramOverride := token.GetParamOverride(); len(paramOverride) > 0 {
+		// 令牌所有者不再是管理员时，之前保存的策略也必须满足白名单
+		if err := relaycommon.ValidateTokenParamOverride(paramOverride); err != nil && !model.IsAdmin(token.UserId) {
+			message := common.TranslateMessage(c, i18n.MsgTokenParamOverrideNotAllowed, map[string]any{"Error": err.Error()})
+			abortWithOpenAiMessage(c, http.StatusForbidden, message, types.ErrorCodeAccessDenied)
+			return errors.New(message)
+		}
+		common.SetContextKey(c, constant.ContextKeyTokenParamOverride, paramOverride)
+	}
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	usageLimitKindTokens   = "tokens"
+	usageLimitKindRequests = "requests"
+)
+
+type usageLimitScope struct {
+	name  string // token / user / group / model
+	id    string
+	limit operation_setting.UsageLimit
+}
+
+func (s usageLimitScope) tpmKey() string {
+	return fmt.Sprintf("usageLimit:tpm:%s:%s", s.name, s.id)
+}
+
+func (s usageLimitScope) concurrencyKey() string {
+	return fmt.Sprintf("usageLimit:concurrency:%s:%s", s.name, s.id)
+}
+
+// collectUsageLimitScopes 收集当前请求适用的全部限制维度，令牌维度始终生效，其余维度需启用配置
+func collectUsageLimitScopes(c *gin.Context) []usageLimitScope {
+	scopes := make([]usageLimitScope, 0, 4)
+	tokenLimit := operation_setting.UsageLimit{
+		TPM:         common.GetContextKeyInt(c, constant.ContextKeyTokenTpmLimit),
+		Concurrency: common.GetContextKeyInt(c, constant.ContextKeyTokenConcurrencyLimit),
+	}
+	if tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId); tokenId > 0 && !tokenLimit.IsEmpty() {
+		scopes = append(scopes, usageLimitScope{name: "token", id: strconv.Itoa(tokenId), limit: tokenLimit})
+	}
+
+	setting := operation_setting.GetUsageLimitSetting()
+	if !setting.Enabled {
+		return scopes
+	}
+	if userId := common.GetContextKeyInt(c, constant.ContextKeyUserId); userId > 0 {
+		if limit := setting.GetUserLimit(userId); !limit.IsEmpty() {
+			scopes = append(scopes, usageLimitScope{name: "user", id: strconv.Itoa(userId), limit: limit})
+		}
+	}
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
+	}
+	if group == "" {
+		group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+	}
+	if group != "" {
+		if limit := setting.GetGroupLimit(group); !limit.IsEmpty() {
+			scopes = append(scopes, usageLimitScope{name: "group", id: group, limit: limit})
+		}
+	}
+	if modelName := common.GetContextKeyString(c, constant.ContextKeyOriginalModel); modelName != "" {
+		if limit := setting.GetModelLimit(modelName); !limit.IsEmpty() {
+			scopes = append(scopes, usageLimitScope{name: "model", id: modelName, limit: limit})
+		}
+	}
+	return scopes
+}
+
+// ModelUsageLimit TPM 与并发数限制中间件，需放在 Distribute 之后以获取模型与分组
+func ModelUsageLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		// token 计数请求不产生用量，不占用 TPM 与并发额度
+		if relayconstant.Path2RelayMode(c.Request.URL.Path) == relayconstant.RelayModeCountTokens {
+			c.Next()
+			return
+		}
+		scopes := collectUsageLimitScopes(c)
+		if len(scopes) == 0 {
+			c.Next()
+			return
+		}
+		ctx := context.Background()
+		usageLimiter := limiter.GetUsageLimiter()
+
+		// 1. 检查 TPM，用量在请求结束后才结算，因此这里只判断窗口内是否已超限
+		for _, scope := range scopes {
+			if scope.limit.TPM <= 0 {
+				continue
+			}
+			used, reset, err := usageLimiter.TPMUsage(ctx, scope.tpmKey())
+			if err != nil {
+				logger.LogError(c, "检查 TPM 限制失败: "+err.Error())
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return
+			}
+			if used >= int64(scope.limit.TPM) {
+				abortWithUsageLimit(c, usageLimitKindTokens, int64(scope.limit.TPM), 0, reset,
+					fmt.Sprintf("已达到%s的 TPM 限制：每分钟最多 %d tokens", usageLimitScopeLabel(scope.name), scope.limit.TPM))
+				return
+			}
+		}
+
+		// 2. 占用并发名额，任一维度失败时释放已占用的名额
+		acquired := make([]string, 0, len(scopes))
+		releaseAll := func() {
+			for _, key := range acquired {
+				if err := usageLimiter.ReleaseConcurrency(ctx, key); err != nil {
+					logger.LogError(c, "释放并发名额失败: "+err.Error())
+				}
+			}
+		}
+		for _, scope := range scopes {
+			if scope.limit.Concurrency <= 0 {
+				continue
+			}
+			ok, _, err := usageLimiter.AcquireConcurrency(ctx, scope.concurrencyKey(), scope.limit.Concurrency)
+			if err != nil {
+				releaseAll()
+				logger.LogError(c, "检查并发限制失败: "+err.Error())
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return
+			}
+			if !ok {
+				releaseAll()
+				abortWithUsageLimit(c, usageLimitKindRequests, int64(scope.limit.Concurrency), 0, time.Second,
+					fmt.Sprintf("已达到%s的并发限制：最多同时进行 %d 个请求", usageLimitScopeLabel(scope.name), scope.limit.Concurrency))
+				return
+			}
+			acquired = append(acquired, scope.concurrencyKey())
+		}
+		defer releaseAll()
+
+		c.Next()
+
+		// 3. 按实际结算的 token 数计入 TPM
+		tokens := common.GetContextKeyInt(c, constant.ContextKeyConsumedTokens)
+		if tokens <= 0 {
+			return
+		}
+		for _, scope := range scopes {
+			if scope.limit.TPM <= 0 {
+				continue
+			}
+			if err := usageLimiter.AddTPMUsage(ctx, scope.tpmKey(), int64(tokens)); err != nil {
+				logger.LogError(c, "记录 TPM 用量失败: "+err.Error())
+			}
+		}
+	}
+}
+
+func usageLimitScopeLabel(name string) string {
+	switch name {
+	case "token":
+		return "令牌"
+	case "user":
+		return "用户"
+	case "group":
+		return "分组"
+	case "model":
+		return "模型"
+	}
+	return name
+}
+
+// abortWithUsageLimit 以 429 拒绝请求，并按 OpenAI / Claude 的格式返回限流响应头与错误体
+func abortWithUsageLimit(c *gin.Context, kind string, limit int64, remaining int64, reset time.Duration, message string) {
+	retryAfter := int64(math.Ceil(reset.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("retry-after", strconv.FormatInt(retryAfter, 10))
+	c.Header("x-ratelimit-limit-"+kind, strconv.FormatInt(limit, 10))
+	c.Header("x-ratelimit-remaining-"+kind, strconv.FormatInt(remaining, 10))
+	c.Header("x-ratelimit-reset-"+kind, fmt.Sprintf("%ds", retryAfter))
+
+	message = common.MessageWithRequestId(message, c.GetString(common.RequestIdKey))
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/messages") {
+		resetAt := time.Now().Add(time.Duration(retryAfter) * time.Second).UTC().Format(time.RFC3339)
+		c.Header("anthropic-ratelimit-"+kind+"-limit", strconv.FormatInt(limit, 10))
+		c.Header("anthropic-ratelimit-"+kind+"-remaining", strconv.FormatInt(remaining, 10))
+		c.Header("anthropic-ratelimit-"+kind+"-reset", resetAt)
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"type": "error",
+			"error": types.ClaudeError{
+				Type:    "rate_limit_error",
+				Message: message,
+			},
+		})
+	} else {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": types.OpenAIError{
+				Message: message,
+				Type:    kind,
+				Code:    "rate_limit_exceeded",
+			},
+		})
+	}
+	c.Abort()
+	logger.LogWarn(c, message)
+}
@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenScopeForRequest 返回请求所属的接口范围，空字符串表示不受接口范围限制（如模型列表）
+func tokenScopeForRequest(method string, path string) string {
+	switch {
+	case strings.HasPrefix(path, "/dashboard"), strings.HasPrefix(path, "/v1/dashboard"):
+		return types.TokenScopeUsage
+	case strings.HasPrefix(path, "/v1/messages"):
+		return types.TokenScopeChat
+	case strings.HasPrefix(path, "/v1/files"), strings.HasPrefix(path, "/v1/batches"):
+		return types.TokenScopeBatch
+	case strings.HasPrefix(path, "/v1/video"), strings.HasPrefix(path, "/kling"), strings.HasPrefix(path, "/jimeng"):
+		return types.TokenScopeVideo
+	case strings.HasPrefix(path, "/suno"):
+		return types.TokenScopeAudio
+	case strings.HasPrefix(path, "/mj/") || strings.Contains(path, "/mj/"):
+		return types.TokenScopeImages
+	}
+
+	switch relayconstant.Path2RelayMode(path) {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions, relayconstant.RelayModeEdits,
+		relayconstant.RelayModeResponses, relayconstant.RelayModeResponsesCompact, relayconstant.RelayModeCountTokens:
+		return types.TokenScopeChat
+	case relayconstant.RelayModeEmbeddings:
+		return types.TokenScopeEmbeddings
+	case relayconstant.RelayModeModerations:
+		return types.TokenScopeModerations
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits:
+		return types.TokenScopeImages
+	case relayconstant.RelayModeAudioSpeech, relayconstant.RelayModeAudioTranscription, relayconstant.RelayModeAudioTranslation:
+		return types.TokenScopeAudio
+	case relayconstant.RelayModeRerank:
+		return types.TokenScopeRerank
+	case relayconstant.RelayModeRealtime:
+		return types.TokenScopeRealtime
+	case relayconstant.RelayModeGemini:
+		if method == http.MethodGet {
+			// 模型列表与模型详情
+			return ""
+		}
+		switch {
+		case strings.HasSuffix(path, ":embedContent"), strings.HasSuffix(path, ":batchEmbedContents"):
+			return types.TokenScopeEmbeddings
+		case strings.HasSuffix(path, ":predict"), strings.HasSuffix(path, ":predictLongRunning"):
+			return types.TokenScopeImages
+		}
+		return types.TokenScopeChat
+	}
+	return ""
+}
+
+// checkTokenScope 令牌配置了接口范围时，只允许访问范围内的接口
+func checkTokenScope(c *gin.Context, restrictions types.TokenRestrictions) bool {
+	scope := tokenScopeForRequest(c.Request.Method, c.Request.URL.Path)
+	if scope == "" || restrictions.AllowsScope(scope) {
+		return true
+	}
+	abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权访问 %s 类接口", scope), types.ErrorCodeAccessDenied)
+	return false
+}
+
+// checkTokenRequestLimits 检查请求体中的 max_tokens 与禁用特性，在选择渠道与预扣费之前执行
+func checkTokenRequestLimits(c *gin.Context) bool {
+	restrictions, ok := common.GetContextKeyType[types.TokenRestrictions](c, constant.ContextKeyTokenRestrictions)
+	if !ok || !restrictions.HasRequestLimits() {
+		return true
+	}
+	if !strings.HasPrefix(c.ContentType(), "application/json") {
+		return true
+	}
+	var body map[string]any
+	if err := common.UnmarshalBodyReusable(c, &body); err != nil || body == nil {
+		return true
+	}
+	for _, feature := range detectRequestFeatures(body) {
+		if restrictions.DisallowsFeature(feature) {
+			abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌禁止在请求中使用 %s", feature), types.ErrorCodeAccessDenied)
+			return false
+		}
+	}
+	scope := tokenScopeForRequest(c.Request.Method, c.Request.URL.Path)
+	if restrictions.MaxTokens > 0 && scope == types.TokenScopeChat && !strings.HasPrefix(c.Request.URL.Path, "/v1/messages/count_tokens") &&
+		!strings.HasSuffix(c.Request.URL.Path, ":countTokens") {
+		maxTokens, found := requestMaxTokens(body)
+		if !found || maxTokens > restrictions.MaxTokens {
+			// 未指定时上游可能使用很大的默认值，因此要求显式指定
+			abortWithOpenAiMessage(c, http.StatusBadRequest,
+				fmt.Sprintf("该令牌要求请求显式指定 max_tokens 且不超过 %d", restrictions.MaxTokens), types.ErrorCodeAccessDenied)
+			return false
+		}
+	}
+	return true
+}
+
+// requestMaxTokens 读取各格式请求中的最大输出 token 数
+func requestMaxTokens(body map[string]any) (int, bool) {
+	for _, key := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens"} {
+		if value, ok := body[key].(float64); ok {
+			return int(value), true
+		}
+	}
+	for _, configKey := range []string{"generationConfig", "generation_config"} {
+		config, ok := body[configKey].(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"maxOutputTokens", "max_output_tokens"} {
+			if value, ok := config[key].(float64); ok {
+				return int(value), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// detectRequestFeatures 识别 OpenAI、Claude 与 Gemini 格式请求中使用的工具、联网搜索与文件输入
+func detectRequestFeatures(body map[string]any) []string {
+	features := make(map[string]bool)
+	if _, ok := body["web_search_options"]; ok {
+		features[types.TokenFeatureWebSearch] = true
+	}
+	if functions, ok := body["functions"].([]any); ok && len(functions) > 0 {
+		features[types.TokenFeatureTools] = true
+	}
+	if tools, ok := body["tools"].([]any); ok {
+		for _, tool := range tools {
+			toolMap, ok := tool.(map[string]any)
+			if !ok {
+				continue
+			}
+			if isWebSearchTool(toolMap) {
+				features[types.TokenFeatureWebSearch] = true
+			} else {
+				features[types.TokenFeatureTools] = true
+			}
+		}
+	}
+	if containsFileInput(body) {
+		features[types.TokenFeatureFileInput] = true
+	}
+	result := make([]string, 0, len(features))
+	for _, feature := range types.TokenFeatures {
+		if features[feature] {
+			result = append(result, feature)
+		}
+	}
+	return result
+}
+
+func isWebSearchTool(tool map[string]any) bool {
+	if toolType, ok := tool["type"].(string); ok && strings.HasPrefix(toolType, "web_search") {
+		return true
+	}
+	for _, key := range []string{"googleSearch", "google_search", "googleSearchRetrieval", "google_search_retrieval"} {
+		if _, ok := tool[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsFileInput 递归查找文件类型的内容块
+func containsFileInput(value any) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		if partType, ok := v["type"].(string); ok {
+			switch partType {
+			case "file", "input_file", "document":
+				return true
+			}
+		}
+		if _, ok := v["fileData"]; ok {
+			return true
+		}
+		if _, ok := v["file_data"]; ok {
+			return true
+		}
+		for _, key := range []string{"inlineData", "inline_data"} {
+			if inline, ok := v[key].(map[string]any); ok {
+				mimeType, _ := inline["mimeType"].(string)
+				if mimeType == "" {
+					mimeType, _ = inline["mime_type"].(string)
+				}
+				if !strings.HasPrefix(mimeType, "image/") {
+					return true
+				}
+			}
+		}
+		for _, child := range v {
+			if containsFileInput(child) {
+				return true
+			}
+		}
+	case []any:
+		for _, child := range v {
+			if containsFileInput(child) {
+				return true
+			}
+		}
+	}
+	return false
+}
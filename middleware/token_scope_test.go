@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenScopeForRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   string
+	}{
+		{"chat completions", http.MethodPost, "/v1/chat/completions", types.TokenScopeChat},
+		{"completions", http.MethodPost, "/v1/completions", types.TokenScopeChat},
+		{"claude messages", http.MethodPost, "/v1/messages", types.TokenScopeChat},
+		{"responses", http.MethodPost, "/v1/responses", types.TokenScopeChat},
+		{"responses compact", http.MethodPost, "/v1/responses/compact", types.TokenScopeChat},
+		{"embeddings", http.MethodPost, "/v1/embeddings", types.TokenScopeEmbeddings},
+		{"moderations", http.MethodPost, "/v1/moderations", types.TokenScopeModerations},
+		{"image generations", http.MethodPost, "/v1/images/generations", types.TokenScopeImages},
+		{"image edits", http.MethodPost, "/v1/images/edits", types.TokenScopeImages},
+		{"audio speech", http.MethodPost, "/v1/audio/speech", types.TokenScopeAudio},
+		{"audio transcriptions", http.MethodPost, "/v1/audio/transcriptions", types.TokenScopeAudio},
+		{"rerank", http.MethodPost, "/v1/rerank", types.TokenScopeRerank},
+		{"realtime", http.MethodGet, "/v1/realtime", types.TokenScopeRealtime},
+		{"suno task", http.MethodPost, "/suno/submit/music", types.TokenScopeAudio},
+		{"video task", http.MethodPost, "/v1/video/generations", types.TokenScopeVideo},
+		{"kling task", http.MethodPost, "/kling/v1/videos/text2video", types.TokenScopeVideo},
+		{"jimeng task", http.MethodPost, "/jimeng/", types.TokenScopeVideo},
+		{"mj submit", http.MethodPost, "/mj/submit/imagine", types.TokenScopeImages},
+		{"mj with mode prefix", http.MethodPost, "/fast/mj/submit/imagine", types.TokenScopeImages},
+		{"batch files", http.MethodPost, "/v1/files", types.TokenScopeBatch},
+		{"batches", http.MethodGet, "/v1/batches/batch_1", types.TokenScopeBatch},
+		{"usage dashboard", http.MethodGet, "/v1/dashboard/billing/usage", types.TokenScopeUsage},
+		{"gemini generate content", http.MethodPost, "/v1beta/models/gemini-2.5-pro:generateContent", types.TokenScopeChat},
+		{"gemini stream generate content", http.MethodPost, "/v1beta/models/gemini-2.5-pro:streamGenerateContent", types.TokenScopeChat},
+		{"gemini embed content", http.MethodPost, "/v1beta/models/text-embedding-004:embedContent", types.TokenScopeEmbeddings},
+		{"gemini predict", http.MethodPost, "/v1beta/models/imagen-3.0:predict", types.TokenScopeImages},
+		{"gemini model list", http.MethodGet, "/v1beta/models", ""},
+		{"model list", http.MethodGet, "/v1/models", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tokenScopeForRequest(tt.method, tt.path))
+		})
+	}
+}
+
+func TestCheckTokenScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		path    string
+		scopes  []string
+		allowed bool
+	}{
+		{"no scopes allows everything", "/v1/embeddings", nil, true},
+		{"scope in list", "/v1/chat/completions", []string{types.TokenScopeChat}, true},
+		{"scope not in list", "/v1/embeddings", []string{types.TokenScopeChat}, false},
+		{"unscoped route always allowed", "/v1/models", []string{types.TokenScopeChat}, true},
+		{"mj requires images", "/mj/submit/imagine", []string{types.TokenScopeChat}, false},
+		{"gemini generate content is chat", "/v1beta/models/gemini-2.5-pro:generateContent", []string{types.TokenScopeChat}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, tt.path, nil)
+
+			allowed := checkTokenScope(c, types.TokenRestrictions{Scopes: tt.scopes})
+			assert.Equal(t, tt.allowed, allowed)
+			if !tt.allowed {
+				assert.Equal(t, http.StatusForbidden, recorder.Code)
+				assert.Contains(t, recorder.Body.String(), string(types.ErrorCodeAccessDenied))
+			}
+		})
+	}
+}
+
+func TestDetectRequestFeatures(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"plain chat", `{"model":"m","messages":[{"role":"user","content":"hi"}]}`, []string{}},
+		{"stream is not a feature", `{"model":"m","stream":true,"stream_options":{"include_usage":true}}`, []string{}},
+		{"openai tools", `{"tools":[{"type":"function","function":{"name":"f"}}]}`, []string{types.TokenFeatureTools}},
+		{"legacy functions", `{"functions":[{"name":"f"}]}`, []string{types.TokenFeatureTools}},
+		{"claude tools", `{"tools":[{"name":"f","input_schema":{}}]}`, []string{types.TokenFeatureTools}},
+		{"gemini function declarations", `{"tools":[{"functionDeclarations":[{"name":"f"}]}]}`, []string{types.TokenFeatureTools}},
+		{"responses web search", `{"tools":[{"type":"web_search_preview"}]}`, []string{types.TokenFeatureWebSearch}},
+		{"web search options", `{"web_search_options":{}}`, []string{types.TokenFeatureWebSearch}},
+		{"gemini google search", `{"tools":[{"googleSearch":{}}]}`, []string{types.TokenFeatureWebSearch}},
+		{"tools and web search", `{"tools":[{"type":"function"},{"type":"web_search"}]}`, []string{types.TokenFeatureTools, types.TokenFeatureWebSearch}},
+		{"vision image_url is not file input", `{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"https://x/y.png"}}]}]}`, []string{}},
+		{"claude image is not file input", `{"messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64"}}]}]}`, []string{}},
+		{"gemini inline image is not file input", `{"contents":[{"parts":[{"inlineData":{"mimeType":"image/png","data":"x"}}]}]}`, []string{}},
+		{"openai file part", `{"messages":[{"role":"user","content":[{"type":"file","file":{"file_id":"f"}}]}]}`, []string{types.TokenFeatureFileInput}},
+		{"responses input file", `{"input":[{"role":"user","content":[{"type":"input_file","file_id":"f"}]}]}`, []string{types.TokenFeatureFileInput}},
+		{"claude document", `{"messages":[{"role":"user","content":[{"type":"document","source":{}}]}]}`, []string{types.TokenFeatureFileInput}},
+		{"gemini inline pdf", `{"contents":[{"parts":[{"inlineData":{"mimeType":"application/pdf","data":"x"}}]}]}`, []string{types.TokenFeatureFileInput}},
+		{"gemini file data", `{"contents":[{"parts":[{"fileData":{"fileUri":"gs://x"}}]}]}`, []string{types.TokenFeatureFileInput}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]any
+			require.NoError(t, common.UnmarshalJsonStr(tt.body, &body))
+			assert.Equal(t, tt.want, detectRequestFeatures(body))
+		})
+	}
+}
+
+func TestRequestMaxTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		want      int
+		wantFound bool
+	}{
+		{"missing", `{"model":"m"}`, 0, false},
+		{"chat max_tokens", `{"max_tokens":256}`, 256, true},
+		{"chat max_completion_tokens", `{"max_completion_tokens":512}`, 512, true},
+		{"responses max_output_tokens", `{"max_output_tokens":1024}`, 1024, true},
+		{"gemini generationConfig", `{"generationConfig":{"maxOutputTokens":2048}}`, 2048, true},
+		{"gemini snake case", `{"generation_config":{"max_output_tokens":64}}`, 64, true},
+		{"non-numeric value", `{"max_tokens":"100"}`, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]any
+			require.NoError(t, common.UnmarshalJsonStr(tt.body, &body))
+			got, found := requestMaxTokens(body)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantFound, found)
+		})
+	}
+}
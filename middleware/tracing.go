@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Tracing 为 relay 请求创建根 span，后续各阶段的 span 都挂在它下面
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		span := tracing.StartRequestSpan(c)
+		if span == nil {
+			c.Next()
+			return
+		}
+		defer span.End()
+
+		c.Next()
+
+		span.SetAttributes(
+			attribute.Int("user.id", common.GetContextKeyInt(c, constant.ContextKeyUserId)),
+			attribute.Int("token.id", common.GetContextKeyInt(c, constant.ContextKeyTokenId)),
+			attribute.String("group", common.GetContextKeyString(c, constant.ContextKeyUsingGroup)),
+			attribute.String("model", common.GetContextKeyString(c, constant.ContextKeyOriginalModel)),
+			attribute.Int("channel.id", common.GetContextKeyInt(c, constant.ContextKeyChannelId)),
+		)
+		span.SetHTTPStatus(c.Writer.Status())
+	}
+}
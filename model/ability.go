@@ -119,6 +119,10 @@ func GetChannel(group string, model string, retry int) (*Channel, error) {
 	if err != nil {
 		return nil, err
 	}
+	abilities = filterGuardedAbilities(abilities)
+	if picked := pickAbilityChannelByStrategy(group, abilities); picked != nil {
+		return picked, nil
+	}
 	channel := Channel{}
 	if len(abilities) > 0 {
 		// Randomly choose one
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditTargetChannel             = "channel"
+	AuditTargetToken               = "token"
+	AuditTargetUser                = "user"
+	AuditTargetOption              = "option"
+	AuditTargetSubscriptionPlan    = "subscription_plan"
+	AuditTargetRedemption          = "redemption"
+	AuditTargetCustomOAuthProvider = "custom_oauth_provider"
+	AuditTargetOther               = "other"
+)
+
+var ErrAuditLogNotFound = errors.New("审计日志不存在")
+
+// AuditLog 管理操作审计日志，记录操作人、目标对象以及修改前后的内容（敏感字段已脱敏）
+type AuditLog struct {
+	Id         int    `json:"id"`
+	ActorId    int    `json:"actor_id" gorm:"index"`
+	ActorName  string `json:"actor_name" gorm:"type:varchar(64);default:''"`
+	ActorRole  int    `json:"actor_role"`
+	Ip         string `json:"ip" gorm:"type:varchar(64);default:''"`
+	Method     string `json:"method" gorm:"type:varchar(16)"`
+	Route      string `json:"route" gorm:"type:varchar(255)"`
+	Path       string `json:"path" gorm:"type:varchar(255)"`
+	TargetType string `json:"target_type" gorm:"type:varchar(32);index:idx_audit_target,priority:1"`
+	TargetId   string `json:"target_id" gorm:"type:varchar(255);index:idx_audit_target,priority:2;default:''"`
+	Before     string `json:"before" gorm:"type:text"`
+	After      string `json:"after" gorm:"type:text"`
+	Diff       string `json:"diff" gorm:"type:text"`
+	StatusCode int    `json:"status_code"`
+	Success    bool   `json:"success"`
+	Message    string `json:"message" gorm:"type:text"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+type AuditLogQuery struct {
+	ActorId        int
+	TargetType     string
+	TargetId       string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func RecordAuditLog(log *AuditLog) error {
+	return LOG_DB.Create(log).Error
+}
+
+func GetAuditLogs(query AuditLogQuery, startIdx int, num int) (logs []*AuditLog, total int64, err error) {
+	tx := LOG_DB.Model(&AuditLog{})
+	if query.ActorId != 0 {
+		tx = tx.Where("actor_id = ?", query.ActorId)
+	}
+	if query.TargetType != "" {
+		tx = tx.Where("target_type = ?", query.TargetType)
+	}
+	if query.TargetId != "" {
+		tx = tx.Where("target_id = ?", query.TargetId)
+	}
+	if query.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", query.StartTimestamp)
+	}
+	if query.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", query.EndTimestamp)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, total, err
+}
+
+func GetAuditLogById(id int) (*AuditLog, error) {
+	var log AuditLog
+	if err := LOG_DB.First(&log, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAuditLogNotFound
+		}
+		return nil, err
+	}
+	return &log, nil
+}
@@ -0,0 +1,416 @@
+package model
+
+import (
+	"io"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+const (
+	BatchItemStatusPending   = "pending"
+	BatchItemStatusCompleted = "completed"
+	BatchItemStatusFailed    = "failed"
+)
+
+// FileChunkSize 文件内容按块存储的大小，读写时内存中最多只保留一块
+const FileChunkSize = 1 << 20
+
+// File 通过 /v1/files 上传或由批处理生成的文件，内容按块存储在 FileChunk 中，
+// 所有节点都能读取，且读写均为流式
+type File struct {
+	Id        int    `json:"-" gorm:"primaryKey"`
+	FileId    string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32);index"`
+	Filename  string `json:"filename" gorm:"type:varchar(255)"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// FileChunk 文件内容的一块，按 Seq 顺序拼接即为完整内容
+type FileChunk struct {
+	Id     int    `json:"-" gorm:"primaryKey"`
+	FileId string `json:"-" gorm:"type:varchar(64);uniqueIndex:idx_file_chunk_seq,priority:1"`
+	Seq    int    `json:"-" gorm:"uniqueIndex:idx_file_chunk_seq,priority:2"`
+	Data   []byte `json:"-"`
+}
+
+// Batch 批处理任务，状态由 service 中的批处理 worker 推进
+type Batch struct {
+	Id               int    `json:"-" gorm:"primaryKey"`
+	BatchId          string `json:"id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id" gorm:"index"`
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(20);index"`
+	Metadata         string `json:"metadata" gorm:"type:text"`
+	Errors           string `json:"errors" gorm:"type:text"`
+	TotalCount       int    `json:"total_count"`
+	CompletedCount   int    `json:"completed_count"`
+	FailedCount      int    `json:"failed_count"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+	// 上游原生执行时的渠道、多 key 渠道的 key 下标与上游批处理 ID，为空表示由进程内 worker 执行
+	UpstreamChannelId int    `json:"upstream_channel_id,omitempty"`
+	UpstreamKeyIndex  int    `json:"-"`
+	UpstreamBatchId   string `json:"-" gorm:"type:varchar(128)"`
+	// 上游原生执行前按估算预扣的额度及其资金来源，上游结束后退还，再逐行按实际用量结算
+	ReservedQuota  int    `json:"-"`
+	BillingSource  string `json:"-" gorm:"type:varchar(32)"`
+	SubscriptionId int    `json:"-"`
+	OrgId          int    `json:"-"`
+}
+
+// BatchItem 批处理中的单个请求行，输出文件生成后删除
+type BatchItem struct {
+	Id        int    `json:"id" gorm:"primaryKey"`
+	BatchId   string `json:"batch_id" gorm:"type:varchar(64);index:idx_batch_item_status,priority:1"`
+	LineIndex int    `json:"line_index"`
+	CustomId  string `json:"custom_id" gorm:"type:varchar(255)"`
+	Status    string `json:"status" gorm:"type:varchar(20);index:idx_batch_item_status,priority:2"`
+	Attempts  int    `json:"attempts"`
+	Body      []byte `json:"-"`
+	Output    []byte `json:"-"`
+	// UpstreamResponse 上游原生执行返回的响应体，仅在结算时使用，不落库
+	UpstreamResponse []byte `json:"-" gorm:"-"`
+}
+
+func (batch *Batch) IsFinished() bool {
+	switch batch.Status {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (file *File) Insert() error {
+	return DB.Create(file).Error
+}
+
+// GetUserFile 获取用户文件的元数据
+func GetUserFile(userId int, fileId string) (*File, error) {
+	var file File
+	err := DB.Where("file_id = ? AND user_id = ?", fileId, userId).First(&file).Error
+	return &file, err
+}
+
+// FileContentWriter 将内容按块写入 FileChunk，Close 时写入最后一块
+type FileContentWriter struct {
+	fileId  string
+	seq     int
+	buf     []byte
+	written int64
+}
+
+func NewFileContentWriter(fileId string) *FileContentWriter {
+	return &FileContentWriter{fileId: fileId, buf: make([]byte, 0, FileChunkSize)}
+}
+
+func (w *FileContentWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		free := FileChunkSize - len(w.buf)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.buf = append(w.buf, p[:free]...)
+		p = p[free:]
+		n += free
+		if len(w.buf) == FileChunkSize {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	w.written += int64(n)
+	return n, nil
+}
+
+func (w *FileContentWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := DB.Create(&FileChunk{FileId: w.fileId, Seq: w.seq, Data: w.buf}).Error; err != nil {
+		return err
+	}
+	w.seq++
+	w.buf = make([]byte, 0, FileChunkSize)
+	return nil
+}
+
+func (w *FileContentWriter) Close() error {
+	return w.flush()
+}
+
+// Written 返回已写入的字节数
+func (w *FileContentWriter) Written() int64 {
+	return w.written
+}
+
+// SaveFileContent 将 reader 的内容流式写入文件，失败时清理已写入的块
+func SaveFileContent(fileId string, reader io.Reader) (int64, error) {
+	writer := NewFileContentWriter(fileId)
+	_, err := io.Copy(writer, reader)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		_ = DeleteFileChunks(fileId)
+		return 0, err
+	}
+	return writer.Written(), nil
+}
+
+type fileContentReader struct {
+	fileId string
+	seq    int
+	buf    []byte
+	done   bool
+}
+
+// OpenFileContent 按块顺序读取文件内容
+func OpenFileContent(fileId string) io.Reader {
+	return &fileContentReader{fileId: fileId}
+}
+
+func (r *fileContentReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		var chunks []FileChunk
+		if err := DB.Select("data").Where("file_id = ? AND seq = ?", r.fileId, r.seq).Limit(1).Find(&chunks).Error; err != nil {
+			return 0, err
+		}
+		if len(chunks) == 0 {
+			r.done = true
+			continue
+		}
+		r.seq++
+		r.buf = chunks[0].Data
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func DeleteFileChunks(fileId string) error {
+	return DB.Where("file_id = ?", fileId).Delete(&FileChunk{}).Error
+}
+
+// GetUserFiles 按创建时间倒序列出用户文件，after 为上一页最后一个文件 ID
+func GetUserFiles(userId int, purpose string, after string, limit int) ([]*File, error) {
+	var files []*File
+	tx := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		tx = tx.Where("purpose = ?", purpose)
+	}
+	if after != "" {
+		var cursor File
+		if err := DB.Select("id").Where("file_id = ? AND user_id = ?", after, userId).First(&cursor).Error; err != nil {
+			return nil, err
+		}
+		tx = tx.Where("id < ?", cursor.Id)
+	}
+	err := tx.Order("id desc").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+func DeleteUserFile(userId int, fileId string) (bool, error) {
+	result := DB.Where("file_id = ? AND user_id = ?", fileId, userId).Delete(&File{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, DeleteFileChunks(fileId)
+}
+
+func (batch *Batch) Insert() error {
+	return DB.Create(batch).Error
+}
+
+func GetUserBatch(userId int, batchId string) (*Batch, error) {
+	var batch Batch
+	err := DB.Where("batch_id = ? AND user_id = ?", batchId, userId).First(&batch).Error
+	return &batch, err
+}
+
+func GetBatchByBatchId(batchId string) (*Batch, error) {
+	var batch Batch
+	err := DB.Where("batch_id = ?", batchId).First(&batch).Error
+	return &batch, err
+}
+
+func GetUserBatches(userId int, after string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	tx := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var cursor Batch
+		if err := DB.Select("id").Where("batch_id = ? AND user_id = ?", after, userId).First(&cursor).Error; err != nil {
+			return nil, err
+		}
+		tx = tx.Where("id < ?", cursor.Id)
+	}
+	err := tx.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatches 返回需要 worker 推进的批处理，按创建顺序
+func GetUnfinishedBatches(limit int) ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status IN ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).
+		Order("id asc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// UpdateWithStatus 仅当批处理仍处于 fromStatus 时更新，返回是否更新成功（CAS）
+func (batch *Batch) UpdateWithStatus(fromStatus string) (bool, error) {
+	result := DB.Model(batch).Where("status = ?", fromStatus).Select("*").Updates(batch)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CancelUserBatch 将进行中的批处理标记为 cancelling，由 worker 完成收尾
+func CancelUserBatch(userId int, batchId string) (*Batch, error) {
+	batch, err := GetUserBatch(userId, batchId)
+	if err != nil {
+		return nil, err
+	}
+	if batch.Status != BatchStatusValidating && batch.Status != BatchStatusInProgress {
+		return batch, nil
+	}
+	now := common.GetTimestamp()
+	result := DB.Model(&Batch{}).
+		Where("id = ? AND status IN ?", batch.Id, []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]any{"status": BatchStatusCancelling, "cancelling_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return GetUserBatch(userId, batchId)
+}
+
+// IncreaseBatchCounts 累加批处理的完成 / 失败计数
+func IncreaseBatchCounts(batchId string, completed int, failed int) error {
+	if completed == 0 && failed == 0 {
+		return nil
+	}
+	return DB.Model(&Batch{}).Where("batch_id = ?", batchId).Updates(map[string]any{
+		"completed_count": gorm.Expr("completed_count + ?", completed),
+		"failed_count":    gorm.Expr("failed_count + ?", failed),
+	}).Error
+}
+
+func InsertBatchItems(items []*BatchItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	return DB.CreateInBatches(items, 100).Error
+}
+
+func GetPendingBatchItems(batchId string, limit int) ([]*BatchItem, error) {
+	var items []*BatchItem
+	err := DB.Where("batch_id = ? AND status = ?", batchId, BatchItemStatusPending).
+		Order("line_index asc").Limit(limit).Find(&items).Error
+	return items, err
+}
+
+// GetBatchItemsAfter 分页读取批处理的全部请求行（不含请求体），用于生成输出文件
+func GetBatchItemsAfter(batchId string, afterLineIndex int, limit int) ([]*BatchItem, error) {
+	var items []*BatchItem
+	err := DB.Omit("body").Where("batch_id = ? AND line_index > ?", batchId, afterLineIndex).
+		Order("line_index asc").Limit(limit).Find(&items).Error
+	return items, err
+}
+
+// GetBatchItemBodiesAfter 分页读取批处理的请求行（含请求体），用于提交上游批处理
+func GetBatchItemBodiesAfter(batchId string, afterLineIndex int, limit int) ([]*BatchItem, error) {
+	var items []*BatchItem
+	err := DB.Where("batch_id = ? AND line_index > ?", batchId, afterLineIndex).
+		Order("line_index asc").Limit(limit).Find(&items).Error
+	return items, err
+}
+
+func GetBatchItemByCustomId(batchId string, customId string) (*BatchItem, error) {
+	var item BatchItem
+	err := DB.Where("batch_id = ? AND custom_id = ?", batchId, customId).First(&item).Error
+	return &item, err
+}
+
+// UpdateBatchCounts 同步上游原生批处理的进度
+func UpdateBatchCounts(batchId string, completed int, failed int) error {
+	return DB.Model(&Batch{}).Where("batch_id = ?", batchId).Updates(map[string]any{
+		"completed_count": completed,
+		"failed_count":    failed,
+	}).Error
+}
+
+// ClearBatchReservedQuota 将批处理的预扣额度清零，返回是否由本次调用清零，避免重复退还
+func ClearBatchReservedQuota(batchId string, quota int) (bool, error) {
+	result := DB.Model(&Batch{}).Where("batch_id = ? AND reserved_quota = ?", batchId, quota).Update("reserved_quota", 0)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (item *BatchItem) Update() error {
+	return DB.Model(item).Select("status", "attempts", "output").Updates(item).Error
+}
+
+func DeleteBatchItems(batchId string) error {
+	return DB.Where("batch_id = ?", batchId).Delete(&BatchItem{}).Error
+}
+
+// DeleteExpiredFilesAndBatches 清理早于 before 的文件与已结束的批处理
+func DeleteExpiredFilesAndBatches(before int64) (int64, error) {
+	var deleted int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		expiredFiles := tx.Model(&File{}).Select("file_id").Where("created_at < ?", before)
+		if err := tx.Where("file_id IN (?)", expiredFiles).Delete(&FileChunk{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("created_at < ?", before).Delete(&File{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted += result.RowsAffected
+		result = tx.Where("created_at < ? AND status IN ?", before,
+			[]string{BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled}).Delete(&Batch{})
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted += result.RowsAffected
+		return nil
+	})
+	return deleted, err
+}
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BudgetOwnerToken = "token"
+	BudgetOwnerUser  = "user"
+)
+
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// BudgetUsage 令牌或用户当前预算窗口内的消费，预算配置保存在令牌和用户上
+type BudgetUsage struct {
+	Id          int    `json:"id" gorm:"primaryKey"`
+	OwnerType   string `json:"owner_type" gorm:"type:varchar(16);uniqueIndex:idx_budget_owner,priority:1"`
+	OwnerId     int    `json:"owner_id" gorm:"uniqueIndex:idx_budget_owner,priority:2"`
+	Used        int    `json:"used" gorm:"default:0"`
+	WindowStart int64  `json:"window_start" gorm:"bigint"`
+	ResetAt     int64  `json:"reset_at" gorm:"bigint"`
+	NotifiedAt  int64  `json:"notified_at" gorm:"bigint;default:0"` // 已发送预警的窗口起点，每个窗口只提醒一次
+}
+
+func getOrCreateBudgetUsage(ownerType string, ownerId int) (*BudgetUsage, error) {
+	usage := &BudgetUsage{}
+	err := DB.Where(BudgetUsage{OwnerType: ownerType, OwnerId: ownerId}).FirstOrCreate(usage).Error
+	if err != nil {
+		// 并发创建时唯一索引冲突，重新读取
+		if err := DB.Where(BudgetUsage{OwnerType: ownerType, OwnerId: ownerId}).First(usage).Error; err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
+
+// getCurrentBudgetUsage 返回当前窗口的消费记录，窗口已过期时重置
+func getCurrentBudgetUsage(ownerType string, ownerId int, window types.BudgetWindow) (*BudgetUsage, error) {
+	usage, err := getOrCreateBudgetUsage(ownerType, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if usage.ResetAt > now.Unix() {
+		return usage, nil
+	}
+	start, end := window.WindowAt(now)
+	// 只有一个请求能完成重置，其余请求重新读取重置后的记录
+	err = DB.Model(&BudgetUsage{}).
+		Where("id = ? AND reset_at = ?", usage.Id, usage.ResetAt).
+		Updates(map[string]interface{}{
+			"used":         0,
+			"window_start": start.Unix(),
+			"reset_at":     end.Unix(),
+			"notified_at":  0,
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := DB.First(usage, usage.Id).Error; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// ReserveBudget 在当前窗口内占用 amount 额度，超出上限时返回 ErrBudgetExceeded 与当前消费记录。
+// amount 为 0 时只检查窗口是否已用尽。
+func ReserveBudget(ownerType string, ownerId int, window types.BudgetWindow, amount int) (*BudgetUsage, error) {
+	for i := 0; i < 3; i++ {
+		usage, err := getCurrentBudgetUsage(ownerType, ownerId, window)
+		if err != nil {
+			return nil, err
+		}
+		result := DB.Model(&BudgetUsage{}).
+			Where("id = ? AND window_start = ? AND used < ? AND used + ? <= ?", usage.Id, usage.WindowStart, window.Limit, amount, window.Limit).
+			Update("used", gorm.Expr("used + ?", amount))
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected > 0 {
+			usage.Used += amount
+			return usage, nil
+		}
+		if err := DB.First(usage, usage.Id).Error; err != nil {
+			return nil, err
+		}
+		if usage.ResetAt > common.GetTimestamp() {
+			return usage, ErrBudgetExceeded
+		}
+		// 窗口恰好过期，重置后重试
+	}
+	return nil, ErrBudgetExceeded
+}
+
+// AdjustBudgetUsage 调整指定窗口内的消费，窗口已重置时不再调整，避免退款计入新窗口
+func AdjustBudgetUsage(ownerType string, ownerId int, windowStart int64, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	return DB.Model(&BudgetUsage{}).
+		Where("owner_type = ? AND owner_id = ? AND window_start = ?", ownerType, ownerId, windowStart).
+		Update("used", gorm.Expr("used + ?", delta)).Error
+}
+
+// RecordBudgetUsage 记录不经过预扣的消费（如按次计费的后扣费），不检查上限
+func RecordBudgetUsage(ownerType string, ownerId int, window types.BudgetWindow, amount int) (*BudgetUsage, error) {
+	usage, err := getCurrentBudgetUsage(ownerType, ownerId, window)
+	if err != nil {
+		return nil, err
+	}
+	if err := AdjustBudgetUsage(ownerType, ownerId, usage.WindowStart, amount); err != nil {
+		return nil, err
+	}
+	usage.Used += amount
+	return usage, nil
+}
+
+func GetBudgetUsage(ownerType string, ownerId int) (*BudgetUsage, error) {
+	var usage BudgetUsage
+	err := DB.Where(BudgetUsage{OwnerType: ownerType, OwnerId: ownerId}).First(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// GetBudgetStatus 返回当前窗口的已用额度和重置时间，滚动窗口尚未开始时 resetAt 为 0
+func GetBudgetStatus(ownerType string, ownerId int, window types.BudgetWindow) (used int, resetAt int64, err error) {
+	usage, err := GetBudgetUsage(ownerType, ownerId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, 0, err
+	}
+	now := time.Now()
+	if err == nil && usage.ResetAt > now.Unix() {
+		return usage.Used, usage.ResetAt, nil
+	}
+	if window.Mode == types.BudgetModeRolling {
+		return 0, 0, nil
+	}
+	_, end := window.WindowAt(now)
+	return 0, end.Unix(), nil
+}
+
+// MarkBudgetNotified 标记窗口已发送预警，返回 false 表示该窗口已提醒过
+func MarkBudgetNotified(usage *BudgetUsage) (bool, error) {
+	result := DB.Model(&BudgetUsage{}).
+		Where("id = ? AND window_start = ? AND notified_at <> ?", usage.Id, usage.WindowStart, usage.WindowStart).
+		Update("notified_at", usage.WindowStart)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBudgetWindowCalendarAlignment(t *testing.T) {
+	now := time.Date(2026, 3, 18, 15, 30, 0, 0, time.UTC) // 周三
+
+	start, end := types.BudgetWindow{Period: types.BudgetPeriodDaily}.WindowAt(now)
+	assert.Equal(t, time.Date(2026, 3, 18, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2026, 3, 19, 0, 0, 0, 0, time.UTC), end)
+
+	start, end = types.BudgetWindow{Period: types.BudgetPeriodWeekly}.WindowAt(now)
+	assert.Equal(t, time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2026, 3, 23, 0, 0, 0, 0, time.UTC), end)
+
+	start, end = types.BudgetWindow{Period: types.BudgetPeriodMonthly}.WindowAt(now)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), end)
+
+	start, end = types.BudgetWindow{Period: types.BudgetPeriodDaily, Mode: types.BudgetModeRolling}.WindowAt(now)
+	assert.Equal(t, now, start)
+	assert.Equal(t, now.Add(24*time.Hour), end)
+}
+
+func TestReserveBudgetRejectsOverLimitAndResetsExpiredWindow(t *testing.T) {
+	t.Cleanup(func() { DB.Exec("DELETE FROM budget_usages") })
+	window := types.BudgetWindow{Period: types.BudgetPeriodDaily, Limit: 1000}
+
+	usage, err := ReserveBudget(BudgetOwnerToken, 1, window, 600)
+	require.NoError(t, err)
+	assert.Equal(t, 600, usage.Used)
+
+	usage, err = ReserveBudget(BudgetOwnerToken, 1, window, 500)
+	require.True(t, errors.Is(err, ErrBudgetExceeded))
+	assert.Equal(t, 600, usage.Used)
+
+	// 其他令牌的预算互不影响
+	_, err = ReserveBudget(BudgetOwnerToken, 2, window, 500)
+	require.NoError(t, err)
+
+	require.NoError(t, AdjustBudgetUsage(BudgetOwnerToken, 1, usage.WindowStart, 400))
+	_, err = ReserveBudget(BudgetOwnerToken, 1, window, 0)
+	require.True(t, errors.Is(err, ErrBudgetExceeded), "exhausted window should reject even zero reservations")
+
+	// 窗口过期后重新计算
+	require.NoError(t, DB.Model(&BudgetUsage{}).Where("id = ?", usage.Id).Update("reset_at", time.Now().Unix()-1).Error)
+	usage, err = ReserveBudget(BudgetOwnerToken, 1, window, 300)
+	require.NoError(t, err)
+	assert.Equal(t, 300, usage.Used)
+	assert.Greater(t, usage.ResetAt, time.Now().Unix())
+
+	marked, err := MarkBudgetNotified(usage)
+	require.NoError(t, err)
+	assert.True(t, marked)
+	marked, err = MarkBudgetNotified(usage)
+	require.NoError(t, err)
+	assert.False(t, marked, "warning should be sent once per window")
+}
@@ -70,14 +70,20 @@ func InitChannelCache() {
 	for i, channel := range newChannelId2channel {
 		if channel.ChannelInfo.IsMultiKey {
 			channel.Keys = channel.GetKeys()
-			if channel.ChannelInfo.MultiKeyMode == constant.MultiKeyModePolling {
+			if channel.ChannelInfo.MultiKeyMode == constant.MultiKeyModePolling || channel.ChannelInfo.MultiKeyMode == constant.MultiKeyModeCooldown {
 				if oldChannel, ok := channelsIDM[i]; ok {
 					// 存在旧的渠道，如果是多key且轮询，保留轮询索引信息
-					if oldChannel.ChannelInfo.IsMultiKey && oldChannel.ChannelInfo.MultiKeyMode == constant.MultiKeyModePolling {
+					if oldChannel.ChannelInfo.IsMultiKey && oldChannel.ChannelInfo.MultiKeyMode == channel.ChannelInfo.MultiKeyMode {
 						channel.ChannelInfo.MultiKeyPollingIndex = oldChannel.ChannelInfo.MultiKeyPollingIndex
 					}
 				}
 			}
+			if channel.ChannelInfo.MultiKeyMode == constant.MultiKeyModeHeadroom {
+				// 限流余量只保存在内存中，同步时沿用旧值
+				if oldChannel, ok := channelsIDM[i]; ok && oldChannel.ChannelInfo.IsMultiKey {
+					channel.ChannelInfo.MultiKeyHeadroom = oldChannel.ChannelInfo.MultiKeyHeadroom
+				}
+			}
 		}
 	}
 	channelsIDM = newChannelId2channel
@@ -115,6 +121,9 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, nil
 	}
 
+	// skip channels vetoed by the select guard (e.g. open circuit breaker)
+	channels = filterGuardedChannels(channels)
+
 	if len(channels) == 1 {
 		if channel, ok := channelsIDM[channels[0]]; ok {
 			return channel, nil
@@ -159,6 +168,12 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
 	}
 
+	if strategy := getChannelSelectStrategy(); strategy != nil && len(targetChannels) > 1 {
+		if channel := strategy.PickChannel(group, targetChannels); channel != nil {
+			return channel, nil
+		}
+	}
+
 	// smoothing factor and adjustment
 	smoothingFactor := 1
 	smoothingAdjustment := 0
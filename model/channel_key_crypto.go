@@ -0,0 +1,227 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 渠道密钥（包括多 key 列表、Vertex JSON 凭据、AWS AK/SK、Codex OAuth 凭据）配置主密钥后加密存储，
+// 读出时在 AfterFind 中解密，内存中与渠道缓存里始终是明文，调用方无需感知。
+// 密文无法直接比较，key_hash 列保存明文密钥的 HMAC（复用令牌哈希盐），渠道搜索按完整密钥精确匹配该列。
+
+const channelKeyReencryptBatchSize = 200
+
+// channelKeyWritten 判断本次写入是否包含 key 列。只更新其他列时不触碰内存中的明文，
+// 避免缓存中的渠道对象在保存期间短暂变为密文。
+func channelKeyWritten(tx *gorm.DB) bool {
+	if _, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		return false
+	}
+	if slices.Contains(tx.Statement.Omits, "key") {
+		return false
+	}
+	if len(tx.Statement.Selects) == 0 {
+		return true
+	}
+	return slices.Contains(tx.Statement.Selects, "key") || slices.Contains(tx.Statement.Selects, "*")
+}
+
+func (channel *Channel) BeforeSave(tx *gorm.DB) error {
+	if !channelKeyWritten(tx) {
+		return nil
+	}
+	if channel.Key != "" && !common.IsEncryptedSecret(channel.Key) {
+		channel.KeyHash = hashChannelKeyInTx(tx, channel.Key)
+		if len(tx.Statement.Selects) > 0 && !slices.Contains(tx.Statement.Selects, "*") {
+			tx.Statement.Selects = append(tx.Statement.Selects, "key_hash")
+		}
+	}
+	encrypted, err := common.EncryptSecret(channel.Key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt channel key: %w", err)
+	}
+	channel.Key = encrypted
+	return nil
+}
+
+func (channel *Channel) AfterSave(tx *gorm.DB) error {
+	channel.decryptKey()
+	return nil
+}
+
+func (channel *Channel) AfterFind(tx *gorm.DB) error {
+	channel.decryptKey()
+	return nil
+}
+
+// decryptKey 解密失败时保留密文，避免后续保存时覆盖数据库中的原值
+func (channel *Channel) decryptKey() {
+	if !common.IsEncryptedSecret(channel.Key) {
+		return
+	}
+	plaintext, err := common.DecryptSecret(channel.Key)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to decrypt key of channel %d: %v", channel.Id, err))
+		return
+	}
+	channel.Key = plaintext
+}
+
+// UpdateChannelKey 只更新渠道密钥列与对应的搜索哈希，按需加密
+func UpdateChannelKey(channelId int, key string) error {
+	encrypted, err := common.EncryptSecret(key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt channel key: %w", err)
+	}
+	return DB.Model(&Channel{}).Where("id = ?", channelId).Updates(map[string]interface{}{
+		"key":      encrypted,
+		"key_hash": HashChannelKey(key),
+	}).Error
+}
+
+// HashChannelKey 计算明文渠道密钥的搜索哈希，与令牌哈希使用同一个盐但加前缀区分用途
+func HashChannelKey(key string) string {
+	secret, err := getTokenKeyHashSecret()
+	if err != nil {
+		common.SysError("failed to load channel key hash secret: " + err.Error())
+		return ""
+	}
+	return hashChannelKeyWithSecret(secret, key)
+}
+
+// hashChannelKeyInTx 在保存钩子中计算搜索哈希。哈希盐尚未加载时在当前事务内只读查询，
+// 避免连接数受限时另开连接等待当前事务而死锁；仍不可用时留空，由启动时的迁移补算
+func hashChannelKeyInTx(tx *gorm.DB, key string) string {
+	tokenKeyHashSecretLock.Lock()
+	secret := tokenKeyHashSecret
+	tokenKeyHashSecretLock.Unlock()
+	if secret == nil {
+		var option Option
+		err := tx.Session(&gorm.Session{NewDB: true}).
+			Where(Option{Key: TokenKeyHashSecretOption}).
+			Limit(1).Find(&option).Error
+		if err != nil || option.Value == "" {
+			return ""
+		}
+		secret = []byte(option.Value)
+	}
+	return hashChannelKeyWithSecret(secret, key)
+}
+
+func hashChannelKeyWithSecret(secret []byte, key string) string {
+	return common.GenerateHMACWithKey(secret, "channel-key:"+key)
+}
+
+// channelKeySearchHash 返回搜索关键字对应的密钥哈希，无法计算时返回不可能匹配的值
+func channelKeySearchHash(keyword string) string {
+	if keyword == "" {
+		return "-"
+	}
+	if hash := HashChannelKey(keyword); hash != "" {
+		return hash
+	}
+	return "-"
+}
+
+// ReencryptChannelKeys 将明文或由旧主密钥加密的渠道密钥转换为当前主密钥加密，返回更新的渠道数。
+// 逐批处理且只在密钥未被并发修改时更新，可在服务运行期间执行。
+func ReencryptChannelKeys() (int, error) {
+	if !common.SecretEncryptionEnabled() {
+		return 0, errors.New("channel key master key is not configured")
+	}
+	rawDB := DB.Session(&gorm.Session{SkipHooks: true})
+	updated := 0
+	lastId := 0
+	for {
+		var channels []*Channel
+		err := rawDB.Select("id", commonKeyCol).
+			Where("id > ?", lastId).
+			Order("id").Limit(channelKeyReencryptBatchSize).
+			Find(&channels).Error
+		if err != nil {
+			return updated, fmt.Errorf("failed to load channels for key encryption: %w", err)
+		}
+		for _, channel := range channels {
+			lastId = channel.Id
+			if !common.SecretNeedsRewrap(channel.Key) {
+				continue
+			}
+			rewrapped, err := common.RewrapSecret(channel.Key)
+			if err != nil {
+				return updated, fmt.Errorf("failed to encrypt key of channel %d: %w", channel.Id, err)
+			}
+			result := rawDB.Model(&Channel{}).
+				Where("id = ? AND "+commonKeyCol+" = ?", channel.Id, channel.Key).
+				Update("key", rewrapped)
+			if result.Error != nil {
+				return updated, fmt.Errorf("failed to update key of channel %d: %w", channel.Id, result.Error)
+			}
+			updated += int(result.RowsAffected)
+		}
+		if len(channels) < channelKeyReencryptBatchSize {
+			break
+		}
+	}
+	return updated, nil
+}
+
+// migrateChannelKeysToEncrypted 配置主密钥后在后台加密存量明文密钥，迁移期间明文与密文均可正常读取
+func migrateChannelKeysToEncrypted() {
+	if !common.SecretEncryptionEnabled() {
+		return
+	}
+	go func() {
+		updated, err := ReencryptChannelKeys()
+		if err != nil {
+			common.SysError("failed to encrypt channel keys: " + err.Error())
+		}
+		if updated > 0 {
+			common.SysLog(fmt.Sprintf("encrypted %d channel keys with the current master key", updated))
+		}
+	}()
+}
+
+// migrateChannelKeyHashes 在后台为存量渠道补算密钥搜索哈希，需要能够解密已加密的密钥
+func migrateChannelKeyHashes() {
+	go func() {
+		updated := 0
+		lastId := 0
+		for {
+			var channels []*Channel
+			err := DB.Select("id", commonKeyCol).
+				Where("id > ? AND (key_hash = '' OR key_hash IS NULL)", lastId).
+				Order("id").Limit(channelKeyReencryptBatchSize).
+				Find(&channels).Error
+			if err != nil {
+				common.SysError("failed to load channels for key hash migration: " + err.Error())
+				return
+			}
+			for _, channel := range channels {
+				lastId = channel.Id
+				// 解密失败时 AfterFind 保留密文，跳过这些渠道
+				if channel.Key == "" || common.IsEncryptedSecret(channel.Key) {
+					continue
+				}
+				result := DB.Model(&Channel{}).
+					Where("id = ? AND (key_hash = '' OR key_hash IS NULL)", channel.Id).
+					Update("key_hash", HashChannelKey(channel.Key))
+				if result.Error != nil {
+					common.SysError(fmt.Sprintf("failed to update key hash of channel %d: %v", channel.Id, result.Error))
+					continue
+				}
+				updated += int(result.RowsAffected)
+			}
+			if len(channels) < channelKeyReencryptBatchSize {
+				break
+			}
+		}
+		if updated > 0 {
+			common.SysLog(fmt.Sprintf("computed key hashes for %d channels", updated))
+		}
+	}()
+}
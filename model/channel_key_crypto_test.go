@@ -0,0 +1,99 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func rawChannelKey(t *testing.T, id int) string {
+	t.Helper()
+	var channel Channel
+	require.NoError(t, DB.Session(&gorm.Session{SkipHooks: true}).Select("id", "key").First(&channel, id).Error)
+	return channel.Key
+}
+
+func TestChannelKeyEncryptedAtRest(t *testing.T) {
+	truncateTables(t)
+	common.SetSecretMasterKeys("master-key-a")
+	t.Cleanup(func() { common.SetSecretMasterKeys("") })
+
+	channel := &Channel{Name: "encrypted", Key: "sk-one\nsk-two"}
+	require.NoError(t, DB.Create(channel).Error)
+	assert.Equal(t, "sk-one\nsk-two", channel.Key, "in-memory key stays plaintext after save")
+	assert.True(t, common.IsEncryptedSecret(rawChannelKey(t, channel.Id)))
+
+	loaded, err := GetChannelById(channel.Id, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sk-one", "sk-two"}, loaded.GetKeys())
+
+	loaded.Key = "sk-three"
+	require.NoError(t, DB.Model(loaded).Updates(loaded).Error)
+	assert.Equal(t, "sk-three", loaded.Key)
+	decrypted, err := common.DecryptSecret(rawChannelKey(t, channel.Id))
+	require.NoError(t, err)
+	assert.Equal(t, "sk-three", decrypted)
+
+	// 只更新其他列时不改写 key
+	stored := rawChannelKey(t, channel.Id)
+	require.NoError(t, loaded.SaveChannelInfo())
+	assert.Equal(t, stored, rawChannelKey(t, channel.Id))
+}
+
+func TestReencryptChannelKeys(t *testing.T) {
+	truncateTables(t)
+	initCol()
+	t.Cleanup(func() { common.SetSecretMasterKeys("") })
+
+	common.SetSecretMasterKeys("")
+	legacy := &Channel{Name: "legacy", Key: "sk-legacy"}
+	require.NoError(t, DB.Create(legacy).Error)
+	assert.Equal(t, "sk-legacy", rawChannelKey(t, legacy.Id))
+
+	common.SetSecretMasterKeys("master-key-a")
+	rotated := &Channel{Name: "rotated", Key: "sk-rotated"}
+	require.NoError(t, DB.Create(rotated).Error)
+
+	common.SetSecretMasterKeys("master-key-b", "master-key-a")
+	updated, err := ReencryptChannelKeys()
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated)
+
+	common.SetSecretMasterKeys("master-key-b")
+	for id, key := range map[int]string{legacy.Id: "sk-legacy", rotated.Id: "sk-rotated"} {
+		assert.False(t, common.SecretNeedsRewrap(rawChannelKey(t, id)))
+		loaded, err := GetChannelById(id, true)
+		require.NoError(t, err)
+		assert.Equal(t, key, loaded.Key)
+	}
+}
+
+func TestSearchChannelsMatchesEncryptedKeyByHash(t *testing.T) {
+	truncateTables(t)
+	initCol()
+	require.NoError(t, initTokenKeyHashSecret())
+	common.SetSecretMasterKeys("master-key-a")
+	t.Cleanup(func() { common.SetSecretMasterKeys("") })
+
+	channel := &Channel{Name: "searchable", Key: "sk-search-me"}
+	require.NoError(t, DB.Create(channel).Error)
+	require.NoError(t, DB.Create(&Channel{Name: "other", Key: "sk-other"}).Error)
+
+	found, err := SearchChannels("sk-search-me", "", "", false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, channel.Id, found[0].Id)
+
+	found, err = SearchChannels("sk-search", "", "", false)
+	require.NoError(t, err)
+	assert.Empty(t, found, "partial keys do not match")
+
+	require.NoError(t, UpdateChannelKey(channel.Id, "sk-rotated-key"))
+	found, err = SearchChannels("sk-rotated-key", "", "", false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, channel.Id, found[0].Id)
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MultiKeyRateLimitState 单次上游响应中解析出的限流状态
+type MultiKeyRateLimitState struct {
+	// Headroom 剩余额度占上限的比例（0~1），小于 0 表示响应未携带限流信息
+	Headroom float64
+	// CooldownUntil key 需要冷却到的时间戳，0 表示无需冷却
+	CooldownUntil int64
+}
+
+// filterCooledDownKeys 剔除冷却中的 key，并清理已到期的冷却记录使 key 自动恢复。
+// 所有 key 都在冷却时返回最早恢复的那一个，避免仅因冷却导致渠道不可用。
+func filterCooledDownKeys(info *ChannelInfo, keyIndexes []int, now int64) []int {
+	if len(info.MultiKeyCooldownUntil) == 0 {
+		return keyIndexes
+	}
+	available := make([]int, 0, len(keyIndexes))
+	earliest := -1
+	for _, idx := range keyIndexes {
+		until, ok := info.MultiKeyCooldownUntil[idx]
+		if !ok || until <= now {
+			delete(info.MultiKeyCooldownUntil, idx)
+			available = append(available, idx)
+			continue
+		}
+		if earliest < 0 || until < info.MultiKeyCooldownUntil[earliest] {
+			earliest = idx
+		}
+	}
+	if len(available) == 0 && earliest >= 0 {
+		return []int{earliest}
+	}
+	return available
+}
+
+// selectHeadroomKey 选择限流余量最大的 key，尚无记录的 key 视为余量充足，余量相同时随机选择
+func selectHeadroomKey(info *ChannelInfo, keyIndexes []int) int {
+	best := make([]int, 0, len(keyIndexes))
+	bestHeadroom := -1.0
+	for _, idx := range keyIndexes {
+		headroom := 1.0
+		if value, ok := info.MultiKeyHeadroom[idx]; ok {
+			headroom = value
+		}
+		switch {
+		case headroom > bestHeadroom:
+			bestHeadroom = headroom
+			best = append(best[:0], idx)
+		case headroom == bestHeadroom:
+			best = append(best, idx)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// UpdateMultiKeyRateLimit 记录多 key 渠道某个 key 的限流余量与冷却时间，
+// 仅对根据限流响应头调度的渠道生效
+func UpdateMultiKeyRateLimit(channelId int, keyIndex int, state MultiKeyRateLimitState) {
+	channel, err := CacheGetChannel(channelId)
+	if err != nil || channel == nil {
+		return
+	}
+	if !channel.ChannelInfo.IsMultiKey || !channel.ChannelInfo.MultiKeyMode.UsesRateLimitHeaders() {
+		return
+	}
+
+	lock := GetChannelPollingLock(channelId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	info := &channel.ChannelInfo
+	if state.Headroom >= 0 {
+		if info.MultiKeyHeadroom == nil {
+			info.MultiKeyHeadroom = make(map[int]float64)
+		}
+		info.MultiKeyHeadroom[keyIndex] = state.Headroom
+	}
+	if state.CooldownUntil <= common.GetTimestamp() || state.CooldownUntil <= info.MultiKeyCooldownUntil[keyIndex] {
+		return
+	}
+	if info.MultiKeyCooldownUntil == nil {
+		info.MultiKeyCooldownUntil = make(map[int]int64)
+	}
+	info.MultiKeyCooldownUntil[keyIndex] = state.CooldownUntil
+	// 冷却状态需要持久化，使其在渠道缓存同步与多实例之间生效；限流余量只保存在内存中
+	if err := saveMultiKeyCooldown(channelId, keyIndex, state.CooldownUntil); err != nil {
+		common.SysLog(fmt.Sprintf("failed to save multi-key cooldown: channel_id=%d, error=%v", channelId, err))
+	}
+}
+
+// saveMultiKeyCooldown 基于数据库中最新的 channel_info 只写入冷却时间，
+// 避免用缓存中的旧数据覆盖并发修改的密钥状态等字段
+func saveMultiKeyCooldown(channelId int, keyIndex int, cooldownUntil int64) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Select("id", "channel_info")
+		if !common.UsingSQLite {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		var stored Channel
+		if err := query.First(&stored, "id = ?", channelId).Error; err != nil {
+			return err
+		}
+		info := &stored.ChannelInfo
+		if cooldownUntil <= info.MultiKeyCooldownUntil[keyIndex] {
+			return nil
+		}
+		if info.MultiKeyCooldownUntil == nil {
+			info.MultiKeyCooldownUntil = make(map[int]int64)
+		}
+		info.MultiKeyCooldownUntil[keyIndex] = cooldownUntil
+		return tx.Model(&stored).Update("channel_info", stored.ChannelInfo).Error
+	})
+}
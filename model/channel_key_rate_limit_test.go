@@ -0,0 +1,49 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterCooledDownKeys(t *testing.T) {
+	info := &ChannelInfo{MultiKeyCooldownUntil: map[int]int64{0: 100, 1: 300, 2: 200}}
+
+	assert.Equal(t, []int{0, 3}, filterCooledDownKeys(info, []int{0, 1, 2, 3}, 150))
+	_, exists := info.MultiKeyCooldownUntil[0]
+	assert.False(t, exists, "expired cooldown should be cleared")
+
+	// every key cooling down: fall back to the one that recovers first
+	assert.Equal(t, []int{2}, filterCooledDownKeys(info, []int{1, 2}, 150))
+}
+
+func TestSelectHeadroomKey(t *testing.T) {
+	info := &ChannelInfo{MultiKeyHeadroom: map[int]float64{0: 0.2, 1: 0.9, 2: 0.5}}
+	assert.Equal(t, 1, selectHeadroomKey(info, []int{0, 1, 2}))
+
+	// keys without samples are treated as having full headroom
+	assert.Equal(t, 3, selectHeadroomKey(info, []int{0, 1, 3}))
+}
+
+func TestSaveMultiKeyCooldownKeepsConcurrentKeyStatus(t *testing.T) {
+	channel := &Channel{Name: "rate-limit-channel", Key: "k1\nk2"}
+	channel.ChannelInfo.IsMultiKey = true
+	require.NoError(t, DB.Create(channel).Error)
+	t.Cleanup(func() { DB.Delete(&Channel{}, channel.Id) })
+
+	// another request disabled key 1 after this channel was cached
+	stored := *channel
+	stored.ChannelInfo.MultiKeyStatusList = map[int]int{1: common.ChannelStatusAutoDisabled}
+	require.NoError(t, stored.SaveChannelInfo())
+
+	require.NoError(t, saveMultiKeyCooldown(channel.Id, 0, 200))
+	require.NoError(t, saveMultiKeyCooldown(channel.Id, 0, 100))
+
+	var reloaded Channel
+	require.NoError(t, DB.First(&reloaded, "id = ?", channel.Id).Error)
+	assert.Equal(t, map[int]int{1: common.ChannelStatusAutoDisabled}, reloaded.ChannelInfo.MultiKeyStatusList)
+	assert.Equal(t, map[int]int64{0: 200}, reloaded.ChannelInfo.MultiKeyCooldownUntil)
+}
@@ -0,0 +1,124 @@
+package model
+
+import "sync/atomic"
+
+// ChannelSelectGuard lets upper layers veto channels or multi-key indexes during
+// selection without touching their persisted status (e.g. a circuit breaker).
+type ChannelSelectGuard interface {
+	AllowChannel(channelId int) bool
+	AllowKey(channelId int, keyIndex int) bool
+}
+
+var channelSelectGuard atomic.Value // ChannelSelectGuard
+
+func SetChannelSelectGuard(guard ChannelSelectGuard) {
+	channelSelectGuard.Store(&guard)
+}
+
+func getChannelSelectGuard() ChannelSelectGuard {
+	v, ok := channelSelectGuard.Load().(*ChannelSelectGuard)
+	if !ok || v == nil {
+		return nil
+	}
+	return *v
+}
+
+// filterGuardedChannels drops channels rejected by the guard. When the guard would reject
+// every candidate the original list is kept, so selection never fails solely because of it.
+func filterGuardedChannels(channels []int) []int {
+	guard := getChannelSelectGuard()
+	if guard == nil || len(channels) == 0 {
+		return channels
+	}
+	allowed := make([]int, 0, len(channels))
+	for _, id := range channels {
+		if guard.AllowChannel(id) {
+			allowed = append(allowed, id)
+		}
+	}
+	if len(allowed) == 0 {
+		return channels
+	}
+	return allowed
+}
+
+// filterGuardedKeys is the multi-key counterpart of filterGuardedChannels.
+func filterGuardedKeys(channelId int, keyIndexes []int) []int {
+	guard := getChannelSelectGuard()
+	if guard == nil || len(keyIndexes) == 0 {
+		return keyIndexes
+	}
+	allowed := make([]int, 0, len(keyIndexes))
+	for _, idx := range keyIndexes {
+		if guard.AllowKey(channelId, idx) {
+			allowed = append(allowed, idx)
+		}
+	}
+	if len(allowed) == 0 {
+		return keyIndexes
+	}
+	return allowed
+}
+
+func filterGuardedAbilities(abilities []Ability) []Ability {
+	if getChannelSelectGuard() == nil || len(abilities) == 0 {
+		return abilities
+	}
+	ids := make([]int, 0, len(abilities))
+	for _, ability := range abilities {
+		ids = append(ids, ability.ChannelId)
+	}
+	allowedIds := filterGuardedChannels(ids)
+	if len(allowedIds) == len(ids) {
+		return abilities
+	}
+	allowed := make([]Ability, 0, len(allowedIds))
+	for _, ability := range abilities {
+		for _, id := range allowedIds {
+			if id == ability.ChannelId {
+				allowed = append(allowed, ability)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// ChannelSelectStrategy picks one channel out of the candidates of the same priority.
+// Returning nil falls back to the default weighted random selection.
+type ChannelSelectStrategy interface {
+	PickChannel(group string, candidates []*Channel) *Channel
+}
+
+var channelSelectStrategy atomic.Value // ChannelSelectStrategy
+
+func SetChannelSelectStrategy(strategy ChannelSelectStrategy) {
+	channelSelectStrategy.Store(&strategy)
+}
+
+func getChannelSelectStrategy() ChannelSelectStrategy {
+	v, ok := channelSelectStrategy.Load().(*ChannelSelectStrategy)
+	if !ok || v == nil {
+		return nil
+	}
+	return *v
+}
+
+// pickAbilityChannelByStrategy applies the selection strategy on the DB path (memory cache
+// disabled), loading the candidate channels of the abilities. It returns nil when no
+// strategy applies so the caller falls back to the weighted random pick.
+func pickAbilityChannelByStrategy(group string, abilities []Ability) *Channel {
+	strategy := getChannelSelectStrategy()
+	if strategy == nil || len(abilities) < 2 {
+		return nil
+	}
+	ids := make([]int, 0, len(abilities))
+	for _, ability := range abilities {
+		ids = append(ids, ability.ChannelId)
+	}
+	var channels []*Channel
+	if err := DB.Where("id IN ?", ids).Find(&channels).Error; err != nil || len(channels) < 2 {
+		return nil
+	}
+	return strategy.PickChannel(group, channels)
+}
@@ -0,0 +1,254 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+
+	"gorm.io/gorm"
+)
+
+const (
+	InvoiceStatusIssued  = "issued"
+	InvoiceStatusOverdue = "overdue"
+	InvoiceStatusPaid    = "paid"
+)
+
+var (
+	ErrInvoiceNotFound      = errors.New("invoice not found")
+	ErrInvoiceStatusInvalid = errors.New("invoice status invalid")
+)
+
+// Invoice 后付费用户的月度账单，由账期内的消费日志汇总生成
+type Invoice struct {
+	Id           int     `json:"id"`
+	InvoiceNo    string  `json:"invoice_no" gorm:"type:varchar(64);uniqueIndex"`
+	UserId       int     `json:"user_id" gorm:"index"`
+	PeriodStart  int64   `json:"period_start" gorm:"bigint"`
+	PeriodEnd    int64   `json:"period_end" gorm:"bigint"` // 不含
+	RequestCount int64   `json:"request_count" gorm:"bigint;default:0"`
+	Quota        int64   `json:"quota" gorm:"bigint;default:0"`
+	Amount       float64 `json:"amount" gorm:"default:0"` // 按 QuotaPerUnit 折算的金额（美元）
+	Status       string  `json:"status" gorm:"type:varchar(16);index"`
+	IssuedAt     int64   `json:"issued_at" gorm:"bigint"`
+	DueAt        int64   `json:"due_at" gorm:"bigint;index"`
+	PaidAt       int64   `json:"paid_at" gorm:"bigint;default:0"`
+	Suspended    bool    `json:"suspended" gorm:"default:false"` // 是否因逾期禁用了用户
+}
+
+// InvoiceItem 账单明细，按模型与令牌汇总
+type InvoiceItem struct {
+	Id               int    `json:"id"`
+	InvoiceId        int    `json:"invoice_id" gorm:"index"`
+	ModelName        string `json:"model_name" gorm:"default:''"`
+	TokenName        string `json:"token_name" gorm:"default:''"`
+	RequestCount     int64  `json:"request_count" gorm:"bigint;default:0"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"bigint;default:0"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"bigint;default:0"`
+	Quota            int64  `json:"quota" gorm:"bigint;default:0"`
+}
+
+func invoiceNo(userId int, periodStart int64) string {
+	return fmt.Sprintf("INV%s-%d", time.Unix(periodStart, 0).Format("200601"), userId)
+}
+
+// GetPostpaidUserIds 返回设置了信用额度的用户
+func GetPostpaidUserIds() ([]int, error) {
+	var ids []int
+	err := DB.Model(&User{}).Where("credit_limit > ?", 0).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// aggregateInvoiceItems 按模型与令牌汇总账期内由用户钱包支付的消费日志，
+// 组织额度与订阅额度支付的请求不计入
+func aggregateInvoiceItems(userId int, periodStart int64, periodEnd int64) ([]*InvoiceItem, error) {
+	var items []*InvoiceItem
+	err := LOG_DB.Table("logs").
+		Select("model_name, token_name, count(*) AS request_count, sum(prompt_tokens) AS prompt_tokens, "+
+			"sum(completion_tokens) AS completion_tokens, sum(quota) AS quota").
+		Where("user_id = ? AND type = ? AND created_at >= ? AND created_at < ?", userId, LogTypeConsume, periodStart, periodEnd).
+		Where("org_id = ?", 0).
+		Where("other NOT LIKE ?", `%"billing_source":"subscription"%`).
+		Group("model_name, token_name").
+		Order("model_name, token_name").
+		Scan(&items).Error
+	return items, err
+}
+
+// GenerateInvoice 生成用户在 [periodStart, periodEnd) 内的账单。同一账期重复生成时返回已有账单，
+// 账期内没有消费时不生成账单，返回 nil
+func GenerateInvoice(userId int, periodStart int64, periodEnd int64, dueAt int64) (*Invoice, bool, error) {
+	no := invoiceNo(userId, periodStart)
+	existing := &Invoice{}
+	if err := DB.Where("invoice_no = ?", no).First(existing).Error; err == nil {
+		return existing, false, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	items, err := aggregateInvoiceItems(userId, periodStart, periodEnd)
+	if err != nil {
+		return nil, false, err
+	}
+	invoice := &Invoice{
+		InvoiceNo:   no,
+		UserId:      userId,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Status:      InvoiceStatusIssued,
+		IssuedAt:    common.GetTimestamp(),
+		DueAt:       dueAt,
+	}
+	for _, item := range items {
+		invoice.RequestCount += item.RequestCount
+		invoice.Quota += item.Quota
+	}
+	if invoice.Quota <= 0 {
+		return nil, false, nil
+	}
+	invoice.Amount = float64(invoice.Quota) / common.QuotaPerUnit
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(invoice).Error; err != nil {
+			return err
+		}
+		for _, item := range items {
+			item.InvoiceId = invoice.Id
+		}
+		return tx.CreateInBatches(items, 100).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return invoice, true, nil
+}
+
+// GetInvoices 分页查询账单，userId 为 0 时查询所有用户
+func GetInvoices(userId int, status string, startIdx int, num int) (invoices []*Invoice, total int64, err error) {
+	tx := DB.Model(&Invoice{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&invoices).Error
+	return invoices, total, err
+}
+
+// GetInvoiceWithItems 查询账单及明细，userId 不为 0 时只允许查询该用户的账单
+func GetInvoiceWithItems(id int, userId int) (*Invoice, []*InvoiceItem, error) {
+	invoice := &Invoice{}
+	tx := DB.Where("id = ?", id)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err := tx.First(invoice).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvoiceNotFound
+		}
+		return nil, nil, err
+	}
+	var items []*InvoiceItem
+	if err := DB.Where("invoice_id = ?", id).Order("id asc").Find(&items).Error; err != nil {
+		return nil, nil, err
+	}
+	return invoice, items, nil
+}
+
+// MarkInvoicePaid 标记账单已结清并按账单额度恢复用户余额，因该账单被禁用的用户在没有其他逾期停用账单时自动恢复
+func MarkInvoicePaid(id int) (*Invoice, error) {
+	invoice := &Invoice{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", id).First(invoice).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvoiceNotFound
+			}
+			return err
+		}
+		if invoice.Status != InvoiceStatusIssued && invoice.Status != InvoiceStatusOverdue {
+			return ErrInvoiceStatusInvalid
+		}
+		invoice.Status = InvoiceStatusPaid
+		invoice.PaidAt = common.GetTimestamp()
+		if err := tx.Save(invoice).Error; err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", invoice.UserId).Update("quota", gorm.Expr("quota + ?", invoice.Quota)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := invalidateUserCache(invoice.UserId); err != nil {
+		common.SysLog(fmt.Sprintf("failed to invalidate user cache for user %d: %s", invoice.UserId, err.Error()))
+	}
+	RecordLog(invoice.UserId, LogTypeManage, fmt.Sprintf("账单 %s 已结清，恢复额度 %s", invoice.InvoiceNo, logger.FormatQuota(int(invoice.Quota))))
+
+	if invoice.Suspended {
+		var remaining int64
+		if err := DB.Model(&Invoice{}).Where("user_id = ? AND status = ? AND suspended = ?", invoice.UserId, InvoiceStatusOverdue, true).Count(&remaining).Error; err != nil {
+			return invoice, err
+		}
+		if remaining == 0 {
+			if err := setInvoiceUserStatus(invoice.UserId, common.UserStatusDisabled, common.UserStatusEnabled); err != nil {
+				return invoice, err
+			}
+			RecordLog(invoice.UserId, LogTypeManage, "逾期账单已全部结清，账户已恢复")
+		}
+	}
+	return invoice, nil
+}
+
+// MarkOverdueInvoices 将到期未结清的账单标记为逾期
+func MarkOverdueInvoices(now int64) (int64, error) {
+	result := DB.Model(&Invoice{}).
+		Where("status = ? AND due_at < ?", InvoiceStatusIssued, now).
+		Update("status", InvoiceStatusOverdue)
+	return result.RowsAffected, result.Error
+}
+
+// SuspendOverdueInvoiceUsers 禁用账单在 deadline 前到期仍未结清的用户，返回被处理的账单数。
+// 只处理当前仍启用的用户，已被管理员禁用的用户结清账单后不会被自动恢复
+func SuspendOverdueInvoiceUsers(deadline int64, limit int) (int, error) {
+	enabledUsers := DB.Model(&User{}).Select("id").Where("status = ? AND role < ?", common.UserStatusEnabled, common.RoleRootUser)
+	var invoices []*Invoice
+	err := DB.Where("status = ? AND due_at < ? AND suspended = ?", InvoiceStatusOverdue, deadline, false).
+		Where("user_id IN (?)", enabledUsers).
+		Order("id asc").Limit(limit).Find(&invoices).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, invoice := range invoices {
+		if err := DB.Model(&Invoice{}).Where("id = ?", invoice.Id).Update("suspended", true).Error; err != nil {
+			return 0, err
+		}
+		if err := setInvoiceUserStatus(invoice.UserId, common.UserStatusEnabled, common.UserStatusDisabled); err != nil {
+			return 0, err
+		}
+		RecordLog(invoice.UserId, LogTypeManage, fmt.Sprintf("账单 %s 逾期未结清，账户已停用", invoice.InvoiceNo))
+	}
+	return len(invoices), nil
+}
+
+// setInvoiceUserStatus 仅在用户处于 from 状态时修改状态，不处理超级管理员
+func setInvoiceUserStatus(userId int, from int, to int) error {
+	err := DB.Model(&User{}).
+		Where("id = ? AND status = ? AND role < ?", userId, from, common.RoleRootUser).
+		Update("status", to).Error
+	if err != nil {
+		return err
+	}
+	if err := invalidateUserCache(userId); err != nil {
+		common.SysLog(fmt.Sprintf("failed to invalidate user cache for user %d: %s", userId, err.Error()))
+	}
+	if err := InvalidateUserTokensCache(userId); err != nil {
+		common.SysLog(fmt.Sprintf("failed to invalidate tokens cache for user %d: %s", userId, err.Error()))
+	}
+	return nil
+}
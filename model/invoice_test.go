@@ -0,0 +1,124 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedInvoiceLog(t *testing.T, userId int, modelName string, tokenName string, quota int, createdAt int64, other string) {
+	t.Helper()
+	require.NoError(t, LOG_DB.Create(&Log{
+		UserId:           userId,
+		Type:             LogTypeConsume,
+		ModelName:        modelName,
+		TokenName:        tokenName,
+		Quota:            quota,
+		PromptTokens:     10,
+		CompletionTokens: 5,
+		CreatedAt:        createdAt,
+		Other:            other,
+	}).Error)
+}
+
+func TestGenerateInvoiceAggregatesWalletUsage(t *testing.T) {
+	truncateTables(t)
+
+	seedInvoiceLog(t, 7, "gpt-a", "prod", 100, 1000, `{"billing_source":"wallet"}`)
+	seedInvoiceLog(t, 7, "gpt-a", "prod", 50, 1500, `{"billing_source":"wallet"}`)
+	seedInvoiceLog(t, 7, "gpt-b", "dev", 30, 1800, `{}`)
+	seedInvoiceLog(t, 7, "gpt-a", "prod", 999, 1900, `{"billing_source":"subscription"}`)
+	seedInvoiceLog(t, 7, "gpt-a", "prod", 999, 2000, `{}`) // 账期外
+	seedInvoiceLog(t, 8, "gpt-a", "prod", 999, 1200, `{}`)
+
+	invoice, created, err := GenerateInvoice(7, 1000, 2000, 5000)
+	require.NoError(t, err)
+	require.True(t, created)
+	assert.EqualValues(t, 180, invoice.Quota)
+	assert.EqualValues(t, 3, invoice.RequestCount)
+	assert.Equal(t, InvoiceStatusIssued, invoice.Status)
+
+	_, items, err := GetInvoiceWithItems(invoice.Id, 7)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "gpt-a", items[0].ModelName)
+	assert.EqualValues(t, 150, items[0].Quota)
+	assert.EqualValues(t, 2, items[0].RequestCount)
+	assert.EqualValues(t, 20, items[0].PromptTokens)
+
+	again, created, err := GenerateInvoice(7, 1000, 2000, 5000)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, invoice.Id, again.Id)
+
+	_, _, err = GetInvoiceWithItems(invoice.Id, 8)
+	assert.ErrorIs(t, err, ErrInvoiceNotFound)
+
+	empty, created, err := GenerateInvoice(9, 1000, 2000, 5000)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Nil(t, empty)
+}
+
+func TestOverdueInvoiceSuspendsAndPaymentRestoresUser(t *testing.T) {
+	truncateTables(t)
+
+	user := &User{Id: 21, Username: "postpaid", Password: "password123", Status: common.UserStatusEnabled, Quota: -180, CreditLimit: 1000, AffCode: "inv21"}
+	require.NoError(t, DB.Create(user).Error)
+	seedInvoiceLog(t, user.Id, "gpt-a", "prod", 180, 1000, `{}`)
+	invoice, _, err := GenerateInvoice(user.Id, 1000, 2000, 5000)
+	require.NoError(t, err)
+
+	n, err := MarkOverdueInvoices(4000)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, n)
+	n, err = MarkOverdueInvoices(6000)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, n)
+
+	suspended, err := SuspendOverdueInvoiceUsers(5000, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, suspended, "grace period not yet passed")
+	suspended, err = SuspendOverdueInvoiceUsers(9000, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, suspended)
+
+	var reloaded User
+	require.NoError(t, DB.First(&reloaded, user.Id).Error)
+	assert.Equal(t, common.UserStatusDisabled, reloaded.Status)
+
+	paid, err := MarkInvoicePaid(invoice.Id)
+	require.NoError(t, err)
+	assert.Equal(t, InvoiceStatusPaid, paid.Status)
+
+	require.NoError(t, DB.First(&reloaded, user.Id).Error)
+	assert.Equal(t, common.UserStatusEnabled, reloaded.Status)
+	assert.Equal(t, 0, reloaded.Quota)
+
+	_, err = MarkInvoicePaid(invoice.Id)
+	assert.ErrorIs(t, err, ErrInvoiceStatusInvalid)
+}
+
+func TestPaymentDoesNotEnableAdminDisabledUser(t *testing.T) {
+	truncateTables(t)
+
+	user := &User{Id: 22, Username: "disabled", Password: "password123", Status: common.UserStatusDisabled, CreditLimit: 1000, AffCode: "inv22"}
+	require.NoError(t, DB.Create(user).Error)
+	seedInvoiceLog(t, user.Id, "gpt-a", "prod", 50, 1000, `{}`)
+	invoice, _, err := GenerateInvoice(user.Id, 1000, 2000, 5000)
+	require.NoError(t, err)
+	_, err = MarkOverdueInvoices(6000)
+	require.NoError(t, err)
+
+	suspended, err := SuspendOverdueInvoiceUsers(9000, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, suspended)
+
+	_, err = MarkInvoicePaid(invoice.Id)
+	require.NoError(t, err)
+	var reloaded User
+	require.NoError(t, DB.First(&reloaded, user.Id).Error)
+	assert.Equal(t, common.UserStatusDisabled, reloaded.Status)
+}
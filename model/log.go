@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/metrics"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -36,6 +38,9 @@ type Log struct {
 	Group            string `json:"group" gorm:"index"`
 	Ip               string `json:"ip" gorm:"index;default:''"`
 	RequestId        string `json:"request_id,omitempty" gorm:"type:varchar(64);index:idx_logs_request_id;default:''"`
+	OrgId            int    `json:"org_id,omitempty" gorm:"default:0;index"`
+	UpstreamCost     int    `json:"upstream_cost,omitempty" gorm:"default:0"` // 按渠道上游成本表达式计算的采购成本，仅管理员可见
+	Hedged           bool   `json:"hedged,omitempty" gorm:"default:false"`    // 对冲请求，落败尝试的上游成本未计入 UpstreamCost
 	Other            string `json:"other"`
 }
 
@@ -52,20 +57,27 @@ const (
 
 func formatUserLogs(logs []*Log, startIdx int) {
 	for i := range logs {
-		logs[i].ChannelName = ""
-		var otherMap map[string]interface{}
-		otherMap, _ = common.StrToMap(logs[i].Other)
-		if otherMap != nil {
-			// Remove admin-only debug fields.
-			delete(otherMap, "admin_info")
-			// delete(otherMap, "reject_reason")
-			delete(otherMap, "stream_status")
-		}
-		logs[i].Other = common.MapToJsonStr(otherMap)
+		stripAdminLogFields(logs[i])
 		logs[i].Id = startIdx + i + 1
 	}
 }
 
+// stripAdminLogFields 移除仅管理员可见的字段
+func stripAdminLogFields(log *Log) {
+	log.ChannelName = ""
+	log.UpstreamCost = 0
+	log.Hedged = false
+	var otherMap map[string]interface{}
+	otherMap, _ = common.StrToMap(log.Other)
+	if otherMap != nil {
+		// Remove admin-only debug fields.
+		delete(otherMap, "admin_info")
+		// delete(otherMap, "reject_reason")
+		delete(otherMap, "stream_status")
+	}
+	log.Other = common.MapToJsonStr(otherMap)
+}
+
 func GetLogByTokenId(tokenId int) (logs []*Log, err error) {
 	err = LOG_DB.Model(&Log{}).Where("token_id = ?", tokenId).Order("id desc").Limit(common.MaxRecentItems).Find(&logs).Error
 	formatUserLogs(logs, 0)
@@ -198,10 +210,15 @@ type RecordConsumeLogParams struct {
 	UseTimeSeconds   int                    `json:"use_time_seconds"`
 	IsStream         bool                   `json:"is_stream"`
 	Group            string                 `json:"group"`
+	UpstreamCost     int                    `json:"upstream_cost"`
+	Hedged           bool                   `json:"hedged"`
 	Other            map[string]interface{} `json:"other"`
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	// TPM 限流按实际结算的 token 数计量，与是否记录日志无关
+	common.SetContextKey(c, constant.ContextKeyConsumedTokens, params.PromptTokens+params.CompletionTokens)
+	metrics.AddConsumed(params.ModelName, params.Group, params.Quota, params.PromptTokens, params.CompletionTokens)
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -238,8 +255,11 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 			}
 			return ""
 		}(),
-		RequestId: requestId,
-		Other:     otherStr,
+		RequestId:    requestId,
+		OrgId:        common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId),
+		UpstreamCost: params.UpstreamCost,
+		Hedged:       params.Hedged,
+		Other:        otherStr,
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -260,6 +280,7 @@ type RecordTaskBillingLogParams struct {
 	ModelName string
 	Quota     int
 	TokenId   int
+	OrgId     int
 	Group     string
 	Other     map[string]interface{}
 }
@@ -286,6 +307,7 @@ func RecordTaskBillingLog(params RecordTaskBillingLogParams) {
 		Quota:     params.Quota,
 		ChannelId: params.ChannelId,
 		TokenId:   params.TokenId,
+		OrgId:     params.OrgId,
 		Group:     params.Group,
 		Other:     common.MapToJsonStr(params.Other),
 	}
@@ -296,37 +318,18 @@ func RecordTaskBillingLog(params RecordTaskBillingLogParams) {
 }
 
 func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int, group string, requestId string) (logs []*Log, total int64, err error) {
-	var tx *gorm.DB
-	if logType == LogTypeUnknown {
-		tx = LOG_DB
-	} else {
-		tx = LOG_DB.Where("logs.type = ?", logType)
-	}
-
-	if modelName != "" {
-		tx = tx.Where("logs.model_name like ?", modelName)
-	}
-	if username != "" {
-		tx = tx.Where("logs.username = ?", username)
-	}
-	if tokenName != "" {
-		tx = tx.Where("logs.token_name = ?", tokenName)
-	}
-	if requestId != "" {
-		tx = tx.Where("logs.request_id = ?", requestId)
-	}
-	if startTimestamp != 0 {
-		tx = tx.Where("logs.created_at >= ?", startTimestamp)
-	}
-	if endTimestamp != 0 {
-		tx = tx.Where("logs.created_at <= ?", endTimestamp)
-	}
-	if channel != 0 {
-		tx = tx.Where("logs.channel_id = ?", channel)
-	}
-	if group != "" {
-		tx = tx.Where("logs."+logGroupCol+" = ?", group)
-	}
+	filter := LogQueryFilter{
+		Type:           logType,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      modelName,
+		Username:       username,
+		TokenName:      tokenName,
+		Channel:        channel,
+		Group:          group,
+		RequestId:      requestId,
+	}
+	tx := filter.applyAll(LOG_DB)
 	err = tx.Model(&Log{}).Count(&total).Error
 	if err != nil {
 		return nil, 0, err
@@ -336,6 +339,12 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 		return nil, 0, err
 	}
 
+	err = fillLogChannelNames(logs)
+	return logs, total, err
+}
+
+// fillLogChannelNames 填充日志的渠道名称
+func fillLogChannelNames(logs []*Log) error {
 	channelIds := types.NewSet[int]()
 	for _, log := range logs {
 		if log.ChannelId != 0 {
@@ -363,8 +372,8 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 			}
 		} else {
 			// Bulk query channels from DB
-			if err = DB.Table("channels").Select("id, name").Where("id IN ?", channelIds.Items()).Find(&channels).Error; err != nil {
-				return logs, total, err
+			if err := DB.Table("channels").Select("id, name").Where("id IN ?", channelIds.Items()).Find(&channels).Error; err != nil {
+				return err
 			}
 		}
 		channelMap := make(map[int]string, len(channels))
@@ -375,41 +384,51 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 			logs[i].ChannelName = channelMap[logs[i].ChannelId]
 		}
 	}
-
-	return logs, total, err
+	return nil
 }
 
 const logSearchCountLimit = 10000
 
-func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int, group string, requestId string) (logs []*Log, total int64, err error) {
-	var tx *gorm.DB
-	if logType == LogTypeUnknown {
-		tx = LOG_DB.Where("logs.user_id = ?", userId)
-	} else {
-		tx = LOG_DB.Where("logs.user_id = ? and logs.type = ?", userId, logType)
-	}
-
-	if modelName != "" {
-		modelNamePattern, err := sanitizeLikePattern(modelName)
-		if err != nil {
-			return nil, 0, err
-		}
-		tx = tx.Where("logs.model_name LIKE ? ESCAPE '!'", modelNamePattern)
-	}
-	if tokenName != "" {
-		tx = tx.Where("logs.token_name = ?", tokenName)
-	}
-	if requestId != "" {
-		tx = tx.Where("logs.request_id = ?", requestId)
-	}
+// GetConsumeLogsByModel 按时间倒序获取模型的消费日志，用于计费重算
+func GetConsumeLogsByModel(modelName string, startTimestamp int64, endTimestamp int64, limit int) (logs []*Log, err error) {
+	tx := LOG_DB.Where("type = ? AND model_name = ?", LogTypeConsume, modelName)
 	if startTimestamp != 0 {
-		tx = tx.Where("logs.created_at >= ?", startTimestamp)
+		tx = tx.Where("created_at >= ?", startTimestamp)
 	}
 	if endTimestamp != 0 {
-		tx = tx.Where("logs.created_at <= ?", endTimestamp)
+		tx = tx.Where("created_at <= ?", endTimestamp)
 	}
-	if group != "" {
-		tx = tx.Where("logs."+logGroupCol+" = ?", group)
+	err = tx.Order("id desc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
+func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int, group string, requestId string) (logs []*Log, total int64, err error) {
+	return getScopedLogs(LOG_DB.Where("logs.user_id = ?", userId), logType, startTimestamp, endTimestamp, modelName, tokenName, startIdx, num, group, requestId)
+}
+
+// GetOrganizationLogs 查询通过组织令牌产生的日志，userId 为 0 时返回所有成员的日志
+func GetOrganizationLogs(orgId int, userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int, group string, requestId string) (logs []*Log, total int64, err error) {
+	tx := LOG_DB.Where("logs.org_id = ?", orgId)
+	if userId != 0 {
+		tx = tx.Where("logs.user_id = ?", userId)
+	}
+	return getScopedLogs(tx, logType, startTimestamp, endTimestamp, modelName, tokenName, startIdx, num, group, requestId)
+}
+
+// getScopedLogs 在 tx 限定的范围内按用户日志的筛选条件查询
+func getScopedLogs(tx *gorm.DB, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int, group string, requestId string) (logs []*Log, total int64, err error) {
+	filter := LogQueryFilter{
+		Type:           logType,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      modelName,
+		TokenName:      tokenName,
+		Group:          group,
+		RequestId:      requestId,
+	}
+	tx, err = filter.applyScoped(tx)
+	if err != nil {
+		return nil, 0, err
 	}
 	err = tx.Model(&Log{}).Limit(logSearchCountLimit).Count(&total).Error
 	if err != nil {
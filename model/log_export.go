@@ -0,0 +1,266 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+// LogQueryFilter 日志查询条件，与日志列表接口的筛选参数一致
+type LogQueryFilter struct {
+	Type           int    `json:"type"`
+	StartTimestamp int64  `json:"start_timestamp"`
+	EndTimestamp   int64  `json:"end_timestamp"`
+	ModelName      string `json:"model_name,omitempty"`
+	Username       string `json:"username,omitempty"`
+	TokenName      string `json:"token_name,omitempty"`
+	Channel        int    `json:"channel,omitempty"`
+	Group          string `json:"group,omitempty"`
+	RequestId      string `json:"request_id,omitempty"`
+}
+
+// applyAll 管理员查询全部日志时的筛选条件
+func (f *LogQueryFilter) applyAll(tx *gorm.DB) *gorm.DB {
+	if f.Type != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", f.Type)
+	}
+	if f.ModelName != "" {
+		tx = tx.Where("logs.model_name like ?", f.ModelName)
+	}
+	if f.Username != "" {
+		tx = tx.Where("logs.username = ?", f.Username)
+	}
+	if f.TokenName != "" {
+		tx = tx.Where("logs.token_name = ?", f.TokenName)
+	}
+	if f.RequestId != "" {
+		tx = tx.Where("logs.request_id = ?", f.RequestId)
+	}
+	if f.StartTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", f.StartTimestamp)
+	}
+	if f.EndTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", f.EndTimestamp)
+	}
+	if f.Channel != 0 {
+		tx = tx.Where("logs.channel_id = ?", f.Channel)
+	}
+	if f.Group != "" {
+		tx = tx.Where("logs."+logGroupCol+" = ?", f.Group)
+	}
+	return tx
+}
+
+// applyScoped 用户查询自己的日志时的筛选条件，不支持按用户名与渠道筛选
+func (f *LogQueryFilter) applyScoped(tx *gorm.DB) (*gorm.DB, error) {
+	if f.Type != LogTypeUnknown {
+		tx = tx.Where("logs.type = ?", f.Type)
+	}
+	if f.ModelName != "" {
+		modelNamePattern, err := sanitizeLikePattern(f.ModelName)
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where("logs.model_name LIKE ? ESCAPE '!'", modelNamePattern)
+	}
+	if f.TokenName != "" {
+		tx = tx.Where("logs.token_name = ?", f.TokenName)
+	}
+	if f.RequestId != "" {
+		tx = tx.Where("logs.request_id = ?", f.RequestId)
+	}
+	if f.StartTimestamp != 0 {
+		tx = tx.Where("logs.created_at >= ?", f.StartTimestamp)
+	}
+	if f.EndTimestamp != 0 {
+		tx = tx.Where("logs.created_at <= ?", f.EndTimestamp)
+	}
+	if f.Group != "" {
+		tx = tx.Where("logs."+logGroupCol+" = ?", f.Group)
+	}
+	return tx, nil
+}
+
+// IterateLogs 按 id 升序分批遍历符合条件的日志，userId 为 0 时遍历全部日志（管理员导出），
+// 否则只遍历该用户的日志并移除仅管理员可见的字段
+func IterateLogs(filter LogQueryFilter, userId int, batchSize int, fn func(logs []*Log) error) error {
+	var tx *gorm.DB
+	if userId == 0 {
+		tx = filter.applyAll(LOG_DB)
+	} else {
+		var err error
+		tx, err = filter.applyScoped(LOG_DB.Where("logs.user_id = ?", userId))
+		if err != nil {
+			return err
+		}
+	}
+	lastId := 0
+	exported := 0
+	for {
+		var logs []*Log
+		err := tx.Session(&gorm.Session{}).Where("logs.id > ?", lastId).
+			Order("logs.id asc").Limit(batchSize).Find(&logs).Error
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		lastId = logs[len(logs)-1].Id
+		if userId == 0 {
+			if err := fillLogChannelNames(logs); err != nil {
+				return err
+			}
+		} else {
+			// 与日志列表一致，不向用户暴露真实的日志 id
+			formatUserLogs(logs, exported)
+		}
+		exported += len(logs)
+		if err := fn(logs); err != nil {
+			return err
+		}
+		if len(logs) < batchSize {
+			return nil
+		}
+	}
+}
+
+const (
+	LogExportFormatCSV   = "csv"
+	LogExportFormatJSONL = "jsonl"
+)
+
+const (
+	LogExportStatusPending   = "pending"
+	LogExportStatusRunning   = "running"
+	LogExportStatusSucceeded = "succeeded"
+	LogExportStatusFailed    = "failed"
+)
+
+var ErrLogExportJobNotFound = errors.New("导出任务不存在")
+
+// LogExportJob 日志导出任务，导出文件保存在执行任务节点的磁盘缓存目录下
+type LogExportJob struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	AllUsers    bool   `json:"all_users"` // 管理员导出全部用户的日志
+	Format      string `json:"format" gorm:"type:varchar(16)"`
+	Filter      string `json:"filter" gorm:"type:text"`
+	Status      string `json:"status" gorm:"type:varchar(16);index"`
+	RowCount    int64  `json:"row_count" gorm:"default:0"`
+	FileSize    int64  `json:"file_size" gorm:"default:0"`
+	StoragePath string `json:"-" gorm:"type:varchar(512);default:''"`
+	Error       string `json:"error" gorm:"type:text"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+	FinishedAt  int64  `json:"finished_at" gorm:"bigint;default:0"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+func (job *LogExportJob) GetFilter() LogQueryFilter {
+	var filter LogQueryFilter
+	if job.Filter != "" {
+		_ = common.UnmarshalJsonStr(job.Filter, &filter)
+	}
+	return filter
+}
+
+func CreateLogExportJob(job *LogExportJob) error {
+	job.Status = LogExportStatusPending
+	job.CreatedAt = common.GetTimestamp()
+	return DB.Create(job).Error
+}
+
+// GetLogExportJob 查询导出任务，userId 为 0 时不限制任务所属用户
+func GetLogExportJob(id int, userId int) (*LogExportJob, error) {
+	var job LogExportJob
+	tx := DB.Where("id = ?", id)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if err := tx.First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLogExportJobNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+func GetUserLogExportJobs(userId int, startIdx int, num int) (jobs []*LogExportJob, total int64, err error) {
+	tx := DB.Model(&LogExportJob{}).Where("user_id = ?", userId)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&jobs).Error
+	return jobs, total, err
+}
+
+// MarkLogExportJobRunning 仅 pending 状态的任务可以开始执行
+func MarkLogExportJobRunning(id int) (bool, error) {
+	result := DB.Model(&LogExportJob{}).
+		Where("id = ? AND status = ?", id, LogExportStatusPending).
+		Update("status", LogExportStatusRunning)
+	return result.RowsAffected > 0, result.Error
+}
+
+func FinishLogExportJob(job *LogExportJob) error {
+	job.FinishedAt = common.GetTimestamp()
+	return DB.Model(&LogExportJob{}).Where("id = ?", job.Id).
+		Select("status", "row_count", "file_size", "storage_path", "error", "finished_at", "expires_at").
+		Updates(job).Error
+}
+
+// DeleteExpiredLogExportJobs 删除过期的导出任务记录，导出文件由各节点按修改时间清理
+func DeleteExpiredLogExportJobs() (int64, error) {
+	result := DB.Where("expires_at > 0 AND expires_at <= ?", common.GetTimestamp()).Delete(&LogExportJob{})
+	return result.RowsAffected, result.Error
+}
+
+// LogSinkState 日志推送进度，记录已推送的最大日志 id
+type LogSinkState struct {
+	Name      string `json:"name" gorm:"primaryKey;type:varchar(64)"`
+	LastLogId int    `json:"last_log_id"`
+	UpdatedAt int64  `json:"updated_at" gorm:"bigint"`
+}
+
+// GetLogSinkCursor 返回推送进度，首次启用时从当前最新的日志开始，不推送历史日志
+func GetLogSinkCursor(name string) (int, error) {
+	var state LogSinkState
+	err := DB.Where(&LogSinkState{Name: name}).First(&state).Error
+	if err == nil {
+		return state.LastLogId, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	var maxId int
+	if err := LOG_DB.Model(&Log{}).Select("COALESCE(MAX(id), 0)").Scan(&maxId).Error; err != nil {
+		return 0, err
+	}
+	return maxId, SaveLogSinkCursor(name, maxId)
+}
+
+func SaveLogSinkCursor(name string, lastLogId int) error {
+	return DB.Save(&LogSinkState{Name: name, LastLogId: lastLogId, UpdatedAt: common.GetTimestamp()}).Error
+}
+
+// GetLogsAfterId 按 id 升序查询 lastId 之后的日志，logTypes 为空时不限制类型
+func GetLogsAfterId(lastId int, logTypes []int, limit int) (logs []*Log, err error) {
+	tx := LOG_DB.Where("id > ?", lastId)
+	if len(logTypes) > 0 {
+		tx = tx.Where("type IN ?", logTypes)
+	}
+	err = tx.Order("id asc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
+// CountActiveLogExportJobs 统计用户未完成的导出任务数
+func CountActiveLogExportJobs(userId int) (int64, error) {
+	var count int64
+	err := DB.Model(&LogExportJob{}).
+		Where("user_id = ? AND status IN ?", userId, []string{LogExportStatusPending, LogExportStatusRunning}).
+		Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	MarginDimensionChannel = "channel"
+	MarginDimensionModel   = "model"
+	MarginDimensionGroup   = "group"
+	MarginDimensionDay     = "day"
+)
+
+// MarginReportFilter 毛利报表的查询条件，TzOffset 为按天汇总时的时区偏移（秒）
+type MarginReportFilter struct {
+	Dimension      string
+	StartTimestamp int64
+	EndTimestamp   int64
+	ChannelId      int
+	ModelName      string
+	Group          string
+	TzOffset       int
+}
+
+// MarginReportItem 收入与上游成本汇总。Revenue 为全部消费额度，
+// CostedRevenue 仅统计已计算上游成本（配置了成本表达式）的请求，毛利基于这部分计算。
+// HedgedCount 为对冲请求数，落败尝试的上游成本未计入，这部分请求的毛利偏高
+type MarginReportItem struct {
+	Key           string  `json:"key"`
+	ChannelName   string  `json:"channel_name,omitempty"`
+	RequestCount  int64   `json:"request_count"`
+	Revenue       int64   `json:"revenue"`
+	CostedCount   int64   `json:"costed_count"`
+	CostedRevenue int64   `json:"costed_revenue"`
+	UpstreamCost  int64   `json:"upstream_cost"`
+	HedgedCount   int64   `json:"hedged_count"`
+	Margin        int64   `json:"margin"`
+	MarginPercent float64 `json:"margin_percent"`
+}
+
+func marginDimensionColumn(dimension string, tzOffset int) (string, error) {
+	switch dimension {
+	case MarginDimensionChannel:
+		return "channel_id", nil
+	case MarginDimensionModel:
+		return "model_name", nil
+	case MarginDimensionGroup:
+		return logGroupCol, nil
+	case MarginDimensionDay:
+		if tzOffset <= -86400 || tzOffset >= 86400 {
+			return "", errors.New("invalid tz offset")
+		}
+		return fmt.Sprintf("created_at - ((created_at + %d) %% 86400)", tzOffset), nil
+	default:
+		return "", errors.New("invalid dimension")
+	}
+}
+
+// GetMarginReport 按渠道/模型/分组/天汇总消费日志中的收入与上游成本
+func GetMarginReport(filter MarginReportFilter) ([]*MarginReportItem, error) {
+	column, err := marginDimensionColumn(filter.Dimension, filter.TzOffset)
+	if err != nil {
+		return nil, err
+	}
+	tx := LOG_DB.Table("logs").
+		Select(column+" AS dim_key, count(*) AS request_count, sum(quota) AS revenue, "+
+			"sum(case when upstream_cost > 0 then 1 else 0 end) AS costed_count, "+
+			"sum(case when upstream_cost > 0 then quota else 0 end) AS costed_revenue, "+
+			"sum(upstream_cost) AS upstream_cost, "+
+			"sum(case when hedged then 1 else 0 end) AS hedged_count").
+		Where("type = ?", LogTypeConsume)
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.Group != "" {
+		tx = tx.Where(logGroupCol+" = ?", filter.Group)
+	}
+
+	var rows []struct {
+		DimKey        string
+		RequestCount  int64
+		Revenue       int64
+		CostedCount   int64
+		CostedRevenue int64
+		UpstreamCost  int64
+		HedgedCount   int64
+	}
+	if err := tx.Group(column).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]*MarginReportItem, 0, len(rows))
+	for _, row := range rows {
+		item := &MarginReportItem{
+			Key:           row.DimKey,
+			RequestCount:  row.RequestCount,
+			Revenue:       row.Revenue,
+			CostedCount:   row.CostedCount,
+			CostedRevenue: row.CostedRevenue,
+			UpstreamCost:  row.UpstreamCost,
+			HedgedCount:   row.HedgedCount,
+			Margin:        row.CostedRevenue - row.UpstreamCost,
+		}
+		if row.CostedRevenue > 0 {
+			item.MarginPercent = float64(item.Margin) / float64(row.CostedRevenue) * 100
+		}
+		items = append(items, item)
+	}
+	if filter.Dimension == MarginDimensionChannel {
+		fillMarginChannelNames(items)
+	}
+	if filter.Dimension == MarginDimensionDay {
+		sort.Slice(items, func(i, j int) bool {
+			left, _ := strconv.ParseInt(items[i].Key, 10, 64)
+			right, _ := strconv.ParseInt(items[j].Key, 10, 64)
+			return left < right
+		})
+	} else {
+		// 毛利最低的排在前面，便于发现亏损线路
+		sort.Slice(items, func(i, j int) bool { return items[i].Margin < items[j].Margin })
+	}
+	return items, nil
+}
+
+func fillMarginChannelNames(items []*MarginReportItem) {
+	logs := make([]*Log, 0, len(items))
+	for _, item := range items {
+		channelId, err := strconv.Atoi(item.Key)
+		if err != nil {
+			continue
+		}
+		logs = append(logs, &Log{ChannelId: channelId})
+	}
+	if err := fillLogChannelNames(logs); err != nil {
+		return
+	}
+	names := make(map[string]string, len(logs))
+	for _, log := range logs {
+		names[strconv.Itoa(log.ChannelId)] = log.ChannelName
+	}
+	for _, item := range items {
+		item.ChannelName = names[item.Key]
+	}
+}
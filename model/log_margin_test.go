@@ -0,0 +1,111 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMarginReportByChannel(t *testing.T) {
+	truncateTables(t)
+
+	require.NoError(t, DB.Create(&Channel{Id: 1, Name: "cheap", Key: "k1"}).Error)
+	require.NoError(t, DB.Create(&Channel{Id: 2, Name: "expensive", Key: "k2"}).Error)
+	logs := []*Log{
+		{Type: LogTypeConsume, ChannelId: 1, ModelName: "m", Quota: 100, UpstreamCost: 60, CreatedAt: 1000},
+		{Type: LogTypeConsume, ChannelId: 1, ModelName: "m", Quota: 50, Hedged: true, CreatedAt: 1000},
+		{Type: LogTypeConsume, ChannelId: 2, ModelName: "m", Quota: 100, UpstreamCost: 120, CreatedAt: 1000},
+		{Type: LogTypeTopup, ChannelId: 2, Quota: 999, CreatedAt: 1000},
+	}
+	for _, log := range logs {
+		require.NoError(t, LOG_DB.Create(log).Error)
+	}
+
+	items, err := GetMarginReport(MarginReportFilter{Dimension: MarginDimensionChannel})
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+
+	// 亏损渠道排在前面
+	assert.Equal(t, "2", items[0].Key)
+	assert.Equal(t, "expensive", items[0].ChannelName)
+	assert.EqualValues(t, -20, items[0].Margin)
+
+	assert.Equal(t, "cheap", items[1].ChannelName)
+	assert.EqualValues(t, 2, items[1].RequestCount)
+	assert.EqualValues(t, 150, items[1].Revenue)
+	assert.EqualValues(t, 1, items[1].CostedCount)
+	assert.EqualValues(t, 100, items[1].CostedRevenue)
+	assert.EqualValues(t, 1, items[1].HedgedCount)
+	assert.Zero(t, items[0].HedgedCount)
+	assert.EqualValues(t, 40, items[1].Margin)
+	assert.InDelta(t, 40, items[1].MarginPercent, 0.001)
+}
+
+func TestGetMarginReportByDay(t *testing.T) {
+	truncateTables(t)
+
+	for _, createdAt := range []int64{86400 + 10, 86400 + 3600, 2*86400 + 5} {
+		require.NoError(t, LOG_DB.Create(&Log{Type: LogTypeConsume, Quota: 10, UpstreamCost: 5, CreatedAt: createdAt}).Error)
+	}
+
+	items, err := GetMarginReport(MarginReportFilter{Dimension: MarginDimensionDay})
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "86400", items[0].Key)
+	assert.EqualValues(t, 2, items[0].RequestCount)
+	assert.Equal(t, "172800", items[1].Key)
+
+	// UTC+8 的一天从 UTC 16:00 开始
+	items, err = GetMarginReport(MarginReportFilter{Dimension: MarginDimensionDay, TzOffset: 8 * 3600})
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "57600", items[0].Key)
+	assert.EqualValues(t, 2, items[0].RequestCount)
+}
+
+func TestGetMarginReportRejectsUnknownDimension(t *testing.T) {
+	_, err := GetMarginReport(MarginReportFilter{Dimension: "user"})
+	require.Error(t, err)
+}
+
+func TestUserLogsHideUpstreamCost(t *testing.T) {
+	truncateTables(t)
+
+	require.NoError(t, LOG_DB.Create(&Log{UserId: 7, TokenId: 9, Type: LogTypeConsume, ModelName: "m", Quota: 100, UpstreamCost: 60, Hedged: true, CreatedAt: 1000}).Error)
+
+	assertHidden := func(logs []*Log) {
+		t.Helper()
+		require.Len(t, logs, 1)
+		assert.Zero(t, logs[0].UpstreamCost)
+		assert.False(t, logs[0].Hedged)
+		data, err := common.Marshal(logs[0])
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "upstream_cost")
+		assert.NotContains(t, string(data), "hedged")
+	}
+
+	logs, _, err := GetUserLogs(7, LogTypeUnknown, 0, 0, "", "", 0, 10, "", "")
+	require.NoError(t, err)
+	assertHidden(logs)
+
+	logs, err = GetLogByTokenId(9)
+	require.NoError(t, err)
+	assertHidden(logs)
+
+	var exported []*Log
+	require.NoError(t, IterateLogs(LogQueryFilter{}, 7, 10, func(batch []*Log) error {
+		exported = append(exported, batch...)
+		return nil
+	}))
+	assertHidden(exported)
+
+	// 管理员查询保留成本
+	logs, _, err = GetAllLogs(LogTypeUnknown, 0, 0, "", "", "", 0, 10, 0, "", "")
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	assert.Equal(t, 60, logs[0].UpstreamCost)
+	assert.True(t, logs[0].Hedged)
+}
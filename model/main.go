@@ -10,6 +10,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/pkg/metrics"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
@@ -181,6 +182,9 @@ func InitDB() (err error) {
 			db = db.Debug()
 		}
 		DB = db
+		if err := metrics.RegisterGormCallbacks(DB); err != nil {
+			common.SysError("failed to register database metrics callbacks: " + err.Error())
+		}
 		// MySQL charset/collation startup check: ensure Chinese-capable charset
 		if common.UsingMySQL {
 			if err := checkMySQLChineseSupport(DB); err != nil {
@@ -196,6 +200,10 @@ func InitDB() (err error) {
 		sqlDB.SetConnMaxLifetime(time.Second * time.Duration(common.GetEnvOrDefault("SQL_MAX_LIFETIME", 60)))
 
 		if !common.IsMasterNode {
+			// 主节点尚未完成迁移时，哈希盐在首次使用时加载
+			if err := initTokenKeyHashSecret(); err != nil {
+				common.SysError("failed to load token key hash secret, will retry on use: " + err.Error())
+			}
 			return nil
 		}
 		if common.UsingMySQL {
@@ -221,6 +229,9 @@ func InitLogDB() (err error) {
 			db = db.Debug()
 		}
 		LOG_DB = db
+		if err := metrics.RegisterGormCallbacks(LOG_DB); err != nil {
+			common.SysError("failed to register log database metrics callbacks: " + err.Error())
+		}
 		// If log DB is MySQL, also ensure Chinese-capable charset
 		if common.LogSqlType == common.DatabaseTypeMySQL {
 			if err := checkMySQLChineseSupport(LOG_DB); err != nil {
@@ -280,6 +291,20 @@ func migrateDB() error {
 		&SubscriptionPreConsumeRecord{},
 		&CustomOAuthProvider{},
 		&UserOAuthBinding{},
+		&File{},
+		&FileChunk{},
+		&Batch{},
+		&BatchItem{},
+		&BudgetUsage{},
+		&Organization{},
+		&OrganizationMember{},
+		&OrganizationInvitation{},
+		&PayloadCapture{},
+		&LogExportJob{},
+		&LogSinkState{},
+		&AuditLog{},
+		&Invoice{},
+		&InvoiceItem{},
 	)
 	if err != nil {
 		return err
@@ -293,7 +318,15 @@ func migrateDB() error {
 			return err
 		}
 	}
-	return nil
+	if err := initTokenKeyHashSecret(); err != nil {
+		return err
+	}
+	// Encrypt plaintext channel keys in the background when a master key is configured
+	migrateChannelKeysToEncrypted()
+	// Compute search hashes for channel keys stored before key_hash existed
+	migrateChannelKeyHashes()
+	// Hash plaintext token keys in place, old keys keep working
+	return migrateTokenKeysToHash()
 }
 
 func migrateDBFast() error {
@@ -328,6 +361,20 @@ func migrateDBFast() error {
 		{&SubscriptionPreConsumeRecord{}, "SubscriptionPreConsumeRecord"},
 		{&CustomOAuthProvider{}, "CustomOAuthProvider"},
 		{&UserOAuthBinding{}, "UserOAuthBinding"},
+		{&File{}, "File"},
+		{&FileChunk{}, "FileChunk"},
+		{&Batch{}, "Batch"},
+		{&BatchItem{}, "BatchItem"},
+		{&BudgetUsage{}, "BudgetUsage"},
+		{&Organization{}, "Organization"},
+		{&OrganizationMember{}, "OrganizationMember"},
+		{&OrganizationInvitation{}, "OrganizationInvitation"},
+		{&PayloadCapture{}, "PayloadCapture"},
+		{&LogExportJob{}, "LogExportJob"},
+		{&LogSinkState{}, "LogSinkState"},
+		{&AuditLog{}, "AuditLog"},
+		{&Invoice{}, "Invoice"},
+		{&InvoiceItem{}, "InvoiceItem"},
 	}
 	// 动态计算migration数量，确保errChan缓冲区足够大
 	errChan := make(chan error, len(migrations))
@@ -367,7 +414,7 @@ func migrateDBFast() error {
 
 func migrateLOGDB() error {
 	var err error
-	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
+	if err = LOG_DB.AutoMigrate(&Log{}, &PayloadCapture{}, &AuditLog{}); err != nil {
 		return err
 	}
 	return nil
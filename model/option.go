@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"strconv"
 	"strings"
 	"time"
@@ -90,6 +91,11 @@ func InitOptionMap() {
 	common.OptionMap["CreemProducts"] = setting.CreemProducts
 	common.OptionMap["CreemTestMode"] = strconv.FormatBool(setting.CreemTestMode)
 	common.OptionMap["CreemWebhookSecret"] = setting.CreemWebhookSecret
+	common.OptionMap["CustomPaymentEnabled"] = strconv.FormatBool(setting.CustomPaymentEnabled)
+	common.OptionMap["CustomPaymentName"] = setting.CustomPaymentName
+	common.OptionMap["CustomPaymentCheckoutURL"] = setting.CustomPaymentCheckoutURL
+	common.OptionMap["CustomPaymentRefundURL"] = setting.CustomPaymentRefundURL
+	common.OptionMap["CustomPaymentWebhookSecret"] = setting.CustomPaymentWebhookSecret
 	common.OptionMap["WaffoEnabled"] = strconv.FormatBool(setting.WaffoEnabled)
 	common.OptionMap["WaffoApiKey"] = setting.WaffoApiKey
 	common.OptionMap["WaffoPrivateKey"] = setting.WaffoPrivateKey
@@ -122,6 +128,7 @@ func InitOptionMap() {
 	common.OptionMap["Chats"] = setting.Chats2JsonString()
 	common.OptionMap["AutoGroups"] = setting.AutoGroups2JsonString()
 	common.OptionMap["DefaultUseAutoGroup"] = strconv.FormatBool(setting.DefaultUseAutoGroup)
+	common.OptionMap["GroupChannelBalance"] = setting.GroupChannelBalance2JsonString()
 	common.OptionMap["PayMethods"] = operation_setting.PayMethods2JsonString()
 	common.OptionMap["GitHubClientId"] = ""
 	common.OptionMap["GitHubClientSecret"] = ""
@@ -191,6 +198,9 @@ func InitOptionMap() {
 func loadOptionsFromDatabase() {
 	options, _ := AllOption()
 	for _, option := range options {
+		if option.Key == TokenKeyHashSecretOption {
+			continue
+		}
 		err := updateOptionMap(option.Key, option.Value)
 		if err != nil {
 			common.SysLog("failed to update option map: " + err.Error())
@@ -207,6 +217,10 @@ func SyncOptions(frequency int) {
 }
 
 func UpdateOption(key string, value string) error {
+	if key == TokenKeyHashSecretOption {
+		// 修改哈希盐会使所有令牌失效
+		return errors.New("option " + key + " cannot be modified")
+	}
 	// Save to database first
 	option := Option{
 		Key: key,
@@ -357,6 +371,8 @@ func updateOptionMap(key string, value string) (err error) {
 		err = setting.UpdateChatsByJsonString(value)
 	case "AutoGroups":
 		err = setting.UpdateAutoGroupsByJsonString(value)
+	case "GroupChannelBalance":
+		err = setting.UpdateGroupChannelBalanceByJsonString(value)
 	case "CustomCallbackAddress":
 		operation_setting.CustomCallbackAddress = value
 	case "EpayId":
@@ -389,6 +405,16 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.CreemTestMode = value == "true"
 	case "CreemWebhookSecret":
 		setting.CreemWebhookSecret = value
+	case "CustomPaymentEnabled":
+		setting.CustomPaymentEnabled = value == "true"
+	case "CustomPaymentName":
+		setting.CustomPaymentName = value
+	case "CustomPaymentCheckoutURL":
+		setting.CustomPaymentCheckoutURL = value
+	case "CustomPaymentRefundURL":
+		setting.CustomPaymentRefundURL = value
+	case "CustomPaymentWebhookSecret":
+		setting.CustomPaymentWebhookSecret = value
 	case "WaffoEnabled":
 		setting.WaffoEnabled = value == "true"
 	case "WaffoApiKey":
@@ -0,0 +1,517 @@
+package model
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OrganizationStatusEnabled  = 1
+	OrganizationStatusDisabled = 2
+)
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+const (
+	OrgInvitationStatusPending  = 1
+	OrgInvitationStatusAccepted = 2
+	OrgInvitationStatusRevoked  = 3
+)
+
+var (
+	ErrOrganizationNotFound          = errors.New("organization not found")
+	ErrOrganizationMemberNotFound    = errors.New("organization member not found")
+	ErrOrganizationQuotaInsufficient = errors.New("organization quota insufficient")
+	ErrOrganizationMemberCapExceeded = errors.New("organization member spending cap exceeded")
+)
+
+// Organization 组织共享额度池，成员通过绑定组织的令牌从组织额度中扣费
+type Organization struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name" gorm:"type:varchar(64);index"`
+	OwnerId     int            `json:"owner_id" gorm:"index"`
+	Quota       int            `json:"quota" gorm:"type:int;default:0"`
+	UsedQuota   int            `json:"used_quota" gorm:"type:int;default:0"`
+	Status      int            `json:"status" gorm:"type:int;default:1"`
+	CreatedTime int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// OrganizationMember 组织成员，QuotaLimit 为成员可从组织额度中累计消费的上限，0 表示不限制
+type OrganizationMember struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"uniqueIndex:idx_org_member,priority:1"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_org_member,priority:2;index"`
+	Role        string `json:"role" gorm:"type:varchar(16);default:'member'"`
+	QuotaLimit  int    `json:"quota_limit" gorm:"type:int;default:0"`
+	UsedQuota   int    `json:"used_quota" gorm:"type:int;default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Username    string `json:"username" gorm:"-:all"`
+	Email       string `json:"email" gorm:"-:all"`
+}
+
+// OrganizationInvitation 邮件邀请，受邀用户使用邀请码加入组织，邮箱需与账号邮箱一致
+type OrganizationInvitation struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"index"`
+	Email       string `json:"email" gorm:"type:varchar(128);index"`
+	Role        string `json:"role" gorm:"type:varchar(16);default:'member'"`
+	Code        string `json:"-" gorm:"type:varchar(64);uniqueIndex"`
+	InviterId   int    `json:"inviter_id"`
+	Status      int    `json:"status" gorm:"type:int;default:1"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// UserOrganization 用户所在组织及其角色
+type UserOrganization struct {
+	Organization
+	Role       string `json:"role"`
+	QuotaLimit int    `json:"quota_limit"`
+	MemberUsed int    `json:"member_used_quota"`
+}
+
+func IsValidOrgRole(role string) bool {
+	switch role {
+	case OrgRoleOwner, OrgRoleAdmin, OrgRoleMember:
+		return true
+	}
+	return false
+}
+
+// IsOrgManager 返回角色是否可以管理组织成员与邀请
+func IsOrgManager(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin
+}
+
+// CreateOrganization 创建组织，创建者成为 owner
+func CreateOrganization(org *Organization) error {
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" {
+		return errors.New("organization name is empty")
+	}
+	now := common.GetTimestamp()
+	org.Id = 0
+	org.Quota = 0
+	org.UsedQuota = 0
+	org.Status = OrganizationStatusEnabled
+	org.CreatedTime = now
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:       org.Id,
+			UserId:      org.OwnerId,
+			Role:        OrgRoleOwner,
+			CreatedTime: now,
+		}).Error
+	})
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	var org Organization
+	err := DB.First(&org, "id = ?", id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+func GetAllOrganizations(startIdx int, num int, keyword string) (orgs []*Organization, total int64, err error) {
+	tx := DB.Model(&Organization{})
+	if keyword = strings.TrimSpace(keyword); keyword != "" {
+		if id := common.String2Int(keyword); id > 0 {
+			tx = tx.Where("id = ? OR name LIKE ?", id, "%"+keyword+"%")
+		} else {
+			tx = tx.Where("name LIKE ?", "%"+keyword+"%")
+		}
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, total, err
+}
+
+// GetUserOrganizations 返回用户加入的所有组织
+func GetUserOrganizations(userId int) ([]*UserOrganization, error) {
+	var members []OrganizationMember
+	if err := DB.Where("user_id = ?", userId).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	result := make([]*UserOrganization, 0, len(members))
+	for _, member := range members {
+		org, err := GetOrganizationById(member.OrgId)
+		if err != nil {
+			if errors.Is(err, ErrOrganizationNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		result = append(result, &UserOrganization{
+			Organization: *org,
+			Role:         member.Role,
+			QuotaLimit:   member.QuotaLimit,
+			MemberUsed:   member.UsedQuota,
+		})
+	}
+	return result, nil
+}
+
+func (org *Organization) Update() error {
+	org.Name = strings.TrimSpace(org.Name)
+	if org.Name == "" {
+		return errors.New("organization name is empty")
+	}
+	return DB.Model(org).Select("name", "status").Updates(org).Error
+}
+
+// DeleteOrganization 删除组织并将剩余额度退回 owner 钱包，绑定该组织的令牌随后无法扣费
+func DeleteOrganization(orgId int) error {
+	var remain int
+	var ownerId int
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var org Organization
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&org, "id = ?", orgId).Error; err != nil {
+			return err
+		}
+		remain = org.Quota
+		ownerId = org.OwnerId
+		if err := tx.Where("org_id = ?", orgId).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&OrganizationInvitation{}).
+			Where("org_id = ? AND status = ?", orgId, OrgInvitationStatusPending).
+			Update("status", OrgInvitationStatusRevoked).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Organization{}, orgId).Error
+	})
+	if err != nil {
+		return err
+	}
+	if remain > 0 {
+		return IncreaseUserQuota(ownerId, remain, true)
+	}
+	return nil
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := DB.Where("org_id = ? AND user_id = ?", orgId, userId).First(&member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrganizationMemberNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetOrganizationMembers 返回组织成员，附带用户名与邮箱
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	if err := DB.Where("org_id = ?", orgId).Order("id").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return members, nil
+	}
+	userIds := make([]int, 0, len(members))
+	for _, member := range members {
+		userIds = append(userIds, member.UserId)
+	}
+	var users []User
+	if err := DB.Select("id", "username", "email").Where("id IN ?", userIds).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	userMap := make(map[int]User, len(users))
+	for _, user := range users {
+		userMap[user.Id] = user
+	}
+	for _, member := range members {
+		member.Username = userMap[member.UserId].Username
+		member.Email = userMap[member.UserId].Email
+	}
+	return members, nil
+}
+
+func UpdateOrganizationMember(member *OrganizationMember) error {
+	if member.QuotaLimit < 0 {
+		return errors.New("quota limit must not be negative")
+	}
+	return DB.Model(&OrganizationMember{}).Where("id = ?", member.Id).
+		Updates(map[string]interface{}{
+			"role":        member.Role,
+			"quota_limit": member.QuotaLimit,
+		}).Error
+}
+
+func RemoveOrganizationMember(orgId int, userId int) error {
+	return DB.Where("org_id = ? AND user_id = ?", orgId, userId).Delete(&OrganizationMember{}).Error
+}
+
+// TransferOrganizationOwner 转移组织所有权，原 owner 降为 admin
+func TransferOrganizationOwner(orgId int, fromUserId int, toUserId int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&OrganizationMember{}).
+			Where("org_id = ? AND user_id = ?", orgId, toUserId).
+			Update("role", OrgRoleOwner)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrganizationMemberNotFound
+		}
+		if err := tx.Model(&OrganizationMember{}).
+			Where("org_id = ? AND user_id = ?", orgId, fromUserId).
+			Update("role", OrgRoleAdmin).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Organization{}).Where("id = ?", orgId).Update("owner_id", toUserId).Error
+	})
+}
+
+// PreConsumeOrganizationQuota 从组织额度中预扣 amount，同时检查组织余额与成员消费上限
+func PreConsumeOrganizationQuota(orgId int, userId int, amount int) error {
+	if amount < 0 {
+		return errors.New("quota 不能为负数！")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&OrganizationMember{}).
+			Where("org_id = ? AND user_id = ? AND (quota_limit = 0 OR used_quota + ? <= quota_limit)", orgId, userId, amount).
+			Update("used_quota", gorm.Expr("used_quota + ?", amount))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if _, err := getOrganizationMemberTx(tx, orgId, userId); err != nil {
+				return err
+			}
+			return ErrOrganizationMemberCapExceeded
+		}
+		result = tx.Model(&Organization{}).
+			Where("id = ? AND status = ? AND quota >= ? AND quota > 0", orgId, OrganizationStatusEnabled, amount).
+			Updates(map[string]interface{}{
+				"quota":      gorm.Expr("quota - ?", amount),
+				"used_quota": gorm.Expr("used_quota + ?", amount),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrganizationQuotaInsufficient
+		}
+		return nil
+	})
+}
+
+func getOrganizationMemberTx(tx *gorm.DB, orgId int, userId int) (*OrganizationMember, error) {
+	var member OrganizationMember
+	err := tx.Where("org_id = ? AND user_id = ?", orgId, userId).First(&member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrganizationMemberNotFound
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+// AdjustOrganizationQuota 按差额调整组织额度与成员消费，delta > 0 表示补扣，delta < 0 表示退还，不检查余额
+func AdjustOrganizationQuota(orgId int, userId int, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Organization{}).Unscoped().Where("id = ?", orgId).
+			Updates(map[string]interface{}{
+				"quota":      gorm.Expr("quota - ?", delta),
+				"used_quota": gorm.Expr("used_quota + ?", delta),
+			}).Error; err != nil {
+			return err
+		}
+		// 成员可能已被移出组织，此时只调整组织额度
+		return tx.Model(&OrganizationMember{}).
+			Where("org_id = ? AND user_id = ?", orgId, userId).
+			Update("used_quota", gorm.Expr("used_quota + ?", delta)).Error
+	})
+}
+
+// TransferUserQuotaToOrganization 将用户钱包额度转入组织
+func TransferUserQuotaToOrganization(userId int, orgId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("quota must be positive")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? AND quota >= ?", userId, quota).
+			Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("用户额度不足")
+		}
+		result = tx.Model(&Organization{}).Where("id = ?", orgId).
+			Update("quota", gorm.Expr("quota + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrganizationNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		if err := cacheDecrUserQuota(userId, int64(quota)); err != nil {
+			common.SysLog("failed to decrease user quota cache: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// AdminAdjustOrganizationQuota 管理员增减组织额度
+func AdminAdjustOrganizationQuota(orgId int, delta int) error {
+	tx := DB.Model(&Organization{}).Where("id = ?", orgId)
+	if delta < 0 {
+		tx = tx.Where("quota >= ?", -delta)
+	}
+	result := tx.Update("quota", gorm.Expr("quota + ?", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOrganizationQuotaInsufficient
+	}
+	return nil
+}
+
+// ---- 邀请 ----
+
+func CreateOrganizationInvitation(invitation *OrganizationInvitation) error {
+	invitation.Id = 0
+	invitation.Email = strings.ToLower(strings.TrimSpace(invitation.Email))
+	invitation.Code = common.GetUUID()
+	invitation.Status = OrgInvitationStatusPending
+	invitation.CreatedTime = common.GetTimestamp()
+	return DB.Create(invitation).Error
+}
+
+func GetOrganizationInvitations(orgId int) ([]*OrganizationInvitation, error) {
+	var invitations []*OrganizationInvitation
+	err := DB.Where("org_id = ?", orgId).Order("id desc").Find(&invitations).Error
+	return invitations, err
+}
+
+func RevokeOrganizationInvitation(orgId int, invitationId int) error {
+	result := DB.Model(&OrganizationInvitation{}).
+		Where("id = ? AND org_id = ? AND status = ?", invitationId, orgId, OrgInvitationStatusPending).
+		Update("status", OrgInvitationStatusRevoked)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请不存在或已失效")
+	}
+	return nil
+}
+
+// AcceptOrganizationInvitation 接受邀请加入组织，邀请邮箱必须与用户邮箱一致
+func AcceptOrganizationInvitation(code string, userId int, email string) (*OrganizationInvitation, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if code == "" || email == "" {
+		return nil, errors.New("邀请无效")
+	}
+	var invitation OrganizationInvitation
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("code = ?", code).First(&invitation).Error; err != nil {
+			return errors.New("邀请无效")
+		}
+		if invitation.Status != OrgInvitationStatusPending ||
+			(invitation.ExpiresAt > 0 && invitation.ExpiresAt < common.GetTimestamp()) {
+			return errors.New("邀请已失效")
+		}
+		if invitation.Email != email {
+			return errors.New("邀请邮箱与当前账号不一致")
+		}
+		var org Organization
+		if err := tx.First(&org, "id = ?", invitation.OrgId).Error; err != nil {
+			return ErrOrganizationNotFound
+		}
+		result := tx.Model(&OrganizationInvitation{}).
+			Where("id = ? AND status = ?", invitation.Id, OrgInvitationStatusPending).
+			Update("status", OrgInvitationStatusAccepted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("邀请已失效")
+		}
+		var count int64
+		if err := tx.Model(&OrganizationMember{}).
+			Where("org_id = ? AND user_id = ?", invitation.OrgId, userId).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("已是该组织成员")
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:       invitation.OrgId,
+			UserId:      userId,
+			Role:        invitation.Role,
+			CreatedTime: common.GetTimestamp(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// OrganizationMemberUsage 成员在组织内的消费汇总
+type OrganizationMemberUsage struct {
+	UserId           int    `json:"user_id"`
+	Username         string `json:"username"`
+	Quota            int    `json:"quota"`
+	RequestCount     int    `json:"request_count"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// GetOrganizationUsage 按成员汇总组织令牌产生的消费日志，userId 为 0 时汇总所有成员
+func GetOrganizationUsage(orgId int, userId int, startTimestamp int64, endTimestamp int64) ([]*OrganizationMemberUsage, error) {
+	tx := LOG_DB.Table("logs").
+		Select("user_id, username, sum(quota) as quota, count(*) as request_count, sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens").
+		Where("org_id = ? AND type = ?", orgId, LogTypeConsume)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	var usages []*OrganizationMemberUsage
+	err := tx.Group("user_id, username").Order("quota desc").Scan(&usages).Error
+	return usages, err
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// mysqlTextMaxBytes MySQL TEXT 列的最大长度，数据库存储时载荷不能超过该大小
+const mysqlTextMaxBytes = 65535
+
+// PayloadCapture 单次请求的请求体与响应体，保存在日志数据库；磁盘存储时只记录文件路径
+type PayloadCapture struct {
+	Id                int    `json:"id"`
+	RequestId         string `json:"request_id" gorm:"type:varchar(64);index"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	TokenId           int    `json:"token_id" gorm:"default:0"`
+	ChannelId         int    `json:"channel_id" gorm:"default:0"`
+	ModelName         string `json:"model_name" gorm:"default:''"`
+	IsStream          bool   `json:"is_stream"`
+	StatusCode        int    `json:"status_code"`
+	RequestBody       string `json:"request_body,omitempty" gorm:"type:text"`
+	ResponseBody      string `json:"response_body,omitempty" gorm:"type:text"`
+	RequestTruncated  bool   `json:"request_truncated"`
+	ResponseTruncated bool   `json:"response_truncated"`
+	StoragePath       string `json:"-" gorm:"type:varchar(512);default:''"`
+	CreatedAt         int64  `json:"created_at" gorm:"bigint"`
+	ExpiresAt         int64  `json:"expires_at" gorm:"bigint;index"`
+}
+
+// payloadCaptureFile 磁盘存储时文件中保存的内容
+type payloadCaptureFile struct {
+	RequestBody  string `json:"request_body"`
+	ResponseBody string `json:"response_body"`
+}
+
+// MaxPayloadCaptureDBBodyBytes 返回数据库存储时单个载荷的最大字节数，0 表示不限制
+func MaxPayloadCaptureDBBodyBytes() int {
+	if LOG_DB != nil && LOG_DB.Dialector.Name() == "mysql" {
+		// 请求体与响应体各占一列，保留余量避免多字节字符截断后超限
+		return mysqlTextMaxBytes - 1024
+	}
+	return 0
+}
+
+// CreatePayloadCapture 保存载荷，toDisk 时请求体与响应体写入磁盘缓存目录，数据库只保留元数据
+func CreatePayloadCapture(capture *PayloadCapture, toDisk bool) error {
+	if toDisk {
+		data, err := common.Marshal(payloadCaptureFile{
+			RequestBody:  capture.RequestBody,
+			ResponseBody: capture.ResponseBody,
+		})
+		if err != nil {
+			return err
+		}
+		path, err := common.WritePersistentDiskCacheFile(common.DiskCacheTypePayload, data)
+		if err != nil {
+			return err
+		}
+		capture.StoragePath = path
+		capture.RequestBody = ""
+		capture.ResponseBody = ""
+	}
+	if err := LOG_DB.Create(capture).Error; err != nil {
+		if capture.StoragePath != "" {
+			_ = common.RemoveDiskCacheFile(capture.StoragePath)
+		}
+		return err
+	}
+	return nil
+}
+
+// GetPayloadCapturesByRequestId 按 RequestId 查询未过期的载荷，磁盘存储的载荷从文件读取
+func GetPayloadCapturesByRequestId(requestId string) ([]*PayloadCapture, error) {
+	var captures []*PayloadCapture
+	err := LOG_DB.Where("request_id = ? AND expires_at > ?", requestId, common.GetTimestamp()).
+		Order("id").Find(&captures).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, capture := range captures {
+		if capture.StoragePath == "" {
+			continue
+		}
+		data, err := common.ReadDiskCacheFile(capture.StoragePath)
+		if err != nil {
+			return nil, errors.New("载荷保存在磁盘上，但当前节点无法读取，可能位于其他节点或已被清理")
+		}
+		var file payloadCaptureFile
+		if err := common.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		capture.RequestBody = file.RequestBody
+		capture.ResponseBody = file.ResponseBody
+	}
+	return captures, nil
+}
+
+// DeleteExpiredPayloadCaptures 删除过期的载荷记录，返回删除数量；磁盘文件由各节点按修改时间清理
+func DeleteExpiredPayloadCaptures(limit int) (int64, error) {
+	var ids []int
+	err := LOG_DB.Model(&PayloadCapture{}).
+		Where("expires_at <= ?", common.GetTimestamp()).
+		Limit(limit).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	result := LOG_DB.Where("id IN ?", ids).Delete(&PayloadCapture{})
+	return result.RowsAffected, result.Error
+}
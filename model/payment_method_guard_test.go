@@ -87,14 +87,14 @@ func getUserQuotaForPaymentGuardTest(t *testing.T, userID int) int {
 	return user.Quota
 }
 
-func TestRechargeWaffoPancake_RejectsMismatchedPaymentMethod(t *testing.T) {
+func TestCompleteTopUp_RejectsCallbackFromOtherProvider(t *testing.T) {
 	truncateTables(t)
 
 	insertUserForPaymentGuardTest(t, 101, 0)
 	insertTopUpForPaymentGuardTest(t, "waffo-pancake-guard", 101, PaymentProviderStripe)
 
-	err := RechargeWaffoPancake("waffo-pancake-guard")
-	require.Error(t, err)
+	err := CompleteTopUp("waffo-pancake-guard", PaymentProviderWaffoPancake, "", nil, "127.0.0.1")
+	require.ErrorIs(t, err, ErrPaymentMethodMismatch)
 
 	topUp := GetTopUpByTradeNo("waffo-pancake-guard")
 	require.NotNil(t, topUp)
@@ -7,6 +7,7 @@ import (
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
 )
@@ -14,7 +15,8 @@ import (
 type Token struct {
 	Id                 int            `json:"id"`
 	UserId             int            `json:"user_id" gorm:"index"`
-	Key                string         `json:"key" gorm:"type:varchar(128);uniqueIndex"`
+	Key                string         `json:"key" gorm:"type:varchar(128);uniqueIndex"`      // 明文 key 的 HMAC，旧数据迁移前为明文
+	KeyPrefix          string         `json:"key_prefix" gorm:"type:varchar(16);default:''"` // 明文 key 的展示前缀，非空表示 key 已哈希
 	Status             int            `json:"status" gorm:"default:1"`
 	Name               string         `json:"name" gorm:"index" `
 	CreatedTime        int64          `json:"created_time" gorm:"bigint"`
@@ -27,7 +29,19 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
-	CrossGroupRetry    bool           `json:"cross_group_retry"` // 跨分组重试，仅auto分组有效
+	CrossGroupRetry    bool           `json:"cross_group_retry"`                                       // 跨分组重试，仅auto分组有效
+	TpmLimit           int            `json:"tpm_limit" gorm:"default:0"`                              // 每分钟 token 数限制，0 不限制
+	ConcurrencyLimit   int            `json:"concurrency_limit" gorm:"default:0"`                      // 最大并发请求数，0 不限制
+	ResponseCache      bool           `json:"response_cache"`                                          // 启用响应缓存，需同时开启全局响应缓存
+	BudgetPeriod       string         `json:"budget_period" gorm:"type:varchar(16);default:''"`        // 周期预算：daily/weekly/monthly，空表示不限制
+	BudgetMode         string         `json:"budget_mode" gorm:"type:varchar(16);default:''"`          // 周期预算窗口：calendar/rolling
+	BudgetLimit        int            `json:"budget_limit" gorm:"default:0"`                           // 每个窗口的消费上限，0 不限制
+	OrgId              int            `json:"org_id" gorm:"default:0;index"`                           // 绑定的组织，非 0 时从组织额度扣费
+	PayloadCapture     bool           `json:"payload_capture"`                                         // 留存请求/响应载荷，需同时开启全局载荷留存
+	Scopes             string         `json:"scopes" gorm:"type:varchar(255);default:''"`              // 允许访问的接口范围，逗号分隔，空表示不限制
+	MaxTokens          int            `json:"max_tokens" gorm:"default:0"`                             // 单次生成请求的最大输出 token 数，0 不限制
+	DisallowedFeatures string         `json:"disallowed_features" gorm:"type:varchar(255);default:''"` // 禁止使用的请求特性，逗号分隔
+	ParamOverride      *string        `json:"param_override" gorm:"type:text"`                         // 令牌级参数覆盖，格式同渠道参数覆盖，先于渠道执行
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -48,14 +62,36 @@ func MaskTokenKey(key string) string {
 	return key[:4] + "**********" + key[len(key)-4:]
 }
 
-func (token *Token) GetFullKey() string {
-	return token.Key
-}
-
 func (token *Token) GetMaskedKey() string {
+	if token.IsKeyHashed() {
+		return token.KeyPrefix + "**********"
+	}
 	return MaskTokenKey(token.Key)
 }
 
+func (token *Token) GetBudgetWindow() types.BudgetWindow {
+	return types.BudgetWindow{Period: token.BudgetPeriod, Mode: token.BudgetMode, Limit: token.BudgetLimit}
+}
+
+func (token *Token) GetRestrictions() types.TokenRestrictions {
+	return types.TokenRestrictions{
+		Scopes:             types.SplitTokenRestrictionList(token.Scopes),
+		MaxTokens:          token.MaxTokens,
+		DisallowedFeatures: types.SplitTokenRestrictionList(token.DisallowedFeatures),
+	}
+}
+
+func (token *Token) GetParamOverride() map[string]interface{} {
+	paramOverride := make(map[string]interface{})
+	if token.ParamOverride != nil && *token.ParamOverride != "" {
+		err := common.Unmarshal([]byte(*token.ParamOverride), &paramOverride)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to unmarshal token param override: token_id=%d, error=%v", token.Id, err))
+		}
+	}
+	return paramOverride
+}
+
 func (token *Token) GetIpLimits() []string {
 	// delete empty spaces
 	//split with \n
@@ -124,6 +160,9 @@ func sanitizeLikePattern(input string) (string, error) {
 
 const searchHardLimit = 100
 
+// ErrTokenKeyFuzzySearch 令牌 key 以哈希存储，不支持模糊搜索
+var ErrTokenKeyFuzzySearch = errors.New("token key only supports exact search")
+
 func SearchUserTokens(userId int, keyword string, token string, offset int, limit int) (tokens []*Token, total int64, err error) {
 	// model 层强制截断
 	if limit <= 0 || limit > searchHardLimit {
@@ -133,14 +172,14 @@ func SearchUserTokens(userId int, keyword string, token string, offset int, limi
 		offset = 0
 	}
 
-	if token != "" {
-		token = strings.TrimPrefix(token, "sk-")
+	token = strings.TrimPrefix(strings.TrimSpace(token), "sk-")
+	if strings.Contains(token, "%") {
+		return nil, 0, ErrTokenKeyFuzzySearch
 	}
 
 	// 超量用户（令牌数超过上限）只允许精确搜索，禁止模糊搜索
 	maxTokens := operation_setting.GetMaxUserTokens()
-	hasFuzzy := strings.Contains(keyword, "%") || strings.Contains(token, "%")
-	if hasFuzzy {
+	if strings.Contains(keyword, "%") {
 		count, err := CountUserTokens(userId)
 		if err != nil {
 			common.SysLog("failed to count user tokens: " + err.Error())
@@ -162,11 +201,8 @@ func SearchUserTokens(userId int, keyword string, token string, offset int, limi
 		baseQuery = baseQuery.Where("name LIKE ? ESCAPE '!'", keywordPattern)
 	}
 	if token != "" {
-		tokenPattern, err := sanitizeLikePattern(token)
-		if err != nil {
-			return nil, 0, err
-		}
-		baseQuery = baseQuery.Where(commonKeyCol+" LIKE ? ESCAPE '!'", tokenPattern)
+		// 数据库中只保存 key 的哈希（启动时已迁移旧的明文 key），只能按完整 key 精确匹配
+		baseQuery = baseQuery.Where(map[string]interface{}{"key": HashTokenKey(token)})
 	}
 
 	// 先查匹配总数（用于分页，受 maxTokens 上限保护，避免全表 COUNT）
@@ -190,6 +226,19 @@ func ValidateUserToken(key string) (token *Token, err error) {
 		return nil, ErrTokenNotProvided
 	}
 	token, err = GetTokenByKey(key, false)
+	return validateToken(token, err)
+}
+
+// ValidateUserTokenById 校验进程内请求（如批处理 worker）使用的令牌，这类请求没有明文 key
+func ValidateUserTokenById(id int) (token *Token, err error) {
+	if id == 0 {
+		return nil, ErrTokenNotProvided
+	}
+	token, err = GetTokenById(id)
+	return validateToken(token, err)
+}
+
+func validateToken(token *Token, err error) (*Token, error) {
 	if err == nil {
 		if token.Status == common.TokenStatusExhausted ||
 			token.Status == common.TokenStatusExpired ||
@@ -218,7 +267,7 @@ func ValidateUserToken(key string) (token *Token, err error) {
 		}
 		return token, nil
 	}
-	common.SysLog("ValidateUserToken: failed to get token: " + err.Error())
+	common.SysLog("validateToken: failed to get token: " + err.Error())
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, ErrTokenInvalid
 	}
@@ -252,7 +301,20 @@ func GetTokenById(id int) (*Token, error) {
 	return &token, err
 }
 
+// GetTokenByKey 通过用户提交的明文 key（不含 sk- 前缀）查找令牌
 func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
+	token, err = GetTokenByKeyHash(HashTokenKey(key), fromDB)
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		// 兼容尚未哈希的明文令牌
+		if legacy, legacyErr := getLegacyTokenByKey(key); legacyErr == nil {
+			return legacy, nil
+		}
+	}
+	return token, err
+}
+
+// GetTokenByKeyHash 通过存储的 key 哈希查找令牌，即上下文中的 token_key
+func GetTokenByKeyHash(keyHash string, fromDB bool) (token *Token, err error) {
 	defer func() {
 		// Update Redis cache asynchronously on successful DB read
 		if shouldUpdateRedis(fromDB, err) && token != nil {
@@ -265,14 +327,17 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 	}()
 	if !fromDB && common.RedisEnabled {
 		// Try Redis first
-		token, err := cacheGetTokenByKey(key)
+		token, err := cacheGetTokenByKey(keyHash)
 		if err == nil {
 			return token, nil
 		}
 		// Don't return error - fall through to DB
 	}
+	if keyHash == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
 	fromDB = true
-	err = DB.Where(commonKeyCol+" = ?", key).First(&token).Error
+	err = DB.Where(&Token{Key: keyHash}).First(&token).Error
 	return token, err
 }
 
@@ -282,6 +347,30 @@ func (token *Token) Insert() error {
 	return err
 }
 
+// RegenerateKey 生成新的 key 替换原 key，原 key 立即失效，返回的明文 key 只展示这一次
+func (token *Token) RegenerateKey() (string, error) {
+	key, err := common.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+	oldKey := token.Key
+	if err := token.SetRawKey(key); err != nil {
+		return "", err
+	}
+	err = DB.Model(token).Select("key", "key_prefix").Updates(token).Error
+	if err != nil {
+		return "", err
+	}
+	if common.RedisEnabled {
+		gopool.Go(func() {
+			if err := cacheDeleteToken(oldKey); err != nil {
+				common.SysLog("failed to delete token cache: " + err.Error())
+			}
+		})
+	}
+	return key, nil
+}
+
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (token *Token) Update() (err error) {
 	defer func() {
@@ -295,7 +384,8 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "group", "cross_group_retry", "tpm_limit", "concurrency_limit", "response_cache",
+		"budget_period", "budget_mode", "budget_limit", "org_id", "payload_capture", "scopes", "max_tokens", "disallowed_features", "param_override").Updates(token).Error
 	return err
 }
 
@@ -473,14 +563,6 @@ func BatchDeleteTokens(ids []int, userId int) (int, error) {
 	return len(tokens), nil
 }
 
-func GetTokenKeysByIds(ids []int, userId int) ([]Token, error) {
-	var tokens []Token
-	err := DB.Select("id", commonKeyCol).
-		Where("user_id = ? AND id IN (?)", userId, ids).
-		Find(&tokens).Error
-	return tokens, err
-}
-
 // InvalidateUserTokensCache 清理指定用户所有令牌在 Redis 中的缓存，
 // 配合 InvalidateUserCache 使用，可在用户被禁用/删除时立即阻断其令牌的请求。
 // 下一次请求将从数据库重新加载令牌及用户状态，从而立即识别出被禁用的用户。
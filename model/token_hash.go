@@ -0,0 +1,174 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 令牌 key 以加盐 HMAC 形式存储在 key 列中，明文 key 只在创建或重新生成时返回一次。
+// key_prefix 非空表示该行已完成哈希，旧版本写入的明文行在迁移或首次使用时原地哈希。
+
+const (
+	// TokenKeyHashSecretOption 存储哈希盐的系统选项，首次启动时随机生成，不能通过选项接口修改
+	TokenKeyHashSecretOption = "TokenKeyHashSecret"
+	tokenKeyPrefixLength     = 4
+	tokenKeyMigrateBatchSize = 500
+)
+
+var (
+	tokenKeyHashSecret     []byte
+	tokenKeyHashSecretLock sync.Mutex
+)
+
+// initTokenKeyHashSecret 从数据库加载哈希盐，不存在时生成，多节点共享同一个盐
+func initTokenKeyHashSecret() error {
+	tokenKeyHashSecretLock.Lock()
+	defer tokenKeyHashSecretLock.Unlock()
+	if tokenKeyHashSecret != nil {
+		return nil
+	}
+	option := Option{Key: TokenKeyHashSecretOption}
+	err := DB.Where(Option{Key: TokenKeyHashSecretOption}).
+		Attrs(Option{Value: common.GetRandomString(64)}).
+		FirstOrCreate(&option).Error
+	if err != nil {
+		// 其他节点同时创建时主键冲突，重新读取
+		if err := DB.Where(Option{Key: TokenKeyHashSecretOption}).First(&option).Error; err != nil {
+			return fmt.Errorf("failed to init token key hash secret: %w", err)
+		}
+	}
+	if option.Value == "" {
+		return errors.New("token key hash secret is empty")
+	}
+	tokenKeyHashSecret = []byte(option.Value)
+	return nil
+}
+
+// getTokenKeyHashSecret 返回哈希盐，从节点启动时主节点可能尚未完成迁移，此时在使用时重新加载
+func getTokenKeyHashSecret() ([]byte, error) {
+	tokenKeyHashSecretLock.Lock()
+	secret := tokenKeyHashSecret
+	tokenKeyHashSecretLock.Unlock()
+	if secret != nil {
+		return secret, nil
+	}
+	if err := initTokenKeyHashSecret(); err != nil {
+		return nil, err
+	}
+	return getTokenKeyHashSecret()
+}
+
+// HashTokenKey 计算明文令牌 key（不含 sk- 前缀）的存储哈希
+func HashTokenKey(key string) string {
+	secret, err := getTokenKeyHashSecret()
+	if err != nil {
+		// 盐不可用时返回不可能匹配的值，请求按令牌无效处理
+		common.SysError("failed to load token key hash secret: " + err.Error())
+		return ""
+	}
+	return common.GenerateHMACWithKey(secret, key)
+}
+
+func tokenKeyPrefix(key string) string {
+	if len(key) <= tokenKeyPrefixLength {
+		return ""
+	}
+	return key[:tokenKeyPrefixLength]
+}
+
+// SetRawKey 设置新的明文 key，只保存哈希与展示前缀，调用方负责把明文 key 返回给用户
+func (token *Token) SetRawKey(key string) error {
+	keyHash := HashTokenKey(key)
+	if keyHash == "" {
+		return errors.New("token key hash secret is not available")
+	}
+	token.Key = keyHash
+	token.KeyPrefix = tokenKeyPrefix(key)
+	if token.KeyPrefix == "" {
+		// 保证已哈希的行 key_prefix 非空
+		token.KeyPrefix = "*"
+	}
+	return nil
+}
+
+// IsKeyHashed reports whether the stored key is a hash rather than a legacy plaintext key.
+func (token *Token) IsKeyHashed() bool {
+	return token.KeyPrefix != ""
+}
+
+// hashLegacyTokenKey 将明文存储的令牌原地哈希，只在 key 仍为原值时更新，避免与并发的迁移冲突
+func hashLegacyTokenKey(token *Token) error {
+	if token.IsKeyHashed() {
+		return nil
+	}
+	rawKey := token.Key
+	hashed := *token
+	if err := hashed.SetRawKey(rawKey); err != nil {
+		return err
+	}
+	result := DB.Unscoped().Model(&Token{}).
+		Where(&Token{Id: token.Id, Key: rawKey}).
+		Where("key_prefix = ?", "").
+		Updates(map[string]interface{}{
+			"key":        hashed.Key,
+			"key_prefix": hashed.KeyPrefix,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	token.Key = hashed.Key
+	token.KeyPrefix = hashed.KeyPrefix
+	if common.RedisEnabled {
+		_ = cacheDeleteToken(rawKey)
+	}
+	return nil
+}
+
+// migrateTokenKeysToHash 将所有明文存储的令牌 key 原地哈希，旧客户端继续使用原 key 访问
+func migrateTokenKeysToHash() error {
+	migrated := 0
+	lastId := 0
+	for {
+		var tokens []*Token
+		err := DB.Unscoped().Select("id", commonKeyCol, "key_prefix").
+			Where("id > ? AND key_prefix = ?", lastId, "").
+			Order("id").Limit(tokenKeyMigrateBatchSize).
+			Find(&tokens).Error
+		if err != nil {
+			return fmt.Errorf("failed to load tokens for key hashing: %w", err)
+		}
+		for _, token := range tokens {
+			lastId = token.Id
+			if token.Key == "" {
+				continue
+			}
+			if err := hashLegacyTokenKey(token); err != nil {
+				return fmt.Errorf("failed to hash key of token %d: %w", token.Id, err)
+			}
+			migrated++
+		}
+		if len(tokens) < tokenKeyMigrateBatchSize {
+			break
+		}
+	}
+	if migrated > 0 {
+		common.SysLog(fmt.Sprintf("hashed %d plaintext token keys", migrated))
+	}
+	return nil
+}
+
+// getLegacyTokenByKey 查找尚未哈希的明文令牌（如旧版本节点在滚动升级期间创建的令牌），找到后原地哈希
+func getLegacyTokenByKey(key string) (*Token, error) {
+	var token *Token
+	err := DB.Where(&Token{Key: key}).Where("key_prefix = ?", "").First(&token).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := hashLegacyTokenKey(token); err != nil {
+		common.SysLog(fmt.Sprintf("failed to hash legacy key of token %d: %v", token.Id, err))
+	}
+	return token, nil
+}
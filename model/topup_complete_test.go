@@ -0,0 +1,80 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompleteTopUp_IsIdempotent(t *testing.T) {
+	truncateTables(t)
+	insertUserForPaymentGuardTest(t, 201, 0)
+	insertTopUpForPaymentGuardTest(t, "custom-complete", 201, PaymentProviderCustom)
+
+	require.NoError(t, CompleteTopUp("custom-complete", PaymentProviderCustom, "card", nil, "127.0.0.1"))
+	require.NoError(t, CompleteTopUp("custom-complete", PaymentProviderCustom, "card", nil, "127.0.0.1"))
+
+	topUp := GetTopUpByTradeNo("custom-complete")
+	require.NotNil(t, topUp)
+	assert.Equal(t, common.TopUpStatusSuccess, topUp.Status)
+	assert.Equal(t, "card", topUp.PaymentMethod)
+	assert.Equal(t, int(2*common.QuotaPerUnit), getUserQuotaForPaymentGuardTest(t, 201))
+}
+
+func TestCompleteTopUp_RejectsMismatchedPaymentProvider(t *testing.T) {
+	truncateTables(t)
+	insertUserForPaymentGuardTest(t, 202, 0)
+	insertTopUpForPaymentGuardTest(t, "custom-mismatch", 202, PaymentProviderEpay)
+
+	err := CompleteTopUp("custom-mismatch", PaymentProviderCustom, "", nil, "127.0.0.1")
+	require.ErrorIs(t, err, ErrPaymentMethodMismatch)
+	assert.Equal(t, common.TopUpStatusPending, getTopUpStatusForPaymentGuardTest(t, "custom-mismatch"))
+	assert.Equal(t, 0, getUserQuotaForPaymentGuardTest(t, 202))
+}
+
+func TestCompleteTopUp_WritesBackPayer(t *testing.T) {
+	truncateTables(t)
+	insertUserForPaymentGuardTest(t, 204, 0)
+	insertTopUpForPaymentGuardTest(t, "stripe-payer", 204, PaymentProviderStripe)
+
+	payer := &TopUpPayer{CustomerId: "cus_123", Email: "payer@example.com"}
+	require.NoError(t, CompleteTopUp("stripe-payer", PaymentProviderStripe, "", payer, "127.0.0.1"))
+
+	var user User
+	require.NoError(t, DB.Select("quota", "email", "stripe_customer").Where("id = ?", 204).First(&user).Error)
+	assert.Equal(t, int(9.99*common.QuotaPerUnit), user.Quota)
+	assert.Equal(t, "cus_123", user.StripeCustomer)
+	assert.Equal(t, "payer@example.com", user.Email)
+
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", 204).Update("email", "bound@example.com").Error)
+	insertTopUpForPaymentGuardTest(t, "creem-payer", 204, PaymentProviderCreem)
+	require.NoError(t, CompleteTopUp("creem-payer", PaymentProviderCreem, "", &TopUpPayer{CustomerId: "cust_456", Email: "other@example.com"}, "127.0.0.1"))
+
+	user = User{}
+	require.NoError(t, DB.Select("quota", "email", "stripe_customer").Where("id = ?", 204).First(&user).Error)
+	assert.Equal(t, int(9.99*common.QuotaPerUnit)+2, user.Quota)
+	assert.Equal(t, "cus_123", user.StripeCustomer)
+	assert.Equal(t, "bound@example.com", user.Email)
+}
+
+func TestRefundTopUp_DeductsQuotaOnce(t *testing.T) {
+	truncateTables(t)
+	insertUserForPaymentGuardTest(t, 203, 0)
+	insertTopUpForPaymentGuardTest(t, "custom-refund", 203, PaymentProviderCustom)
+
+	_, err := RefundTopUp("custom-refund", "127.0.0.1")
+	require.ErrorIs(t, err, ErrTopUpStatusInvalid)
+
+	require.NoError(t, CompleteTopUp("custom-refund", PaymentProviderCustom, "", nil, "127.0.0.1"))
+	quota, err := RefundTopUp("custom-refund", "127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, int(2*common.QuotaPerUnit), quota)
+	assert.Equal(t, common.TopUpStatusRefunded, getTopUpStatusForPaymentGuardTest(t, "custom-refund"))
+	assert.Equal(t, 0, getUserQuotaForPaymentGuardTest(t, 203))
+
+	_, err = RefundTopUp("custom-refund", "127.0.0.1")
+	require.ErrorIs(t, err, ErrTopUpStatusInvalid)
+	assert.Equal(t, 0, getUserQuotaForPaymentGuardTest(t, 203))
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
@@ -52,6 +53,10 @@ type User struct {
 	StripeCustomer   string         `json:"stripe_customer" gorm:"type:varchar(64);column:stripe_customer;index"`
 	CreatedAt        int64          `json:"created_at" gorm:"autoCreateTime;column:created_at"`
 	LastLoginAt      int64          `json:"last_login_at" gorm:"default:0;column:last_login_at"`
+	BudgetPeriod     string         `json:"budget_period" gorm:"type:varchar(16);default:''"` // 周期预算：daily/weekly/monthly，空表示不限制
+	BudgetMode       string         `json:"budget_mode" gorm:"type:varchar(16);default:''"`   // 周期预算窗口：calendar/rolling
+	BudgetLimit      int            `json:"budget_limit" gorm:"type:int;default:0"`           // 每个窗口的消费上限，0 不限制
+	CreditLimit      int            `json:"credit_limit" gorm:"type:int;default:0"`           // 后付费信用额度，余额最多可透支到 -CreditLimit，0 为预付费
 }
 
 func (user *User) ToBaseUser() *UserBase {
@@ -63,10 +68,19 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+
+		BudgetPeriod: user.BudgetPeriod,
+		BudgetMode:   user.BudgetMode,
+		BudgetLimit:  user.BudgetLimit,
+		CreditLimit:  user.CreditLimit,
 	}
 	return cache
 }
 
+func (user *User) GetBudgetWindow() types.BudgetWindow {
+	return types.BudgetWindow{Period: user.BudgetPeriod, Mode: user.BudgetMode, Limit: user.BudgetLimit}
+}
+
 func (user *User) GetAccessToken() string {
 	if user.AccessToken == nil {
 		return ""
@@ -522,10 +536,14 @@ func (user *User) Edit(updatePassword bool) error {
 
 	newUser := *user
 	updates := map[string]interface{}{
-		"username":     newUser.Username,
-		"display_name": newUser.DisplayName,
-		"group":        newUser.Group,
-		"remark":       newUser.Remark,
+		"username":      newUser.Username,
+		"display_name":  newUser.DisplayName,
+		"group":         newUser.Group,
+		"remark":        newUser.Remark,
+		"budget_period": newUser.BudgetPeriod,
+		"budget_mode":   newUser.BudgetMode,
+		"budget_limit":  newUser.BudgetLimit,
+		"credit_limit":  newUser.CreditLimit,
 	}
 	if updatePassword {
 		updates["password"] = newUser.Password
@@ -7,6 +7,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
 
@@ -22,6 +23,11 @@ type UserBase struct {
 	Status   int    `json:"status"`
 	Username string `json:"username"`
 	Setting  string `json:"setting"`
+
+	BudgetPeriod string `json:"budget_period"`
+	BudgetMode   string `json:"budget_mode"`
+	BudgetLimit  int    `json:"budget_limit"`
+	CreditLimit  int    `json:"credit_limit"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -31,6 +37,12 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	common.SetContextKey(c, constant.ContextKeyUserEmail, user.Email)
 	common.SetContextKey(c, constant.ContextKeyUserName, user.Username)
 	common.SetContextKey(c, constant.ContextKeyUserSetting, user.GetSetting())
+	common.SetContextKey(c, constant.ContextKeyUserBudget, user.GetBudgetWindow())
+	common.SetContextKey(c, constant.ContextKeyUserCreditLimit, user.CreditLimit)
+}
+
+func (user *UserBase) GetBudgetWindow() types.BudgetWindow {
+	return types.BudgetWindow{Period: user.BudgetPeriod, Mode: user.BudgetMode, Limit: user.BudgetLimit}
 }
 
 func (user *UserBase) GetSetting() dto.UserSetting {
@@ -105,15 +117,7 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 	}
 
 	// Create cache object from user data
-	userCache = &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
-	}
+	userCache = user.ToBaseUser()
 
 	return userCache, nil
 }
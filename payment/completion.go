@@ -0,0 +1,95 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+)
+
+// HandleEvent 处理网关回调事件。充值与订阅订单共用同一条幂等的完成路径：
+// 按订单号加锁后先尝试订阅订单，不存在时再按充值订单处理，重复回调不会重复入账。
+func HandleEvent(provider Provider, event *WebhookEvent, callerIp string) error {
+	if event == nil {
+		return nil
+	}
+	if event.TradeNo == "" {
+		return errors.New("webhook event has no trade number")
+	}
+
+	LockOrder(event.TradeNo)
+	defer UnlockOrder(event.TradeNo)
+
+	switch event.Type {
+	case EventPaid:
+		return completeOrder(provider.Name(), event, callerIp)
+	case EventFailed:
+		return closeOrder(provider.Name(), event.TradeNo, common.TopUpStatusFailed)
+	case EventExpired:
+		return closeOrder(provider.Name(), event.TradeNo, common.TopUpStatusExpired)
+	default:
+		return nil
+	}
+}
+
+func completeOrder(providerName string, event *WebhookEvent, callerIp string) error {
+	err := model.CompleteSubscriptionOrder(event.TradeNo, event.Payload, providerName, event.PaymentMethod)
+	if !errors.Is(err, model.ErrSubscriptionOrderNotFound) {
+		return err
+	}
+	var payer *model.TopUpPayer
+	if event.CustomerId != "" || event.CustomerEmail != "" {
+		payer = &model.TopUpPayer{CustomerId: event.CustomerId, Email: event.CustomerEmail}
+	}
+	return model.CompleteTopUp(event.TradeNo, providerName, event.PaymentMethod, payer, callerIp)
+}
+
+// closeOrder 关闭未支付的订单，已完成或本地不存在的订单保持不变
+func closeOrder(providerName string, tradeNo string, status string) error {
+	err := model.ExpireSubscriptionOrder(tradeNo, providerName)
+	if !errors.Is(err, model.ErrSubscriptionOrderNotFound) {
+		return err
+	}
+	err = model.UpdatePendingTopUpStatus(tradeNo, providerName, status)
+	if errors.Is(err, model.ErrTopUpStatusInvalid) || errors.Is(err, model.ErrTopUpNotFound) {
+		return nil
+	}
+	return err
+}
+
+// RefundTopUp 通过订单所属网关退款，成功后扣回充值额度，返回扣回的额度
+func RefundTopUp(ctx context.Context, tradeNo string, callerIp string) (int, error) {
+	LockOrder(tradeNo)
+	defer UnlockOrder(tradeNo)
+
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return 0, model.ErrTopUpNotFound
+	}
+	if model.GetSubscriptionOrderByTradeNo(tradeNo) != nil {
+		return 0, errors.New("订阅订单暂不支持退款")
+	}
+	if topUp.Status != common.TopUpStatusSuccess {
+		return 0, model.ErrTopUpStatusInvalid
+	}
+	provider := GetProvider(topUp.PaymentProvider)
+	if provider == nil {
+		return 0, ErrProviderNotFound
+	}
+
+	err := provider.Refund(ctx, &RefundRequest{
+		TradeNo:       topUp.TradeNo,
+		Money:         topUp.Money,
+		PaymentMethod: topUp.PaymentMethod,
+	})
+	if err != nil {
+		return 0, err
+	}
+	quota, err := model.RefundTopUp(tradeNo, callerIp)
+	if err != nil {
+		return 0, fmt.Errorf("gateway refund succeeded but failed to update order: %w", err)
+	}
+	return quota, nil
+}
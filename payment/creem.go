@@ -0,0 +1,130 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+const (
+	creemSignatureHeader = "creem-signature"
+	creemMaxBodySize     = 1 << 20
+)
+
+func init() {
+	RegisterDedicated(&CreemProvider{})
+}
+
+// CreemProvider Creem 按产品下单，充值与订阅由独立接口下单，回调统一走 HandleEvent
+type CreemProvider struct{}
+
+// creemWebhookEvent Creem 回调中用到的字段
+type creemWebhookEvent struct {
+	Id        string `json:"id"`
+	EventType string `json:"eventType"`
+	Object    struct {
+		RequestId string `json:"request_id"`
+		Order     struct {
+			Id     string `json:"id"`
+			Status string `json:"status"`
+			Type   string `json:"type"`
+		} `json:"order"`
+		Customer struct {
+			Email string `json:"email"`
+		} `json:"customer"`
+	} `json:"object"`
+}
+
+func (p *CreemProvider) Name() string {
+	return model.PaymentProviderCreem
+}
+
+func (p *CreemProvider) IsEnabled() bool {
+	products := strings.TrimSpace(setting.CreemProducts)
+	return strings.TrimSpace(setting.CreemApiKey) != "" &&
+		products != "" &&
+		products != "[]" &&
+		strings.TrimSpace(setting.CreemWebhookSecret) != ""
+}
+
+// creemSignature 计算 Creem 回调的 HMAC-SHA256 签名
+func creemSignature(payload string, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyCreemSignature 校验 Creem 回调签名，测试模式下未配置密钥时跳过
+func verifyCreemSignature(ctx context.Context, payload string, signature string, secret string) bool {
+	if secret == "" {
+		if setting.CreemTestMode {
+			logger.LogInfo(ctx, "Creem webhook 验签已跳过 reason=test_mode")
+			return true
+		}
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(creemSignature(payload, secret)))
+}
+
+func (p *CreemProvider) CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	return nil, ErrCheckoutNotSupported
+}
+
+func (p *CreemProvider) VerifyWebhook(r *http.Request) (*WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, creemMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	signature := r.Header.Get(creemSignatureHeader)
+	if signature == "" {
+		return nil, errors.New("missing creem signature")
+	}
+	if !verifyCreemSignature(r.Context(), string(body), signature, setting.CreemWebhookSecret) {
+		return nil, errors.New("invalid creem signature")
+	}
+
+	var event creemWebhookEvent
+	if err := common.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if event.EventType != "checkout.completed" || event.Object.Order.Status != "paid" {
+		return nil, nil
+	}
+	tradeNo := event.Object.RequestId
+	if tradeNo == "" {
+		return nil, errors.New("creem webhook has no request_id")
+	}
+	// 充值只处理一次性付款，订阅订单不受限制
+	if event.Object.Order.Type != "onetime" && model.GetSubscriptionOrderByTradeNo(tradeNo) == nil {
+		logger.LogInfo(r.Context(), fmt.Sprintf("Creem 暂不支持该订单类型，忽略处理 trade_no=%s creem_order_id=%s order_type=%s", tradeNo, event.Object.Order.Id, event.Object.Order.Type))
+		return nil, nil
+	}
+	return &WebhookEvent{
+		Type:          EventPaid,
+		TradeNo:       tradeNo,
+		Payload:       string(body),
+		CustomerEmail: event.Object.Customer.Email,
+	}, nil
+}
+
+func (p *CreemProvider) WebhookResponse(err error) (int, string) {
+	if err != nil {
+		return http.StatusBadRequest, "fail"
+	}
+	return http.StatusOK, "success"
+}
+
+func (p *CreemProvider) Refund(ctx context.Context, req *RefundRequest) error {
+	return ErrRefundNotSupported
+}
@@ -0,0 +1,64 @@
+package payment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCreemWebhookRequest(body string, signature string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/creem/webhook", strings.NewReader(body))
+	req.Header.Set(creemSignatureHeader, signature)
+	return req
+}
+
+func TestCreemProviderVerifyWebhook(t *testing.T) {
+	originalSecret := setting.CreemWebhookSecret
+	t.Cleanup(func() {
+		setting.CreemWebhookSecret = originalSecret
+	})
+	setting.CreemWebhookSecret = "creem_secret"
+	provider := &CreemProvider{}
+
+	body := `{"eventType":"checkout.completed","object":{"request_id":"ref_abc","order":{"id":"ord_1","status":"paid","type":"onetime"},"customer":{"email":"payer@example.com"}}}`
+	event, err := provider.VerifyWebhook(newCreemWebhookRequest(body, creemSignature(body, "creem_secret")))
+	require.NoError(t, err)
+	require.NotNil(t, event)
+	assert.Equal(t, EventPaid, event.Type)
+	assert.Equal(t, "ref_abc", event.TradeNo)
+	assert.Equal(t, "payer@example.com", event.CustomerEmail)
+
+	_, err = provider.VerifyWebhook(newCreemWebhookRequest(body, creemSignature(body, "other_secret")))
+	require.Error(t, err)
+
+	unpaid := `{"eventType":"checkout.completed","object":{"request_id":"ref_abc","order":{"status":"pending","type":"onetime"}}}`
+	event, err = provider.VerifyWebhook(newCreemWebhookRequest(unpaid, creemSignature(unpaid, "creem_secret")))
+	require.NoError(t, err)
+	assert.Nil(t, event)
+}
+
+func TestDedicatedProvidersSkipGenericCheckout(t *testing.T) {
+	for _, name := range []string{"stripe", "creem", "waffo", "waffo_pancake"} {
+		require.NotNil(t, GetProvider(name), name)
+		assert.Nil(t, GetCheckoutProvider(name), name)
+	}
+	assert.NotNil(t, GetCheckoutProvider("epay"))
+
+	originalSecret := setting.StripeApiSecret
+	originalWebhookSecret := setting.StripeWebhookSecret
+	originalPriceID := setting.StripePriceId
+	t.Cleanup(func() {
+		setting.StripeApiSecret = originalSecret
+		setting.StripeWebhookSecret = originalWebhookSecret
+		setting.StripePriceId = originalPriceID
+	})
+	setting.StripeApiSecret = "sk_test_123"
+	setting.StripeWebhookSecret = "whsec_test"
+	setting.StripePriceId = "price_123"
+	assert.NotContains(t, GetEnabledProviderNames(), "stripe")
+}
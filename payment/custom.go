@@ -0,0 +1,170 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 自定义网关协议：下单、退款请求与回调均为 JSON，
+// 请求体的 HMAC-SHA256（十六进制）放在 X-Payment-Signature 头中，密钥为 CustomPaymentWebhookSecret。
+
+const (
+	customSignatureHeader = "X-Payment-Signature"
+	customMaxBodySize     = 1 << 20
+)
+
+func init() {
+	Register(&CustomProvider{})
+}
+
+// CustomProvider 按签名 HTTP 协议对接的自定义支付网关
+type CustomProvider struct{}
+
+type customCheckoutResponse struct {
+	PayLink string            `json:"pay_link"`
+	Params  map[string]string `json:"params"`
+}
+
+type customWebhookPayload struct {
+	TradeNo       string `json:"trade_no"`
+	Status        string `json:"status"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+func (p *CustomProvider) Name() string {
+	return model.PaymentProviderCustom
+}
+
+func (p *CustomProvider) IsEnabled() bool {
+	return setting.CustomPaymentEnabled &&
+		strings.TrimSpace(setting.CustomPaymentCheckoutURL) != "" &&
+		strings.TrimSpace(setting.CustomPaymentWebhookSecret) != ""
+}
+
+// SignCustomPayload 计算自定义网关消息签名
+func SignCustomPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postCustom 向网关发送签名请求，非 2xx 响应视为失败
+func postCustom(ctx context.Context, endpoint string, payload any) ([]byte, error) {
+	body, err := common.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(customSignatureHeader, SignCustomPayload(setting.CustomPaymentWebhookSecret, body))
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, customMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("payment gateway returned status %d", resp.StatusCode)
+	}
+	return respBody, nil
+}
+
+func (p *CustomProvider) CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	if !p.IsEnabled() {
+		return nil, ErrProviderDisabled
+	}
+	respBody, err := postCustom(ctx, setting.CustomPaymentCheckoutURL, map[string]any{
+		"kind":           req.Kind,
+		"trade_no":       req.TradeNo,
+		"user_id":        req.UserId,
+		"title":          req.Title,
+		"money":          req.Money,
+		"payment_method": req.PaymentMethod,
+		"notify_url":     req.NotifyURL,
+		"return_url":     req.ReturnURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var checkout customCheckoutResponse
+	if err := common.Unmarshal(respBody, &checkout); err != nil {
+		return nil, err
+	}
+	if checkout.PayLink == "" {
+		return nil, errors.New("payment gateway returned empty pay link")
+	}
+	return &Checkout{URL: checkout.PayLink, Params: checkout.Params}, nil
+}
+
+func (p *CustomProvider) VerifyWebhook(r *http.Request) (*WebhookEvent, error) {
+	secret := setting.CustomPaymentWebhookSecret
+	if secret == "" {
+		return nil, ErrProviderDisabled
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, customMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	signature := strings.TrimSpace(r.Header.Get(customSignatureHeader))
+	if !hmac.Equal([]byte(signature), []byte(SignCustomPayload(secret, body))) {
+		return nil, errors.New("invalid payment webhook signature")
+	}
+	var payload customWebhookPayload
+	if err := common.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	event := &WebhookEvent{
+		TradeNo:       payload.TradeNo,
+		PaymentMethod: payload.PaymentMethod,
+		Payload:       string(body),
+	}
+	switch strings.ToLower(payload.Status) {
+	case "paid", "success":
+		event.Type = EventPaid
+	case "failed":
+		event.Type = EventFailed
+	case "expired", "canceled", "cancelled":
+		event.Type = EventExpired
+	default:
+		return nil, nil
+	}
+	return event, nil
+}
+
+func (p *CustomProvider) WebhookResponse(err error) (int, string) {
+	if err != nil {
+		return http.StatusBadRequest, "fail"
+	}
+	return http.StatusOK, "success"
+}
+
+func (p *CustomProvider) Refund(ctx context.Context, req *RefundRequest) error {
+	if strings.TrimSpace(setting.CustomPaymentRefundURL) == "" {
+		return ErrRefundNotSupported
+	}
+	_, err := postCustom(ctx, setting.CustomPaymentRefundURL, map[string]any{
+		"trade_no":       req.TradeNo,
+		"money":          req.Money,
+		"payment_method": req.PaymentMethod,
+	})
+	return err
+}
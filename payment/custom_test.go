@@ -0,0 +1,49 @@
+package payment
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCustomWebhookRequest(body string, signature string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/payment/custom/webhook", strings.NewReader(body))
+	req.Header.Set(customSignatureHeader, signature)
+	return req
+}
+
+func TestCustomProviderVerifyWebhook(t *testing.T) {
+	originalSecret := setting.CustomPaymentWebhookSecret
+	t.Cleanup(func() {
+		setting.CustomPaymentWebhookSecret = originalSecret
+	})
+	setting.CustomPaymentWebhookSecret = "webhook_secret"
+	provider := &CustomProvider{}
+
+	body := `{"trade_no":"USR1NOabc","status":"paid","payment_method":"card"}`
+	event, err := provider.VerifyWebhook(newCustomWebhookRequest(body, SignCustomPayload("webhook_secret", []byte(body))))
+	require.NoError(t, err)
+	require.NotNil(t, event)
+	assert.Equal(t, EventPaid, event.Type)
+	assert.Equal(t, "USR1NOabc", event.TradeNo)
+	assert.Equal(t, "card", event.PaymentMethod)
+
+	_, err = provider.VerifyWebhook(newCustomWebhookRequest(body, SignCustomPayload("other_secret", []byte(body))))
+	require.Error(t, err)
+
+	pending := `{"trade_no":"USR1NOabc","status":"pending"}`
+	event, err = provider.VerifyWebhook(newCustomWebhookRequest(pending, SignCustomPayload("webhook_secret", []byte(pending))))
+	require.NoError(t, err)
+	assert.Nil(t, event)
+}
+
+func TestRegistryIncludesBuiltinProviders(t *testing.T) {
+	require.NotNil(t, GetProvider("epay"))
+	require.NotNil(t, GetProvider("custom"))
+	assert.Nil(t, GetProvider("unknown"))
+}
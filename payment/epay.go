@@ -0,0 +1,139 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Calcium-Ion/go-epay/epay"
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+func init() {
+	Register(&EpayProvider{})
+}
+
+// EpayProvider 易支付，充值与订阅共用同一个回调地址
+type EpayProvider struct{}
+
+func (p *EpayProvider) Name() string {
+	return model.PaymentProviderEpay
+}
+
+func epayConfigured() bool {
+	return strings.TrimSpace(operation_setting.PayAddress) != "" &&
+		strings.TrimSpace(operation_setting.EpayId) != "" &&
+		strings.TrimSpace(operation_setting.EpayKey) != ""
+}
+
+func (p *EpayProvider) IsEnabled() bool {
+	return epayConfigured() && len(operation_setting.PayMethods) > 0
+}
+
+// NewEpayClient 根据当前配置创建易支付客户端，未配置时返回 nil
+func NewEpayClient() *epay.Client {
+	if !epayConfigured() {
+		return nil
+	}
+	client, err := epay.NewClient(&epay.Config{
+		PartnerID: operation_setting.EpayId,
+		Key:       operation_setting.EpayKey,
+	}, operation_setting.PayAddress)
+	if err != nil {
+		return nil
+	}
+	return client
+}
+
+func (p *EpayProvider) CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	if !operation_setting.ContainsPayMethod(req.PaymentMethod) {
+		return nil, ErrPaymentMethodUnsupported
+	}
+	client := NewEpayClient()
+	if client == nil {
+		return nil, ErrProviderDisabled
+	}
+	notifyUrl, err := url.Parse(req.NotifyURL)
+	if err != nil {
+		return nil, err
+	}
+	returnUrl, err := url.Parse(req.ReturnURL)
+	if err != nil {
+		return nil, err
+	}
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           req.PaymentMethod,
+		ServiceTradeNo: req.TradeNo,
+		Name:           req.Title,
+		Money:          strconv.FormatFloat(req.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Checkout{URL: uri, Params: params}, nil
+}
+
+// epayRequestParams 易支付回调可能是 POST 表单或 GET 查询参数
+func epayRequestParams(r *http.Request) (map[string]string, error) {
+	values := r.URL.Query()
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		values = r.PostForm
+	}
+	params := make(map[string]string, len(values))
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+	return params, nil
+}
+
+func (p *EpayProvider) VerifyWebhook(r *http.Request) (*WebhookEvent, error) {
+	params, err := epayRequestParams(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(params) == 0 {
+		return nil, errors.New("empty epay notification")
+	}
+	client := NewEpayClient()
+	if client == nil {
+		return nil, ErrProviderDisabled
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyInfo.VerifyStatus {
+		return nil, errors.New("invalid epay signature")
+	}
+	if verifyInfo.TradeStatus != epay.StatusTradeSuccess {
+		return nil, nil
+	}
+	return &WebhookEvent{
+		Type:          EventPaid,
+		TradeNo:       verifyInfo.ServiceTradeNo,
+		PaymentMethod: verifyInfo.Type,
+		Payload:       common.GetJsonString(verifyInfo),
+	}, nil
+}
+
+func (p *EpayProvider) WebhookResponse(err error) (int, string) {
+	if err != nil {
+		return http.StatusOK, "fail"
+	}
+	return http.StatusOK, "success"
+}
+
+func (p *EpayProvider) Refund(ctx context.Context, req *RefundRequest) error {
+	return ErrRefundNotSupported
+}
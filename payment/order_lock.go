@@ -0,0 +1,45 @@
+package payment
+
+import "sync"
+
+// tradeNo lock
+var orderLocks sync.Map
+var createLock sync.Mutex
+
+// refCountedMutex 带引用计数的互斥锁，确保最后一个使用者才从 map 中删除
+type refCountedMutex struct {
+	mu       sync.Mutex
+	refCount int
+}
+
+// LockOrder 尝试对给定订单号加锁
+func LockOrder(tradeNo string) {
+	createLock.Lock()
+	var rcm *refCountedMutex
+	if v, ok := orderLocks.Load(tradeNo); ok {
+		rcm = v.(*refCountedMutex)
+	} else {
+		rcm = &refCountedMutex{}
+		orderLocks.Store(tradeNo, rcm)
+	}
+	rcm.refCount++
+	createLock.Unlock()
+	rcm.mu.Lock()
+}
+
+// UnlockOrder 释放给定订单号的锁
+func UnlockOrder(tradeNo string) {
+	v, ok := orderLocks.Load(tradeNo)
+	if !ok {
+		return
+	}
+	rcm := v.(*refCountedMutex)
+	rcm.mu.Unlock()
+
+	createLock.Lock()
+	rcm.refCount--
+	if rcm.refCount == 0 {
+		orderLocks.Delete(tradeNo)
+	}
+	createLock.Unlock()
+}
@@ -0,0 +1,95 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// OrderKind 订单类型
+type OrderKind string
+
+const (
+	OrderKindTopUp        OrderKind = "topup"
+	OrderKindSubscription OrderKind = "subscription"
+)
+
+// EventType 网关回调事件类型
+type EventType string
+
+const (
+	EventPaid    EventType = "paid"
+	EventFailed  EventType = "failed"
+	EventExpired EventType = "expired"
+)
+
+var (
+	ErrRefundNotSupported       = errors.New("payment provider does not support refund")
+	ErrPaymentMethodUnsupported = errors.New("payment method is not supported by provider")
+	ErrProviderNotFound         = errors.New("payment provider not found")
+	ErrProviderDisabled         = errors.New("payment provider is disabled")
+	ErrCheckoutNotSupported     = errors.New("payment provider uses its own checkout endpoint")
+)
+
+// CheckoutRequest 拉起支付所需的订单信息，订单已以待支付状态落库
+type CheckoutRequest struct {
+	Kind          OrderKind
+	TradeNo       string
+	UserId        int
+	Title         string
+	Money         float64
+	PaymentMethod string
+	NotifyURL     string
+	ReturnURL     string
+}
+
+// Checkout 拉起支付的结果：跳转地址，以及需要以表单提交的参数（可为空）
+type Checkout struct {
+	URL    string
+	Params map[string]string
+}
+
+// WebhookEvent 网关回调解析后的统一事件，Payload 为原始回调内容，用于对账
+type WebhookEvent struct {
+	Type          EventType
+	TradeNo       string
+	PaymentMethod string
+	Payload       string
+	// CustomerId 网关侧的客户标识（如 Stripe customer），充值完成时回写到用户
+	CustomerId string
+	// CustomerEmail 付款邮箱，用户未绑定邮箱时回写
+	CustomerEmail string
+}
+
+// RefundRequest 退款所需的订单信息
+type RefundRequest struct {
+	TradeNo       string
+	Money         float64
+	PaymentMethod string
+}
+
+// Provider 支付网关，实现后通过 Register 注册即可接入通用的下单、回调与退款接口
+type Provider interface {
+	// Name 网关标识，与订单的 payment_provider 一致，也用于路由 /api/payment/:provider
+	Name() string
+
+	// IsEnabled 网关是否已配置并启用
+	IsEnabled() bool
+
+	// CreateCheckout 为已创建的待支付订单拉起支付
+	CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error)
+
+	// VerifyWebhook 校验回调签名并解析事件，返回 nil 事件表示无需处理的通知
+	VerifyWebhook(r *http.Request) (*WebhookEvent, error)
+
+	// WebhookResponse 按网关要求返回回调应答，err 为处理回调时的错误
+	WebhookResponse(err error) (int, string)
+
+	// Refund 向网关发起退款，不支持时返回 ErrRefundNotSupported
+	Refund(ctx context.Context, req *RefundRequest) error
+}
+
+// WebhookResponder 应答需要额外响应头（如签名）的网关可实现该接口，回调入口会优先使用它写出应答
+type WebhookResponder interface {
+	WriteWebhookResponse(w http.ResponseWriter, err error)
+}
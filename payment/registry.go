@@ -0,0 +1,71 @@
+package payment
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	providers = make(map[string]Provider)
+	// dedicated providers price and create orders through their own endpoints,
+	// so they only take part in webhooks and refunds
+	dedicated = make(map[string]bool)
+	mu        sync.RWMutex
+)
+
+// Register registers a payment provider under its name
+func Register(provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[provider.Name()] = provider
+	delete(dedicated, provider.Name())
+}
+
+// RegisterDedicated registers a provider that has its own checkout endpoint.
+// It is excluded from the generic checkout and the enabled provider list.
+func RegisterDedicated(provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[provider.Name()] = provider
+	dedicated[provider.Name()] = true
+}
+
+// Unregister removes a provider from the registry
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(providers, name)
+	delete(dedicated, name)
+}
+
+// GetProvider returns the payment provider for the given name
+func GetProvider(name string) Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return providers[name]
+}
+
+// GetCheckoutProvider returns the provider for the generic checkout, or nil
+// when it is unknown or uses its own checkout endpoint
+func GetCheckoutProvider(name string) Provider {
+	mu.RLock()
+	defer mu.RUnlock()
+	if dedicated[name] {
+		return nil
+	}
+	return providers[name]
+}
+
+// GetEnabledProviderNames returns the names of all enabled generic checkout providers in a stable order
+func GetEnabledProviderNames() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name, provider := range providers {
+		if !dedicated[name] && provider.IsEnabled() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,99 @@
+package payment
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/webhook"
+)
+
+const stripeMaxBodySize = 1 << 20
+
+func init() {
+	RegisterDedicated(&StripeProvider{})
+}
+
+// StripeProvider Stripe Checkout，充值与订阅由独立接口下单，回调统一走 HandleEvent
+type StripeProvider struct{}
+
+func (p *StripeProvider) Name() string {
+	return model.PaymentProviderStripe
+}
+
+func (p *StripeProvider) IsEnabled() bool {
+	return strings.TrimSpace(setting.StripeApiSecret) != "" &&
+		strings.TrimSpace(setting.StripeWebhookSecret) != "" &&
+		strings.TrimSpace(setting.StripePriceId) != ""
+}
+
+func (p *StripeProvider) CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	return nil, ErrCheckoutNotSupported
+}
+
+func (p *StripeProvider) VerifyWebhook(r *http.Request) (*WebhookEvent, error) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, stripeMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), setting.StripeWebhookSecret, webhook.ConstructEventOptions{
+		IgnoreAPIVersionMismatch: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tradeNo := event.GetObjectValue("client_reference_id")
+	if tradeNo == "" {
+		// 非本系统创建的 Checkout Session
+		return nil, nil
+	}
+	result := &WebhookEvent{
+		TradeNo:    tradeNo,
+		CustomerId: event.GetObjectValue("customer"),
+		Payload: common.GetJsonString(map[string]any{
+			"customer":     event.GetObjectValue("customer"),
+			"amount_total": event.GetObjectValue("amount_total"),
+			"currency":     strings.ToUpper(event.GetObjectValue("currency")),
+			"event_type":   string(event.Type),
+		}),
+	}
+	switch event.Type {
+	case stripe.EventTypeCheckoutSessionCompleted:
+		// 延迟到账的支付方式（银行转账、SEPA 等）在 async_payment_succeeded 中完成
+		if event.GetObjectValue("status") != "complete" || event.GetObjectValue("payment_status") != "paid" {
+			return nil, nil
+		}
+		result.Type = EventPaid
+	case stripe.EventTypeCheckoutSessionAsyncPaymentSucceeded:
+		result.Type = EventPaid
+	case stripe.EventTypeCheckoutSessionAsyncPaymentFailed:
+		result.Type = EventFailed
+	case stripe.EventTypeCheckoutSessionExpired:
+		if event.GetObjectValue("status") != "expired" {
+			return nil, nil
+		}
+		result.Type = EventExpired
+	default:
+		return nil, nil
+	}
+	return result, nil
+}
+
+// WebhookResponse 非 2xx 应答会让 Stripe 重试，完成订单是幂等的
+func (p *StripeProvider) WebhookResponse(err error) (int, string) {
+	if err != nil {
+		return http.StatusBadRequest, "fail"
+	}
+	return http.StatusOK, "success"
+}
+
+func (p *StripeProvider) Refund(ctx context.Context, req *RefundRequest) error {
+	return ErrRefundNotSupported
+}
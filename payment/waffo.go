@@ -0,0 +1,146 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	waffo "github.com/waffo-com/waffo-go"
+	"github.com/waffo-com/waffo-go/config"
+	"github.com/waffo-com/waffo-go/core"
+)
+
+const (
+	waffoSignatureHeader = "X-SIGNATURE"
+	waffoMaxBodySize     = 1 << 20
+)
+
+func init() {
+	RegisterDedicated(&WaffoProvider{})
+}
+
+// WaffoProvider Waffo 收银台，充值由独立接口下单，回调统一走 HandleEvent
+type WaffoProvider struct{}
+
+type waffoPaymentNotification struct {
+	EventType string                         `json:"eventType"`
+	Result    core.PaymentNotificationResult `json:"result"`
+}
+
+func (p *WaffoProvider) Name() string {
+	return model.PaymentProviderWaffo
+}
+
+func (p *WaffoProvider) IsEnabled() bool {
+	if !setting.WaffoEnabled {
+		return false
+	}
+	if setting.WaffoSandbox {
+		return strings.TrimSpace(setting.WaffoSandboxApiKey) != "" &&
+			strings.TrimSpace(setting.WaffoSandboxPrivateKey) != "" &&
+			strings.TrimSpace(setting.WaffoSandboxPublicCert) != ""
+	}
+	return strings.TrimSpace(setting.WaffoApiKey) != "" &&
+		strings.TrimSpace(setting.WaffoPrivateKey) != "" &&
+		strings.TrimSpace(setting.WaffoPublicCert) != ""
+}
+
+// NewWaffoClient 根据当前配置（沙盒或生产）创建 Waffo SDK 客户端
+func NewWaffoClient() (*waffo.Waffo, error) {
+	env := config.Sandbox
+	apiKey := setting.WaffoSandboxApiKey
+	privateKey := setting.WaffoSandboxPrivateKey
+	publicKey := setting.WaffoSandboxPublicCert
+	if !setting.WaffoSandbox {
+		env = config.Production
+		apiKey = setting.WaffoApiKey
+		privateKey = setting.WaffoPrivateKey
+		publicKey = setting.WaffoPublicCert
+	}
+	builder := config.NewConfigBuilder().
+		APIKey(apiKey).
+		PrivateKey(privateKey).
+		WaffoPublicKey(publicKey).
+		Environment(env)
+	if setting.WaffoMerchantId != "" {
+		builder = builder.MerchantID(setting.WaffoMerchantId)
+	}
+	cfg, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return waffo.New(cfg), nil
+}
+
+func (p *WaffoProvider) CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	return nil, ErrCheckoutNotSupported
+}
+
+func (p *WaffoProvider) VerifyWebhook(r *http.Request) (*WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, waffoMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewWaffoClient()
+	if err != nil {
+		return nil, err
+	}
+	if !client.Webhook().VerifySignature(string(body), r.Header.Get(waffoSignatureHeader)) {
+		return nil, errors.New("invalid waffo signature")
+	}
+
+	var notification waffoPaymentNotification
+	if err := common.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	if notification.EventType != core.EventPayment || notification.Result.MerchantOrderID == "" {
+		return nil, nil
+	}
+	event := &WebhookEvent{
+		Type:    EventPaid,
+		TradeNo: notification.Result.MerchantOrderID,
+		Payload: string(body),
+	}
+	// 非成功状态均为终态失败，关闭订单避免永远停在 pending
+	if notification.Result.OrderStatus != "PAY_SUCCESS" {
+		event.Type = EventFailed
+	}
+	return event, nil
+}
+
+// WebhookResponse Waffo 要求带签名的 JSON 应答，实际由 WriteWebhookResponse 写出
+func (p *WaffoProvider) WebhookResponse(err error) (int, string) {
+	body, _ := p.buildWebhookResponse(err)
+	return http.StatusOK, body
+}
+
+func (p *WaffoProvider) WriteWebhookResponse(w http.ResponseWriter, err error) {
+	body, signature := p.buildWebhookResponse(err)
+	if signature != "" {
+		w.Header().Set(waffoSignatureHeader, signature)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(body))
+}
+
+func (p *WaffoProvider) buildWebhookResponse(err error) (string, string) {
+	client, clientErr := NewWaffoClient()
+	if clientErr != nil {
+		return `{"message":"fail"}`, ""
+	}
+	if err != nil {
+		return client.Webhook().BuildFailedResponse(err.Error())
+	}
+	return client.Webhook().BuildSuccessResponse()
+}
+
+func (p *WaffoProvider) Refund(ctx context.Context, req *RefundRequest) error {
+	return ErrRefundNotSupported
+}
@@ -0,0 +1,83 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+const waffoPancakeMaxBodySize = 1 << 20
+
+func init() {
+	RegisterDedicated(&WaffoPancakeProvider{})
+}
+
+// WaffoPancakeProvider Waffo Pancake 收银台，充值由独立接口下单，回调统一走 HandleEvent
+type WaffoPancakeProvider struct{}
+
+func (p *WaffoPancakeProvider) Name() string {
+	return model.PaymentProviderWaffoPancake
+}
+
+func (p *WaffoPancakeProvider) IsEnabled() bool {
+	if !setting.WaffoPancakeEnabled {
+		return false
+	}
+	webhookKey := setting.WaffoPancakeWebhookPublicKey
+	if setting.WaffoPancakeSandbox {
+		webhookKey = setting.WaffoPancakeWebhookTestKey
+	}
+	return strings.TrimSpace(webhookKey) != "" &&
+		strings.TrimSpace(setting.WaffoPancakeMerchantID) != "" &&
+		strings.TrimSpace(setting.WaffoPancakePrivateKey) != "" &&
+		strings.TrimSpace(setting.WaffoPancakeStoreID) != "" &&
+		strings.TrimSpace(setting.WaffoPancakeProductID) != ""
+}
+
+func (p *WaffoPancakeProvider) CreateCheckout(ctx context.Context, req *CheckoutRequest) (*Checkout, error) {
+	return nil, ErrCheckoutNotSupported
+}
+
+func (p *WaffoPancakeProvider) VerifyWebhook(r *http.Request) (*WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, waffoPancakeMaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	event, err := service.VerifyConfiguredWaffoPancakeWebhook(string(body), r.Header.Get("X-Waffo-Signature"))
+	if err != nil {
+		return nil, err
+	}
+	if event.NormalizedEventType() != "order.completed" {
+		return nil, nil
+	}
+	tradeNo, err := service.ResolveWaffoPancakeTradeNo(event)
+	if err != nil {
+		// 无法映射到本地订单的通知无需重试
+		logger.LogWarn(r.Context(), fmt.Sprintf("Waffo Pancake webhook 订单号映射失败 event_id=%s order_id=%s error=%q", event.ID, event.Data.OrderID, err.Error()))
+		return nil, nil
+	}
+	return &WebhookEvent{
+		Type:    EventPaid,
+		TradeNo: tradeNo,
+		Payload: string(body),
+	}, nil
+}
+
+// WebhookResponse 非 2xx 应答会让 Waffo Pancake 重试
+func (p *WaffoPancakeProvider) WebhookResponse(err error) (int, string) {
+	if err != nil {
+		return http.StatusInternalServerError, "retry"
+	}
+	return http.StatusOK, "OK"
+}
+
+func (p *WaffoPancakeProvider) Refund(ctx context.Context, req *RefundRequest) error {
+	return ErrRefundNotSupported
+}
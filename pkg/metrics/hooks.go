@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// RedisHook counts failed Redis commands. Missing keys (redis.Nil) are not errors.
+type RedisHook struct{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	recordRedisCmdError(cmd)
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		recordRedisCmdError(cmd)
+	}
+	return nil
+}
+
+func recordRedisCmdError(cmd redis.Cmder) {
+	if err := cmd.Err(); err != nil && !errors.Is(err, redis.Nil) {
+		RecordRedisError(cmd.Name())
+	}
+}
+
+// RegisterGormCallbacks counts failed database operations of the given connection.
+func RegisterGormCallbacks(db *gorm.DB) error {
+	callback := db.Callback()
+	if err := callback.Create().After("gorm:create").Register("metrics:create", gormErrorCallback("create")); err != nil {
+		return err
+	}
+	if err := callback.Query().After("gorm:query").Register("metrics:query", gormErrorCallback("query")); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:update").Register("metrics:update", gormErrorCallback("update")); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:delete").Register("metrics:delete", gormErrorCallback("delete")); err != nil {
+		return err
+	}
+	if err := callback.Row().After("gorm:row").Register("metrics:row", gormErrorCallback("row")); err != nil {
+		return err
+	}
+	return callback.Raw().After("gorm:raw").Register("metrics:raw", gormErrorCallback("raw"))
+}
+
+func gormErrorCallback(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			RecordDBError(operation)
+		}
+	}
+}
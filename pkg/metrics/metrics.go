@@ -0,0 +1,204 @@
+// Package metrics exposes Prometheus metrics for relay traffic, billing and channel health.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "newapi"
+
+// latencyBuckets covers fast cached answers up to long reasoning requests (seconds)
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60, 120, 300}
+
+var registry = prometheus.NewRegistry()
+
+var (
+	relayRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_requests_total",
+		Help:      "Relay requests by relay format, model, group, final channel and response status code.",
+	}, []string{"relay_format", "model", "group", "channel", "status_code"})
+
+	relayDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "relay_request_duration_seconds",
+		Help:      "End-to-end relay request duration including retries.",
+		Buckets:   latencyBuckets,
+	}, []string{"relay_format", "model"})
+
+	relayRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_retries_total",
+		Help:      "Retry attempts made by the relay loop after a failed channel attempt.",
+	}, []string{"relay_format", "model"})
+
+	relayHedges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_hedges_total",
+		Help:      "Hedged attempts started because the first channel was slow, by which attempt won.",
+	}, []string{"relay_format", "model", "winner"})
+
+	upstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_latency_seconds",
+		Help:      "Latency of a single upstream attempt.",
+		Buckets:   latencyBuckets,
+	}, []string{"channel", "model", "success"})
+
+	upstreamTTFT = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_time_to_first_token_seconds",
+		Help:      "Time to first streamed token of a single upstream attempt.",
+		Buckets:   latencyBuckets,
+	}, []string{"channel", "model"})
+
+	quotaConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_consumed_total",
+		Help:      "Quota settled for consumed requests.",
+	}, []string{"model", "group"})
+
+	tokensConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_consumed_total",
+		Help:      "Prompt and completion tokens settled for consumed requests.",
+	}, []string{"model", "group", "type"})
+
+	preConsumeRefunds = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pre_consume_refunds_total",
+		Help:      "Pre-consumed quota refunds after failed requests.",
+	})
+
+	preConsumeRefundQuota = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pre_consume_refund_quota_total",
+		Help:      "Token quota returned by pre-consume refunds.",
+	})
+
+	channelAutoDisabled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_auto_disabled_total",
+		Help:      "Channels or multi-key entries disabled automatically after upstream errors.",
+	}, []string{"channel"})
+
+	taskPollingBacklog = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "task_polling_backlog",
+		Help:      "Unfinished async tasks seen by the last polling round.",
+	}, []string{"platform"})
+
+	redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_errors_total",
+		Help:      "Failed Redis commands (excluding missing keys).",
+	}, []string{"command"})
+
+	dbErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_errors_total",
+		Help:      "Failed database operations (excluding record not found).",
+	}, []string{"operation"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		relayRequests,
+		relayDuration,
+		relayRetries,
+		relayHedges,
+		upstreamLatency,
+		upstreamTTFT,
+		quotaConsumed,
+		tokensConsumed,
+		preConsumeRefunds,
+		preConsumeRefundQuota,
+		channelAutoDisabled,
+		taskPollingBacklog,
+		redisErrors,
+		dbErrors,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Registry exposes the underlying registry, mainly for tests.
+func Registry() *prometheus.Registry {
+	return registry
+}
+
+func RecordRelayRequest(relayFormat, model, group string, channelId int, statusCode int, duration time.Duration) {
+	relayRequests.WithLabelValues(relayFormat, model, group, strconv.Itoa(channelId), strconv.Itoa(statusCode)).Inc()
+	relayDuration.WithLabelValues(relayFormat, model).Observe(duration.Seconds())
+}
+
+func RecordRelayRetry(relayFormat, model string) {
+	relayRetries.WithLabelValues(relayFormat, model).Inc()
+}
+
+// RecordRelayHedge counts a hedged attempt; winner is "primary", "hedge" or "none".
+func RecordRelayHedge(relayFormat, model, winner string) {
+	relayHedges.WithLabelValues(relayFormat, model, winner).Inc()
+}
+
+// ObserveUpstreamAttempt records the latency of one upstream attempt, and its time to first token
+// when ttft is positive.
+func ObserveUpstreamAttempt(channelId int, model string, success bool, latency time.Duration, ttft time.Duration) {
+	channel := strconv.Itoa(channelId)
+	upstreamLatency.WithLabelValues(channel, model, strconv.FormatBool(success)).Observe(latency.Seconds())
+	if ttft > 0 {
+		upstreamTTFT.WithLabelValues(channel, model).Observe(ttft.Seconds())
+	}
+}
+
+func AddConsumed(model, group string, quota int, promptTokens int, completionTokens int) {
+	if quota > 0 {
+		quotaConsumed.WithLabelValues(model, group).Add(float64(quota))
+	}
+	if promptTokens > 0 {
+		tokensConsumed.WithLabelValues(model, group, "prompt").Add(float64(promptTokens))
+	}
+	if completionTokens > 0 {
+		tokensConsumed.WithLabelValues(model, group, "completion").Add(float64(completionTokens))
+	}
+}
+
+func RecordPreConsumeRefund(quota int) {
+	preConsumeRefunds.Inc()
+	if quota > 0 {
+		preConsumeRefundQuota.Add(float64(quota))
+	}
+}
+
+func RecordChannelAutoDisabled(channelId int) {
+	channelAutoDisabled.WithLabelValues(strconv.Itoa(channelId)).Inc()
+}
+
+func SetTaskPollingBacklog(platform string, count int) {
+	taskPollingBacklog.WithLabelValues(platform).Set(float64(count))
+}
+
+// ResetTaskPollingBacklog clears the backlog gauges before a new polling round so finished
+// platforms drop back to zero.
+func ResetTaskPollingBacklog() {
+	taskPollingBacklog.Reset()
+}
+
+func RecordRedisError(command string) {
+	redisErrors.WithLabelValues(command).Inc()
+}
+
+func RecordDBError(operation string) {
+	dbErrors.WithLabelValues(operation).Inc()
+}
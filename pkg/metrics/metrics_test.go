@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordRelayRequest(t *testing.T) {
+	RecordRelayRequest("openai", "gpt-test", "default", 7, http.StatusOK, 150*time.Millisecond)
+	RecordRelayRequest("openai", "gpt-test", "default", 7, http.StatusOK, 250*time.Millisecond)
+
+	require.Equal(t, float64(2), testutil.ToFloat64(relayRequests.WithLabelValues("openai", "gpt-test", "default", "7", "200")))
+}
+
+func TestAddConsumedSkipsZeroValues(t *testing.T) {
+	AddConsumed("gpt-consumed", "vip", 0, 10, 0)
+
+	require.Equal(t, float64(10), testutil.ToFloat64(tokensConsumed.WithLabelValues("gpt-consumed", "vip", "prompt")))
+	require.Equal(t, 0, testutil.CollectAndCount(quotaConsumed.MustCurryWith(map[string]string{"model": "gpt-consumed"})))
+}
+
+func TestHandlerServesMetrics(t *testing.T) {
+	RecordChannelAutoDisabled(42)
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.True(t, strings.Contains(recorder.Body.String(), `newapi_channel_auto_disabled_total{channel="42"} 1`))
+}
@@ -0,0 +1,186 @@
+// Package tracing wires OpenTelemetry tracing into the relay pipeline.
+//
+// Tracing is enabled when an OTLP endpoint is configured through the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT / OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables, e.g.
+// http://localhost:4318 for a local collector. Other standard OTEL_* variables
+// (service name, headers, sampler) are honoured by the SDK.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/QuantumNous/new-api"
+
+var enabled atomic.Bool
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Enabled reports whether spans are being recorded and exported.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Start configures the global tracer provider with an OTLP/HTTP exporter. It returns a
+// shutdown function that flushes pending spans; both are no-ops when no endpoint is set.
+func Start() (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return noop, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(common.GetEnvOrDefaultString("OTEL_SERVICE_NAME", "new-api")),
+		semconv.ServiceVersion(common.Version),
+	))
+	if err != nil {
+		return noop, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	enabled.Store(true)
+	common.SysLog("OpenTelemetry tracing enabled")
+	return provider.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Span is a span bound to a gin request. While open, the request context carries the span
+// so that nested spans become its children; End restores the parent span. A nil *Span
+// (tracing disabled) is valid and all methods are no-ops.
+type Span struct {
+	span   trace.Span
+	c      *gin.Context
+	parent trace.Span
+	once   sync.Once
+}
+
+// StartRequestSpan starts the server span of a relay request, continuing an incoming
+// W3C traceparent if present.
+func StartRequestSpan(c *gin.Context) *Span {
+	if !Enabled() {
+		return nil
+	}
+	parent := c.Request.Context()
+	ctx := propagator.Extract(parent, propagation.HeaderCarrier(c.Request.Header))
+	name := c.FullPath()
+	if name == "" {
+		name = c.Request.URL.Path
+	}
+	ctx, span := tracer().Start(ctx, c.Request.Method+" "+name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.HTTPRoute(name),
+			attribute.String("request.id", c.GetString(common.RequestIdKey)),
+		),
+	)
+	c.Request = c.Request.WithContext(ctx)
+	return &Span{span: span, c: c, parent: trace.SpanFromContext(parent)}
+}
+
+// StartSpan starts a child span of whatever span the request context currently carries.
+func StartSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) *Span {
+	if !Enabled() || c == nil || c.Request == nil {
+		return nil
+	}
+	parent := c.Request.Context()
+	ctx, span := tracer().Start(parent, name, trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	return &Span{span: span, c: c, parent: trace.SpanFromContext(parent)}
+}
+
+func (s *Span) SetAttributes(attrs ...attribute.KeyValue) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attrs...)
+}
+
+// SetHTTPStatus records the response status code, marking server errors as failed spans.
+func (s *Span) SetHTTPStatus(statusCode int) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(semconv.HTTPResponseStatusCode(statusCode))
+	if statusCode >= http.StatusInternalServerError {
+		s.span.SetStatus(codes.Error, http.StatusText(statusCode))
+	}
+}
+
+// End ends the span and makes the parent span current again. Only the span is swapped, so
+// values attached to the request context while the span was open survive. It is safe to
+// call more than once.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.span.End()
+		if s.c.Request != nil {
+			s.c.Request = s.c.Request.WithContext(trace.ContextWithSpan(s.c.Request.Context(), s.parent))
+		}
+	})
+}
+
+func (s *Span) EndWithError(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+}
+
+// EndWithAPIError is EndWithError for *types.NewAPIError, avoiding the typed-nil interface trap.
+func (s *Span) EndWithAPIError(err *types.NewAPIError) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.SetAttributes(
+			attribute.String("error.code", string(err.GetErrorCode())),
+			attribute.Int("error.status_code", err.StatusCode),
+		)
+		s.EndWithError(err)
+		return
+	}
+	s.End()
+}
+
+// InjectTraceParent writes the W3C trace context of the current request span into header.
+func InjectTraceParent(c *gin.Context, header http.Header) {
+	if !Enabled() || c == nil || c.Request == nil {
+		return
+	}
+	propagator.Inject(c.Request.Context(), propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func withTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	enabled.Store(true)
+	t.Cleanup(func() {
+		enabled.Store(false)
+		otel.SetTracerProvider(previous)
+	})
+	return exporter
+}
+
+func newTestContext() *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c
+}
+
+func TestSpansNestUnderRequestSpan(t *testing.T) {
+	exporter := withTestTracer(t)
+	c := newTestContext()
+
+	root := StartRequestSpan(c)
+	stage := StartSpan(c, "stage")
+	child := StartSpan(c, "child")
+	child.End()
+	stage.End()
+	sibling := StartSpan(c, "sibling")
+	sibling.End()
+	root.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	rootId := byName["POST /v1/chat/completions"].SpanContext.SpanID()
+	require.Equal(t, rootId, byName["stage"].Parent.SpanID())
+	require.Equal(t, byName["stage"].SpanContext.SpanID(), byName["child"].Parent.SpanID())
+	require.Equal(t, rootId, byName["sibling"].Parent.SpanID(), "ending a span restores its parent")
+}
+
+func TestEndKeepsContextValues(t *testing.T) {
+	withTestTracer(t)
+	c := newTestContext()
+
+	root := StartRequestSpan(c)
+	stage := StartSpan(c, "stage")
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), testContextKey{}, "kept"))
+	stage.End()
+
+	require.Equal(t, "kept", c.Request.Context().Value(testContextKey{}), "values set inside a span survive its end")
+	require.Equal(t, root.span.SpanContext().SpanID(), trace.SpanFromContext(c.Request.Context()).SpanContext().SpanID())
+	root.End()
+}
+
+type testContextKey struct{}
+
+func TestInjectTraceParent(t *testing.T) {
+	withTestTracer(t)
+	c := newTestContext()
+	c.Request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	root := StartRequestSpan(c)
+	defer root.End()
+
+	header := http.Header{}
+	InjectTraceParent(c, header)
+	require.Contains(t, header.Get("traceparent"), "4bf92f3577b34da6a3ce929d0e0e4736", "incoming trace id is continued")
+}
+
+func TestDisabledSpansAreNoop(t *testing.T) {
+	c := newTestContext()
+	request := c.Request
+
+	span := StartSpan(c, "noop")
+	span.SetAttributes()
+	span.EndWithAPIError(nil)
+
+	require.Nil(t, span)
+	require.Same(t, request, c.Request)
+}
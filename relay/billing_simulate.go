@@ -0,0 +1,172 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/billing_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BillingSimulationRequest 计费模拟参数：模型、分组、各类 token 数、工具调用次数，
+// 以及可选的请求体/请求头（供表达式中的 param()/header() 使用）与拟修改的表达式
+type BillingSimulationRequest struct {
+	Model                 string            `json:"model"`
+	Group                 string            `json:"group"`
+	UserGroup             string            `json:"user_group"`
+	RelayFormat           string            `json:"relay_format"`
+	PromptTokens          int               `json:"prompt_tokens"`
+	CompletionTokens      int               `json:"completion_tokens"`
+	MaxTokens             int               `json:"max_tokens"`
+	CacheTokens           int               `json:"cache_tokens"`
+	CacheCreationTokens   int               `json:"cache_creation_tokens"`
+	CacheCreationTokens5m int               `json:"cache_creation_tokens_5m"`
+	CacheCreationTokens1h int               `json:"cache_creation_tokens_1h"`
+	ImageTokens           int               `json:"image_tokens"`
+	ImageOutputTokens     int               `json:"image_output_tokens"`
+	AudioTokens           int               `json:"audio_tokens"`
+	AudioOutputTokens     int               `json:"audio_output_tokens"`
+	WebSearchCalls        int               `json:"web_search_calls"`
+	ClaudeWebSearchCalls  int               `json:"claude_web_search_calls"`
+	FileSearchCalls       int               `json:"file_search_calls"`
+	Body                  json.RawMessage   `json:"body"`
+	Headers               map[string]string `json:"headers"`
+	Expr                  string            `json:"expr"`
+}
+
+// BillingSimulationResult 计费模拟结果
+type BillingSimulationResult struct {
+	PreConsumedQuota int                        `json:"pre_consumed_quota"`
+	FreeModel        bool                       `json:"free_model"`
+	Breakdown        service.TextQuotaBreakdown `json:"breakdown"`
+	EstimatedTier    string                     `json:"estimated_tier,omitempty"`
+	// RequestMultiplier 请求体/请求头条件对表达式结果的综合影响（有请求上下文 / 无请求上下文）
+	RequestMultiplier float64 `json:"request_multiplier,omitempty"`
+}
+
+func (req *BillingSimulationRequest) usage(claudeFormat bool) *dto.Usage {
+	usage := &dto.Usage{
+		PromptTokens:                req.PromptTokens,
+		CompletionTokens:            req.CompletionTokens,
+		TotalTokens:                 req.PromptTokens + req.CompletionTokens,
+		ClaudeCacheCreation5mTokens: req.CacheCreationTokens5m,
+		ClaudeCacheCreation1hTokens: req.CacheCreationTokens1h,
+	}
+	usage.PromptTokensDetails.CachedTokens = req.CacheTokens
+	usage.PromptTokensDetails.CachedCreationTokens = req.CacheCreationTokens
+	usage.PromptTokensDetails.ImageTokens = req.ImageTokens
+	usage.PromptTokensDetails.AudioTokens = req.AudioTokens
+	usage.CompletionTokenDetails.ImageTokens = req.ImageOutputTokens
+	usage.CompletionTokenDetails.AudioTokens = req.AudioOutputTokens
+	if claudeFormat {
+		usage.UsageSemantic = "anthropic"
+	}
+	return usage
+}
+
+// SimulateTextBilling 按实际请求的计费流程（ModelPriceHelper 预扣 + PostTextConsumeQuota 结算）计算价格，
+// 不扣费、不写日志。c 为管理员请求的上下文，模拟时使用其副本，不会被修改。
+func SimulateTextBilling(c *gin.Context, req *BillingSimulationRequest) (*BillingSimulationResult, error) {
+	if strings.TrimSpace(req.Model) == "" {
+		return nil, errors.New("model is required")
+	}
+	if req.PromptTokens < 0 || req.CompletionTokens < 0 {
+		return nil, errors.New("token counts must not be negative")
+	}
+	if req.Expr != "" {
+		if err := billing_setting.SmokeTestExpr(req.Expr); err != nil {
+			return nil, fmt.Errorf("invalid billing expression: %w", err)
+		}
+	}
+	group := req.Group
+	if group == "" {
+		group = "default"
+	}
+	userGroup := req.UserGroup
+	if userGroup == "" {
+		userGroup = group
+	}
+	relayFormat := types.RelayFormatOpenAI
+	if req.RelayFormat == string(types.RelayFormatClaude) {
+		relayFormat = types.RelayFormatClaude
+	}
+
+	simCtx := c.Copy()
+	if req.ClaudeWebSearchCalls > 0 {
+		simCtx.Set("claude_web_search_requests", req.ClaudeWebSearchCalls)
+	}
+	requestInput := billingexpr.RequestInput{Headers: req.Headers}
+	if len(req.Body) > 0 && string(req.Body) != "null" {
+		requestInput.Body = req.Body
+	}
+	info := &relaycommon.RelayInfo{
+		UserId:              c.GetInt("id"),
+		UserGroup:           userGroup,
+		UsingGroup:          group,
+		StartTime:           time.Now(),
+		OriginModelName:     req.Model,
+		RequestHeaders:      req.Headers,
+		RelayFormat:         relayFormat,
+		BillingRequestInput: &requestInput,
+	}
+	if req.WebSearchCalls > 0 || req.FileSearchCalls > 0 {
+		info.ResponsesUsageInfo = &relaycommon.ResponsesUsageInfo{BuiltInTools: map[string]*relaycommon.BuildInToolInfo{}}
+		if req.WebSearchCalls > 0 {
+			info.ResponsesUsageInfo.BuiltInTools[dto.BuildInToolWebSearchPreview] = &relaycommon.BuildInToolInfo{ToolName: dto.BuildInToolWebSearchPreview, CallCount: req.WebSearchCalls}
+		}
+		if req.FileSearchCalls > 0 {
+			info.ResponsesUsageInfo.BuiltInTools[dto.BuildInToolFileSearch] = &relaycommon.BuildInToolInfo{ToolName: dto.BuildInToolFileSearch, CallCount: req.FileSearchCalls}
+		}
+	}
+
+	meta := &types.TokenCountMeta{MaxTokens: req.MaxTokens}
+	var priceData types.PriceData
+	var err error
+	if req.Expr != "" {
+		priceData, err = helper.ModelPriceHelperWithExpr(simCtx, info, req.PromptTokens, meta, req.Expr)
+	} else {
+		priceData, err = helper.ModelPriceHelper(simCtx, info, req.PromptTokens, meta)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	usage := req.usage(relayFormat == types.RelayFormatClaude)
+	result := &BillingSimulationResult{
+		PreConsumedQuota: priceData.QuotaToPreConsume,
+		FreeModel:        priceData.FreeModel,
+		Breakdown:        service.SimulateTextQuota(simCtx, info, usage),
+	}
+	if snap := info.TieredBillingSnapshot; snap != nil {
+		result.EstimatedTier = snap.EstimatedTier
+		result.RequestMultiplier = requestMultiplier(snap.ExprString, usage, result.Breakdown.UsageSemantic == "anthropic", requestInput)
+	}
+	return result, nil
+}
+
+// requestMultiplier 比较有无请求上下文时表达式的结果，反映 param()/header() 条件带来的倍率
+func requestMultiplier(exprStr string, usage *dto.Usage, claudeUsage bool, requestInput billingexpr.RequestInput) float64 {
+	if len(requestInput.Body) == 0 && len(requestInput.Headers) == 0 {
+		return 0
+	}
+	params := service.BuildTieredTokenParams(usage, claudeUsage, billingexpr.UsedVars(exprStr))
+	withRequest, _, err := billingexpr.RunExprWithRequest(exprStr, params, requestInput)
+	if err != nil {
+		return 0
+	}
+	withoutRequest, _, err := billingexpr.RunExpr(exprStr, params)
+	if err != nil || withoutRequest == 0 {
+		return 0
+	}
+	return withRequest / withoutRequest
+}
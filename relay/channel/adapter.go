@@ -81,3 +81,10 @@ type TaskAdaptor interface {
 type OpenAIVideoConverter interface {
 	ConvertToOpenAIVideo(originTask *model.Task) ([]byte, error)
 }
+
+// TokenCounter 由支持上游 token 计数接口的渠道实现（Claude count_tokens、Gemini countTokens），
+// 未实现或 ConvertCountTokensRequest 返回 nil 时使用本地估算
+type TokenCounter interface {
+	ConvertCountTokensRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (any, error)
+	ParseCountTokensResponse(body []byte) (int, error)
+}
@@ -13,6 +13,7 @@ import (
 
 	common2 "github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/tracing"
 	"github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/relay/helper"
@@ -23,6 +24,7 @@ import (
 	"github.com/bytedance/gopkg/util/gopool"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Header) {
@@ -515,7 +517,15 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 		}
 	}
 
+	span := tracing.StartSpan(c, "upstream_do_request", attribute.String("http.request.method", req.Method))
+	if info.ChannelSetting.TraceContextPropagation {
+		tracing.InjectTraceParent(c, req.Header)
+	}
 	resp, err := client.Do(req)
+	if resp != nil {
+		span.SetHTTPStatus(resp.StatusCode)
+	}
+	span.EndWithError(err)
 	if err != nil {
 		logger.LogError(c, "do request failed: "+err.Error())
 		return nil, types.NewError(err, types.ErrorCodeDoRequestFailed, types.ErrOptionWithHideErrMsg("upstream error: do request failed"))
@@ -523,6 +533,9 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	if info.ChannelIsMultiKey {
+		service.RecordMultiKeyRateLimit(info.ChannelId, info.ChannelMultiKeyIndex, resp)
+	}
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
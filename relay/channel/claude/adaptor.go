@@ -7,9 +7,11 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/relay/channel"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/types"
 
@@ -43,6 +45,9 @@ func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 
 func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	requestURL := fmt.Sprintf("%s/v1/messages", info.ChannelBaseUrl)
+	if info.RelayMode == relayconstant.RelayModeCountTokens {
+		requestURL = fmt.Sprintf("%s/v1/messages/count_tokens", info.ChannelBaseUrl)
+	}
 	if !shouldAppendClaudeBetaQuery(info) {
 		return requestURL, nil
 	}
@@ -132,3 +137,24 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+func (a *Adaptor) ConvertCountTokensRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (any, error) {
+	claudeRequest, ok := request.(*dto.ClaudeRequest)
+	if !ok {
+		return nil, nil
+	}
+	return dto.NewClaudeCountTokensRequest(claudeRequest, info.UpstreamModelName), nil
+}
+
+func (a *Adaptor) ParseCountTokensResponse(body []byte) (int, error) {
+	return ParseClaudeCountTokensResponse(body)
+}
+
+// ParseClaudeCountTokensResponse 解析 Anthropic count_tokens 响应，供 AWS、Vertex 等 Claude 兼容渠道复用
+func ParseClaudeCountTokensResponse(body []byte) (int, error) {
+	var resp dto.ClaudeCountTokensResponse
+	if err := common.Unmarshal(body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.InputTokens, nil
+}
@@ -0,0 +1,48 @@
+package claude
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestConvertCountTokensRequest(t *testing.T) {
+	maxTokens := uint(1024)
+	request := &dto.ClaudeRequest{
+		Model:     "claude-alias",
+		MaxTokens: &maxTokens,
+		System:    "be brief",
+		Messages:  []dto.ClaudeMessage{{Role: "user", Content: "hello"}},
+	}
+	info := &relaycommon.RelayInfo{
+		RelayMode:   relayconstant.RelayModeCountTokens,
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "claude-sonnet-4-5", ChannelBaseUrl: "https://api.anthropic.com"},
+	}
+
+	adaptor := &Adaptor{}
+	converted, err := adaptor.ConvertCountTokensRequest(nil, info, request)
+	require.NoError(t, err)
+	data, err := common.Marshal(converted)
+	require.NoError(t, err)
+	require.Equal(t, "claude-sonnet-4-5", gjson.GetBytes(data, "model").String())
+	require.Equal(t, "be brief", gjson.GetBytes(data, "system").String())
+	require.False(t, gjson.GetBytes(data, "max_tokens").Exists())
+
+	url, err := adaptor.GetRequestURL(info)
+	require.NoError(t, err)
+	require.Equal(t, "https://api.anthropic.com/v1/messages/count_tokens", url)
+
+	tokens, err := adaptor.ParseCountTokensResponse([]byte(`{"input_tokens":42}`))
+	require.NoError(t, err)
+	require.Equal(t, 42, tokens)
+
+	// 非 Claude 格式的请求无法转发，交由本地估算
+	converted, err = adaptor.ConvertCountTokensRequest(nil, info, &dto.GeminiChatRequest{})
+	require.NoError(t, err)
+	require.Nil(t, converted)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/relay/channel"
 	"github.com/QuantumNous/new-api/relay/channel/openai"
@@ -150,6 +151,10 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 		return fmt.Sprintf("%s/%s/models/%s:predict", info.ChannelBaseUrl, version, info.UpstreamModelName), nil
 	}
 
+	if info.RelayMode == constant.RelayModeCountTokens {
+		return fmt.Sprintf("%s/%s/models/%s:countTokens", info.ChannelBaseUrl, version, info.UpstreamModelName), nil
+	}
+
 	if strings.HasPrefix(info.UpstreamModelName, "text-embedding") ||
 		strings.HasPrefix(info.UpstreamModelName, "embedding") ||
 		strings.HasPrefix(info.UpstreamModelName, "gemini-embedding") {
@@ -285,3 +290,45 @@ func (a *Adaptor) GetModelList() []string {
 func (a *Adaptor) GetChannelName() string {
 	return ChannelName
 }
+
+func (a *Adaptor) ConvertCountTokensRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (any, error) {
+	geminiRequest, err := ConvertCountTokensGeminiRequest(c, info, request)
+	if err != nil || geminiRequest == nil {
+		return nil, err
+	}
+	return &dto.GeminiCountTokensUpstreamRequest{
+		GenerateContentRequest: dto.GeminiGenerateContentCountRequest{
+			Model:             fmt.Sprintf("models/%s", info.UpstreamModelName),
+			GeminiChatRequest: geminiRequest,
+		},
+	}, nil
+}
+
+func (a *Adaptor) ParseCountTokensResponse(body []byte) (int, error) {
+	return ParseGeminiCountTokensResponse(body)
+}
+
+// ConvertCountTokensGeminiRequest 将 Gemini 或 Claude 格式的计数请求统一转换为 GeminiChatRequest，
+// 不支持的格式返回 nil
+func ConvertCountTokensGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (*dto.GeminiChatRequest, error) {
+	switch req := request.(type) {
+	case *dto.GeminiChatRequest:
+		return req, nil
+	case *dto.ClaudeRequest:
+		converted, err := (&Adaptor{}).ConvertClaudeRequest(c, info, req)
+		if err != nil {
+			return nil, err
+		}
+		geminiRequest, _ := converted.(*dto.GeminiChatRequest)
+		return geminiRequest, nil
+	}
+	return nil, nil
+}
+
+func ParseGeminiCountTokensResponse(body []byte) (int, error) {
+	var resp dto.GeminiCountTokensResponse
+	if err := common.Unmarshal(body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.TotalTokens, nil
+}
@@ -0,0 +1,38 @@
+package gemini
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestConvertCountTokensRequest(t *testing.T) {
+	var countRequest dto.GeminiCountTokensRequest
+	require.NoError(t, common.Unmarshal([]byte(`{"contents":[{"role":"user","parts":[{"text":"hello"}]}]}`), &countRequest))
+
+	info := &relaycommon.RelayInfo{
+		RelayMode:   relayconstant.RelayModeCountTokens,
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gemini-2.5-flash", ChannelBaseUrl: "https://generativelanguage.googleapis.com"},
+	}
+
+	adaptor := &Adaptor{}
+	converted, err := adaptor.ConvertCountTokensRequest(nil, info, countRequest.ToGeminiChatRequest())
+	require.NoError(t, err)
+	data, err := common.Marshal(converted)
+	require.NoError(t, err)
+	require.Equal(t, "models/gemini-2.5-flash", gjson.GetBytes(data, "generateContentRequest.model").String())
+	require.Equal(t, "hello", gjson.GetBytes(data, "generateContentRequest.contents.0.parts.0.text").String())
+
+	url, err := adaptor.GetRequestURL(info)
+	require.NoError(t, err)
+	require.Contains(t, url, "/models/gemini-2.5-flash:countTokens")
+
+	tokens, err := adaptor.ParseCountTokensResponse([]byte(`{"totalTokens":7}`))
+	require.NoError(t, err)
+	require.Equal(t, 7, tokens)
+}
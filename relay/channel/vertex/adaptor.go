@@ -232,8 +232,15 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 		if strings.HasPrefix(info.UpstreamModelName, "imagen") {
 			suffix = "predict"
 		}
+		if info.RelayMode == constant.RelayModeCountTokens {
+			suffix = "countTokens"
+		}
 		return a.getRequestUrl(info, info.UpstreamModelName, suffix)
 	} else if a.RequestMode == RequestModeClaude {
+		if info.RelayMode == constant.RelayModeCountTokens {
+			// https://cloud.google.com/vertex-ai/generative-ai/docs/partner-models/claude/count-tokens
+			return a.getRequestUrl(info, "count-tokens", "rawPredict")
+		}
 		if info.IsStream {
 			suffix = "streamRawPredict?alt=sse"
 		} else {
@@ -401,6 +408,39 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 	return
 }
 
+func (a *Adaptor) ConvertCountTokensRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (any, error) {
+	switch a.RequestMode {
+	case RequestModeClaude:
+		claudeRequest, ok := request.(*dto.ClaudeRequest)
+		if !ok {
+			return nil, nil
+		}
+		model := info.UpstreamModelName
+		if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
+			model = v
+		}
+		return dto.NewClaudeCountTokensRequest(claudeRequest, model), nil
+	case RequestModeGemini:
+		// Vertex 的 countTokens 直接接受 contents/systemInstruction/tools 等字段
+		geminiRequest, err := gemini.ConvertCountTokensGeminiRequest(c, info, request)
+		if err != nil || geminiRequest == nil {
+			return nil, err
+		}
+		if model_setting.GetGeminiSettings().RemoveFunctionResponseIdEnabled {
+			removeFunctionResponseID(geminiRequest)
+		}
+		return geminiRequest, nil
+	}
+	return nil, nil
+}
+
+func (a *Adaptor) ParseCountTokensResponse(body []byte) (int, error) {
+	if a.RequestMode == RequestModeClaude {
+		return claude.ParseClaudeCountTokensResponse(body)
+	}
+	return gemini.ParseGeminiCountTokensResponse(body)
+}
+
 func (a *Adaptor) GetModelList() []string {
 	var modelList []string
 	for i, s := range ModelList {
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/types"
+)
+
+// HedgeRace 对冲请求的竞速状态：首个向客户端写出数据（或进入结算）的尝试胜出，
+// 其余尝试视为落败，既不下发响应也不计费。
+type HedgeRace struct {
+	winner atomic.Int32
+	hedged atomic.Bool
+	once   sync.Once
+	onWin  func(id int32)
+}
+
+func NewHedgeRace(onWin func(id int32)) *HedgeRace {
+	return &HedgeRace{onWin: onWin}
+}
+
+// Claim 尝试让 id 胜出，id 已胜出时同样返回 true
+func (r *HedgeRace) Claim(id int32) bool {
+	if r.winner.CompareAndSwap(0, id) {
+		r.once.Do(func() {
+			if r.onWin != nil {
+				r.onWin(id)
+			}
+		})
+		return true
+	}
+	return r.winner.Load() == id
+}
+
+// Winner 返回胜出尝试的 id，尚未决出时返回 0
+func (r *HedgeRace) Winner() int32 {
+	return r.winner.Load()
+}
+
+// MarkHedged 在发起备用尝试前调用，标记本次请求确实向多个渠道发出过
+func (r *HedgeRace) MarkHedged() {
+	r.hedged.Store(true)
+}
+
+// Hedged 返回是否已发起备用尝试
+func (r *HedgeRace) Hedged() bool {
+	return r.hedged.Load()
+}
+
+// HedgeAttempt 标识 RelayInfo 所属的对冲尝试
+type HedgeAttempt struct {
+	Race *HedgeRace
+	Id   int32
+}
+
+// ClaimHedgeWin 在结算前调用，非对冲请求总是返回 true；
+// 对冲请求中仅胜出的尝试返回 true，保证只有一个尝试计费。
+func (info *RelayInfo) ClaimHedgeWin() bool {
+	if info.Hedge == nil {
+		return true
+	}
+	return info.Hedge.Race.Claim(info.Hedge.Id)
+}
+
+// IsHedged 返回本次请求是否已向另一渠道发出对冲请求。
+// 落败的尝试被取消、没有用量信息，其上游成本不会记录，需在成本统计中单独标注。
+func (info *RelayInfo) IsHedged() bool {
+	return info.Hedge != nil && info.Hedge.Race.Hedged()
+}
+
+// CloneForHedge 复制一份 RelayInfo 供对冲尝试并发使用，
+// 适配器会修改的指针字段一并复制，计费会话等共享字段保持同一实例。
+func (info *RelayInfo) CloneForHedge(attempt *HedgeAttempt) *RelayInfo {
+	cloned := *info
+	cloned.Hedge = attempt
+	if info.ChannelMeta != nil {
+		channelMeta := *info.ChannelMeta
+		cloned.ChannelMeta = &channelMeta
+	}
+	if info.ClaudeConvertInfo != nil {
+		claudeConvertInfo := *info.ClaudeConvertInfo
+		cloned.ClaudeConvertInfo = &claudeConvertInfo
+	}
+	if info.RerankerInfo != nil {
+		rerankerInfo := *info.RerankerInfo
+		cloned.RerankerInfo = &rerankerInfo
+	}
+	if info.ResponsesUsageInfo != nil {
+		responsesUsageInfo := *info.ResponsesUsageInfo
+		cloned.ResponsesUsageInfo = &responsesUsageInfo
+	}
+	cloned.StreamStatus = nil
+	cloned.RequestConversionChain = append([]types.RelayFormat(nil), info.RequestConversionChain...)
+	return &cloned
+}
@@ -36,7 +36,8 @@ var paramOverrideKeyAuditPaths = map[string]struct{}{
 }
 
 type paramOverrideAuditRecorder struct {
-	lines []string
+	lines  []string
+	prefix string // 区分令牌策略与渠道覆盖产生的审计行
 }
 
 type ConditionOperation struct {
@@ -170,18 +171,30 @@ func buildLegacyParamOverride(paramOverride map[string]interface{}) map[string]i
 }
 
 func ApplyParamOverrideWithRelayInfo(jsonData []byte, info *RelayInfo) ([]byte, error) {
+	tokenParamOverride := getTokenParamOverrideMap(info)
 	paramOverride := getParamOverrideMap(info)
-	if len(paramOverride) == 0 {
+	if len(tokenParamOverride) == 0 && len(paramOverride) == 0 {
 		return jsonData, nil
 	}
 
 	overrideCtx := BuildParamOverrideContext(info)
 	var recorder *paramOverrideAuditRecorder
-	if shouldEnableParamOverrideAudit(paramOverride) {
+	if shouldEnableParamOverrideAudit(tokenParamOverride) || shouldEnableParamOverrideAudit(paramOverride) {
 		recorder = &paramOverrideAuditRecorder{}
 		overrideCtx[paramOverrideContextAuditRecorder] = recorder
 	}
-	result, err := ApplyParamOverride(jsonData, paramOverride, overrideCtx)
+	// 令牌策略先于渠道参数覆盖执行，渠道仍可在其基础上调整
+	result := jsonData
+	var err error
+	if len(tokenParamOverride) > 0 {
+		recorder.setPrefix("token ")
+		result, err = ApplyParamOverride(result, tokenParamOverride, overrideCtx)
+		if err != nil {
+			return nil, err
+		}
+		recorder.setPrefix("")
+	}
+	result, err = ApplyParamOverride(result, paramOverride, overrideCtx)
 	if err != nil {
 		return nil, err
 	}
@@ -241,12 +254,20 @@ func (r *paramOverrideAuditRecorder) recordOperation(mode, path, from, to string
 	if line == "" {
 		return
 	}
+	line = r.prefix + line
 	if lo.Contains(r.lines, line) {
 		return
 	}
 	r.lines = append(r.lines, line)
 }
 
+func (r *paramOverrideAuditRecorder) setPrefix(prefix string) {
+	if r == nil {
+		return
+	}
+	r.prefix = prefix
+}
+
 func shouldAuditParamPath(path string) bool {
 	path = strings.TrimSpace(path)
 	if path == "" {
@@ -377,6 +398,97 @@ func getParamOverrideMap(info *RelayInfo) map[string]interface{} {
 	return info.ChannelMeta.ParamOverride
 }
 
+func getTokenParamOverrideMap(info *RelayInfo) map[string]interface{} {
+	if info == nil || len(info.TokenParamOverride) == 0 {
+		return nil
+	}
+	return expandTokenParamOverrideValue(info.TokenParamOverride, info).(map[string]interface{})
+}
+
+// tokenParamOverrideAllowedFields 普通用户的令牌策略只能改写这些顶层字段，
+// 模型、计费相关字段与请求头均不可改写，避免绕过定价与渠道配置
+var tokenParamOverrideAllowedFields = map[string]struct{}{
+	"temperature":           {},
+	"top_p":                 {},
+	"max_tokens":            {},
+	"max_completion_tokens": {},
+	"max_output_tokens":     {},
+	"messages":              {},
+	"system":                {},
+	"instructions":          {},
+	"systemInstruction":     {},
+	"tools":                 {},
+	"tool_choice":           {},
+	"user":                  {},
+	"safety_identifier":     {},
+}
+
+// ValidateTokenParamOverride 校验普通用户令牌策略只使用允许的字段与操作
+func ValidateTokenParamOverride(paramOverride map[string]interface{}) error {
+	if len(paramOverride) == 0 {
+		return nil
+	}
+	for key := range buildLegacyParamOverride(paramOverride) {
+		if !isTokenParamOverrideFieldAllowed(key) {
+			return fmt.Errorf("field %s is not allowed", key)
+		}
+	}
+	if _, exists := paramOverride["operations"]; !exists {
+		return nil
+	}
+	operations, ok := tryParseOperations(paramOverride)
+	if !ok {
+		return fmt.Errorf("operations format is invalid")
+	}
+	for _, op := range operations {
+		mode := strings.TrimSpace(op.Mode)
+		switch {
+		case mode == "return_error":
+		case mode == "copy" || mode == "move":
+			for _, path := range []string{op.From, op.To} {
+				if !isTokenParamOverrideFieldAllowed(path) {
+					return fmt.Errorf("field %s is not allowed", path)
+				}
+			}
+		case isPathBasedOperation(mode):
+			if !isTokenParamOverrideFieldAllowed(op.Path) {
+				return fmt.Errorf("field %s is not allowed", op.Path)
+			}
+		default:
+			return fmt.Errorf("mode %s is not allowed", mode)
+		}
+	}
+	return nil
+}
+
+func isTokenParamOverrideFieldAllowed(path string) bool {
+	root, _, _ := strings.Cut(strings.TrimSpace(path), ".")
+	_, ok := tokenParamOverrideAllowedFields[root]
+	return ok
+}
+
+// expandTokenParamOverrideValue 替换令牌策略中的 {{token_id}} 占位符，
+// 便于将 user、safety_identifier 等字段固定为令牌 ID
+func expandTokenParamOverrideValue(value interface{}, info *RelayInfo) interface{} {
+	switch typed := value.(type) {
+	case string:
+		return strings.ReplaceAll(typed, "{{token_id}}", strconv.Itoa(info.TokenId))
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(typed))
+		for key, item := range typed {
+			expanded[key] = expandTokenParamOverrideValue(item, info)
+		}
+		return expanded
+	case []interface{}:
+		expanded := make([]interface{}, len(typed))
+		for i, item := range typed {
+			expanded[i] = expandTokenParamOverrideValue(item, info)
+		}
+		return expanded
+	}
+	return value
+}
+
 func getHeaderOverrideMap(info *RelayInfo) map[string]interface{} {
 	if info == nil || info.ChannelMeta == nil {
 		return nil
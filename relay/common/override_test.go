@@ -2201,3 +2201,94 @@ func assertJSONEqual(t *testing.T, want, got string) {
 		t.Fatalf("json not equal\nwant: %s\ngot:  %s", want, got)
 	}
 }
+
+func TestApplyParamOverrideWithRelayInfoTokenPolicyBeforeChannel(t *testing.T) {
+	info := &RelayInfo{
+		TokenId: 42,
+		TokenParamOverride: map[string]interface{}{
+			"operations": []interface{}{
+				map[string]interface{}{
+					"mode":  "set",
+					"path":  "temperature",
+					"value": 0.1,
+				},
+				map[string]interface{}{
+					"mode":  "set",
+					"path":  "user",
+					"value": "token-{{token_id}}",
+				},
+				map[string]interface{}{
+					"mode": "delete",
+					"path": "tools",
+				},
+				map[string]interface{}{
+					"mode":  "set",
+					"path":  "model",
+					"value": "gpt-4o-mini",
+				},
+			},
+		},
+		ChannelMeta: &ChannelMeta{
+			ParamOverride: map[string]interface{}{
+				"temperature": 0.3,
+			},
+		},
+	}
+
+	out, err := ApplyParamOverrideWithRelayInfo([]byte(`{"model":"gpt-4o","temperature":0.9,"tools":[{"type":"function"}]}`), info)
+	if err != nil {
+		t.Fatalf("ApplyParamOverrideWithRelayInfo returned error: %v", err)
+	}
+	assertJSONEqual(t, `{"model":"gpt-4o-mini","temperature":0.3,"user":"token-42"}`, string(out))
+
+	if len(info.ParamOverrideAudit) == 0 || info.ParamOverrideAudit[0] != "token set model = gpt-4o-mini" {
+		t.Fatalf("expected token audit line, got: %v", info.ParamOverrideAudit)
+	}
+	if !info.HasParamOverride() {
+		t.Fatalf("expected HasParamOverride to report token policy")
+	}
+}
+
+func TestValidateTokenParamOverride(t *testing.T) {
+	tests := []struct {
+		name          string
+		paramOverride map[string]interface{}
+		wantErr       bool
+	}{
+		{"legacy sampling fields", map[string]interface{}{"temperature": 0.2, "max_tokens": 256}, false},
+		{"legacy model", map[string]interface{}{"model": "gpt-4o"}, true},
+		{"set nested system prompt", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "prepend", "path": "messages", "value": []interface{}{}},
+			map[string]interface{}{"mode": "set", "path": "safety_identifier", "value": "{{token_id}}"},
+		}}, false},
+		{"set model", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "set", "path": "model", "value": "gpt-4o"},
+		}}, true},
+		{"wildcard root", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "delete", "path": "*"},
+		}}, true},
+		{"copy into model", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "copy", "from": "user", "to": "model"},
+		}}, true},
+		{"set header", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "set_header", "path": "Authorization", "value": "x"},
+		}}, true},
+		{"pass headers", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "pass_headers", "value": []interface{}{"*"}},
+		}}, true},
+		{"sync fields", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "sync_fields", "from": "header:x-model", "to": "json:model"},
+		}}, true},
+		{"return error", map[string]interface{}{"operations": []interface{}{
+			map[string]interface{}{"mode": "return_error", "value": "blocked"},
+		}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTokenParamOverride(tt.paramOverride)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTokenParamOverride() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -92,6 +92,7 @@ type RelayInfo struct {
 	UsingGroup        string // 使用的分组，当auto跨分组重试时，会变动
 	UserGroup         string // 用户所在分组
 	TokenUnlimited    bool
+	OrgId             int // 令牌绑定的组织，非 0 时从组织额度扣费
 	StartTime         time.Time
 	FirstResponseTime time.Time
 	isFirstResponse   bool
@@ -117,6 +118,9 @@ type RelayInfo struct {
 	UserSetting            dto.UserSetting
 	UserEmail              string
 	UserQuota              int
+	TokenBudget            types.BudgetWindow // 令牌周期预算
+	UserBudget             types.BudgetWindow // 用户周期预算
+	UserCreditLimit        int                // 后付费信用额度，钱包余额最多透支到 -UserCreditLimit
 	RelayFormat            types.RelayFormat
 	SendResponseCount      int
 	ReceivedResponseCount  int
@@ -152,6 +156,7 @@ type RelayInfo struct {
 	RuntimeHeadersOverride                map[string]interface{}
 	UseRuntimeHeadersOverride             bool
 	ParamOverrideAudit                    []string
+	TokenParamOverride                    map[string]interface{} // 令牌级参数策略，先于渠道参数覆盖执行
 
 	PriceData types.PriceData
 
@@ -171,6 +176,9 @@ type RelayInfo struct {
 
 	StreamStatus *StreamStatus
 
+	// Hedge 非空时表示该 RelayInfo 属于对冲请求中的一个尝试
+	Hedge *HedgeAttempt
+
 	ThinkingContentInfo
 	TokenCountMeta
 	*ClaudeConvertInfo
@@ -468,6 +476,7 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
 		TokenUnlimited: common.GetContextKeyBool(c, constant.ContextKeyTokenUnlimited),
 		TokenGroup:     tokenGroup,
+		OrgId:          common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId),
 
 		isFirstResponse: true,
 		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
@@ -501,6 +510,10 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 	if ok {
 		info.UserSetting = userSetting
 	}
+	info.TokenBudget, _ = common.GetContextKeyType[types.BudgetWindow](c, constant.ContextKeyTokenBudget)
+	info.UserBudget, _ = common.GetContextKeyType[types.BudgetWindow](c, constant.ContextKeyUserBudget)
+	info.UserCreditLimit = common.GetContextKeyInt(c, constant.ContextKeyUserCreditLimit)
+	info.TokenParamOverride = common.GetContextKeyStringMap(c, constant.ContextKeyTokenParamOverride)
 
 	return info
 }
@@ -582,6 +595,20 @@ func GenRelayInfo(c *gin.Context, relayFormat types.RelayFormat, request dto.Req
 	return info, nil
 }
 
+// HasParamOverride 渠道或令牌任一配置了参数覆盖时返回 true
+func (info *RelayInfo) HasParamOverride() bool {
+	return len(info.TokenParamOverride) > 0 || (info.ChannelMeta != nil && len(info.ParamOverride) > 0)
+}
+
+// BodyPassThroughEnabled 全局或渠道开启请求体透传时返回 true；
+// 令牌配置了参数策略时强制走转换流程，保证策略生效
+func (info *RelayInfo) BodyPassThroughEnabled() bool {
+	if len(info.TokenParamOverride) > 0 {
+		return false
+	}
+	return model_setting.GetGlobalSettings().PassThroughRequestEnabled || info.ChannelSetting.PassThroughBodyEnabled
+}
+
 func (info *RelayInfo) InitRequestConversionChain() {
 	if info == nil {
 		return
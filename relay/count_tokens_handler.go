@@ -0,0 +1,131 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/tracing"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errCountTokensUnsupported = errors.New("channel does not support upstream token counting")
+
+// CountTokensHelper 处理 Claude count_tokens 与 Gemini countTokens 请求。
+// 渠道支持上游计数时直接转发，否则或上游失败时回退到本地估算；计数请求不计费。
+func CountTokensHelper(c *gin.Context, info *relaycommon.RelayInfo) *types.NewAPIError {
+	info.InitChannelMeta(c)
+
+	var request dto.Request
+	switch req := info.Request.(type) {
+	case *dto.ClaudeRequest:
+		copied, err := common.DeepCopy(req)
+		if err != nil {
+			return types.NewError(fmt.Errorf("failed to copy request to ClaudeRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+		}
+		request = copied
+	case *dto.GeminiChatRequest:
+		copied, err := common.DeepCopy(req)
+		if err != nil {
+			return types.NewError(fmt.Errorf("failed to copy request to GeminiChatRequest: %w", err), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+		}
+		request = copied
+	default:
+		return types.NewErrorWithStatusCode(fmt.Errorf("invalid request type for count tokens: %T", info.Request), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+
+	err := helper.ModelMappedHelper(c, info, request)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeChannelModelMappedError, types.ErrOptionWithSkipRetry())
+	}
+	info.IsStream = false
+
+	span := tracing.StartSpan(c, "upstream_count_tokens")
+	tokens, err := countTokensUpstream(c, info, request)
+	span.EndWithError(err)
+	if err != nil {
+		if !errors.Is(err, errCountTokensUnsupported) {
+			logger.LogWarn(c, fmt.Sprintf("upstream count tokens failed, fallback to local estimate: %s", err.Error()))
+		}
+		tokens = estimateCountTokensLocally(c, info, request)
+	}
+
+	switch info.RelayFormat {
+	case types.RelayFormatGemini:
+		c.JSON(http.StatusOK, dto.GeminiCountTokensResponse{TotalTokens: tokens})
+	default:
+		c.JSON(http.StatusOK, dto.ClaudeCountTokensResponse{InputTokens: tokens})
+	}
+	return nil
+}
+
+func countTokensUpstream(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) (int, error) {
+	adaptor := GetAdaptor(info.ApiType)
+	if adaptor == nil {
+		return 0, fmt.Errorf("invalid api type: %d", info.ApiType)
+	}
+	adaptor.Init(info)
+
+	counter, ok := adaptor.(channel.TokenCounter)
+	if !ok {
+		return 0, errCountTokensUnsupported
+	}
+	convertedRequest, err := counter.ConvertCountTokensRequest(c, info, request)
+	if err != nil {
+		return 0, err
+	}
+	if convertedRequest == nil {
+		return 0, errCountTokensUnsupported
+	}
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return 0, err
+	}
+	logger.LogDebug(c, fmt.Sprintf("count tokens request body: %s", string(jsonData)))
+
+	resp, err := adaptor.DoRequest(c, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return 0, errCountTokensUnsupported
+	}
+	defer service.CloseResponseBodyGracefully(httpResp)
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status code %d: %s", httpResp.StatusCode, string(body))
+	}
+	return counter.ParseCountTokensResponse(body)
+}
+
+// estimateCountTokensLocally 使用本地估算器计算输入 token，不受 CountToken 开关影响
+func estimateCountTokensLocally(c *gin.Context, info *relaycommon.RelayInfo, request dto.Request) int {
+	meta := request.GetTokenCountMeta()
+	if meta == nil {
+		return 0
+	}
+	if constant.CountToken {
+		tokens, err := service.EstimateRequestToken(c, meta, info)
+		if err == nil && tokens > 0 {
+			return tokens
+		}
+	}
+	return service.CountTextToken(meta.CombineText, info.OriginModelName)
+}
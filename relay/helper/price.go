@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/pkg/billingexpr"
@@ -61,6 +62,16 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(relayInfo.UsingGroup)
 	}
 
+	// 批处理请求叠加批处理折扣
+	if common.GetContextKeyString(ctx, constant.ContextKeyBatchId) != "" {
+		groupRatioInfo.GroupRatio *= operation_setting.GetBatchSetting().GetDiscountRatio()
+	}
+
+	// 命中响应缓存的请求按缓存倍率计费
+	if common.GetContextKeyBool(ctx, constant.ContextKeyResponseCacheHit) {
+		groupRatioInfo.GroupRatio *= operation_setting.GetResponseCacheSetting().GetBillingRatio()
+	}
+
 	return groupRatioInfo
 }
 
@@ -243,7 +254,16 @@ func modelPriceHelperTiered(c *gin.Context, info *relaycommon.RelayInfo, promptT
 	if !ok {
 		return types.PriceData{}, fmt.Errorf("model %s is configured as tiered_expr but has no billing expression", info.OriginModelName)
 	}
+	return modelPriceHelperTieredExpr(c, info, promptTokens, meta, groupRatioInfo, exprStr)
+}
+
+// ModelPriceHelperWithExpr 使用指定的阶梯表达式代替已保存的配置计算价格，用于计费模拟
+func ModelPriceHelperWithExpr(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, meta *types.TokenCountMeta, exprStr string) (types.PriceData, error) {
+	groupRatioInfo := HandleGroupRatio(c, info)
+	return modelPriceHelperTieredExpr(c, info, promptTokens, meta, groupRatioInfo, exprStr)
+}
 
+func modelPriceHelperTieredExpr(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, meta *types.TokenCountMeta, groupRatioInfo types.GroupRatioInfo, exprStr string) (types.PriceData, error) {
 	estimatedCompletionTokens := 0
 	if meta.MaxTokens != 0 {
 		estimatedCompletionTokens = meta.MaxTokens
@@ -28,6 +28,8 @@ func GetAndValidateRequest(c *gin.Context, format types.RelayFormat) (request dt
 			request, err = GetAndValidateGeminiEmbeddingRequest(c)
 		} else if strings.Contains(c.Request.URL.Path, ":batchEmbedContents") {
 			request, err = GetAndValidateGeminiBatchEmbeddingRequest(c)
+		} else if relayMode == relayconstant.RelayModeCountTokens {
+			request, err = GetAndValidateGeminiCountTokensRequest(c)
 		} else {
 			request, err = GetAndValidateGeminiRequest(c)
 		}
@@ -322,6 +324,20 @@ func GetAndValidateGeminiRequest(c *gin.Context) (*dto.GeminiChatRequest, error)
 	return request, nil
 }
 
+// GetAndValidateGeminiCountTokensRequest 解析 countTokens 请求，contents 与 generateContentRequest 统一为 GeminiChatRequest
+func GetAndValidateGeminiCountTokensRequest(c *gin.Context) (*dto.GeminiChatRequest, error) {
+	countRequest := &dto.GeminiCountTokensRequest{}
+	err := common.UnmarshalBodyReusable(c, countRequest)
+	if err != nil {
+		return nil, err
+	}
+	request := countRequest.ToGeminiChatRequest()
+	if len(request.Contents) == 0 {
+		return nil, errors.New("contents is required")
+	}
+	return request, nil
+}
+
 func GetAndValidateGeminiEmbeddingRequest(c *gin.Context) (*dto.GeminiEmbeddingRequest, error) {
 	request := &dto.GeminiEmbeddingRequest{}
 	err := common.UnmarshalBodyReusable(c, request)
@@ -0,0 +1,279 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	appconstant "github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/tracing"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/service/openaicompat"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shouldResponsesUseChatCompletions 判断渠道是否需要通过 Chat Completions 桥接 Responses 请求，
+// 原生支持 Responses API 的渠道直接转发
+func shouldResponsesUseChatCompletions(info *relaycommon.RelayInfo) bool {
+	if info.RelayMode != relayconstant.RelayModeResponses {
+		return false
+	}
+	switch info.ApiType {
+	case appconstant.APITypeOpenAI, appconstant.APITypeCodex, appconstant.APITypeOpenRouter, appconstant.APITypeXinference,
+		appconstant.APITypeAli, appconstant.APITypeXai, appconstant.APITypeVolcEngine, appconstant.APITypePerplexity,
+		appconstant.APITypeCloudflare:
+		return false
+	}
+	return true
+}
+
+// chatToResponsesStreamWriter 拦截渠道处理器输出的 Chat Completions SSE 分片，
+// 转换为 Responses 流式事件后写入原始 writer
+type chatToResponsesStreamWriter struct {
+	gin.ResponseWriter
+	converter *openaicompat.ChatToResponsesStreamConverter
+	pending   bytes.Buffer
+}
+
+func (w *chatToResponsesStreamWriter) Write(data []byte) (int, error) {
+	w.pending.Write(data)
+	for {
+		idx := bytes.Index(w.pending.Bytes(), []byte("\n\n"))
+		if idx < 0 {
+			break
+		}
+		block := string(w.pending.Next(idx + 2))
+		if err := w.handleBlock(block); err != nil {
+			return len(data), err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *chatToResponsesStreamWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *chatToResponsesStreamWriter) handleBlock(block string) error {
+	for _, line := range strings.Split(block, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ":") {
+			// 保留 keep-alive 注释，同一块中后续的 data 行继续转换
+			if _, err := w.ResponseWriter.Write([]byte(line + "\n\n")); err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+		var chunk dto.ChatCompletionsStreamResponse
+		if err := common.UnmarshalJsonStr(payload, &chunk); err != nil {
+			continue
+		}
+		if err := w.writeEvents(w.converter.HandleChunk(&chunk)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *chatToResponsesStreamWriter) writeEvents(events []dto.ResponsesStreamResponse) error {
+	for _, event := range events {
+		data, err := common.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w.ResponseWriter, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chatToResponsesBufferWriter 缓存非流式的 Chat Completions 响应，由桥接逻辑转换后再写出
+type chatToResponsesBufferWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *chatToResponsesBufferWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *chatToResponsesBufferWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *chatToResponsesBufferWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *chatToResponsesBufferWriter) WriteHeaderNow() {}
+
+func (w *chatToResponsesBufferWriter) Written() bool {
+	return false
+}
+
+func (w *chatToResponsesBufferWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *chatToResponsesBufferWriter) Flush() {}
+
+// responsesViaChatCompletions 将 Responses 请求转换为 Chat Completions 请求发送给只支持 Chat 的渠道，
+// 并将渠道处理器输出的 Chat 响应转换回 Responses 格式
+func responsesViaChatCompletions(c *gin.Context, info *relaycommon.RelayInfo, adaptor channel.Adaptor, request *dto.OpenAIResponsesRequest) (*dto.Usage, *types.NewAPIError) {
+	chatReq, err := service.ResponsesRequestToChatCompletionsRequest(request)
+	if err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	if !info.SupportStreamOptions {
+		chatReq.StreamOptions = nil
+	}
+	applySystemPromptIfNeeded(c, info, chatReq)
+	info.AppendRequestConversion(types.RelayFormatOpenAI)
+
+	savedRelayMode := info.RelayMode
+	savedRelayFormat := info.RelayFormat
+	savedRequestURLPath := info.RequestURLPath
+	savedShouldIncludeUsage := info.ShouldIncludeUsage
+	defer func() {
+		info.RelayMode = savedRelayMode
+		info.RelayFormat = savedRelayFormat
+		info.RequestURLPath = savedRequestURLPath
+		info.ShouldIncludeUsage = savedShouldIncludeUsage
+	}()
+
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RelayFormat = types.RelayFormatOpenAI
+	info.RequestURLPath = "/v1/chat/completions"
+	info.ShouldIncludeUsage = true
+
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, chatReq)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+	relaycommon.AppendRequestConversionFromRequest(info, convertedRequest)
+
+	jsonData, err := common.Marshal(convertedRequest)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeJsonMarshalFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	jsonData, err = relaycommon.RemoveDisabledFields(jsonData, info.ChannelOtherSettings, info.ChannelSetting.PassThroughBodyEnabled)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeConvertRequestFailed, types.ErrOptionWithSkipRetry())
+	}
+
+	if info.HasParamOverride() {
+		jsonData, err = relaycommon.ApplyParamOverrideWithRelayInfo(jsonData, info)
+		if err != nil {
+			return nil, newAPIErrorFromParamOverride(err)
+		}
+	}
+
+	logger.LogDebug(c, fmt.Sprintf("responses via chat completions request body: %s", string(jsonData)))
+
+	var requestBody io.Reader = bytes.NewBuffer(jsonData)
+
+	resp, err := adaptor.DoRequest(c, info, requestBody)
+	if err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeDoRequestFailed, http.StatusInternalServerError)
+	}
+
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		info.IsStream = info.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+		if httpResp.StatusCode != http.StatusOK {
+			newApiErr := service.RelayErrorHandler(c.Request.Context(), httpResp, false)
+			service.ResetStatusCode(newApiErr, statusCodeMappingStr)
+			return nil, newApiErr
+		}
+	}
+
+	responseId := c.GetString(common.RequestIdKey)
+	originWriter := c.Writer
+	defer func() {
+		c.Writer = originWriter
+	}()
+
+	if info.IsStream {
+		streamWriter := &chatToResponsesStreamWriter{
+			ResponseWriter: originWriter,
+			converter:      service.NewChatToResponsesStreamConverter(responseId, info.OriginModelName),
+		}
+		c.Writer = streamWriter
+
+		responseSpan := tracing.StartSpan(c, "adaptor_do_response")
+		usage, newApiErr := adaptor.DoResponse(c, httpResp, info)
+		responseSpan.EndWithAPIError(newApiErr)
+		if newApiErr != nil {
+			service.ResetStatusCode(newApiErr, statusCodeMappingStr)
+			return nil, newApiErr
+		}
+		// 渠道处理器未统计到用量时，使用流中分片携带的用量
+		usageDto, ok := usage.(*dto.Usage)
+		if !ok || usageDto == nil || usageDto.TotalTokens == 0 && usageDto.PromptTokens == 0 && usageDto.CompletionTokens == 0 {
+			usageDto = streamWriter.converter.Usage()
+		}
+		if usageDto == nil {
+			usageDto = &dto.Usage{}
+		}
+
+		c.Writer = originWriter
+		helper.SetEventStreamHeaders(c)
+		if err := streamWriter.writeEvents(streamWriter.converter.Finish(usageDto)); err != nil {
+			logger.LogError(c, "failed to write responses stream events: "+err.Error())
+		}
+		_ = helper.FlushWriter(c)
+		return usageDto, nil
+	}
+
+	bufferWriter := &chatToResponsesBufferWriter{ResponseWriter: originWriter}
+	c.Writer = bufferWriter
+
+	responseSpan := tracing.StartSpan(c, "adaptor_do_response")
+	usage, newApiErr := adaptor.DoResponse(c, httpResp, info)
+	responseSpan.EndWithAPIError(newApiErr)
+	if newApiErr != nil {
+		service.ResetStatusCode(newApiErr, statusCodeMappingStr)
+		return nil, newApiErr
+	}
+	usageDto, ok := usage.(*dto.Usage)
+	if !ok || usageDto == nil {
+		usageDto = &dto.Usage{}
+	}
+
+	var chatResp dto.OpenAITextResponse
+	if err := common.Unmarshal(bufferWriter.body.Bytes(), &chatResp); err != nil {
+		return nil, types.NewOpenAIError(err, types.ErrorCodeBadResponseBody, http.StatusInternalServerError)
+	}
+
+	c.Writer = originWriter
+	c.Writer.Header().Del("Content-Length")
+	c.JSON(http.StatusOK, service.ChatCompletionsResponseToResponsesResponse(&chatResp, usageDto, responseId, info.OriginModelName))
+	return usageDto, nil
+}
@@ -0,0 +1,30 @@
+package relay
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatToResponsesStreamWriter_KeepAliveBeforeData(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writer := &chatToResponsesStreamWriter{
+		ResponseWriter: c.Writer,
+		converter:      service.NewChatToResponsesStreamConverter("req1", "m"),
+	}
+
+	_, err := writer.WriteString(": keep-alive\ndata: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"}}],\"usage\":{\"prompt_tokens\":2,\"completion_tokens\":1,\"total_tokens\":3}}\n\n")
+	require.NoError(t, err)
+
+	body := recorder.Body.String()
+	assert.Contains(t, body, ": keep-alive\n\n")
+	assert.Contains(t, body, "event: response.output_text.delta", "data lines after a keep-alive comment are still converted")
+	require.NotNil(t, writer.converter.Usage())
+	assert.Equal(t, 3, writer.converter.Usage().TotalTokens)
+}
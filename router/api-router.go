@@ -49,6 +49,8 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.POST("/stripe/webhook", controller.StripeWebhook)
 		apiRouter.POST("/creem/webhook", controller.CreemWebhook)
 		apiRouter.POST("/waffo/webhook", controller.WaffoWebhook)
+		apiRouter.POST("/payment/:provider/webhook", controller.PaymentWebhook)
+		apiRouter.GET("/payment/:provider/webhook", controller.PaymentWebhook)
 		//apiRouter.POST("/waffo-pancake/webhook", controller.WaffoPancakeWebhook)
 
 		// Universal secure verification routes
@@ -91,6 +93,7 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.POST("/stripe/pay", middleware.CriticalRateLimit(), controller.RequestStripePay)
 				selfRoute.POST("/stripe/amount", controller.RequestStripeAmount)
 				selfRoute.POST("/creem/pay", middleware.CriticalRateLimit(), controller.RequestCreemPay)
+				selfRoute.POST("/payment/:provider/pay", middleware.CriticalRateLimit(), controller.RequestPaymentCheckout)
 				selfRoute.POST("/waffo/amount", controller.RequestWaffoAmount)
 				selfRoute.POST("/waffo/pay", middleware.CriticalRateLimit(), controller.RequestWaffoPay)
 				//selfRoute.POST("/waffo-pancake/amount", controller.RequestWaffoPancakeAmount)
@@ -120,6 +123,7 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/topup", controller.GetAllTopUps)
 				adminRoute.POST("/topup/complete", controller.AdminCompleteTopUp)
+				adminRoute.POST("/topup/refund", middleware.CriticalRateLimit(), controller.AdminRefundTopUp)
 				adminRoute.GET("/search", controller.SearchUsers)
 				adminRoute.GET("/:id/oauth/bindings", controller.GetUserOAuthBindingsByAdmin)
 				adminRoute.DELETE("/:id/oauth/bindings/:provider_id", controller.UnbindCustomOAuthByAdmin)
@@ -147,6 +151,7 @@ func SetApiRouter(router *gin.Engine) {
 			subscriptionRoute.POST("/epay/pay", middleware.CriticalRateLimit(), controller.SubscriptionRequestEpay)
 			subscriptionRoute.POST("/stripe/pay", middleware.CriticalRateLimit(), controller.SubscriptionRequestStripePay)
 			subscriptionRoute.POST("/creem/pay", middleware.CriticalRateLimit(), controller.SubscriptionRequestCreemPay)
+			subscriptionRoute.POST("/payment/:provider/pay", middleware.CriticalRateLimit(), controller.SubscriptionRequestPaymentCheckout)
 		}
 		subscriptionAdminRoute := apiRouter.Group("/subscription/admin")
 		subscriptionAdminRoute.Use(middleware.AdminAuth())
@@ -164,6 +169,51 @@ func SetApiRouter(router *gin.Engine) {
 			subscriptionAdminRoute.DELETE("/user_subscriptions/:id", controller.AdminDeleteUserSubscription)
 		}
 
+		// Postpaid invoices
+		invoiceRoute := apiRouter.Group("/invoice")
+		invoiceRoute.Use(middleware.UserAuth())
+		{
+			invoiceRoute.GET("/self", controller.GetSelfInvoices)
+			invoiceRoute.GET("/self/:id", controller.GetSelfInvoice)
+		}
+		invoiceAdminRoute := apiRouter.Group("/invoice/admin")
+		invoiceAdminRoute.Use(middleware.AdminAuth())
+		{
+			invoiceAdminRoute.GET("/", controller.AdminListInvoices)
+			invoiceAdminRoute.GET("/:id", controller.AdminGetInvoice)
+			invoiceAdminRoute.POST("/generate", controller.AdminGenerateInvoices)
+			invoiceAdminRoute.POST("/:id/pay", controller.AdminMarkInvoicePaid)
+		}
+
+		// Organizations (shared quota, members, invitations)
+		organizationRoute := apiRouter.Group("/organization")
+		organizationRoute.Use(middleware.UserAuth())
+		{
+			organizationRoute.GET("/self", controller.GetSelfOrganizations)
+			organizationRoute.POST("/", controller.CreateOrganization)
+			organizationRoute.POST("/invitations/accept", middleware.CriticalRateLimit(), controller.AcceptOrganizationInvitation)
+			organizationRoute.GET("/:id", controller.GetOrganization)
+			organizationRoute.PUT("/:id", controller.UpdateOrganization)
+			organizationRoute.DELETE("/:id", controller.DeleteOrganization)
+			organizationRoute.POST("/:id/transfer", middleware.CriticalRateLimit(), controller.TransferQuotaToOrganization)
+			organizationRoute.POST("/:id/owner", controller.TransferOrganizationOwnership)
+			organizationRoute.GET("/:id/members", controller.GetOrganizationMembers)
+			organizationRoute.PUT("/:id/members/:user_id", controller.UpdateOrganizationMember)
+			organizationRoute.DELETE("/:id/members/:user_id", controller.RemoveOrganizationMember)
+			organizationRoute.GET("/:id/invitations", controller.GetOrganizationInvitations)
+			organizationRoute.POST("/:id/invitations", middleware.CriticalRateLimit(), controller.CreateOrganizationInvitation)
+			organizationRoute.DELETE("/:id/invitations/:invitation_id", controller.RevokeOrganizationInvitation)
+			organizationRoute.GET("/:id/logs", controller.GetOrganizationLogs)
+			organizationRoute.GET("/:id/usage", controller.GetOrganizationUsage)
+		}
+		organizationAdminRoute := apiRouter.Group("/organization/admin")
+		organizationAdminRoute.Use(middleware.AdminAuth())
+		{
+			organizationAdminRoute.GET("/", controller.AdminListOrganizations)
+			organizationAdminRoute.POST("/:id/quota", controller.AdminAdjustOrganizationQuota)
+			organizationAdminRoute.PATCH("/:id", controller.AdminUpdateOrganizationStatus)
+		}
+
 		// Subscription payment callbacks (no auth)
 		apiRouter.POST("/subscription/epay/notify", controller.SubscriptionEpayNotify)
 		apiRouter.GET("/subscription/epay/notify", controller.SubscriptionEpayNotify)
@@ -201,6 +251,12 @@ func SetApiRouter(router *gin.Engine) {
 			performanceRoute.GET("/logs", controller.GetLogFiles)
 			performanceRoute.DELETE("/logs", controller.CleanupLogFiles)
 		}
+		billingRoute := apiRouter.Group("/billing")
+		billingRoute.Use(middleware.RootAuth())
+		{
+			billingRoute.POST("/simulate", controller.SimulateBilling)
+			billingRoute.POST("/replay", middleware.CriticalRateLimit(), controller.ReplayBilling)
+		}
 		ratioSyncRoute := apiRouter.Group("/ratio_sync")
 		ratioSyncRoute.Use(middleware.RootAuth())
 		{
@@ -218,6 +274,9 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/:id/key", middleware.RootAuth(), middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
+			channelRoute.GET("/breaker", controller.GetChannelBreakerStates)
+			channelRoute.DELETE("/breaker/:id", controller.ResetChannelBreaker)
+			channelRoute.GET("/balance", controller.GetChannelBalanceStats)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
 			channelRoute.POST("/", controller.AddChannel)
@@ -229,6 +288,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
 			channelRoute.POST("/batch", controller.DeleteChannelBatch)
 			channelRoute.POST("/fix", controller.FixChannelsAbilities)
+			channelRoute.POST("/reencrypt_keys", middleware.RootAuth(), middleware.CriticalRateLimit(), controller.ReencryptChannelKeys)
 			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
 			channelRoute.POST("/fetch_models", middleware.RootAuth(), controller.FetchModels)
 			channelRoute.POST("/codex/oauth/start", controller.StartCodexOAuth)
@@ -256,12 +316,12 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", middleware.SearchRateLimit(), controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
-			tokenRoute.POST("/:id/key", middleware.CriticalRateLimit(), middleware.DisableCache(), controller.GetTokenKey)
+			tokenRoute.POST("/:id/regenerate", middleware.CriticalRateLimit(), middleware.DisableCache(), controller.RegenerateTokenKey)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 			tokenRoute.POST("/batch", controller.DeleteTokenBatch)
-			tokenRoute.POST("/batch/keys", middleware.CriticalRateLimit(), middleware.DisableCache(), controller.GetTokenKeysBatch)
+			tokenRoute.POST("/batch/regenerate", middleware.CriticalRateLimit(), middleware.DisableCache(), controller.RegenerateTokenKeysBatch)
 		}
 
 		usageRoute := apiRouter.Group("/usage")
@@ -285,15 +345,27 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.DELETE("/invalid", controller.DeleteInvalidRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		auditRoute := apiRouter.Group("/audit")
+		{
+			auditRoute.GET("/", middleware.AdminAuth(), controller.GetAuditLogs)
+			auditRoute.POST("/:id/rollback", middleware.RootAuth(), controller.RollbackAuditOption)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/margin", middleware.AdminAuth(), controller.GetMarginReport)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/channel_affinity_usage_cache", middleware.AdminAuth(), controller.GetChannelAffinityUsageCacheStats)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/payload/:request_id", middleware.AdminAuth(), controller.GetPayloadCapture)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), middleware.SearchRateLimit(), controller.SearchUserLogs)
+		logRoute.POST("/export", middleware.AdminAuth(), controller.CreateAllLogsExport)
+		logRoute.POST("/self/export", middleware.UserAuth(), middleware.SearchRateLimit(), controller.CreateUserLogsExport)
+		logRoute.GET("/export/jobs", middleware.UserAuth(), controller.GetLogExportJobs)
+		logRoute.GET("/export/jobs/:id", middleware.UserAuth(), controller.GetLogExportJob)
+		logRoute.GET("/export/jobs/:id/download", middleware.UserAuth(), controller.DownloadLogExport)
 
 		dataRoute := apiRouter.Group("/data")
 		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
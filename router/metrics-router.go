@@ -0,0 +1,25 @@
+package router
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetMetricsRouter(router *gin.Engine) {
+	if !constant.MetricsEnabled {
+		return
+	}
+	if constant.MetricsToken == "" {
+		common.SysLog("METRICS_TOKEN not set, /metrics is accessible without authentication")
+	}
+	metricsRouter := router.Group("/metrics")
+	metricsRouter.Use(middleware.RouteTag("metrics"))
+	metricsRouter.Use(middleware.MetricsAuth())
+	{
+		metricsRouter.GET("", gin.WrapH(metrics.Handler()))
+	}
+}
@@ -0,0 +1,362 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	auditMaskedValue = "******"
+
+	// auditMaxRequestBodyBytes 超过该大小或非 JSON 的请求体不记录
+	auditMaxRequestBodyBytes = 1 << 20
+	// auditMaxResponseBytes 只记录响应开头用于判断是否成功
+	auditMaxResponseBytes = 4 << 10
+
+	auditStartedKey = "admin_audit_started"
+)
+
+// auditTargetRoute 按路由前缀确定审计目标类型，resolveId 为空时从路径参数 id 或请求体 id 字段获取目标 id
+type auditTargetRoute struct {
+	prefix     string
+	targetType string
+	resolveId  func(c *gin.Context, body map[string]any) string
+}
+
+var auditTargetRoutes = []auditTargetRoute{
+	{prefix: "/api/option/", targetType: model.AuditTargetOption, resolveId: func(c *gin.Context, body map[string]any) string {
+		key, _ := body["key"].(string)
+		return key
+	}},
+	{prefix: "/api/audit/:id/rollback", targetType: model.AuditTargetOption, resolveId: func(c *gin.Context, body map[string]any) string {
+		id, _ := strconv.Atoi(c.Param("id"))
+		if log, err := model.GetAuditLogById(id); err == nil && log.TargetType == model.AuditTargetOption {
+			return log.TargetId
+		}
+		return ""
+	}},
+	{prefix: "/api/channel/", targetType: model.AuditTargetChannel},
+	{prefix: "/api/token/", targetType: model.AuditTargetToken},
+	{prefix: "/api/user/", targetType: model.AuditTargetUser},
+	{prefix: "/api/subscription/admin/plans", targetType: model.AuditTargetSubscriptionPlan},
+	{prefix: "/api/redemption/", targetType: model.AuditTargetRedemption},
+	{prefix: "/api/custom-oauth-provider/", targetType: model.AuditTargetCustomOAuthProvider},
+}
+
+// auditSensitiveFieldSuffixes 快照中需要脱敏的字段（按小写字段名后缀匹配）
+var auditSensitiveFieldSuffixes = []string{"key", "secret", "password", "access_token", "_token", "setting_token"}
+
+// AdminAudit 单次管理操作的审计上下文
+type AdminAudit struct {
+	log         *model.AuditLog
+	requestBody map[string]any
+	before      map[string]any
+	writer      *auditResponseWriter
+}
+
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	if remaining := auditMaxResponseBytes - w.body.Len(); remaining > 0 {
+		if len(data) > remaining {
+			w.body.Write(data[:remaining])
+		} else {
+			w.body.Write(data)
+		}
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// ShouldAuditRequest 管理员及以上角色发起的修改类请求需要审计，同一请求只记录一次
+func ShouldAuditRequest(c *gin.Context, role int) bool {
+	if role < common.RoleAdminUser {
+		return false
+	}
+	switch c.Request.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return false
+	}
+	if c.GetBool(auditStartedKey) {
+		return false
+	}
+	c.Set(auditStartedKey, true)
+	return true
+}
+
+// BeginAdminAudit 在处理请求前记录目标对象修改前的快照
+func BeginAdminAudit(c *gin.Context) *AdminAudit {
+	audit := &AdminAudit{
+		log: &model.AuditLog{
+			ActorId:   c.GetInt("id"),
+			ActorName: c.GetString("username"),
+			ActorRole: c.GetInt("role"),
+			Ip:        c.ClientIP(),
+			Method:    c.Request.Method,
+			Route:     c.FullPath(),
+			Path:      c.Request.URL.Path,
+		},
+		requestBody: readAuditRequestBody(c),
+	}
+	audit.log.TargetType, audit.log.TargetId = resolveAuditTarget(c, audit.requestBody)
+	audit.before = loadAuditSnapshot(audit.log.TargetType, audit.log.TargetId)
+	audit.writer = &auditResponseWriter{ResponseWriter: c.Writer}
+	c.Writer = audit.writer
+	return audit
+}
+
+// Finish 请求处理完成后记录修改后的快照与差异，异步写入审计日志
+func (a *AdminAudit) Finish(c *gin.Context) {
+	log := a.log
+	log.StatusCode = a.writer.Status()
+	log.Success, log.Message = parseAuditResponse(log.StatusCode, a.writer.body.Bytes())
+	log.CreatedAt = common.GetTimestamp()
+
+	var after map[string]any
+	if log.TargetId != "" {
+		after = loadAuditSnapshot(log.TargetType, log.TargetId)
+	} else {
+		// 创建或批量操作无法确定单个目标，记录请求内容
+		after = a.requestBody
+	}
+	sensitive := log.TargetType == model.AuditTargetOption && IsSensitiveOptionKey(log.TargetId)
+	log.Diff = marshalAuditMap(diffAuditSnapshots(a.before, after, sensitive))
+	log.Before = marshalAuditMap(maskAuditSnapshot(a.before, sensitive))
+	log.After = marshalAuditMap(maskAuditSnapshot(after, sensitive))
+
+	gopool.Go(func() {
+		if err := model.RecordAuditLog(log); err != nil {
+			common.SysError("failed to record audit log: " + err.Error())
+		}
+	})
+}
+
+func readAuditRequestBody(c *gin.Context) map[string]any {
+	if c.Request.Body == nil || c.Request.ContentLength > auditMaxRequestBodyBytes ||
+		!strings.HasPrefix(c.ContentType(), "application/json") {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, auditMaxRequestBodyBytes+1))
+	// 还原请求体，未读完的部分继续从原始请求体读取
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), c.Request.Body))
+	if err != nil || len(data) > auditMaxRequestBodyBytes {
+		return nil
+	}
+	var body map[string]any
+	if err := common.Unmarshal(data, &body); err != nil {
+		return nil
+	}
+	return body
+}
+
+func resolveAuditTarget(c *gin.Context, body map[string]any) (string, string) {
+	route := c.FullPath()
+	for _, target := range auditTargetRoutes {
+		if !strings.HasPrefix(route, target.prefix) && route+"/" != target.prefix {
+			continue
+		}
+		if target.resolveId != nil {
+			return target.targetType, target.resolveId(c, body)
+		}
+		return target.targetType, resolveAuditTargetId(c, body)
+	}
+	return model.AuditTargetOther, ""
+}
+
+func resolveAuditTargetId(c *gin.Context, body map[string]any) string {
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	switch id := body["id"].(type) {
+	case float64:
+		if id > 0 {
+			return strconv.Itoa(int(id))
+		}
+	case string:
+		return id
+	}
+	return ""
+}
+
+// loadAuditSnapshot 读取目标对象当前的内容，对象不存在（如已删除）时返回 nil
+func loadAuditSnapshot(targetType string, targetId string) map[string]any {
+	if targetId == "" {
+		return nil
+	}
+	if targetType == model.AuditTargetOption {
+		common.OptionMapRWMutex.RLock()
+		value, ok := common.OptionMap[targetId]
+		common.OptionMapRWMutex.RUnlock()
+		if !ok {
+			return nil
+		}
+		return map[string]any{"value": value}
+	}
+	id, err := strconv.Atoi(targetId)
+	if err != nil || id <= 0 {
+		return nil
+	}
+	var entity any
+	switch targetType {
+	case model.AuditTargetChannel:
+		entity, err = model.GetChannelById(id, true)
+	case model.AuditTargetToken:
+		entity, err = model.GetTokenById(id)
+	case model.AuditTargetUser:
+		entity, err = model.GetUserById(id, false)
+	case model.AuditTargetSubscriptionPlan:
+		entity, err = model.GetSubscriptionPlanById(id)
+	case model.AuditTargetRedemption:
+		entity, err = model.GetRedemptionById(id)
+	case model.AuditTargetCustomOAuthProvider:
+		entity, err = model.GetCustomOAuthProviderById(id)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	data, err := common.Marshal(entity)
+	if err != nil {
+		return nil
+	}
+	var snapshot map[string]any
+	if err := common.Unmarshal(data, &snapshot); err != nil {
+		return nil
+	}
+	return snapshot
+}
+
+// IsSensitiveOptionKey 选项列表接口与审计日志共用的敏感选项判断，敏感选项的值不返回也不记录明文
+func IsSensitiveOptionKey(key string) bool {
+	return strings.HasSuffix(key, "Token") ||
+		strings.HasSuffix(key, "Secret") ||
+		strings.HasSuffix(key, "Key") ||
+		strings.HasSuffix(key, "secret") ||
+		strings.HasSuffix(key, "api_key")
+}
+
+func isSensitiveAuditField(field string) bool {
+	field = strings.ToLower(field)
+	for _, suffix := range auditSensitiveFieldSuffixes {
+		if strings.HasSuffix(field, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskAuditSnapshot 对敏感字段脱敏，maskAll 时所有字段均脱敏
+func maskAuditSnapshot(snapshot map[string]any, maskAll bool) map[string]any {
+	if snapshot == nil {
+		return nil
+	}
+	masked := make(map[string]any, len(snapshot))
+	for field, value := range snapshot {
+		if (maskAll || isSensitiveAuditField(field)) && !isEmptyAuditValue(value) {
+			masked[field] = auditMaskedValue
+		} else {
+			masked[field] = value
+		}
+	}
+	return masked
+}
+
+func isEmptyAuditValue(value any) bool {
+	return value == nil || value == ""
+}
+
+type auditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// diffAuditSnapshots 比较修改前后的顶层字段，敏感字段只标记是否变化
+func diffAuditSnapshots(before map[string]any, after map[string]any, maskAll bool) map[string]auditChange {
+	diff := make(map[string]auditChange)
+	fields := make(map[string]struct{}, len(before)+len(after))
+	for field := range before {
+		fields[field] = struct{}{}
+	}
+	for field := range after {
+		fields[field] = struct{}{}
+	}
+	for field := range fields {
+		oldValue, newValue := before[field], after[field]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		if maskAll || isSensitiveAuditField(field) {
+			if !isEmptyAuditValue(oldValue) {
+				oldValue = auditMaskedValue
+			}
+			if !isEmptyAuditValue(newValue) {
+				newValue = auditMaskedValue
+			}
+		}
+		diff[field] = auditChange{Before: oldValue, After: newValue}
+	}
+	return diff
+}
+
+func marshalAuditMap[T any](m map[string]T) string {
+	if m == nil {
+		return ""
+	}
+	data, err := common.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// parseAuditResponse 管理接口大多以 200 + success 字段返回结果
+func parseAuditResponse(statusCode int, body []byte) (bool, string) {
+	var resp struct {
+		Success *bool  `json:"success"`
+		Message string `json:"message"`
+	}
+	_ = common.Unmarshal(body, &resp)
+	success := statusCode < http.StatusBadRequest
+	if resp.Success != nil {
+		success = success && *resp.Success
+	}
+	return success, resp.Message
+}
+
+// GetAuditOptionRollbackValue 返回审计日志中选项修改前的值，用于回滚
+func GetAuditOptionRollbackValue(log *model.AuditLog) (string, error) {
+	if log.TargetType != model.AuditTargetOption || log.TargetId == "" {
+		return "", fmt.Errorf("只能回滚选项类的审计记录")
+	}
+	if IsSensitiveOptionKey(log.TargetId) {
+		return "", fmt.Errorf("敏感选项的值未记录明文，无法回滚")
+	}
+	var before map[string]any
+	if log.Before == "" || common.UnmarshalJsonStr(log.Before, &before) != nil {
+		return "", fmt.Errorf("该记录没有修改前的值")
+	}
+	value, ok := before["value"]
+	if !ok {
+		return "", fmt.Errorf("该记录没有修改前的值")
+	}
+	return common.Interface2String(value), nil
+}
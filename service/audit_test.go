@@ -0,0 +1,88 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminAuditRecordsMaskedChannelDiff(t *testing.T) {
+	truncate(t)
+	t.Cleanup(func() { model.DB.Exec("DELETE FROM audit_logs") })
+	gin.SetMode(gin.TestMode)
+
+	name := "old-name"
+	require.NoError(t, model.DB.Create(&model.Channel{Id: 5, Name: name, Key: "sk-old"}).Error)
+
+	router := gin.New()
+	router.PUT("/api/channel/", func(c *gin.Context) {
+		c.Set("id", 1)
+		c.Set("username", "root")
+		c.Set("role", common.RoleRootUser)
+		require.True(t, ShouldAuditRequest(c, common.RoleRootUser))
+		// 同一请求不重复审计
+		require.False(t, ShouldAuditRequest(c, common.RoleRootUser))
+		audit := BeginAdminAudit(c)
+		c.Next()
+		audit.Finish(c)
+	}, func(c *gin.Context) {
+		var body struct {
+			Id   int    `json:"id"`
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		}
+		// 审计读取请求体后处理函数仍能正常读取
+		data, _ := io.ReadAll(c.Request.Body)
+		require.NoError(t, common.Unmarshal(data, &body))
+		require.NoError(t, model.DB.Model(&model.Channel{}).Where("id = ?", body.Id).
+			Updates(map[string]any{"name": body.Name, "key": body.Key}).Error)
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": ""})
+	})
+
+	req := httptest.NewRequest(http.MethodPut, "/api/channel/", bytes.NewBufferString(`{"id":5,"name":"new-name","key":"sk-new"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var log model.AuditLog
+	require.Eventually(t, func() bool {
+		return model.LOG_DB.First(&log).Error == nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, model.AuditTargetChannel, log.TargetType)
+	assert.Equal(t, "5", log.TargetId)
+	assert.Equal(t, 1, log.ActorId)
+	assert.True(t, log.Success)
+	assert.Contains(t, log.Diff, `"name":{"before":"old-name","after":"new-name"}`)
+	assert.Contains(t, log.Diff, `"key":{"before":"******","after":"******"}`)
+	assert.NotContains(t, log.Before+log.After+log.Diff, "sk-old")
+	assert.NotContains(t, log.Before+log.After+log.Diff, "sk-new")
+}
+
+func TestAuditOptionRollbackValue(t *testing.T) {
+	value, err := GetAuditOptionRollbackValue(&model.AuditLog{
+		TargetType: model.AuditTargetOption,
+		TargetId:   "QuotaForNewUser",
+		Before:     `{"value":"100"}`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "100", value)
+
+	_, err = GetAuditOptionRollbackValue(&model.AuditLog{
+		TargetType: model.AuditTargetOption,
+		TargetId:   "SMTPToken",
+		Before:     `{"value":"******"}`,
+	})
+	assert.Error(t, err)
+
+	_, err = GetAuditOptionRollbackValue(&model.AuditLog{TargetType: model.AuditTargetChannel, TargetId: "1"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,638 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	batchWorkerTickInterval = 5 * time.Second
+	// batchWorkerTimeSlice 每轮在单个批处理上花费的最长时间，避免大批处理饿死其他批处理
+	batchWorkerTimeSlice = 30 * time.Second
+	// batchItemMaxAttempts 被限流（429）的请求最多重试次数，超过后按失败写入错误文件
+	batchItemMaxAttempts = 5
+	batchItemPageSize    = 500
+	batchMaxInputErrors  = 100
+	batchCleanupInterval = time.Hour
+	batchCompletionSpan  = 24 * time.Hour
+)
+
+// BatchCompletionWindow 目前唯一支持的 completion_window
+const BatchCompletionWindow = "24h"
+
+// BatchRelayHandler 由 main 包注入（gin 引擎），批处理的每一行都在进程内走完整的 relay 链路：
+// 鉴权、限流、渠道分发、计费与日志均与普通请求一致。
+var BatchRelayHandler http.Handler
+
+var batchSupportedEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+	"/v1/moderations":      true,
+}
+
+var (
+	batchWorkerOnce    sync.Once
+	batchWorkerRunning atomic.Bool
+	batchCleanupLast   atomic.Int64
+)
+
+func IsBatchEndpointSupported(endpoint string) bool {
+	return batchSupportedEndpoints[endpoint]
+}
+
+func GenerateFileId() string {
+	return "file-" + common.GetRandomString(24)
+}
+
+func GenerateBatchId() string {
+	return "batch_" + common.GetRandomString(24)
+}
+
+// NewBatch 创建一个待校验的批处理，由 worker 异步解析输入文件并执行
+func NewBatch(userId int, tokenId int, req *dto.BatchCreateRequest) (*model.Batch, error) {
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		BatchId:          GenerateBatchId(),
+		UserId:           userId,
+		TokenId:          tokenId,
+		Endpoint:         req.Endpoint,
+		InputFileId:      req.InputFileId,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		CreatedAt:        now,
+		ExpiresAt:        now + int64(batchCompletionSpan.Seconds()),
+	}
+	if len(req.Metadata) > 0 {
+		metadata, err := common.Marshal(req.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		batch.Metadata = string(metadata)
+	}
+	if err := batch.Insert(); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+func StartBatchWorker() {
+	batchWorkerOnce.Do(func() {
+		if !common.IsMasterNode || BatchRelayHandler == nil {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("batch worker started: tick=%s", batchWorkerTickInterval))
+			ticker := time.NewTicker(batchWorkerTickInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				runBatchWorkerOnce()
+			}
+		})
+	})
+}
+
+func runBatchWorkerOnce() {
+	if !batchWorkerRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer batchWorkerRunning.Store(false)
+
+	ctx := context.Background()
+	cleanupExpiredBatchFiles(ctx)
+	if !operation_setting.GetBatchSetting().Enabled {
+		return
+	}
+	batches, err := model.GetUnfinishedBatches(20)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("batch worker failed to load batches: %v", err))
+		return
+	}
+	for _, batch := range batches {
+		if err := advanceBatch(ctx, batch); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("batch %s: %v", batch.BatchId, err))
+		}
+	}
+}
+
+func advanceBatch(ctx context.Context, batch *model.Batch) error {
+	switch batch.Status {
+	case model.BatchStatusValidating:
+		return validateBatch(ctx, batch)
+	case model.BatchStatusInProgress:
+		return runBatch(ctx, batch)
+	case model.BatchStatusFinalizing:
+		return finalizeBatch(batch, model.BatchStatusFinalizing, model.BatchStatusCompleted)
+	case model.BatchStatusCancelling:
+		if batch.UpstreamBatchId != "" {
+			return pollUpstreamBatch(ctx, batch)
+		}
+		return finalizeBatch(batch, model.BatchStatusCancelling, model.BatchStatusCancelled)
+	}
+	return nil
+}
+
+// validateBatch 流式解析输入文件并分页写入 BatchItem，校验失败时批处理直接进入 failed。
+// 渠道支持原生 Batch API 时提交到上游执行，否则由进程内 worker 执行。
+func validateBatch(ctx context.Context, batch *model.Batch) error {
+	// 上次校验可能在写入后中断，先清理
+	if err := model.DeleteBatchItems(batch.BatchId); err != nil {
+		return err
+	}
+	maxLines := operation_setting.GetBatchSetting().MaxRequestsPerBatch
+	summary, err := parseBatchInput(batch.BatchId, model.OpenFileContent(batch.InputFileId), batch.Endpoint, maxLines, model.InsertBatchItems)
+	if err != nil {
+		_ = model.DeleteBatchItems(batch.BatchId)
+		return err
+	}
+	if len(summary.Errors) > 0 {
+		if err := model.DeleteBatchItems(batch.BatchId); err != nil {
+			return err
+		}
+		return failBatch(batch, summary.Errors)
+	}
+	batch.Status = model.BatchStatusInProgress
+	batch.InProgressAt = common.GetTimestamp()
+	batch.TotalCount = summary.Count
+	submitted := submitUpstreamBatch(ctx, batch, summary.Model)
+	won, err := batch.UpdateWithStatus(model.BatchStatusValidating)
+	if (err != nil || !won) && submitted {
+		// 上游信息与预扣额度未能落库，撤回上游批处理并退还预扣
+		cancelUpstreamBatch(ctx, batch)
+		refundBatchReservedQuota(ctx, batch)
+	}
+	if err != nil {
+		return err
+	}
+	if !won {
+		// 校验期间被取消，交给 cancelling 流程收尾
+		return model.DeleteBatchItems(batch.BatchId)
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("batch %s validated with %d requests", batch.BatchId, summary.Count))
+	return nil
+}
+
+// batchInputSummary 输入文件的解析结果，Model 为所有请求行共同的模型（不一致时为空）
+type batchInputSummary struct {
+	Count  int
+	Model  string
+	Errors []dto.BatchError
+}
+
+// parseBatchInput 逐行解析输入文件，校验通过的请求行按页交给 emit 写入；
+// 出现校验错误后不再写入，由调用方清理已写入的请求行
+func parseBatchInput(batchId string, reader io.Reader, endpoint string, maxLines int, emit func([]*model.BatchItem) error) (*batchInputSummary, error) {
+	summary := &batchInputSummary{Errors: make([]dto.BatchError, 0)}
+	pending := make([]*model.BatchItem, 0, batchItemPageSize)
+	customIds := make(map[string]bool)
+	models := make(map[string]bool)
+	addError := func(lineNo int, code string, message string) {
+		line := lineNo
+		summary.Errors = append(summary.Errors, dto.BatchError{Code: code, Message: message, Line: &line})
+	}
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if len(summary.Errors) == 0 && (maxLines <= 0 || summary.Count <= maxLines) {
+			if err := emit(pending); err != nil {
+				return err
+			}
+		}
+		pending = make([]*model.BatchItem, 0, batchItemPageSize)
+		return nil
+	}
+	parseLine := func(lineNo int, raw []byte) {
+		var line dto.BatchInputLine
+		if err := common.Unmarshal(raw, &line); err != nil {
+			addError(lineNo, "invalid_json_line", "This line is not parseable as valid JSON.")
+			return
+		}
+		if line.CustomId == "" {
+			addError(lineNo, "missing_required_parameter", "custom_id is required.")
+			return
+		}
+		if customIds[line.CustomId] {
+			addError(lineNo, "duplicate_custom_id", fmt.Sprintf("The custom_id %s is duplicated.", line.CustomId))
+			return
+		}
+		customIds[line.CustomId] = true
+		if line.Method != http.MethodPost {
+			addError(lineNo, "invalid_method", "Only the POST method is supported.")
+			return
+		}
+		if line.Url != endpoint {
+			addError(lineNo, "mismatched_endpoint", fmt.Sprintf("The url %s does not match the batch endpoint %s.", line.Url, endpoint))
+			return
+		}
+		body, modelName, err := normalizeBatchBody(line.Body)
+		if err != nil {
+			addError(lineNo, "invalid_request", "The body must be a JSON object.")
+			return
+		}
+		models[modelName] = true
+		pending = append(pending, &model.BatchItem{
+			BatchId:   batchId,
+			LineIndex: summary.Count,
+			CustomId:  line.CustomId,
+			Status:    model.BatchItemStatusPending,
+			Body:      body,
+		})
+		summary.Count++
+	}
+
+	buffered := bufio.NewReaderSize(reader, 64<<10)
+	for lineNo := 1; len(summary.Errors) < batchMaxInputErrors; lineNo++ {
+		raw, readErr := buffered.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, readErr
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 {
+			parseLine(lineNo, raw)
+		}
+		if len(pending) >= batchItemPageSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(summary.Errors) == 0 && summary.Count == 0 {
+		summary.Errors = append(summary.Errors, dto.BatchError{Code: "empty_file", Message: "The input file does not contain any requests."})
+	}
+	if maxLines > 0 && summary.Count > maxLines {
+		summary.Errors = append(summary.Errors, dto.BatchError{Code: "too_many_requests",
+			Message: fmt.Sprintf("The batch contains %d requests, the limit is %d.", summary.Count, maxLines)})
+	}
+	if len(models) == 1 {
+		for modelName := range models {
+			summary.Model = modelName
+		}
+	}
+	return summary, nil
+}
+
+// normalizeBatchBody 批处理结果需要完整响应，强制关闭流式输出，同时返回请求的模型
+func normalizeBatchBody(body json.RawMessage) ([]byte, string, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := common.Unmarshal(body, &fields); err != nil {
+		return nil, "", err
+	}
+	delete(fields, "stream")
+	delete(fields, "stream_options")
+	var modelName string
+	if raw, ok := fields["model"]; ok {
+		_ = common.Unmarshal(raw, &modelName)
+	}
+	normalized, err := common.Marshal(fields)
+	return normalized, modelName, err
+}
+
+func failBatch(batch *model.Batch, batchErrors []dto.BatchError) error {
+	errorsJson, err := common.Marshal(dto.BatchErrors{Object: "list", Data: batchErrors})
+	if err != nil {
+		return err
+	}
+	fromStatus := batch.Status
+	batch.Status = model.BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	batch.Errors = string(errorsJson)
+	_, err = batch.UpdateWithStatus(fromStatus)
+	return err
+}
+
+// runBatch 执行待处理的请求行，直到时间片用完、被限流或全部完成
+func runBatch(ctx context.Context, batch *model.Batch) error {
+	if batch.UpstreamBatchId != "" {
+		return pollUpstreamBatch(ctx, batch)
+	}
+	deadline := time.Now().Add(batchWorkerTimeSlice)
+	concurrency := operation_setting.GetBatchSetting().GetConcurrency()
+	for time.Now().Before(deadline) {
+		if common.GetTimestamp() > batch.ExpiresAt {
+			return expireBatch(batch)
+		}
+		items, err := model.GetPendingBatchItems(batch.BatchId, concurrency*4)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			batch.Status = model.BatchStatusFinalizing
+			batch.FinalizingAt = common.GetTimestamp()
+			won, err := batch.UpdateWithStatus(model.BatchStatusInProgress)
+			if err != nil || !won {
+				return err
+			}
+			return finalizeBatch(batch, model.BatchStatusFinalizing, model.BatchStatusCompleted)
+		}
+		throttled := executeBatchItems(ctx, batch, items, concurrency)
+
+		current, err := model.GetBatchByBatchId(batch.BatchId)
+		if err != nil {
+			return err
+		}
+		if current.Status != model.BatchStatusInProgress {
+			// 被取消，下一轮进入 cancelling 收尾
+			return nil
+		}
+		*batch = *current
+		if throttled {
+			return nil
+		}
+	}
+	return nil
+}
+
+// executeBatchItems 并发执行一组请求行，返回是否有请求被限流
+func executeBatchItems(ctx context.Context, batch *model.Batch, items []*model.BatchItem, concurrency int) bool {
+	var (
+		wg        sync.WaitGroup
+		completed atomic.Int64
+		failed    atomic.Int64
+		throttled atomic.Bool
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(item *model.BatchItem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if executeBatchItem(ctx, batch, item) {
+				throttled.Store(true)
+			}
+			if err := item.Update(); err != nil {
+				logger.LogError(ctx, fmt.Sprintf("batch %s failed to save line %d: %v", batch.BatchId, item.LineIndex, err))
+				return
+			}
+			switch item.Status {
+			case model.BatchItemStatusCompleted:
+				completed.Add(1)
+			case model.BatchItemStatusFailed:
+				failed.Add(1)
+			}
+		}(item)
+	}
+	wg.Wait()
+	if err := model.IncreaseBatchCounts(batch.BatchId, int(completed.Load()), int(failed.Load())); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("batch %s failed to update request counts: %v", batch.BatchId, err))
+	}
+	return throttled.Load()
+}
+
+// executeBatchItem 通过 BatchRelayHandler 执行单行请求并记录结果，返回 true 表示被限流、稍后重试
+func executeBatchItem(ctx context.Context, batch *model.Batch, item *model.BatchItem) bool {
+	// 令牌通过 BatchLine.TokenId 鉴权，令牌被删除或禁用时请求在鉴权阶段失败并写入错误文件
+	line := &common.BatchLine{BatchId: batch.BatchId, CustomId: item.CustomId, TokenId: batch.TokenId}
+	if item.UpstreamResponse != nil {
+		line.ChannelId = batch.UpstreamChannelId
+		line.UpstreamResponse = item.UpstreamResponse
+	}
+	lineCtx := common.WithBatchLine(ctx, line)
+	req, err := http.NewRequestWithContext(lineCtx, http.MethodPost, batch.Endpoint, bytes.NewReader(item.Body))
+	item.Attempts++
+	if err != nil {
+		setBatchItemError(item, "internal_error", err.Error())
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	BatchRelayHandler.ServeHTTP(recorder, req)
+
+	if recorder.Code == http.StatusTooManyRequests && item.Attempts < batchItemMaxAttempts {
+		return true
+	}
+	body := recorder.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = common.Marshal(string(body))
+	}
+	output := dto.BatchOutputLine{
+		Id:       "batch_req_" + common.GetRandomString(24),
+		CustomId: item.CustomId,
+		Response: &dto.BatchOutputResponse{
+			StatusCode: recorder.Code,
+			RequestId:  recorder.Header().Get(common.RequestIdKey),
+			Body:       body,
+		},
+	}
+	item.Output, _ = common.Marshal(output)
+	if recorder.Code >= http.StatusOK && recorder.Code < http.StatusMultipleChoices {
+		item.Status = model.BatchItemStatusCompleted
+	} else {
+		item.Status = model.BatchItemStatusFailed
+	}
+	return false
+}
+
+func setBatchItemError(item *model.BatchItem, code string, message string) {
+	item.Output, _ = common.Marshal(dto.BatchOutputLine{
+		Id:       "batch_req_" + common.GetRandomString(24),
+		CustomId: item.CustomId,
+		Error:    &dto.BatchOutputError{Code: code, Message: message},
+	})
+	item.Status = model.BatchItemStatusFailed
+}
+
+// expireBatch 将未执行的请求写入错误文件后结束批处理
+func expireBatch(batch *model.Batch) error {
+	for {
+		items, err := model.GetPendingBatchItems(batch.BatchId, batchItemPageSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			setBatchItemError(item, "batch_expired", "This request could not be executed before the completion window expired.")
+			if err := item.Update(); err != nil {
+				return err
+			}
+		}
+		if len(items) < batchItemPageSize {
+			break
+		}
+	}
+	return finalizeBatch(batch, model.BatchStatusInProgress, model.BatchStatusExpired)
+}
+
+// finalizeBatch 汇总请求行流式生成输出 / 错误文件，并将批处理从 fromStatus 推进到 finalStatus
+func finalizeBatch(batch *model.Batch, fromStatus string, finalStatus string) (err error) {
+	output := &batchOutputFile{batch: batch, kind: "output"}
+	errorOutput := &batchOutputFile{batch: batch, kind: "error"}
+	defer func() {
+		if err != nil {
+			output.discard()
+			errorOutput.discard()
+		}
+	}()
+	completed, failed := 0, 0
+	after := -1
+	for {
+		items, err := model.GetBatchItemsAfter(batch.BatchId, after, batchItemPageSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			after = item.LineIndex
+			switch item.Status {
+			case model.BatchItemStatusCompleted:
+				if err := output.writeLine(item.Output); err != nil {
+					return err
+				}
+				completed++
+			case model.BatchItemStatusFailed:
+				if err := errorOutput.writeLine(item.Output); err != nil {
+					return err
+				}
+				failed++
+			}
+		}
+		if len(items) < batchItemPageSize {
+			break
+		}
+	}
+
+	now := common.GetTimestamp()
+	if batch.OutputFileId, err = output.save(now); err != nil {
+		return err
+	}
+	if batch.ErrorFileId, err = errorOutput.save(now); err != nil {
+		return err
+	}
+	batch.CompletedCount = completed
+	batch.FailedCount = failed
+	batch.Status = finalStatus
+	switch finalStatus {
+	case model.BatchStatusCompleted:
+		batch.CompletedAt = now
+	case model.BatchStatusExpired:
+		batch.ExpiredAt = now
+	case model.BatchStatusCancelled:
+		batch.CancelledAt = now
+	}
+	won, err := batch.UpdateWithStatus(fromStatus)
+	if err != nil {
+		return err
+	}
+	if !won {
+		output.discard()
+		errorOutput.discard()
+		return nil
+	}
+	return model.DeleteBatchItems(batch.BatchId)
+}
+
+// batchOutputFile 流式写入批处理的输出 / 错误文件，首次写入时才创建
+type batchOutputFile struct {
+	batch  *model.Batch
+	kind   string
+	fileId string
+	writer *model.FileContentWriter
+}
+
+func (f *batchOutputFile) writeLine(line []byte) error {
+	if f.writer == nil {
+		f.fileId = GenerateFileId()
+		f.writer = model.NewFileContentWriter(f.fileId)
+	}
+	if _, err := f.writer.Write(line); err != nil {
+		return err
+	}
+	_, err := f.writer.Write([]byte{'\n'})
+	return err
+}
+
+// save 写入最后一块并创建文件记录，没有内容时返回空的文件 ID
+func (f *batchOutputFile) save(now int64) (string, error) {
+	if f.writer == nil {
+		return "", nil
+	}
+	if err := f.writer.Close(); err != nil {
+		return "", err
+	}
+	file := &model.File{
+		FileId:    f.fileId,
+		UserId:    f.batch.UserId,
+		Purpose:   model.FilePurposeBatchOutput,
+		Filename:  fmt.Sprintf("%s_%s.jsonl", f.batch.BatchId, f.kind),
+		Bytes:     f.writer.Written(),
+		CreatedAt: now,
+	}
+	if err := file.Insert(); err != nil {
+		return "", err
+	}
+	return f.fileId, nil
+}
+
+func (f *batchOutputFile) discard() {
+	if f.writer == nil {
+		return
+	}
+	_ = model.DeleteFileChunks(f.fileId)
+	_, _ = model.DeleteUserFile(f.batch.UserId, f.fileId)
+}
+
+func appendBatchInfo(ctx *gin.Context, other map[string]interface{}) {
+	if ctx == nil || other == nil {
+		return
+	}
+	batchId := common.GetContextKeyString(ctx, constant.ContextKeyBatchId)
+	if batchId == "" {
+		return
+	}
+	other["batch_id"] = batchId
+	other["batch_discount"] = operation_setting.GetBatchSetting().GetDiscountRatio()
+	if ctx.Request == nil {
+		return
+	}
+	if line := common.GetBatchLine(ctx.Request.Context()); line != nil && line.UpstreamResponse != nil {
+		other["batch_upstream"] = true
+	}
+}
+
+func cleanupExpiredBatchFiles(ctx context.Context) {
+	retentionDays := operation_setting.GetBatchSetting().FileRetentionDays
+	if retentionDays <= 0 {
+		return
+	}
+	lastCleanup := time.Unix(batchCleanupLast.Load(), 0)
+	if time.Since(lastCleanup) < batchCleanupInterval {
+		return
+	}
+	batchCleanupLast.Store(time.Now().Unix())
+	before := common.GetTimestamp() - int64(retentionDays)*24*3600
+	deleted, err := model.DeleteExpiredFilesAndBatches(before)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("batch file cleanup failed: %v", err))
+		return
+	}
+	if deleted > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("batch file cleanup removed %d records", deleted))
+	}
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchInputLine(customId string, url string, body string) string {
+	return fmt.Sprintf(`{"custom_id":%q,"method":"POST","url":%q,"body":%s}`, customId, url, body)
+}
+
+func seedBatch(t *testing.T, lines ...string) *model.Batch {
+	t.Helper()
+	t.Cleanup(func() {
+		model.DB.Exec("DELETE FROM files")
+		model.DB.Exec("DELETE FROM file_chunks")
+		model.DB.Exec("DELETE FROM batches")
+		model.DB.Exec("DELETE FROM batch_items")
+	})
+	input := &model.File{
+		FileId:    GenerateFileId(),
+		UserId:    1,
+		Purpose:   model.FilePurposeBatch,
+		CreatedAt: common.GetTimestamp(),
+	}
+	bytesWritten, err := model.SaveFileContent(input.FileId, strings.NewReader(strings.Join(lines, "\n")))
+	require.NoError(t, err)
+	input.Bytes = bytesWritten
+	require.NoError(t, input.Insert())
+	batch, err := NewBatch(1, 0, &dto.BatchCreateRequest{
+		InputFileId:      input.FileId,
+		Endpoint:         "/v1/chat/completions",
+		CompletionWindow: BatchCompletionWindow,
+	})
+	require.NoError(t, err)
+	return batch
+}
+
+func reloadBatch(t *testing.T, batchId string) *model.Batch {
+	t.Helper()
+	batch, err := model.GetBatchByBatchId(batchId)
+	require.NoError(t, err)
+	return batch
+}
+
+func collectBatchInput(t *testing.T, content string) ([]*model.BatchItem, *batchInputSummary) {
+	t.Helper()
+	var items []*model.BatchItem
+	summary, err := parseBatchInput("batch_x", strings.NewReader(content), "/v1/chat/completions", 0, func(page []*model.BatchItem) error {
+		items = append(items, page...)
+		return nil
+	})
+	require.NoError(t, err)
+	return items, summary
+}
+
+func TestParseBatchInput(t *testing.T) {
+	items, summary := collectBatchInput(t, strings.Join([]string{
+		batchInputLine("a", "/v1/chat/completions", `{"model":"m","stream":true}`),
+		batchInputLine("b", "/v1/chat/completions", `{"model":"m"}`),
+	}, "\n"))
+
+	require.Empty(t, summary.Errors)
+	require.Len(t, items, 2)
+	assert.Equal(t, 2, summary.Count)
+	assert.Equal(t, "m", summary.Model)
+	assert.NotContains(t, string(items[0].Body), "stream", "streaming is disabled for batch lines")
+
+	_, summary = collectBatchInput(t, strings.Join([]string{
+		batchInputLine("a", "/v1/chat/completions", `{"model":"m"}`),
+		batchInputLine("b", "/v1/chat/completions", `{"model":"n"}`),
+	}, "\n"))
+	assert.Empty(t, summary.Model, "mixed models cannot be passed through upstream")
+}
+
+func TestParseBatchInput_Errors(t *testing.T) {
+	items, summary := collectBatchInput(t, strings.Join([]string{
+		batchInputLine("a", "/v1/chat/completions", `{"model":"m"}`),
+		`not json`,
+		batchInputLine("a", "/v1/chat/completions", `{}`),
+		batchInputLine("b", "/v1/embeddings", `{}`),
+	}, "\n"))
+
+	assert.Empty(t, items, "invalid input files do not emit any items")
+	inputErrors := summary.Errors
+	require.Len(t, inputErrors, 3)
+	assert.Equal(t, "invalid_json_line", inputErrors[0].Code)
+	assert.Equal(t, 2, *inputErrors[0].Line)
+	assert.Equal(t, "duplicate_custom_id", inputErrors[1].Code)
+	assert.Equal(t, "mismatched_endpoint", inputErrors[2].Code)
+}
+
+func TestBatchLifecycle(t *testing.T) {
+	var mu sync.Mutex
+	var seenBatchIds []string
+	BatchRelayHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if line := common.GetBatchLine(r.Context()); line != nil {
+			mu.Lock()
+			seenBatchIds = append(seenBatchIds, line.BatchId)
+			mu.Unlock()
+		}
+		var body bytes.Buffer
+		_, _ = body.ReadFrom(r.Body)
+		if strings.Contains(body.String(), "bad") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"bad request"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"chatcmpl-1"}`))
+	})
+	t.Cleanup(func() { BatchRelayHandler = nil })
+
+	batch := seedBatch(t,
+		batchInputLine("ok-1", "/v1/chat/completions", `{"model":"good"}`),
+		batchInputLine("bad-1", "/v1/chat/completions", `{"model":"bad"}`),
+		batchInputLine("ok-2", "/v1/chat/completions", `{"model":"good"}`),
+	)
+
+	require.NoError(t, advanceBatch(t.Context(), batch))
+	batch = reloadBatch(t, batch.BatchId)
+	require.Equal(t, model.BatchStatusInProgress, batch.Status)
+	require.Equal(t, 3, batch.TotalCount)
+
+	require.NoError(t, advanceBatch(t.Context(), batch))
+	batch = reloadBatch(t, batch.BatchId)
+	require.Equal(t, model.BatchStatusCompleted, batch.Status)
+	assert.Equal(t, 2, batch.CompletedCount)
+	assert.Equal(t, 1, batch.FailedCount)
+	assert.Len(t, seenBatchIds, 3)
+	assert.Equal(t, batch.BatchId, seenBatchIds[0])
+
+	output, err := io.ReadAll(model.OpenFileContent(batch.OutputFileId))
+	require.NoError(t, err)
+	outputLines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	require.Len(t, outputLines, 2)
+	var first dto.BatchOutputLine
+	require.NoError(t, common.Unmarshal([]byte(outputLines[0]), &first))
+	assert.Equal(t, "ok-1", first.CustomId)
+	assert.Equal(t, http.StatusOK, first.Response.StatusCode)
+
+	errorOutput, err := io.ReadAll(model.OpenFileContent(batch.ErrorFileId))
+	require.NoError(t, err)
+	assert.Contains(t, string(errorOutput), `"custom_id":"bad-1"`)
+
+	pending, err := model.GetPendingBatchItems(batch.BatchId, 10)
+	require.NoError(t, err)
+	assert.Empty(t, pending, "batch items are removed after finalizing")
+}
+
+func TestBatchCancelBeforeRun(t *testing.T) {
+	batch := seedBatch(t, batchInputLine("a", "/v1/chat/completions", `{"model":"m"}`))
+	require.NoError(t, advanceBatch(t.Context(), batch))
+
+	cancelled, err := model.CancelUserBatch(1, batch.BatchId)
+	require.NoError(t, err)
+	require.Equal(t, model.BatchStatusCancelling, cancelled.Status)
+
+	require.NoError(t, advanceBatch(t.Context(), cancelled))
+	batch = reloadBatch(t, batch.BatchId)
+	assert.Equal(t, model.BatchStatusCancelled, batch.Status)
+	assert.Empty(t, batch.OutputFileId)
+	assert.NotZero(t, batch.CancelledAt)
+}
+
+func TestUpstreamBatchSettlement(t *testing.T) {
+	var mu sync.Mutex
+	replayed := make(map[string]string)
+	BatchRelayHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		line := common.GetBatchLine(r.Context())
+		require.NotNil(t, line)
+		mu.Lock()
+		replayed[line.CustomId] = string(line.UpstreamResponse)
+		mu.Unlock()
+		assert.NotZero(t, line.ChannelId, "settlement must stay on the upstream channel")
+		_, _ = w.Write(line.UpstreamResponse)
+	})
+	t.Cleanup(func() { BatchRelayHandler = nil })
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/batches/batch_up":
+			_, _ = w.Write([]byte(`{"id":"batch_up","status":"completed","output_file_id":"file_out","error_file_id":"file_err"}`))
+		case "/v1/files/file_out/content":
+			_, _ = w.Write([]byte(`{"custom_id":"ok","response":{"status_code":200,"body":{"id":"chatcmpl-up","usage":{"prompt_tokens":3,"completion_tokens":4}}}}` + "\n"))
+		case "/v1/files/file_err/content":
+			_, _ = w.Write([]byte(`{"custom_id":"bad","response":{"status_code":400,"body":{"error":{"message":"bad"}}}}` + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	if GetHttpClient() == nil {
+		InitHttpClient()
+	}
+
+	channel := &model.Channel{Type: 1, Key: "sk-upstream", Status: common.ChannelStatusEnabled, Name: "native", BaseURL: &upstream.URL}
+	require.NoError(t, model.DB.Create(channel).Error)
+	t.Cleanup(func() { model.DB.Exec("DELETE FROM channels") })
+
+	batch := seedBatch(t,
+		batchInputLine("ok", "/v1/chat/completions", `{"model":"m"}`),
+		batchInputLine("bad", "/v1/chat/completions", `{"model":"m"}`),
+		batchInputLine("lost", "/v1/chat/completions", `{"model":"m"}`),
+	)
+	require.NoError(t, advanceBatch(t.Context(), batch))
+	batch = reloadBatch(t, batch.BatchId)
+	require.Equal(t, model.BatchStatusInProgress, batch.Status)
+	require.NoError(t, model.DB.Model(&model.Batch{}).Where("batch_id = ?", batch.BatchId).Updates(map[string]any{
+		"upstream_channel_id": channel.Id,
+		"upstream_batch_id":   "batch_up",
+	}).Error)
+
+	require.NoError(t, advanceBatch(t.Context(), reloadBatch(t, batch.BatchId)))
+	batch = reloadBatch(t, batch.BatchId)
+	require.Equal(t, model.BatchStatusCompleted, batch.Status)
+	assert.Equal(t, 1, batch.CompletedCount)
+	assert.Equal(t, 2, batch.FailedCount)
+	require.Len(t, replayed, 1, "only successful upstream lines are replayed for billing")
+	assert.Contains(t, replayed["ok"], "chatcmpl-up")
+
+	errorOutput, err := io.ReadAll(model.OpenFileContent(batch.ErrorFileId))
+	require.NoError(t, err)
+	assert.Contains(t, string(errorOutput), `"custom_id":"bad"`)
+	assert.Contains(t, string(errorOutput), `"custom_id":"lost"`)
+}
+
+func TestUpstreamBatchQuotaReservation(t *testing.T) {
+	truncate(t)
+	originalPrices, err := common.Marshal(ratio_setting.GetModelPriceCopy())
+	require.NoError(t, err)
+	require.NoError(t, ratio_setting.UpdateModelPriceByJSONString(`{"batch-priced":0.01}`))
+	t.Cleanup(func() { _ = ratio_setting.UpdateModelPriceByJSONString(string(originalPrices)) })
+
+	seedUser(t, 1, 1_000_000)
+	seedToken(t, 1, 1, "batch-token-key", 1_000_000)
+	require.NoError(t, model.DB.Model(&model.User{}).Where("id = ?", 1).Update("group", "default").Error)
+
+	batch := seedBatch(t,
+		batchInputLine("a", "/v1/chat/completions", `{"model":"batch-priced"}`),
+		batchInputLine("b", "/v1/chat/completions", `{"model":"batch-priced"}`),
+	)
+	require.NoError(t, advanceBatch(t.Context(), batch))
+	batch = reloadBatch(t, batch.BatchId)
+	batch.TokenId = 1
+
+	require.NoError(t, reserveBatchUpstreamQuota(t.Context(), batch, "batch-priced"))
+	require.Positive(t, batch.ReservedQuota)
+	assert.Equal(t, BillingSourceWallet, batch.BillingSource)
+	assert.Equal(t, 1_000_000-batch.ReservedQuota, getUserQuota(t, 1))
+	assert.Equal(t, 1_000_000-batch.ReservedQuota, getTokenRemainQuota(t, 1))
+
+	_, err = batch.UpdateWithStatus(model.BatchStatusInProgress)
+	require.NoError(t, err)
+	stale := *batch
+	require.NoError(t, releaseBatchReservedQuota(t.Context(), batch))
+	require.NoError(t, releaseBatchReservedQuota(t.Context(), &stale), "a second release must not refund twice")
+	assert.Zero(t, reloadBatch(t, batch.BatchId).ReservedQuota)
+	assert.Equal(t, 1_000_000, getUserQuota(t, 1))
+	assert.Equal(t, 1_000_000, getTokenRemainQuota(t, 1))
+
+	// 额度不足时不提交上游，由进程内 worker 逐行计费
+	require.NoError(t, model.DB.Model(&model.User{}).Where("id = ?", 1).Update("quota", 0).Error)
+	require.Error(t, reserveBatchUpstreamQuota(t.Context(), batch, "batch-priced"))
+	assert.Zero(t, batch.ReservedQuota)
+	assert.Equal(t, 1_000_000, getTokenRemainQuota(t, 1))
+}
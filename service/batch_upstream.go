@@ -0,0 +1,557 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/billing_setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 上游原生 Batch API：批处理的模型选中的渠道为 OpenAI 类型且开启了 native_batch 时，
+// 整个输入文件提交到上游批处理执行。提交前按估算额度整体预扣，上游完成后退还预扣，
+// 再逐行把上游响应交给进程内 relay 链路结算，鉴权、预扣、计费与日志与进程内执行一致，只是不再请求上游。
+
+const (
+	// batchUpstreamPollInterval 查询上游批处理状态的最小间隔
+	batchUpstreamPollInterval = 30 * time.Second
+	// batchUpstreamGrace 超过完成窗口后仍无法从上游取得结果时，按过期处理
+	batchUpstreamGrace = time.Hour
+)
+
+var batchUpstreamLastPoll sync.Map // map[string]time.Time
+
+// selectBatchUpstreamChannel 返回可原生执行该批处理的渠道，不满足条件时返回 nil 由进程内 worker 执行
+func selectBatchUpstreamChannel(batch *model.Batch, modelName string) *model.Channel {
+	if modelName == "" {
+		return nil
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		return nil
+	}
+	// 结算请求固定使用选中的渠道，分发时不再检查令牌的模型限制，这里提前检查
+	if token.ModelLimitsEnabled {
+		if _, ok := token.GetModelLimitsMap()[ratio_setting.FormatMatchingModelName(modelName)]; !ok {
+			return nil
+		}
+	}
+	group := token.Group
+	if group == "" {
+		group, err = model.GetUserGroup(batch.UserId, false)
+		if err != nil {
+			return nil
+		}
+	}
+	if group == "auto" {
+		return nil
+	}
+	channel, err := model.GetRandomSatisfiedChannel(group, modelName, 0)
+	if err != nil || channel == nil {
+		return nil
+	}
+	if channel.Type != constant.ChannelTypeOpenAI || !channel.GetSetting().NativeBatch {
+		return nil
+	}
+	// 上游直接执行原始请求体，渠道的模型映射与参数覆盖无法生效，此类渠道仍由进程内执行
+	if mapping := channel.GetModelMapping(); mapping != "" && mapping != "{}" {
+		modelMapping := make(map[string]string)
+		if err := common.UnmarshalJsonStr(mapping, &modelMapping); err != nil || modelMapping[modelName] != "" {
+			return nil
+		}
+	}
+	if len(channel.GetParamOverride()) > 0 {
+		return nil
+	}
+	return channel
+}
+
+func batchUpstreamKey(channel *model.Channel, keyIndex int) string {
+	if !channel.ChannelInfo.IsMultiKey {
+		return channel.Key
+	}
+	keys := channel.GetKeys()
+	if keyIndex < 0 || keyIndex >= len(keys) {
+		return ""
+	}
+	return keys[keyIndex]
+}
+
+func doBatchUpstreamRequest(ctx context.Context, channel *model.Channel, keyIndex int, method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(channel.GetBaseURL(), "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+batchUpstreamKey(channel, keyIndex))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client, err := GetHttpClientWithProxy(channel.GetSetting().Proxy)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("upstream %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+func doBatchUpstreamJSON(ctx context.Context, channel *model.Channel, keyIndex int, method string, path string, payload any, result any) error {
+	var body io.Reader
+	contentType := ""
+	if payload != nil {
+		data, err := common.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	resp, err := doBatchUpstreamRequest(ctx, channel, keyIndex, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+	return common.DecodeJson(resp.Body, result)
+}
+
+// uploadBatchUpstreamInput 将请求行流式写成上游输入文件并上传，返回上游文件 ID
+func uploadBatchUpstreamInput(ctx context.Context, channel *model.Channel, keyIndex int, batch *model.Batch) (string, error) {
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		writer.CloseWithError(writeBatchUpstreamInput(form, batch))
+	}()
+	resp, err := doBatchUpstreamRequest(ctx, channel, keyIndex, http.MethodPost, "/v1/files", form.FormDataContentType(), reader)
+	_ = reader.Close()
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var file dto.OpenAIFile
+	if err := common.DecodeJson(resp.Body, &file); err != nil {
+		return "", err
+	}
+	if file.Id == "" {
+		return "", errors.New("upstream file id is empty")
+	}
+	return file.Id, nil
+}
+
+func writeBatchUpstreamInput(form *multipart.Writer, batch *model.Batch) error {
+	if err := form.WriteField("purpose", model.FilePurposeBatch); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("file", batch.BatchId+".jsonl")
+	if err != nil {
+		return err
+	}
+	after := -1
+	for {
+		items, err := model.GetBatchItemBodiesAfter(batch.BatchId, after, batchItemPageSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			after = item.LineIndex
+			line, err := common.Marshal(dto.BatchInputLine{
+				CustomId: item.CustomId,
+				Method:   http.MethodPost,
+				Url:      batch.Endpoint,
+				Body:     item.Body,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := part.Write(append(line, '\n')); err != nil {
+				return err
+			}
+		}
+		if len(items) < batchItemPageSize {
+			break
+		}
+	}
+	return form.Close()
+}
+
+// submitUpstreamBatch 尝试将已校验的批处理提交到上游原生执行，成功时记录上游信息并返回 true。
+// 提交失败不影响批处理，继续由进程内 worker 执行。
+func submitUpstreamBatch(ctx context.Context, batch *model.Batch, modelName string) bool {
+	channel := selectBatchUpstreamChannel(batch, modelName)
+	if channel == nil {
+		return false
+	}
+	_, keyIndex, apiErr := channel.GetNextEnabledKey()
+	if apiErr != nil {
+		return false
+	}
+	// 上游执行期间无法逐行预扣，预扣失败时由进程内 worker 逐行计费
+	if err := reserveBatchUpstreamQuota(ctx, batch, modelName); err != nil {
+		logger.LogInfo(ctx, fmt.Sprintf("batch %s: quota reservation failed, running in-process: %v", batch.BatchId, err))
+		return false
+	}
+	fileId, err := uploadBatchUpstreamInput(ctx, channel, keyIndex, batch)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("batch %s: upload to channel #%d failed, running in-process: %v", batch.BatchId, channel.Id, err))
+		refundBatchReservedQuota(ctx, batch)
+		return false
+	}
+	var upstream dto.OpenAIBatch
+	err = doBatchUpstreamJSON(ctx, channel, keyIndex, http.MethodPost, "/v1/batches", map[string]any{
+		"input_file_id":     fileId,
+		"endpoint":          batch.Endpoint,
+		"completion_window": BatchCompletionWindow,
+	}, &upstream)
+	if err != nil || upstream.Id == "" {
+		logger.LogWarn(ctx, fmt.Sprintf("batch %s: create on channel #%d failed, running in-process: %v", batch.BatchId, channel.Id, err))
+		refundBatchReservedQuota(ctx, batch)
+		return false
+	}
+	batch.UpstreamChannelId = channel.Id
+	batch.UpstreamKeyIndex = keyIndex
+	batch.UpstreamBatchId = upstream.Id
+	logger.LogInfo(ctx, fmt.Sprintf("batch %s submitted to channel #%d as %s", batch.BatchId, channel.Id, upstream.Id))
+	return true
+}
+
+// reserveBatchUpstreamQuota 按估算额度通过 BillingSession 一次性预扣用户与令牌额度，
+// 预扣结果与资金来源记录在批处理上，由 releaseBatchReservedQuota 退还
+func reserveBatchUpstreamQuota(ctx context.Context, batch *model.Batch, modelName string) error {
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		return err
+	}
+	if token.Status != common.TokenStatusEnabled || (token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp()) {
+		return errors.New("token is not available")
+	}
+	user, err := model.GetUserCache(batch.UserId)
+	if err != nil {
+		return err
+	}
+	if user.Status != common.UserStatusEnabled {
+		return errors.New("user is disabled")
+	}
+	usingGroup := token.Group
+	if usingGroup == "" {
+		usingGroup = user.Group
+	}
+	quota, err := estimateBatchUpstreamQuota(batch, modelName, user.Group, usingGroup, user.GetSetting())
+	if err != nil {
+		return err
+	}
+	// 周期预算仍在逐行结算时检查，这里不设置预算窗口
+	relayInfo := &relaycommon.RelayInfo{
+		TokenId:         token.Id,
+		TokenKey:        token.Key,
+		TokenUnlimited:  token.UnlimitedQuota,
+		OrgId:           token.OrgId,
+		UserId:          batch.UserId,
+		UserGroup:       user.Group,
+		UsingGroup:      usingGroup,
+		UserSetting:     user.GetSetting(),
+		UserCreditLimit: user.CreditLimit,
+		OriginModelName: modelName,
+		RequestId:       batch.BatchId,
+		ForcePreConsume: true,
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, batch.Endpoint, nil).WithContext(ctx)
+	session, apiErr := NewBillingSession(c, relayInfo, quota)
+	if apiErr != nil {
+		return apiErr
+	}
+	batch.ReservedQuota = session.GetPreConsumedQuota()
+	batch.BillingSource = relayInfo.BillingSource
+	batch.SubscriptionId = relayInfo.SubscriptionId
+	batch.OrgId = relayInfo.OrgId
+	return nil
+}
+
+// estimateBatchUpstreamQuota 按 relay 预扣的规则估算整个批处理的额度：按次计费的模型每行按模型价格，
+// 其余按请求体估算的输入 token 加上最大输出 token 乘以模型倍率
+func estimateBatchUpstreamQuota(batch *model.Batch, modelName string, userGroup string, usingGroup string, userSetting dto.UserSetting) (int, error) {
+	if billing_setting.GetBillingMode(modelName) == billing_setting.BillingModeTieredExpr {
+		return 0, errors.New("tiered billing is not supported by native batches")
+	}
+	groupRatio, ok := ratio_setting.GetGroupGroupRatio(userGroup, usingGroup)
+	if !ok {
+		groupRatio = ratio_setting.GetGroupRatio(usingGroup)
+	}
+	groupRatio *= operation_setting.GetBatchSetting().GetDiscountRatio()
+	modelPrice, usePrice := ratio_setting.GetModelPrice(modelName, false)
+	modelRatio, success, _ := ratio_setting.GetModelRatio(modelName)
+	if !usePrice && !success && !userSetting.AcceptUnsetRatioModel {
+		return 0, fmt.Errorf("model %s has no price or ratio configured", modelName)
+	}
+	quota := 0.0
+	after := -1
+	for {
+		items, err := model.GetBatchItemBodiesAfter(batch.BatchId, after, batchItemPageSize)
+		if err != nil {
+			return 0, err
+		}
+		for _, item := range items {
+			after = item.LineIndex
+			if usePrice {
+				quota += modelPrice * common.QuotaPerUnit * groupRatio
+				continue
+			}
+			var limits struct {
+				MaxTokens           int `json:"max_tokens"`
+				MaxCompletionTokens int `json:"max_completion_tokens"`
+				MaxOutputTokens     int `json:"max_output_tokens"`
+			}
+			_ = common.Unmarshal(item.Body, &limits)
+			tokens := max(EstimateTokenByModel(modelName, string(item.Body)), common.PreConsumedQuota)
+			tokens += max(limits.MaxTokens, limits.MaxCompletionTokens, limits.MaxOutputTokens)
+			quota += float64(tokens) * modelRatio * groupRatio
+		}
+		if len(items) < batchItemPageSize {
+			break
+		}
+	}
+	return int(quota), nil
+}
+
+// releaseBatchReservedQuota 退还已落库的预扣额度，并发调用时只有清零预扣记录的一方执行退还
+func releaseBatchReservedQuota(ctx context.Context, batch *model.Batch) error {
+	if batch.ReservedQuota == 0 {
+		return nil
+	}
+	released, err := model.ClearBatchReservedQuota(batch.BatchId, batch.ReservedQuota)
+	if err != nil {
+		return err
+	}
+	if released {
+		refundBatchReservedQuota(ctx, batch)
+	}
+	batch.ReservedQuota = 0
+	return nil
+}
+
+// refundBatchReservedQuota 将预扣额度退还到资金来源与令牌
+func refundBatchReservedQuota(ctx context.Context, batch *model.Batch) {
+	quota := batch.ReservedQuota
+	if quota == 0 {
+		return
+	}
+	batch.ReservedQuota = 0
+	if err := adjustFundingQuota(batch.BillingSource, batch.UserId, batch.SubscriptionId, batch.OrgId, -quota); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("batch %s: refund reserved quota %d failed: %v", batch.BatchId, quota, err))
+		return
+	}
+	tokenKey := resolveTokenKey(ctx, batch.TokenId, batch.BatchId)
+	if tokenKey == "" {
+		return
+	}
+	if err := model.IncreaseTokenQuota(batch.TokenId, tokenKey, quota); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("batch %s: refund reserved token quota %d failed: %v", batch.BatchId, quota, err))
+	}
+}
+
+func cancelUpstreamBatch(ctx context.Context, batch *model.Batch) {
+	channel, err := model.GetChannelById(batch.UpstreamChannelId, true)
+	if err != nil {
+		return
+	}
+	path := "/v1/batches/" + batch.UpstreamBatchId + "/cancel"
+	if err := doBatchUpstreamJSON(ctx, channel, batch.UpstreamKeyIndex, http.MethodPost, path, nil, nil); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("batch %s: cancel upstream %s failed: %v", batch.BatchId, batch.UpstreamBatchId, err))
+	}
+}
+
+// pollUpstreamBatch 推进上游原生执行的批处理：同步进度、转发取消，上游结束后结算结果
+func pollUpstreamBatch(ctx context.Context, batch *model.Batch) error {
+	if v, ok := batchUpstreamLastPoll.Load(batch.BatchId); ok && time.Since(v.(time.Time)) < batchUpstreamPollInterval {
+		return nil
+	}
+	batchUpstreamLastPoll.Store(batch.BatchId, time.Now())
+
+	channel, err := model.GetChannelById(batch.UpstreamChannelId, true)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return expireUpstreamBatch(ctx, batch)
+	}
+	if err != nil {
+		return err
+	}
+	var upstream dto.OpenAIBatch
+	err = doBatchUpstreamJSON(ctx, channel, batch.UpstreamKeyIndex, http.MethodGet, "/v1/batches/"+batch.UpstreamBatchId, nil, &upstream)
+	if err != nil {
+		if common.GetTimestamp() > batch.ExpiresAt+int64(batchUpstreamGrace.Seconds()) {
+			return expireUpstreamBatch(ctx, batch)
+		}
+		return err
+	}
+
+	switch upstream.Status {
+	case model.BatchStatusValidating, model.BatchStatusInProgress, model.BatchStatusFinalizing, model.BatchStatusCancelling:
+		if batch.Status == model.BatchStatusCancelling && upstream.Status != model.BatchStatusCancelling {
+			cancelUpstreamBatch(ctx, batch)
+		}
+		return model.UpdateBatchCounts(batch.BatchId, upstream.RequestCounts.Completed, upstream.RequestCounts.Failed)
+	case model.BatchStatusFailed:
+		if batch.Status == model.BatchStatusInProgress {
+			// 上游拒绝了该批处理（例如不支持该模型），改由进程内 worker 执行
+			logger.LogWarn(ctx, fmt.Sprintf("batch %s: upstream %s failed, running in-process", batch.BatchId, batch.UpstreamBatchId))
+			if err := releaseBatchReservedQuota(ctx, batch); err != nil {
+				return err
+			}
+			batch.UpstreamChannelId = 0
+			batch.UpstreamBatchId = ""
+			_, err := batch.UpdateWithStatus(model.BatchStatusInProgress)
+			return err
+		}
+	}
+
+	// 上游已结束，退还预扣后逐行按实际用量结算
+	if err := releaseBatchReservedQuota(ctx, batch); err != nil {
+		return err
+	}
+	settled, err := settleUpstreamBatch(ctx, batch, channel, &upstream)
+	if err != nil || !settled {
+		return err
+	}
+	batchUpstreamLastPoll.Delete(batch.BatchId)
+	finalStatus := model.BatchStatusCompleted
+	switch {
+	case batch.Status == model.BatchStatusCancelling:
+		finalStatus = model.BatchStatusCancelled
+	case upstream.Status == model.BatchStatusExpired:
+		finalStatus = model.BatchStatusExpired
+	}
+	return finalizeBatch(batch, batch.Status, finalStatus)
+}
+
+// settleUpstreamBatch 读取上游的输出与错误文件：成功的请求行经 relay 链路结算，失败的直接写入错误文件，
+// 上游没有结果的请求行按未执行处理。有请求行被限流时返回 false，下一轮继续结算剩余行。
+func settleUpstreamBatch(ctx context.Context, batch *model.Batch, channel *model.Channel, upstream *dto.OpenAIBatch) (bool, error) {
+	throttled := false
+	for _, fileId := range []*string{upstream.OutputFileId, upstream.ErrorFileId} {
+		if fileId == nil || *fileId == "" {
+			continue
+		}
+		resp, err := doBatchUpstreamRequest(ctx, channel, batch.UpstreamKeyIndex, http.MethodGet, "/v1/files/"+*fileId+"/content", "", nil)
+		if err != nil {
+			return false, err
+		}
+		fileThrottled, err := settleUpstreamBatchFile(ctx, batch, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return false, err
+		}
+		throttled = throttled || fileThrottled
+	}
+	if throttled {
+		return false, nil
+	}
+	code, message := "batch_expired", "This request could not be executed before the completion window expired."
+	if batch.Status == model.BatchStatusCancelling {
+		code, message = "batch_cancelled", "This request was not executed because the batch was cancelled."
+	}
+	for {
+		items, err := model.GetPendingBatchItems(batch.BatchId, batchItemPageSize)
+		if err != nil {
+			return false, err
+		}
+		for _, item := range items {
+			setBatchItemError(item, code, message)
+			if err := item.Update(); err != nil {
+				return false, err
+			}
+		}
+		if len(items) < batchItemPageSize {
+			return true, nil
+		}
+	}
+}
+
+func settleUpstreamBatchFile(ctx context.Context, batch *model.Batch, body io.Reader) (bool, error) {
+	concurrency := operation_setting.GetBatchSetting().GetConcurrency()
+	throttled := false
+	page := make([]*model.BatchItem, 0, batchItemPageSize)
+	settlePage := func() {
+		if len(page) > 0 && executeBatchItems(ctx, batch, page, concurrency) {
+			throttled = true
+		}
+		page = page[:0]
+	}
+	buffered := bufio.NewReaderSize(body, 64<<10)
+	for {
+		raw, readErr := buffered.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return false, readErr
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 {
+			var line dto.BatchOutputLine
+			if err := common.Unmarshal(raw, &line); err != nil {
+				return false, fmt.Errorf("invalid upstream output line: %w", err)
+			}
+			item, err := model.GetBatchItemByCustomId(batch.BatchId, line.CustomId)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return false, err
+			}
+			if err == nil && item.Status == model.BatchItemStatusPending {
+				if line.Response != nil && line.Response.StatusCode >= http.StatusOK && line.Response.StatusCode < http.StatusMultipleChoices {
+					item.UpstreamResponse = line.Response.Body
+					page = append(page, item)
+				} else {
+					item.Attempts++
+					item.Output = raw
+					item.Status = model.BatchItemStatusFailed
+					if err := item.Update(); err != nil {
+						return false, err
+					}
+				}
+			}
+		}
+		if len(page) >= concurrency*4 {
+			settlePage()
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	settlePage()
+	return throttled, nil
+}
+
+// expireUpstreamBatch 渠道已删除或长期无法访问时，未结算的请求行按过期写入错误文件
+func expireUpstreamBatch(ctx context.Context, batch *model.Batch) error {
+	batchUpstreamLastPoll.Delete(batch.BatchId)
+	if err := releaseBatchReservedQuota(ctx, batch); err != nil {
+		return err
+	}
+	if batch.Status == model.BatchStatusCancelling {
+		return finalizeBatch(batch, model.BatchStatusCancelling, model.BatchStatusCancelled)
+	}
+	return expireBatch(batch)
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -9,6 +10,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/metrics"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/types"
 
@@ -33,6 +35,8 @@ type BillingSession struct {
 	settled          bool // Settle 全部完成（资金 + 令牌）
 	refunded         bool // Refund 已调用
 	mu               sync.Mutex
+
+	budgets []*budgetReservation // 令牌与用户周期预算的占用
 }
 
 // Settle 根据实际消耗额度进行结算。
@@ -55,6 +59,8 @@ func (s *BillingSession) Settle(actualQuota int) error {
 			return err
 		}
 		s.fundingSettled = true
+		// 周期预算按实际消耗记账，结算时超出上限的部分不再拦截
+		s.settleBudgets(delta)
 	}
 	// 2) 调整令牌额度
 	var tokenErr error
@@ -87,6 +93,7 @@ func (s *BillingSession) Refund(c *gin.Context) {
 	}
 	s.refunded = true
 	s.mu.Unlock()
+	metrics.RecordPreConsumeRefund(s.tokenConsumed)
 
 	logger.LogInfo(c, fmt.Sprintf("用户 %d 请求失败, 返还预扣费（token_quota=%s, funding=%s）",
 		s.relayInfo.UserId,
@@ -102,8 +109,13 @@ func (s *BillingSession) Refund(c *gin.Context) {
 	extraReserved := s.extraReserved
 	subscriptionId := s.relayInfo.SubscriptionId
 	funding := s.funding
+	budgets := s.budgets
 
 	gopool.Go(func() {
+		// 0) 退还周期预算
+		for _, r := range budgets {
+			r.release(r.reserved)
+		}
 		// 1) 退还资金来源
 		if err := funding.Refund(); err != nil {
 			common.SysLog("error refunding billing source: " + err.Error())
@@ -162,11 +174,16 @@ func (s *BillingSession) Reserve(targetQuota int) error {
 		return nil
 	}
 
+	if apiErr := s.reserveBudgets(delta); apiErr != nil {
+		return apiErr
+	}
 	if err := s.reserveFunding(delta); err != nil {
+		s.releaseBudgets(delta)
 		return err
 	}
 	if err := s.reserveToken(delta); err != nil {
 		s.rollbackFundingReserve(delta)
+		s.releaseBudgets(delta)
 		return err
 	}
 
@@ -195,9 +212,15 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 		logger.LogInfo(c, fmt.Sprintf("用户 %d 需要预扣费 %s (funding=%s)", s.relayInfo.UserId, logger.FormatQuota(effectiveQuota), s.funding.Source()))
 	}
 
+	// ---- 0) 占用令牌与用户周期预算（信任旁路时只检查预算是否已用尽） ----
+	if apiErr := s.reserveBudgets(effectiveQuota); apiErr != nil {
+		return apiErr
+	}
+
 	// ---- 1) 预扣令牌额度 ----
 	if effectiveQuota > 0 {
 		if err := PreConsumeTokenQuota(s.relayInfo, effectiveQuota); err != nil {
+			s.releaseBudgets(effectiveQuota)
 			return types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 		}
 		s.tokenConsumed = effectiveQuota
@@ -205,6 +228,7 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 
 	// ---- 2) 预扣资金来源 ----
 	if err := s.funding.PreConsume(effectiveQuota); err != nil {
+		s.releaseBudgets(effectiveQuota)
 		// 预扣费失败，回滚令牌额度
 		if s.tokenConsumed > 0 && !s.relayInfo.IsPlayground {
 			if rollbackErr := model.IncreaseTokenQuota(s.relayInfo.TokenId, s.relayInfo.TokenKey, s.tokenConsumed); rollbackErr != nil {
@@ -214,6 +238,9 @@ func (s *BillingSession) preConsume(c *gin.Context, quota int) *types.NewAPIErro
 			s.tokenConsumed = 0
 		}
 		// TODO: model 层应定义哨兵错误（如 ErrNoActiveSubscription），用 errors.Is 替代字符串匹配
+		if errors.Is(err, model.ErrOrganizationQuotaInsufficient) || errors.Is(err, model.ErrOrganizationMemberCapExceeded) || errors.Is(err, model.ErrOrganizationMemberNotFound) {
+			return newOrganizationQuotaError(err)
+		}
 		errMsg := err.Error()
 		if strings.Contains(errMsg, "no active subscription") || strings.Contains(errMsg, "subscription quota insufficient") {
 			return types.NewErrorWithStatusCode(fmt.Errorf("订阅额度不足或未配置订阅: %s", errMsg), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
@@ -248,6 +275,12 @@ func (s *BillingSession) reserveFunding(delta int) error {
 			)
 		}
 		return nil
+	case *OrganizationFunding:
+		if err := model.PreConsumeOrganizationQuota(funding.orgId, funding.userId, delta); err != nil {
+			return newOrganizationQuotaError(err)
+		}
+		funding.consumed += delta
+		return nil
 	default:
 		return types.NewError(fmt.Errorf("unsupported funding source: %s", s.funding.Source()), types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
 	}
@@ -265,6 +298,12 @@ func (s *BillingSession) rollbackFundingReserve(delta int) {
 		if err := model.PostConsumeUserSubscriptionDelta(funding.subscriptionId, -int64(delta)); err != nil {
 			common.SysLog("error rolling back subscription funding reserve: " + err.Error())
 		}
+	case *OrganizationFunding:
+		if err := model.AdjustOrganizationQuota(funding.orgId, funding.userId, -delta); err != nil {
+			common.SysLog("error rolling back organization funding reserve: " + err.Error())
+		} else {
+			funding.consumed -= delta
+		}
 	}
 }
 
@@ -302,13 +341,16 @@ func (s *BillingSession) shouldTrust(c *gin.Context) bool {
 
 	switch s.funding.Source() {
 	case BillingSourceWallet:
-		return s.relayInfo.UserQuota > trustQuota
+		return s.relayInfo.UserQuota+max(s.relayInfo.UserCreditLimit, 0) > trustQuota
 	case BillingSourceSubscription:
 		// 订阅不能启用信任旁路。原因：
 		// 1. PreConsumeUserSubscription 要求 amount>0 来创建预扣记录并锁定订阅
 		// 2. SubscriptionFunding.PreConsume 忽略参数，始终用 s.amount 预扣
 		// 3. 若信任旁路将 effectiveQuota 设为 0，会导致 preConsumedQuota 与实际订阅预扣不一致
 		return false
+	case BillingSourceOrganization:
+		// 组织额度由多个成员共享，且需要按成员上限预扣，不启用信任旁路
+		return false
 	default:
 		return false
 	}
@@ -344,23 +386,29 @@ func NewBillingSession(c *gin.Context, relayInfo *relaycommon.RelayInfo, preCons
 		return nil, types.NewError(fmt.Errorf("relayInfo is nil"), types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
 	}
 
+	// 绑定组织的令牌只从组织额度扣费，不回退到个人钱包或订阅
+	if relayInfo.OrgId > 0 {
+		return newOrganizationBillingSession(c, relayInfo, preConsumedQuota)
+	}
+
 	pref := common.NormalizeBillingPreference(relayInfo.UserSetting.BillingPreference)
 
-	// 钱包路径需要先检查用户额度
+	// 钱包路径需要先检查用户额度，后付费用户可透支到信用额度
 	tryWallet := func() (*BillingSession, *types.NewAPIError) {
 		userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
 		if err != nil {
 			return nil, types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
 		}
-		if userQuota <= 0 {
+		availableQuota := userQuota + max(relayInfo.UserCreditLimit, 0)
+		if availableQuota <= 0 {
 			return nil, types.NewErrorWithStatusCode(
-				fmt.Errorf("用户额度不足, 剩余额度: %s", logger.FormatQuota(userQuota)),
+				fmt.Errorf("用户额度不足, 剩余额度: %s", logger.FormatQuota(availableQuota)),
 				types.ErrorCodeInsufficientUserQuota, http.StatusForbidden,
 				types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 		}
-		if userQuota-preConsumedQuota < 0 {
+		if availableQuota-preConsumedQuota < 0 {
 			return nil, types.NewErrorWithStatusCode(
-				fmt.Errorf("预扣费额度失败, 用户剩余额度: %s, 需要预扣费额度: %s", logger.FormatQuota(userQuota), logger.FormatQuota(preConsumedQuota)),
+				fmt.Errorf("预扣费额度失败, 用户剩余额度: %s, 需要预扣费额度: %s", logger.FormatQuota(availableQuota), logger.FormatQuota(preConsumedQuota)),
 				types.ErrorCodeInsufficientUserQuota, http.StatusForbidden,
 				types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
 		}
@@ -432,3 +480,48 @@ func NewBillingSession(c *gin.Context, relayInfo *relaycommon.RelayInfo, preCons
 		return session, nil
 	}
 }
+
+func newOrganizationBillingSession(c *gin.Context, relayInfo *relaycommon.RelayInfo, preConsumedQuota int) (*BillingSession, *types.NewAPIError) {
+	org, err := model.GetOrganizationById(relayInfo.OrgId)
+	if err != nil {
+		if errors.Is(err, model.ErrOrganizationNotFound) {
+			return nil, newOrganizationQuotaError(err)
+		}
+		return nil, types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if org.Status != model.OrganizationStatusEnabled {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("组织 %s 已被禁用", org.Name),
+			types.ErrorCodeInsufficientUserQuota, http.StatusForbidden,
+			types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if org.Quota-preConsumedQuota < 0 || org.Quota <= 0 {
+		return nil, types.NewErrorWithStatusCode(
+			fmt.Errorf("组织额度不足, 剩余额度: %s, 需要预扣费额度: %s", logger.FormatQuota(org.Quota), logger.FormatQuota(preConsumedQuota)),
+			types.ErrorCodeInsufficientUserQuota, http.StatusForbidden,
+			types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	session := &BillingSession{
+		relayInfo: relayInfo,
+		funding:   &OrganizationFunding{orgId: org.Id, userId: relayInfo.UserId},
+	}
+	if apiErr := session.preConsume(c, preConsumedQuota); apiErr != nil {
+		return nil, apiErr
+	}
+	return session, nil
+}
+
+func newOrganizationQuotaError(err error) *types.NewAPIError {
+	var msg string
+	switch {
+	case errors.Is(err, model.ErrOrganizationMemberCapExceeded):
+		msg = "已达到组织成员消费上限"
+	case errors.Is(err, model.ErrOrganizationMemberNotFound):
+		msg = "令牌所属用户已不是该组织成员"
+	case errors.Is(err, model.ErrOrganizationNotFound):
+		msg = "令牌绑定的组织不存在"
+	default:
+		msg = "组织额度不足"
+	}
+	return types.NewErrorWithStatusCode(fmt.Errorf("%s: %w", msg, err), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden,
+		types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+}
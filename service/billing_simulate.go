@@ -0,0 +1,251 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/billing_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	billingReplayDefaultLimit = 200
+	billingReplayMaxLimit     = 2000
+	billingReplayMaxItems     = 100
+)
+
+// TextQuotaBreakdown 文本请求的计费明细，与 PostTextConsumeQuota 的计算结果一致
+type TextQuotaBreakdown struct {
+	Quota                    int     `json:"quota"`
+	UsageSemantic            string  `json:"usage_semantic"`
+	PromptTokens             int     `json:"prompt_tokens"`
+	CompletionTokens         int     `json:"completion_tokens"`
+	CacheTokens              int     `json:"cache_tokens"`
+	CacheCreationTokens      int     `json:"cache_creation_tokens"`
+	CacheCreationTokens5m    int     `json:"cache_creation_tokens_5m"`
+	CacheCreationTokens1h    int     `json:"cache_creation_tokens_1h"`
+	ImageTokens              int     `json:"image_tokens"`
+	AudioTokens              int     `json:"audio_tokens"`
+	UsePrice                 bool    `json:"use_price"`
+	ModelPrice               float64 `json:"model_price"`
+	ModelRatio               float64 `json:"model_ratio"`
+	CompletionRatio          float64 `json:"completion_ratio"`
+	CacheRatio               float64 `json:"cache_ratio"`
+	CacheCreationRatio       float64 `json:"cache_creation_ratio"`
+	CacheCreationRatio5m     float64 `json:"cache_creation_ratio_5m"`
+	CacheCreationRatio1h     float64 `json:"cache_creation_ratio_1h"`
+	ImageRatio               float64 `json:"image_ratio"`
+	GroupRatio               float64 `json:"group_ratio"`
+	AudioInputPrice          float64 `json:"audio_input_price"`
+	WebSearchCallCount       int     `json:"web_search_call_count"`
+	WebSearchPrice           float64 `json:"web_search_price"`
+	ClaudeWebSearchCallCount int     `json:"claude_web_search_call_count"`
+	ClaudeWebSearchPrice     float64 `json:"claude_web_search_price"`
+	FileSearchCallCount      int     `json:"file_search_call_count"`
+	FileSearchPrice          float64 `json:"file_search_price"`
+	ImageGenerationCallPrice float64 `json:"image_generation_call_price"`
+	ToolSurchargeQuota       int     `json:"tool_surcharge_quota"`
+	BillingMode              string  `json:"billing_mode"`
+	MatchedTier              string  `json:"matched_tier,omitempty"`
+	TieredQuotaBeforeGroup   float64 `json:"tiered_quota_before_group,omitempty"`
+}
+
+// SimulateTextQuota 按实际扣费逻辑计算额度明细，不扣费也不记录日志
+func SimulateTextQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage) TextQuotaBreakdown {
+	summary, tieredResult, tieredApplied := settleTextQuota(ctx, relayInfo, usage)
+	breakdown := TextQuotaBreakdown{
+		Quota:                    summary.Quota,
+		UsageSemantic:            summary.UsageSemantic,
+		PromptTokens:             summary.PromptTokens,
+		CompletionTokens:         summary.CompletionTokens,
+		CacheTokens:              summary.CacheTokens,
+		CacheCreationTokens:      summary.CacheCreationTokens,
+		CacheCreationTokens5m:    summary.CacheCreationTokens5m,
+		CacheCreationTokens1h:    summary.CacheCreationTokens1h,
+		ImageTokens:              summary.ImageTokens,
+		AudioTokens:              summary.AudioTokens,
+		UsePrice:                 relayInfo.PriceData.UsePrice,
+		ModelPrice:               summary.ModelPrice,
+		ModelRatio:               summary.ModelRatio,
+		CompletionRatio:          summary.CompletionRatio,
+		CacheRatio:               summary.CacheRatio,
+		CacheCreationRatio:       summary.CacheCreationRatio,
+		CacheCreationRatio5m:     summary.CacheCreationRatio5m,
+		CacheCreationRatio1h:     summary.CacheCreationRatio1h,
+		ImageRatio:               summary.ImageRatio,
+		GroupRatio:               summary.GroupRatio,
+		AudioInputPrice:          summary.AudioInputPrice,
+		WebSearchCallCount:       summary.WebSearchCallCount,
+		WebSearchPrice:           summary.WebSearchPrice,
+		ClaudeWebSearchCallCount: summary.ClaudeWebSearchCallCount,
+		ClaudeWebSearchPrice:     summary.ClaudeWebSearchPrice,
+		FileSearchCallCount:      summary.FileSearchCallCount,
+		FileSearchPrice:          summary.FileSearchPrice,
+		ImageGenerationCallPrice: summary.ImageGenerationCallPrice,
+		ToolSurchargeQuota:       int(summary.ToolCallSurchargeQuota.Round(0).IntPart()),
+		BillingMode:              billing_setting.BillingModeRatio,
+	}
+	if tieredApplied {
+		breakdown.BillingMode = billing_setting.BillingModeTieredExpr
+		if tieredResult != nil {
+			breakdown.MatchedTier = tieredResult.MatchedTier
+			breakdown.TieredQuotaBeforeGroup = tieredResult.ActualQuotaBeforeGroup
+		}
+	}
+	return breakdown
+}
+
+// BillingReplayItem 单条消费日志的重算结果
+type BillingReplayItem struct {
+	LogId          int    `json:"log_id"`
+	CreatedAt      int64  `json:"created_at"`
+	Group          string `json:"group"`
+	OriginalQuota  int    `json:"original_quota"`
+	SimulatedQuota int    `json:"simulated_quota"`
+	MatchedTier    string `json:"matched_tier"`
+}
+
+// BillingReplayResult 历史消费日志在拟修改表达式下的重算汇总
+type BillingReplayResult struct {
+	SampleCount    int                 `json:"sample_count"`
+	SkippedCount   int                 `json:"skipped_count"`
+	OriginalQuota  int64               `json:"original_quota"`
+	SimulatedQuota int64               `json:"simulated_quota"`
+	DeltaQuota     int64               `json:"delta_quota"`
+	DeltaPercent   float64             `json:"delta_percent"`
+	TierCounts     map[string]int      `json:"tier_counts"`
+	Items          []BillingReplayItem `json:"items"`
+}
+
+func otherFloat(other map[string]interface{}, key string) float64 {
+	if value, ok := other[key].(float64); ok {
+		return value
+	}
+	return 0
+}
+
+// replayUsageFromLog 从消费日志还原结算时的用量
+func replayUsageFromLog(log *model.Log, other map[string]interface{}) *dto.Usage {
+	usage := &dto.Usage{
+		PromptTokens:     log.PromptTokens,
+		CompletionTokens: log.CompletionTokens,
+		TotalTokens:      log.PromptTokens + log.CompletionTokens,
+	}
+	usage.PromptTokensDetails.CachedTokens = int(otherFloat(other, "cache_tokens"))
+	usage.PromptTokensDetails.CachedCreationTokens = int(otherFloat(other, "cache_creation_tokens"))
+	usage.PromptTokensDetails.ImageTokens = int(otherFloat(other, "image_output"))
+	usage.PromptTokensDetails.AudioTokens = int(otherFloat(other, "audio_input_token_count"))
+	usage.ClaudeCacheCreation5mTokens = int(otherFloat(other, "cache_creation_tokens_5m"))
+	usage.ClaudeCacheCreation1hTokens = int(otherFloat(other, "cache_creation_tokens_1h"))
+	if semantic, ok := other["usage_semantic"].(string); ok {
+		usage.UsageSemantic = semantic
+	}
+	return usage
+}
+
+// replayToolSurcharge 按日志中记录的工具调用与单价重算附加费用
+func replayToolSurcharge(other map[string]interface{}, groupRatio float64) decimal.Decimal {
+	dGroupRatio := decimal.NewFromFloat(groupRatio)
+	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+	perThousand := func(price float64, count float64) decimal.Decimal {
+		return decimal.NewFromFloat(price).Mul(decimal.NewFromFloat(count)).Div(decimal.NewFromInt(1000)).Mul(dGroupRatio).Mul(dQuotaPerUnit)
+	}
+	surcharge := perThousand(otherFloat(other, "web_search_price"), otherFloat(other, "web_search_call_count"))
+	surcharge = surcharge.Add(perThousand(otherFloat(other, "file_search_price"), otherFloat(other, "file_search_call_count")))
+	surcharge = surcharge.Add(decimal.NewFromFloat(otherFloat(other, "image_generation_call_price")).Mul(dGroupRatio).Mul(dQuotaPerUnit))
+	return surcharge
+}
+
+// ReplayConsumeLogs 用拟修改的表达式重算模型最近的消费日志，按日志中记录的分组倍率与工具调用计算，
+// 用于评估改价对收入的影响。日志不保存请求体与请求头，依赖 param()/header() 的条件按未命中处理。
+func ReplayConsumeLogs(modelName string, exprStr string, startTime int64, endTime int64, limit int) (*BillingReplayResult, error) {
+	if modelName == "" {
+		return nil, errors.New("model is required")
+	}
+	if err := billing_setting.SmokeTestExpr(exprStr); err != nil {
+		return nil, fmt.Errorf("invalid billing expression: %w", err)
+	}
+	if limit <= 0 {
+		limit = billingReplayDefaultLimit
+	}
+	if limit > billingReplayMaxLimit {
+		limit = billingReplayMaxLimit
+	}
+	logs, err := model.GetConsumeLogsByModel(modelName, startTime, endTime, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	usedVars := billingexpr.UsedVars(exprStr)
+	result := &BillingReplayResult{
+		TierCounts: make(map[string]int),
+		Items:      make([]BillingReplayItem, 0, min(len(logs), billingReplayMaxItems)),
+	}
+	for _, log := range logs {
+		if log.PromptTokens+log.CompletionTokens == 0 {
+			result.SkippedCount++
+			continue
+		}
+		other := make(map[string]interface{})
+		if log.Other != "" {
+			if err := common.UnmarshalJsonStr(log.Other, &other); err != nil {
+				result.SkippedCount++
+				continue
+			}
+		}
+		groupRatio := 1.0
+		if ratio, ok := other["group_ratio"].(float64); ok {
+			groupRatio = ratio
+		}
+		usage := replayUsageFromLog(log, other)
+		snap := &billingexpr.BillingSnapshot{
+			BillingMode:  billing_setting.BillingModeTieredExpr,
+			ModelName:    modelName,
+			ExprString:   exprStr,
+			ExprHash:     billingexpr.ExprHashString(exprStr),
+			GroupRatio:   groupRatio,
+			QuotaPerUnit: common.QuotaPerUnit,
+			ExprVersion:  billingexpr.ExprVersion(exprStr),
+		}
+		tiered, err := billingexpr.ComputeTieredQuota(snap, BuildTieredTokenParams(usage, usage.UsageSemantic == "anthropic", usedVars))
+		if err != nil {
+			result.SkippedCount++
+			continue
+		}
+		simulated := tiered.ActualQuotaAfterGroup
+		if surcharge := replayToolSurcharge(other, groupRatio); !surcharge.IsZero() {
+			simulated = int(decimal.NewFromFloat(tiered.ActualQuotaBeforeGroup).
+				Mul(decimal.NewFromFloat(groupRatio)).
+				Add(surcharge).
+				Round(0).
+				IntPart())
+		}
+
+		result.SampleCount++
+		result.OriginalQuota += int64(log.Quota)
+		result.SimulatedQuota += int64(simulated)
+		result.TierCounts[tiered.MatchedTier]++
+		if len(result.Items) < billingReplayMaxItems {
+			result.Items = append(result.Items, BillingReplayItem{
+				LogId:          log.Id,
+				CreatedAt:      log.CreatedAt,
+				Group:          log.Group,
+				OriginalQuota:  log.Quota,
+				SimulatedQuota: simulated,
+				MatchedTier:    tiered.MatchedTier,
+			})
+		}
+	}
+	result.DeltaQuota = result.SimulatedQuota - result.OriginalQuota
+	if result.OriginalQuota > 0 {
+		result.DeltaPercent = float64(result.DeltaQuota) / float64(result.OriginalQuota) * 100
+	}
+	return result, nil
+}
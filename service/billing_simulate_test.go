@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedConsumeLog(t *testing.T, modelName string, prompt int, completion int, quota int, other string) {
+	t.Helper()
+	require.NoError(t, model.LOG_DB.Create(&model.Log{
+		UserId:           1,
+		CreatedAt:        time.Now().Unix(),
+		Type:             model.LogTypeConsume,
+		ModelName:        modelName,
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		Quota:            quota,
+		Other:            other,
+	}).Error)
+}
+
+func TestReplayConsumeLogs(t *testing.T) {
+	truncate(t)
+
+	seedConsumeLog(t, "replay-model", 1000, 500, 100, `{"group_ratio":1}`)
+	seedConsumeLog(t, "replay-model", 1000, 500, 100, `{"group_ratio":2}`)
+	seedConsumeLog(t, "replay-model", 0, 0, 0, `{}`)
+	seedConsumeLog(t, "other-model", 1000, 500, 100, `{"group_ratio":1}`)
+
+	base, err := ReplayConsumeLogs("replay-model", flatExpr, 0, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, base.SampleCount)
+	assert.Equal(t, 1, base.SkippedCount)
+	assert.EqualValues(t, 200, base.OriginalQuota)
+	assert.Equal(t, 2, base.TierCounts["default"])
+	require.Len(t, base.Items, 2)
+	// 按 id 倒序返回，第一条为 group_ratio=2 的日志
+	assert.Equal(t, base.Items[1].SimulatedQuota*2, base.Items[0].SimulatedQuota)
+
+	doubled, err := ReplayConsumeLogs("replay-model", `tier("default", p * 4 + c * 20)`, 0, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, base.SimulatedQuota*2, doubled.SimulatedQuota)
+	assert.Equal(t, doubled.SimulatedQuota-doubled.OriginalQuota, doubled.DeltaQuota)
+}
+
+func TestReplayConsumeLogsRejectsInvalidExpr(t *testing.T) {
+	_, err := ReplayConsumeLogs("replay-model", `invalid +-+ expr`, 0, 0, 0)
+	require.Error(t, err)
+}
+
+func TestReplayToolSurcharge(t *testing.T) {
+	other := map[string]interface{}{
+		"web_search_price":      10.0,
+		"web_search_call_count": 2.0,
+	}
+	base := replayToolSurcharge(other, 1)
+	assert.True(t, base.IsPositive())
+	assert.True(t, replayToolSurcharge(other, 2).Equal(base.Add(base)))
+	assert.True(t, replayToolSurcharge(map[string]interface{}{}, 1).IsZero())
+}
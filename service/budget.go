@@ -0,0 +1,179 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// budgetReservation 计费会话在令牌或用户预算窗口内占用的额度
+type budgetReservation struct {
+	ownerType   string
+	ownerId     int
+	window      types.BudgetWindow
+	windowStart int64
+	reserved    int
+}
+
+type budgetOwner struct {
+	ownerType string
+	ownerId   int
+	window    types.BudgetWindow
+}
+
+// getBudgetOwners 返回请求需要检查的周期预算，playground 请求不使用令牌
+func getBudgetOwners(relayInfo *relaycommon.RelayInfo) []budgetOwner {
+	owners := make([]budgetOwner, 0, 2)
+	if !relayInfo.IsPlayground && relayInfo.TokenId > 0 && relayInfo.TokenBudget.Enabled() {
+		owners = append(owners, budgetOwner{ownerType: model.BudgetOwnerToken, ownerId: relayInfo.TokenId, window: relayInfo.TokenBudget})
+	}
+	if relayInfo.UserId > 0 && relayInfo.UserBudget.Enabled() {
+		owners = append(owners, budgetOwner{ownerType: model.BudgetOwnerUser, ownerId: relayInfo.UserId, window: relayInfo.UserBudget})
+	}
+	return owners
+}
+
+// reserveBudgets 在所有预算窗口内占用 amount 额度，任一预算用尽时回滚已占用的部分
+func (s *BillingSession) reserveBudgets(amount int) *types.NewAPIError {
+	if s.budgets == nil {
+		for _, owner := range getBudgetOwners(s.relayInfo) {
+			s.budgets = append(s.budgets, &budgetReservation{ownerType: owner.ownerType, ownerId: owner.ownerId, window: owner.window})
+		}
+	}
+	for i, r := range s.budgets {
+		usage, err := model.ReserveBudget(r.ownerType, r.ownerId, r.window, amount)
+		if err != nil {
+			for _, done := range s.budgets[:i] {
+				done.release(amount)
+			}
+			if errors.Is(err, model.ErrBudgetExceeded) {
+				return newBudgetExceededError(r, usage)
+			}
+			return types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+		}
+		if usage.WindowStart != r.windowStart {
+			// 首次占用或窗口已重置，之前窗口内的占用按已消费处理
+			r.windowStart = usage.WindowStart
+			r.reserved = 0
+		}
+		r.reserved += amount
+	}
+	return nil
+}
+
+func (r *budgetReservation) release(amount int) {
+	if amount == 0 {
+		return
+	}
+	if err := model.AdjustBudgetUsage(r.ownerType, r.ownerId, r.windowStart, -amount); err != nil {
+		common.SysLog(fmt.Sprintf("error releasing %s %d budget: %s", r.ownerType, r.ownerId, err.Error()))
+		return
+	}
+	r.reserved -= amount
+}
+
+// releaseBudgets 退还每个预算窗口内占用的 amount 额度
+func (s *BillingSession) releaseBudgets(amount int) {
+	for _, r := range s.budgets {
+		r.release(amount)
+	}
+}
+
+// settleBudgets 按实际消耗与预扣的差额调整预算
+func (s *BillingSession) settleBudgets(delta int) {
+	for _, r := range s.budgets {
+		if err := model.AdjustBudgetUsage(r.ownerType, r.ownerId, r.windowStart, delta); err != nil {
+			common.SysLog(fmt.Sprintf("error settling %s %d budget (delta=%d): %s", r.ownerType, r.ownerId, delta, err.Error()))
+		}
+	}
+}
+
+func newBudgetExceededError(r *budgetReservation, usage *model.BudgetUsage) *types.NewAPIError {
+	owner := "令牌"
+	if r.ownerType == model.BudgetOwnerUser {
+		owner = "用户"
+	}
+	msg := fmt.Sprintf("%s周期预算已用尽，上限 %s", owner, logger.FormatQuota(r.window.Limit))
+	if usage != nil {
+		msg = fmt.Sprintf("%s周期预算已用尽，已用 %s / 上限 %s，将于 %s 重置", owner,
+			logger.FormatQuota(usage.Used), logger.FormatQuota(r.window.Limit),
+			time.Unix(usage.ResetAt, 0).Format("2006-01-02 15:04:05"))
+	}
+	return types.NewErrorWithStatusCode(errors.New(msg), types.ErrorCodeBudgetExceeded, http.StatusForbidden,
+		types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+}
+
+// recordBudgetUsage 记录未经 BillingSession 的消费（旧的后扣费路径）
+func recordBudgetUsage(relayInfo *relaycommon.RelayInfo, quota int) {
+	if quota == 0 {
+		return
+	}
+	for _, owner := range getBudgetOwners(relayInfo) {
+		if _, err := model.RecordBudgetUsage(owner.ownerType, owner.ownerId, owner.window, quota); err != nil {
+			common.SysLog(fmt.Sprintf("error recording %s %d budget usage: %s", owner.ownerType, owner.ownerId, err.Error()))
+		}
+	}
+}
+
+// checkAndSendBudgetNotify 预算窗口内消费达到配置的百分比时通知用户，每个窗口只通知一次
+func checkAndSendBudgetNotify(relayInfo *relaycommon.RelayInfo) {
+	percent := operation_setting.GetBudgetSetting().GetNotifyPercent()
+	owners := getBudgetOwners(relayInfo)
+	if percent <= 0 || len(owners) == 0 {
+		return
+	}
+	gopool.Go(func() {
+		for _, owner := range owners {
+			usage, err := model.GetBudgetUsage(owner.ownerType, owner.ownerId)
+			if err != nil || usage.ResetAt <= common.GetTimestamp() {
+				continue
+			}
+			if int64(usage.Used)*100 < int64(owner.window.Limit)*int64(percent) {
+				continue
+			}
+			marked, err := model.MarkBudgetNotified(usage)
+			if err != nil || !marked {
+				continue
+			}
+			sendBudgetNotify(relayInfo, owner, usage)
+		}
+	})
+}
+
+func sendBudgetNotify(relayInfo *relaycommon.RelayInfo, owner budgetOwner, usage *model.BudgetUsage) {
+	prompt := "您的用户周期预算即将用尽"
+	if owner.ownerType == model.BudgetOwnerToken {
+		prompt = fmt.Sprintf("您的令牌（ID %d）周期预算即将用尽", relayInfo.TokenId)
+	}
+	used := logger.FormatQuota(usage.Used)
+	limit := logger.FormatQuota(owner.window.Limit)
+	resetAt := time.Unix(usage.ResetAt, 0).Format("2006-01-02 15:04:05")
+
+	var content string
+	var values []interface{}
+	notifyType := relayInfo.UserSetting.NotifyType
+	if notifyType == "" {
+		notifyType = dto.NotifyTypeEmail
+	}
+	if notifyType == dto.NotifyTypeBark || notifyType == dto.NotifyTypeGotify {
+		content = "{{value}}，已用 {{value}} / {{value}}，{{value}} 重置"
+	} else {
+		content = "{{value}}，当前窗口已用 {{value}}，上限 {{value}}，将于 {{value}} 重置。达到上限后请求将被拒绝。"
+	}
+	values = []interface{}{prompt, used, limit, resetAt}
+
+	if err := NotifyUser(relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting, dto.NewNotify(dto.NotifyTypeBudgetWarning, prompt, content, values)); err != nil {
+		common.SysError(fmt.Sprintf("failed to send budget notify to user %d: %s", relayInfo.UserId, err.Error()))
+	}
+}
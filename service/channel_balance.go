@@ -0,0 +1,341 @@
+package service
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+const (
+	// channelBalanceAlpha is the smoothing factor of the latency / error EWMA
+	channelBalanceAlpha = 0.2
+	// channelBalanceErrorPenalty scales latency by (1 + penalty * errorRate)
+	channelBalanceErrorPenalty = 4.0
+	// live stats older than this are ignored so recovered channels get traffic again
+	channelBalanceStaleAfter = 10 * time.Minute
+)
+
+// channelBalanceStats holds the live relay outcome statistics of a channel.
+type channelBalanceStats struct {
+	mu          sync.Mutex
+	latencyMs   float64
+	errorRate   float64
+	samples     int64
+	lastUpdated time.Time
+
+	outstanding atomic.Int64
+}
+
+type ChannelBalanceSnapshot struct {
+	ChannelId   int     `json:"channel_id"`
+	LatencyMs   float64 `json:"latency_ms"`
+	ErrorRate   float64 `json:"error_rate"`
+	Samples     int64   `json:"samples"`
+	Outstanding int64   `json:"outstanding"`
+	LastUpdated int64   `json:"last_updated"`
+}
+
+var channelBalanceStatsMap sync.Map // map[int]*channelBalanceStats
+
+type channelBalanceStrategy struct{}
+
+func (channelBalanceStrategy) PickChannel(group string, candidates []*model.Channel) *model.Channel {
+	switch setting.GetGroupChannelBalance(group) {
+	case setting.ChannelBalanceEWMALatency:
+		return pickChannelByEWMALatency(candidates)
+	case setting.ChannelBalanceLeastOutstanding:
+		return pickChannelByLeastOutstanding(candidates)
+	case setting.ChannelBalancePowerOfTwo:
+		return pickChannelByPowerOfTwo(candidates)
+	}
+	return nil
+}
+
+func init() {
+	model.SetChannelSelectStrategy(channelBalanceStrategy{})
+}
+
+func getChannelBalanceStats(channelId int) *channelBalanceStats {
+	if v, ok := channelBalanceStatsMap.Load(channelId); ok {
+		return v.(*channelBalanceStats)
+	}
+	v, _ := channelBalanceStatsMap.LoadOrStore(channelId, &channelBalanceStats{})
+	return v.(*channelBalanceStats)
+}
+
+// ChannelBalanceAcquire marks one in-flight relay attempt on the channel.
+func ChannelBalanceAcquire(channelId int) {
+	if channelId <= 0 {
+		return
+	}
+	getChannelBalanceStats(channelId).outstanding.Add(1)
+}
+
+// ChannelBalanceRelease ends an attempt started with ChannelBalanceAcquire and feeds its outcome
+// into the latency / error statistics. Streams are measured by time to first token, other
+// requests by total latency.
+func ChannelBalanceRelease(channelId int, info *relaycommon.RelayInfo, attemptStart time.Time, err *types.NewAPIError) {
+	if channelId <= 0 {
+		return
+	}
+	stats := getChannelBalanceStats(channelId)
+	if stats.outstanding.Add(-1) < 0 {
+		stats.outstanding.Store(0)
+	}
+	failed, counted := isChannelBreakerFailure(err)
+	if !counted {
+		return
+	}
+	latency := time.Since(attemptStart)
+	if info != nil && info.IsStream && info.FirstResponseTime.After(attemptStart) {
+		latency = info.FirstResponseTime.Sub(attemptStart)
+	}
+	stats.observe(time.Now(), float64(latency.Milliseconds()), failed)
+}
+
+func (s *channelBalanceStats) observe(now time.Time, latencyMs float64, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples == 0 || now.Sub(s.lastUpdated) > channelBalanceStaleAfter {
+		s.samples = 0
+		s.latencyMs = 0
+		s.errorRate = 0
+	}
+	errorValue := 0.0
+	if failed {
+		errorValue = 1
+	}
+	if s.samples == 0 {
+		s.errorRate = errorValue
+	} else {
+		s.errorRate = channelBalanceAlpha*errorValue + (1-channelBalanceAlpha)*s.errorRate
+	}
+	// failed attempts say nothing reliable about latency
+	if !failed {
+		if s.latencyMs <= 0 {
+			s.latencyMs = latencyMs
+		} else {
+			s.latencyMs = channelBalanceAlpha*latencyMs + (1-channelBalanceAlpha)*s.latencyMs
+		}
+	}
+	s.samples++
+	s.lastUpdated = now
+}
+
+// read returns the live latency (0 when unknown), error rate and outstanding requests.
+func (s *channelBalanceStats) read(now time.Time) (latencyMs float64, errorRate float64, outstanding int64) {
+	outstanding = s.outstanding.Load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples == 0 || now.Sub(s.lastUpdated) > channelBalanceStaleAfter {
+		return 0, 0, outstanding
+	}
+	return s.latencyMs, s.errorRate, outstanding
+}
+
+type channelBalanceCandidate struct {
+	channel     *model.Channel
+	weight      float64
+	latencyMs   float64
+	errorRate   float64
+	outstanding int64
+}
+
+// buildChannelBalanceCandidates collects live stats for the candidates. Channels without live
+// latency use the response time of the last channel test as a prior. The second return value
+// reports whether any latency information was found at all.
+func buildChannelBalanceCandidates(channels []*model.Channel) ([]channelBalanceCandidate, bool) {
+	now := time.Now()
+	sumWeight := 0
+	for _, channel := range channels {
+		sumWeight += channel.GetWeight()
+	}
+	candidates := make([]channelBalanceCandidate, 0, len(channels))
+	hasLatency := false
+	for _, channel := range channels {
+		weight := float64(channel.GetWeight())
+		if sumWeight == 0 {
+			weight = 1
+		}
+		if weight <= 0 {
+			continue
+		}
+		latencyMs, errorRate, outstanding := getChannelBalanceStats(channel.Id).read(now)
+		if latencyMs <= 0 && channel.ResponseTime > 0 {
+			latencyMs = float64(channel.ResponseTime)
+		}
+		if latencyMs > 0 {
+			hasLatency = true
+		}
+		candidates = append(candidates, channelBalanceCandidate{
+			channel:     channel,
+			weight:      weight,
+			latencyMs:   latencyMs,
+			errorRate:   errorRate,
+			outstanding: outstanding,
+		})
+	}
+	if !hasLatency {
+		return candidates, false
+	}
+	// give channels without any data the average latency so they still get explored
+	sumLatency, known := 0.0, 0
+	for _, candidate := range candidates {
+		if candidate.latencyMs > 0 {
+			sumLatency += candidate.latencyMs
+			known++
+		}
+	}
+	avgLatency := sumLatency / float64(known)
+	for i := range candidates {
+		if candidates[i].latencyMs <= 0 {
+			candidates[i].latencyMs = avgLatency
+		}
+	}
+	return candidates, true
+}
+
+func (c channelBalanceCandidate) cost() float64 {
+	latency := c.latencyMs
+	if latency < 1 {
+		latency = 1
+	}
+	return latency * (1 + channelBalanceErrorPenalty*c.errorRate)
+}
+
+func pickChannelByEWMALatency(channels []*model.Channel) *model.Channel {
+	candidates, hasLatency := buildChannelBalanceCandidates(channels)
+	if !hasLatency || len(candidates) == 0 {
+		return nil
+	}
+	scores := make([]float64, len(candidates))
+	total := 0.0
+	for i, candidate := range candidates {
+		scores[i] = candidate.weight / candidate.cost()
+		total += scores[i]
+	}
+	if total <= 0 {
+		return nil
+	}
+	r := rand.Float64() * total
+	for i, score := range scores {
+		r -= score
+		if r < 0 {
+			return candidates[i].channel
+		}
+	}
+	return candidates[len(candidates)-1].channel
+}
+
+func pickChannelByLeastOutstanding(channels []*model.Channel) *model.Channel {
+	candidates, _ := buildChannelBalanceCandidates(channels)
+	if len(candidates) == 0 {
+		return nil
+	}
+	busy := false
+	for _, candidate := range candidates {
+		if candidate.outstanding > 0 {
+			busy = true
+			break
+		}
+	}
+	if !busy {
+		return nil
+	}
+	var best []channelBalanceCandidate
+	bestLoad := 0.0
+	for _, candidate := range candidates {
+		load := float64(candidate.outstanding) / candidate.weight
+		if len(best) == 0 || load < bestLoad {
+			best = []channelBalanceCandidate{candidate}
+			bestLoad = load
+		} else if load == bestLoad {
+			best = append(best, candidate)
+		}
+	}
+	return best[rand.Intn(len(best))].channel
+}
+
+func pickWeightedChannelBalanceCandidate(candidates []channelBalanceCandidate, exclude int) int {
+	total := 0.0
+	for i, candidate := range candidates {
+		if i != exclude {
+			total += candidate.weight
+		}
+	}
+	r := rand.Float64() * total
+	for i, candidate := range candidates {
+		if i == exclude {
+			continue
+		}
+		r -= candidate.weight
+		if r < 0 {
+			return i
+		}
+	}
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if i != exclude {
+			return i
+		}
+	}
+	return -1
+}
+
+func pickChannelByPowerOfTwo(channels []*model.Channel) *model.Channel {
+	candidates, hasLatency := buildChannelBalanceCandidates(channels)
+	if len(candidates) < 2 {
+		return nil
+	}
+	first := pickWeightedChannelBalanceCandidate(candidates, -1)
+	second := pickWeightedChannelBalanceCandidate(candidates, first)
+	if first < 0 || second < 0 {
+		return nil
+	}
+	a, b := candidates[first], candidates[second]
+	costA := float64(a.outstanding + 1)
+	costB := float64(b.outstanding + 1)
+	if hasLatency {
+		costA *= a.cost()
+		costB *= b.cost()
+	}
+	if costB < costA {
+		return b.channel
+	}
+	return a.channel
+}
+
+// GetChannelBalanceSnapshots lists the live balancing statistics of all channels seen so far.
+func GetChannelBalanceSnapshots(channelId int) []ChannelBalanceSnapshot {
+	now := time.Now()
+	snapshots := make([]ChannelBalanceSnapshot, 0)
+	channelBalanceStatsMap.Range(func(k, v any) bool {
+		id := k.(int)
+		if channelId > 0 && id != channelId {
+			return true
+		}
+		stats := v.(*channelBalanceStats)
+		latencyMs, errorRate, outstanding := stats.read(now)
+		stats.mu.Lock()
+		samples, lastUpdated := stats.samples, stats.lastUpdated
+		stats.mu.Unlock()
+		snapshot := ChannelBalanceSnapshot{
+			ChannelId:   id,
+			LatencyMs:   latencyMs,
+			ErrorRate:   errorRate,
+			Samples:     samples,
+			Outstanding: outstanding,
+		}
+		if !lastUpdated.IsZero() {
+			snapshot.LastUpdated = lastUpdated.Unix()
+		}
+		snapshots = append(snapshots, snapshot)
+		return true
+	})
+	return snapshots
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/stretchr/testify/require"
+)
+
+func newChannelBalanceTestChannel(id int, weight uint) *model.Channel {
+	return &model.Channel{Id: id, Weight: &weight}
+}
+
+func resetChannelBalanceStats(t *testing.T, ids ...int) {
+	t.Helper()
+	t.Cleanup(func() {
+		for _, id := range ids {
+			channelBalanceStatsMap.Delete(id)
+		}
+	})
+}
+
+func TestChannelBalanceEWMAPrefersFastChannel(t *testing.T) {
+	fast := newChannelBalanceTestChannel(920001, 10)
+	slow := newChannelBalanceTestChannel(920002, 10)
+	resetChannelBalanceStats(t, fast.Id, slow.Id)
+	channels := []*model.Channel{fast, slow}
+
+	require.Nil(t, pickChannelByEWMALatency(channels), "without any latency data selection falls back")
+
+	now := time.Now()
+	getChannelBalanceStats(fast.Id).observe(now, 100, false)
+	getChannelBalanceStats(slow.Id).observe(now, 2000, false)
+
+	fastPicks := 0
+	for i := 0; i < 1000; i++ {
+		if pickChannelByEWMALatency(channels) == fast {
+			fastPicks++
+		}
+	}
+	require.Greater(t, fastPicks, 850)
+}
+
+func TestChannelBalanceLeastOutstanding(t *testing.T) {
+	a := newChannelBalanceTestChannel(920011, 1)
+	b := newChannelBalanceTestChannel(920012, 1)
+	resetChannelBalanceStats(t, a.Id, b.Id)
+	channels := []*model.Channel{a, b}
+
+	require.Nil(t, pickChannelByLeastOutstanding(channels), "idle channels fall back to weighted random")
+
+	ChannelBalanceAcquire(a.Id)
+	ChannelBalanceAcquire(a.Id)
+	ChannelBalanceAcquire(b.Id)
+	require.Equal(t, b, pickChannelByLeastOutstanding(channels))
+
+	ChannelBalanceRelease(a.Id, nil, time.Now(), nil)
+	ChannelBalanceRelease(a.Id, nil, time.Now(), nil)
+	require.Equal(t, a, pickChannelByLeastOutstanding(channels))
+}
+
+func TestChannelBalanceErrorRateRaisesCost(t *testing.T) {
+	stats := &channelBalanceStats{}
+	now := time.Now()
+	stats.observe(now, 100, false)
+	stats.observe(now, 0, true)
+
+	latency, errorRate, _ := stats.read(now)
+	require.Equal(t, float64(100), latency, "failures do not change latency")
+	require.InDelta(t, channelBalanceAlpha, errorRate, 1e-9)
+
+	latency, _, _ = stats.read(now.Add(channelBalanceStaleAfter + time.Second))
+	require.Zero(t, latency, "stale statistics are ignored")
+}
@@ -0,0 +1,584 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/cachex"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/hot"
+)
+
+const (
+	ChannelBreakerStateClosed   = "closed"
+	ChannelBreakerStateOpen     = "open"
+	ChannelBreakerStateHalfOpen = "half_open"
+
+	ginKeyChannelBreakerLogInfo = "channel_breaker_log_info"
+
+	channelBreakerCacheNamespace = "new-api:channel_breaker:v1"
+	// how long a shared (Redis) open state is trusted locally before being looked up again
+	channelBreakerRemoteRefresh = time.Second
+	// pub/sub topic used to broadcast admin resets to every node
+	channelBreakerResetTopic = "new-api:channel_breaker:v1:reset"
+)
+
+// channelBreakerBucket holds the outcome counters of one second inside the sliding window.
+type channelBreakerBucket struct {
+	second   int64
+	total    int
+	failures int
+}
+
+// channelBreaker is the circuit breaker of a single channel or a single key of a multi-key channel.
+type channelBreaker struct {
+	mu               sync.Mutex
+	buckets          []channelBreakerBucket
+	state            string
+	openedAt         time.Time
+	halfOpenSuccess  int
+	lastFailure      string
+	lastTransitionAt time.Time
+}
+
+type channelBreakerRemoteEntry struct {
+	openUntil int64
+	checkedAt time.Time
+}
+
+// ChannelBreakerSnapshot is the admin-facing view of a breaker.
+type ChannelBreakerSnapshot struct {
+	ChannelId        int     `json:"channel_id"`
+	KeyIndex         *int    `json:"key_index,omitempty"`
+	State            string  `json:"state"`
+	Total            int     `json:"total"`
+	Failures         int     `json:"failures"`
+	ErrorRate        float64 `json:"error_rate"`
+	OpenedAt         int64   `json:"opened_at,omitempty"`
+	HalfOpenAt       int64   `json:"half_open_at,omitempty"`
+	HalfOpenSuccess  int     `json:"half_open_success"`
+	LastFailure      string  `json:"last_failure,omitempty"`
+	LastTransitionAt int64   `json:"last_transition_at,omitempty"`
+	SharedOpenUntil  int64   `json:"shared_open_until,omitempty"`
+}
+
+var (
+	channelBreakers sync.Map // map[string]*channelBreaker
+
+	channelBreakerRemoteStates sync.Map // map[string]channelBreakerRemoteEntry
+
+	channelBreakerCacheOnce sync.Once
+	channelBreakerCache     *cachex.HybridCache[int64]
+
+	channelBreakerResetOnce sync.Once
+)
+
+type channelBreakerGuard struct{}
+
+func (channelBreakerGuard) AllowChannel(channelId int) bool {
+	return allowChannelBreaker(channelBreakerKey(channelId, -1))
+}
+
+func (channelBreakerGuard) AllowKey(channelId int, keyIndex int) bool {
+	return allowChannelBreaker(channelBreakerKey(channelId, keyIndex))
+}
+
+func init() {
+	model.SetChannelSelectGuard(channelBreakerGuard{})
+}
+
+func channelBreakerKey(channelId int, keyIndex int) string {
+	if keyIndex < 0 {
+		return strconv.Itoa(channelId)
+	}
+	return fmt.Sprintf("%d:%d", channelId, keyIndex)
+}
+
+func parseChannelBreakerKey(key string) (int, *int) {
+	parts := strings.SplitN(key, ":", 2)
+	channelId, _ := strconv.Atoi(parts[0])
+	if len(parts) < 2 {
+		return channelId, nil
+	}
+	keyIndex, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return channelId, nil
+	}
+	return channelId, &keyIndex
+}
+
+func getChannelBreakerCache() *cachex.HybridCache[int64] {
+	channelBreakerCacheOnce.Do(func() {
+		channelBreakerCache = cachex.NewHybridCache[int64](cachex.HybridCacheConfig[int64]{
+			Namespace: cachex.Namespace(channelBreakerCacheNamespace),
+			Redis:     common.RDB,
+			RedisEnabled: func() bool {
+				return common.RedisEnabled && common.RDB != nil
+			},
+			RedisCodec: cachex.JSONCodec[int64]{},
+			Memory: func() *hot.HotCache[string, int64] {
+				return hot.NewHotCache[string, int64](hot.LRU, 10_000).
+					WithJanitor().
+					Build()
+			},
+		})
+	})
+	return channelBreakerCache
+}
+
+func channelBreakerShared() bool {
+	return operation_setting.GetChannelBreakerSetting().RedisShared && common.RedisEnabled && common.RDB != nil
+}
+
+func getChannelBreaker(key string, create bool) *channelBreaker {
+	if v, ok := channelBreakers.Load(key); ok {
+		return v.(*channelBreaker)
+	}
+	if !create {
+		return nil
+	}
+	v, _ := channelBreakers.LoadOrStore(key, &channelBreaker{state: ChannelBreakerStateClosed})
+	return v.(*channelBreaker)
+}
+
+func channelBreakerWindowSeconds() int {
+	windowSeconds := operation_setting.GetChannelBreakerSetting().WindowSeconds
+	if windowSeconds <= 0 {
+		windowSeconds = 60
+	}
+	return windowSeconds
+}
+
+func channelBreakerOpenDuration() time.Duration {
+	openSeconds := operation_setting.GetChannelBreakerSetting().OpenSeconds
+	if openSeconds <= 0 {
+		openSeconds = 30
+	}
+	return time.Duration(openSeconds) * time.Second
+}
+
+// effectiveState returns the state taking the open timeout into account; caller must hold b.mu.
+func (b *channelBreaker) effectiveState(now time.Time) string {
+	if b.state == ChannelBreakerStateOpen && now.Sub(b.openedAt) >= channelBreakerOpenDuration() {
+		return ChannelBreakerStateHalfOpen
+	}
+	return b.state
+}
+
+// windowCounts sums the buckets that are still inside the sliding window; caller must hold b.mu.
+func (b *channelBreaker) windowCounts(now time.Time) (total int, failures int) {
+	minSecond := now.Unix() - int64(channelBreakerWindowSeconds()) + 1
+	for _, bucket := range b.buckets {
+		if bucket.second < minSecond {
+			continue
+		}
+		total += bucket.total
+		failures += bucket.failures
+	}
+	return total, failures
+}
+
+// addOutcome records one outcome into the current bucket; caller must hold b.mu.
+func (b *channelBreaker) addOutcome(now time.Time, failed bool) {
+	windowSeconds := channelBreakerWindowSeconds()
+	if len(b.buckets) != windowSeconds {
+		b.buckets = make([]channelBreakerBucket, windowSeconds)
+	}
+	second := now.Unix()
+	bucket := &b.buckets[int(second%int64(windowSeconds))]
+	if bucket.second != second {
+		*bucket = channelBreakerBucket{second: second}
+	}
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+}
+
+// transition switches the breaker state; caller must hold b.mu.
+func (b *channelBreaker) transition(now time.Time, state string) {
+	b.state = state
+	b.lastTransitionAt = now
+	b.halfOpenSuccess = 0
+	switch state {
+	case ChannelBreakerStateOpen:
+		b.openedAt = now
+	case ChannelBreakerStateClosed:
+		b.buckets = nil
+		b.openedAt = time.Time{}
+	}
+}
+
+func allowChannelBreaker(key string) bool {
+	setting := operation_setting.GetChannelBreakerSetting()
+	if !setting.Enabled {
+		return true
+	}
+	now := time.Now()
+	if b := getChannelBreaker(key, false); b != nil {
+		b.mu.Lock()
+		state := b.effectiveState(now)
+		if state == ChannelBreakerStateHalfOpen && b.state == ChannelBreakerStateOpen {
+			b.transition(now, ChannelBreakerStateHalfOpen)
+		}
+		b.mu.Unlock()
+		switch state {
+		case ChannelBreakerStateOpen:
+			return false
+		case ChannelBreakerStateHalfOpen:
+			return rand.Float64() < setting.HalfOpenProbeRatio
+		}
+	}
+	if channelBreakerShared() {
+		if openUntil := getChannelBreakerSharedOpenUntil(key, now); openUntil > now.UnixMilli() {
+			return false
+		}
+	}
+	return true
+}
+
+func getChannelBreakerSharedOpenUntil(key string, now time.Time) int64 {
+	if v, ok := channelBreakerRemoteStates.Load(key); ok {
+		entry := v.(channelBreakerRemoteEntry)
+		if now.Sub(entry.checkedAt) < channelBreakerRemoteRefresh {
+			return entry.openUntil
+		}
+	}
+	openUntil, found, err := getChannelBreakerCache().Get(key)
+	if err != nil {
+		common.SysError(fmt.Sprintf("channel breaker cache get failed: key=%s, err=%v", key, err))
+	}
+	if !found {
+		openUntil = 0
+	}
+	channelBreakerRemoteStates.Store(key, channelBreakerRemoteEntry{openUntil: openUntil, checkedAt: now})
+	return openUntil
+}
+
+func publishChannelBreakerState(key string, state string, now time.Time) {
+	if !channelBreakerShared() {
+		return
+	}
+	cache := getChannelBreakerCache()
+	if state == ChannelBreakerStateOpen {
+		openDuration := channelBreakerOpenDuration()
+		openUntil := now.Add(openDuration).UnixMilli()
+		if err := cache.SetWithTTL(key, openUntil, openDuration); err != nil {
+			common.SysError(fmt.Sprintf("channel breaker cache set failed: key=%s, err=%v", key, err))
+		}
+		channelBreakerRemoteStates.Store(key, channelBreakerRemoteEntry{openUntil: openUntil, checkedAt: now})
+		return
+	}
+	if _, err := cache.DeleteMany([]string{key}); err != nil {
+		common.SysError(fmt.Sprintf("channel breaker cache delete failed: key=%s, err=%v", key, err))
+	}
+	channelBreakerRemoteStates.Delete(key)
+}
+
+// recordChannelBreakerOutcome feeds one outcome into the breaker identified by key and returns
+// the new state when a transition happened.
+func recordChannelBreakerOutcome(key string, failed bool, reason string) (string, bool) {
+	setting := operation_setting.GetChannelBreakerSetting()
+	now := time.Now()
+	b := getChannelBreaker(key, true)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if failed {
+		b.lastFailure = reason
+	}
+	switch b.effectiveState(now) {
+	case ChannelBreakerStateOpen:
+		// late result of a request started before the breaker opened
+		return "", false
+	case ChannelBreakerStateHalfOpen:
+		if failed {
+			b.transition(now, ChannelBreakerStateOpen)
+			return ChannelBreakerStateOpen, true
+		}
+		if b.state != ChannelBreakerStateHalfOpen {
+			b.transition(now, ChannelBreakerStateHalfOpen)
+		}
+		b.halfOpenSuccess++
+		need := setting.HalfOpenSuccessCount
+		if need <= 0 {
+			need = 1
+		}
+		if b.halfOpenSuccess >= need {
+			b.transition(now, ChannelBreakerStateClosed)
+			return ChannelBreakerStateClosed, true
+		}
+		return "", false
+	}
+
+	b.addOutcome(now, failed)
+	if !failed {
+		return "", false
+	}
+	total, failures := b.windowCounts(now)
+	minRequests := setting.MinRequests
+	if minRequests <= 0 {
+		minRequests = 1
+	}
+	if total < minRequests {
+		return "", false
+	}
+	if float64(failures)*100/float64(total) >= setting.ErrorRateThreshold {
+		b.transition(now, ChannelBreakerStateOpen)
+		return ChannelBreakerStateOpen, true
+	}
+	return "", false
+}
+
+func recordChannelBreaker(channelId int, keyIndex int, failed bool, reason string) {
+	keys := []string{channelBreakerKey(channelId, -1)}
+	if keyIndex >= 0 {
+		keys = append(keys, channelBreakerKey(channelId, keyIndex))
+	}
+	now := time.Now()
+	for _, key := range keys {
+		state, changed := recordChannelBreakerOutcome(key, failed, reason)
+		if !changed {
+			continue
+		}
+		common.SysLog(fmt.Sprintf("channel breaker %s changed to %s, reason: %s", key, state, reason))
+		publishChannelBreakerState(key, state, now)
+	}
+}
+
+// isChannelBreakerFailure classifies a relay error. The second return value is false when the
+// error is caused by the client or by local processing and should not affect the breaker.
+func isChannelBreakerFailure(err *types.NewAPIError) (failed bool, counted bool) {
+	if err == nil {
+		return false, true
+	}
+	if types.IsChannelError(err) {
+		return true, true
+	}
+	if types.IsSkipRetryError(err) {
+		return false, false
+	}
+	code := err.StatusCode
+	switch {
+	case code < 100, code >= 500:
+		return true, true
+	case code == http.StatusUnauthorized, code == http.StatusForbidden,
+		code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true, true
+	}
+	return false, false
+}
+
+func channelBreakerKeyIndexFromContext(c *gin.Context) int {
+	if c == nil || !common.GetContextKeyBool(c, constant.ContextKeyChannelIsMultiKey) {
+		return -1
+	}
+	return common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+}
+
+// RecordChannelBreakerResult feeds the outcome of a relay attempt on the currently selected channel
+// (and multi-key index) into the circuit breaker.
+func RecordChannelBreakerResult(c *gin.Context, channelId int, err *types.NewAPIError) {
+	if channelId <= 0 || !operation_setting.GetChannelBreakerSetting().Enabled {
+		return
+	}
+	failed, counted := isChannelBreakerFailure(err)
+	if !counted {
+		return
+	}
+	reason := ""
+	if failed {
+		reason = err.MaskSensitiveErrorWithStatusCode()
+		if len(reason) > 200 {
+			reason = reason[:200]
+		}
+	}
+	recordChannelBreaker(channelId, channelBreakerKeyIndexFromContext(c), failed, reason)
+}
+
+// MarkChannelBreakerSelection remembers the breaker state of the selected channel so it can be
+// written into the consume log. Nothing is recorded while the breaker is closed.
+func MarkChannelBreakerSelection(c *gin.Context, channelId int) {
+	if c == nil || channelId <= 0 {
+		return
+	}
+	if !operation_setting.GetChannelBreakerSetting().Enabled {
+		c.Set(ginKeyChannelBreakerLogInfo, nil)
+		return
+	}
+	now := time.Now()
+	info := map[string]interface{}{}
+	if state := peekChannelBreakerState(channelBreakerKey(channelId, -1), now); state != ChannelBreakerStateClosed {
+		info["channel_id"] = channelId
+		info["state"] = state
+	}
+	if keyIndex := channelBreakerKeyIndexFromContext(c); keyIndex >= 0 {
+		if state := peekChannelBreakerState(channelBreakerKey(channelId, keyIndex), now); state != ChannelBreakerStateClosed {
+			info["channel_id"] = channelId
+			info["key_index"] = keyIndex
+			info["key_state"] = state
+		}
+	}
+	if len(info) == 0 {
+		c.Set(ginKeyChannelBreakerLogInfo, nil)
+		return
+	}
+	c.Set(ginKeyChannelBreakerLogInfo, info)
+}
+
+func peekChannelBreakerState(key string, now time.Time) string {
+	b := getChannelBreaker(key, false)
+	if b == nil {
+		return ChannelBreakerStateClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.effectiveState(now)
+}
+
+func appendChannelBreakerInfo(ctx *gin.Context, other map[string]interface{}) {
+	if ctx == nil || other == nil {
+		return
+	}
+	anyInfo, ok := ctx.Get(ginKeyChannelBreakerLogInfo)
+	if !ok || anyInfo == nil {
+		return
+	}
+	if info, ok := anyInfo.(map[string]interface{}); ok && len(info) > 0 {
+		other["circuit_breaker"] = info
+	}
+}
+
+// GetChannelBreakerSnapshots lists all known breakers, optionally filtered by channel id (0 = all).
+func GetChannelBreakerSnapshots(channelId int) []ChannelBreakerSnapshot {
+	now := time.Now()
+	openDuration := channelBreakerOpenDuration()
+	shared := channelBreakerShared()
+	snapshots := make([]ChannelBreakerSnapshot, 0)
+	channelBreakers.Range(func(k, v any) bool {
+		key := k.(string)
+		id, keyIndex := parseChannelBreakerKey(key)
+		if channelId > 0 && id != channelId {
+			return true
+		}
+		b := v.(*channelBreaker)
+		b.mu.Lock()
+		total, failures := b.windowCounts(now)
+		snapshot := ChannelBreakerSnapshot{
+			ChannelId:       id,
+			KeyIndex:        keyIndex,
+			State:           b.effectiveState(now),
+			Total:           total,
+			Failures:        failures,
+			HalfOpenSuccess: b.halfOpenSuccess,
+			LastFailure:     b.lastFailure,
+		}
+		if total > 0 {
+			snapshot.ErrorRate = float64(failures) * 100 / float64(total)
+		}
+		if !b.openedAt.IsZero() {
+			snapshot.OpenedAt = b.openedAt.Unix()
+			snapshot.HalfOpenAt = b.openedAt.Add(openDuration).Unix()
+		}
+		if !b.lastTransitionAt.IsZero() {
+			snapshot.LastTransitionAt = b.lastTransitionAt.Unix()
+		}
+		b.mu.Unlock()
+		if shared {
+			if openUntil := getChannelBreakerSharedOpenUntil(key, now); openUntil > now.UnixMilli() {
+				snapshot.SharedOpenUntil = openUntil / 1000
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+		return true
+	})
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].ChannelId != snapshots[j].ChannelId {
+			return snapshots[i].ChannelId < snapshots[j].ChannelId
+		}
+		if snapshots[i].KeyIndex == nil || snapshots[j].KeyIndex == nil {
+			return snapshots[i].KeyIndex == nil && snapshots[j].KeyIndex != nil
+		}
+		return *snapshots[i].KeyIndex < *snapshots[j].KeyIndex
+	})
+	return snapshots
+}
+
+// ResetChannelBreaker closes and forgets all breakers of a channel (including its keys).
+// The reset is broadcast through Redis so every node drops its local breakers as well.
+// It returns how many local breakers were removed.
+func ResetChannelBreaker(channelId int) int {
+	removed := resetLocalChannelBreaker(channelId)
+	if channelBreakerShared() {
+		cache := getChannelBreakerCache()
+		if _, err := cache.DeleteMany([]string{channelBreakerKey(channelId, -1)}); err != nil {
+			common.SysError(fmt.Sprintf("channel breaker cache delete failed: channel_id=%d, err=%v", channelId, err))
+		}
+		if _, err := cache.DeleteByPrefix(strconv.Itoa(channelId)); err != nil {
+			common.SysError(fmt.Sprintf("channel breaker cache delete failed: channel_id=%d, err=%v", channelId, err))
+		}
+	}
+	if common.RedisEnabled && common.RDB != nil {
+		if err := common.RDB.Publish(context.Background(), channelBreakerResetTopic, strconv.Itoa(channelId)).Err(); err != nil {
+			common.SysError(fmt.Sprintf("channel breaker reset broadcast failed: channel_id=%d, err=%v", channelId, err))
+		}
+	}
+	return removed
+}
+
+func resetLocalChannelBreaker(channelId int) int {
+	keys := make([]string, 0)
+	channelBreakers.Range(func(k, _ any) bool {
+		key := k.(string)
+		if id, _ := parseChannelBreakerKey(key); id == channelId {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		channelBreakers.Delete(key)
+		channelBreakerRemoteStates.Delete(key)
+	}
+	return len(keys)
+}
+
+// StartChannelBreakerResetListener subscribes to breaker resets issued on other nodes.
+func StartChannelBreakerResetListener() {
+	channelBreakerResetOnce.Do(func() {
+		if !common.RedisEnabled || common.RDB == nil {
+			return
+		}
+		gopool.Go(func() {
+			for {
+				listenChannelBreakerResets()
+				time.Sleep(5 * time.Second)
+			}
+		})
+	})
+}
+
+func listenChannelBreakerResets() {
+	pubsub := common.RDB.Subscribe(context.Background(), channelBreakerResetTopic)
+	defer pubsub.Close()
+	for msg := range pubsub.Channel() {
+		channelId, err := strconv.Atoi(msg.Payload)
+		if err != nil || channelId <= 0 {
+			continue
+		}
+		resetLocalChannelBreaker(channelId)
+	}
+	common.SysError("channel breaker reset subscription closed, resubscribing")
+}
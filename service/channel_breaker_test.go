@@ -0,0 +1,126 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func withChannelBreakerSetting(t *testing.T, setting operation_setting.ChannelBreakerSetting) {
+	t.Helper()
+	current := operation_setting.GetChannelBreakerSetting()
+	saved := *current
+	*current = setting
+	t.Cleanup(func() {
+		*current = saved
+	})
+}
+
+func TestChannelBreakerOpensAfterErrorRate(t *testing.T) {
+	withChannelBreakerSetting(t, operation_setting.ChannelBreakerSetting{
+		Enabled:              true,
+		WindowSeconds:        60,
+		MinRequests:          4,
+		ErrorRateThreshold:   50,
+		OpenSeconds:          60,
+		HalfOpenProbeRatio:   1,
+		HalfOpenSuccessCount: 1,
+	})
+	channelId := 910001
+	t.Cleanup(func() { ResetChannelBreaker(channelId) })
+
+	recordChannelBreaker(channelId, -1, false, "")
+	recordChannelBreaker(channelId, -1, true, "boom")
+	recordChannelBreaker(channelId, -1, false, "")
+	require.True(t, allowChannelBreaker(channelBreakerKey(channelId, -1)), "below min requests the breaker stays closed")
+
+	recordChannelBreaker(channelId, -1, true, "boom")
+	require.False(t, allowChannelBreaker(channelBreakerKey(channelId, -1)))
+
+	snapshots := GetChannelBreakerSnapshots(channelId)
+	require.Len(t, snapshots, 1)
+	require.Equal(t, ChannelBreakerStateOpen, snapshots[0].State)
+	require.Equal(t, "boom", snapshots[0].LastFailure)
+}
+
+func TestChannelBreakerHalfOpenProbeClosesOnSuccess(t *testing.T) {
+	withChannelBreakerSetting(t, operation_setting.ChannelBreakerSetting{
+		Enabled:              true,
+		WindowSeconds:        60,
+		MinRequests:          1,
+		ErrorRateThreshold:   50,
+		OpenSeconds:          60,
+		HalfOpenProbeRatio:   1,
+		HalfOpenSuccessCount: 2,
+	})
+	channelId := 910002
+	key := channelBreakerKey(channelId, 3)
+	t.Cleanup(func() { ResetChannelBreaker(channelId) })
+
+	recordChannelBreaker(channelId, 3, true, "boom")
+	require.False(t, allowChannelBreaker(key))
+
+	// pretend the open period elapsed
+	b := getChannelBreaker(key, false)
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-2 * time.Minute)
+	b.mu.Unlock()
+
+	require.True(t, allowChannelBreaker(key))
+	require.Equal(t, ChannelBreakerStateHalfOpen, peekChannelBreakerState(key, time.Now()))
+
+	recordChannelBreaker(channelId, 3, false, "")
+	require.Equal(t, ChannelBreakerStateHalfOpen, peekChannelBreakerState(key, time.Now()))
+	recordChannelBreaker(channelId, 3, false, "")
+	require.Equal(t, ChannelBreakerStateClosed, peekChannelBreakerState(key, time.Now()))
+}
+
+func TestChannelBreakerHalfOpenFailureReopens(t *testing.T) {
+	withChannelBreakerSetting(t, operation_setting.ChannelBreakerSetting{
+		Enabled:              true,
+		WindowSeconds:        60,
+		MinRequests:          1,
+		ErrorRateThreshold:   50,
+		OpenSeconds:          60,
+		HalfOpenProbeRatio:   1,
+		HalfOpenSuccessCount: 1,
+	})
+	channelId := 910003
+	key := channelBreakerKey(channelId, -1)
+	t.Cleanup(func() { ResetChannelBreaker(channelId) })
+
+	recordChannelBreaker(channelId, -1, true, "boom")
+	b := getChannelBreaker(key, false)
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-2 * time.Minute)
+	b.mu.Unlock()
+	require.Equal(t, ChannelBreakerStateHalfOpen, peekChannelBreakerState(key, time.Now()))
+
+	recordChannelBreaker(channelId, -1, true, "boom again")
+	require.Equal(t, ChannelBreakerStateOpen, peekChannelBreakerState(key, time.Now()))
+}
+
+func TestIsChannelBreakerFailure(t *testing.T) {
+	failed, counted := isChannelBreakerFailure(nil)
+	require.False(t, failed)
+	require.True(t, counted)
+
+	failed, counted = isChannelBreakerFailure(types.NewOpenAIError(errors.New("upstream"), types.ErrorCodeBadResponseStatusCode, http.StatusBadGateway))
+	require.True(t, failed)
+	require.True(t, counted)
+
+	failed, counted = isChannelBreakerFailure(types.NewOpenAIError(errors.New("rate limited"), types.ErrorCodeBadResponseStatusCode, http.StatusTooManyRequests))
+	require.True(t, failed)
+	require.True(t, counted)
+
+	_, counted = isChannelBreakerFailure(types.NewOpenAIError(errors.New("bad request"), types.ErrorCodeBadResponseStatusCode, http.StatusBadRequest))
+	require.False(t, counted)
+
+	_, counted = isChannelBreakerFailure(types.NewErrorWithStatusCode(errors.New("too large"), types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry()))
+	require.False(t, counted)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	invoiceTickInterval     = 1 * time.Hour
+	invoiceSuspendBatchSize = 100
+)
+
+var (
+	invoiceTaskOnce    sync.Once
+	invoiceTaskRunning atomic.Bool
+)
+
+// InvoicePeriod 返回 year 年 month 月的账期 [start, end)，按服务器时区划分
+func InvoicePeriod(year int, month time.Month) (time.Time, time.Time) {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// GenerateMonthlyInvoices 为后付费用户生成指定账期的账单，userIds 为空时处理所有设置了信用额度的用户。
+// 已生成的账单不会重复生成，返回新生成的账单数
+func GenerateMonthlyInvoices(periodStart time.Time, periodEnd time.Time, userIds []int) (int, error) {
+	if len(userIds) == 0 {
+		ids, err := model.GetPostpaidUserIds()
+		if err != nil {
+			return 0, err
+		}
+		userIds = ids
+	}
+	dueAt := time.Now().AddDate(0, 0, operation_setting.GetInvoiceSetting().GetDueDays()).Unix()
+	created := 0
+	for _, userId := range userIds {
+		_, isNew, err := model.GenerateInvoice(userId, periodStart.Unix(), periodEnd.Unix(), dueAt)
+		if err != nil {
+			return created, fmt.Errorf("generate invoice for user %d failed: %w", userId, err)
+		}
+		if isNew {
+			created++
+		}
+	}
+	return created, nil
+}
+
+// StartInvoiceTask 主节点定期生成上月账单、标记逾期账单并停用逾期超过宽限期的用户
+func StartInvoiceTask() {
+	invoiceTaskOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			logger.LogInfo(context.Background(), fmt.Sprintf("invoice task started: tick=%s", invoiceTickInterval))
+			ticker := time.NewTicker(invoiceTickInterval)
+			defer ticker.Stop()
+
+			runInvoiceTaskOnce(time.Now())
+			for range ticker.C {
+				runInvoiceTaskOnce(time.Now())
+			}
+		})
+	})
+}
+
+func runInvoiceTaskOnce(now time.Time) {
+	setting := operation_setting.GetInvoiceSetting()
+	if !setting.Enabled {
+		return
+	}
+	if !invoiceTaskRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer invoiceTaskRunning.Store(false)
+
+	ctx := context.Background()
+	lastMonth := now.AddDate(0, 0, -now.Day()+1).AddDate(0, -1, 0)
+	periodStart, periodEnd := InvoicePeriod(lastMonth.Year(), lastMonth.Month())
+	created, err := GenerateMonthlyInvoices(periodStart, periodEnd, nil)
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("invoice generate task failed: %v", err))
+	}
+	if created > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("invoice task: generated %d invoices for %s", created, periodStart.Format("2006-01")))
+	}
+
+	overdue, err := model.MarkOverdueInvoices(now.Unix())
+	if err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("invoice overdue task failed: %v", err))
+		return
+	}
+	if overdue > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("invoice task: %d invoices overdue", overdue))
+	}
+
+	if !setting.AutoSuspend {
+		return
+	}
+	deadline := now.AddDate(0, 0, -setting.GetGraceDays()).Unix()
+	for {
+		n, err := model.SuspendOverdueInvoiceUsers(deadline, invoiceSuspendBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("invoice suspend task failed: %v", err))
+			return
+		}
+		if n > 0 {
+			logger.LogInfo(ctx, fmt.Sprintf("invoice task: suspended users for %d overdue invoices", n))
+		}
+		if n < invoiceSuspendBatchSize {
+			break
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletBillingAllowsOverdraftUpToCreditLimit(t *testing.T) {
+	truncate(t)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	const userId, tokenId = 1, 1
+	seedUser(t, userId, 100)
+	seedToken(t, tokenId, userId, "postpaid-key", 10000)
+
+	newRelayInfo := func(creditLimit int) *relaycommon.RelayInfo {
+		info := &relaycommon.RelayInfo{
+			UserId:          userId,
+			TokenId:         tokenId,
+			TokenKey:        "postpaid-key",
+			RequestId:       "req_postpaid",
+			OriginModelName: "test-model",
+			UserCreditLimit: creditLimit,
+		}
+		info.UserSetting.BillingPreference = "wallet_only"
+		return info
+	}
+
+	_, apiErr := NewBillingSession(c, newRelayInfo(0), 300)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, types.ErrorCodeInsufficientUserQuota, apiErr.GetErrorCode())
+
+	session, apiErr := NewBillingSession(c, newRelayInfo(500), 300)
+	require.Nil(t, apiErr)
+	require.NoError(t, session.Settle(400))
+	quota, err := model.GetUserQuota(userId, true)
+	require.NoError(t, err)
+	assert.Equal(t, -300, quota)
+
+	// 透支已接近信用额度
+	_, apiErr = NewBillingSession(c, newRelayInfo(500), 300)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, types.ErrorCodeInsufficientUserQuota, apiErr.GetErrorCode())
+}
+
+func TestInvoicePeriod(t *testing.T) {
+	start, end := InvoicePeriod(2026, time.December)
+	assert.Equal(t, time.Date(2026, time.December, 1, 0, 0, 0, 0, time.Local), start)
+	assert.Equal(t, time.Date(2027, time.January, 1, 0, 0, 0, 0, time.Local), end)
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	logExportBatchSize       = 1000
+	logExportCleanupInterval = 30 * time.Minute
+)
+
+var (
+	logExportSemaphore     chan struct{}
+	logExportSemaphoreOnce sync.Once
+	logExportCleanupOnce   sync.Once
+
+	errLogExportMaxRows = errors.New("max rows reached")
+)
+
+var logExportCSVHeader = []string{
+	"id", "created_at", "time", "type", "user_id", "username", "token_id", "token_name", "model_name",
+	"quota", "prompt_tokens", "completion_tokens", "use_time", "is_stream", "channel", "channel_name",
+	"group", "ip", "request_id", "org_id", "content", "other",
+}
+
+// CreateLogExportJob 创建导出任务并在后台执行，userId 为任务所属用户，allUsers 时导出全部用户的日志
+func CreateLogExportJob(userId int, allUsers bool, format string, filter model.LogQueryFilter) (*model.LogExportJob, error) {
+	if format != model.LogExportFormatCSV && format != model.LogExportFormatJSONL {
+		return nil, fmt.Errorf("不支持的导出格式: %s", format)
+	}
+	if !allUsers {
+		// 普通用户只能导出自己的日志
+		filter.Username = ""
+		filter.Channel = 0
+	}
+	filterStr, err := common.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	job := &model.LogExportJob{
+		UserId:   userId,
+		AllUsers: allUsers,
+		Format:   format,
+		Filter:   string(filterStr),
+		// 任务中断（如节点重启）时仍能按过期时间清理
+		ExpiresAt: logExportExpiresAt(),
+	}
+	if err := model.CreateLogExportJob(job); err != nil {
+		return nil, err
+	}
+	gopool.Go(func() {
+		runLogExportJob(job)
+	})
+	return job, nil
+}
+
+func logExportExpiresAt() int64 {
+	hours := operation_setting.GetLogExportSetting().GetFileRetentionHours()
+	return time.Now().Add(time.Duration(hours) * time.Hour).Unix()
+}
+
+func acquireLogExportSlot() func() {
+	logExportSemaphoreOnce.Do(func() {
+		logExportSemaphore = make(chan struct{}, operation_setting.GetLogExportSetting().GetMaxConcurrentJobs())
+	})
+	logExportSemaphore <- struct{}{}
+	return func() { <-logExportSemaphore }
+}
+
+func runLogExportJob(job *model.LogExportJob) {
+	release := acquireLogExportSlot()
+	defer release()
+
+	ctx := context.Background()
+	started, err := model.MarkLogExportJobRunning(job.Id)
+	if err != nil || !started {
+		return
+	}
+	job.Status = model.LogExportStatusRunning
+
+	err = writeLogExportFile(job)
+	if err != nil {
+		job.Status = model.LogExportStatusFailed
+		job.Error = err.Error()
+		logger.LogWarn(ctx, fmt.Sprintf("log export job %d failed: %v", job.Id, err))
+	} else {
+		job.Status = model.LogExportStatusSucceeded
+	}
+	job.ExpiresAt = logExportExpiresAt()
+	if err := model.FinishLogExportJob(job); err != nil {
+		logger.LogError(ctx, fmt.Sprintf("failed to update log export job %d: %v", job.Id, err))
+	}
+}
+
+// writeLogExportFile 将日志流式写入磁盘文件，成功时设置 job 的文件路径、大小与行数
+func writeLogExportFile(job *model.LogExportJob) (err error) {
+	path, file, err := common.CreatePersistentDiskCacheFile(common.DiskCacheTypeLogExport, "."+job.Format)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(path)
+		}
+	}()
+
+	buffered := bufio.NewWriterSize(file, 64<<10)
+	var csvWriter *csv.Writer
+	if job.Format == model.LogExportFormatCSV {
+		csvWriter = csv.NewWriter(buffered)
+		if err = csvWriter.Write(logExportCSVHeader); err != nil {
+			return err
+		}
+	}
+
+	maxRows := operation_setting.GetLogExportSetting().MaxRows
+	scopeUserId := job.UserId
+	if job.AllUsers {
+		scopeUserId = 0
+	}
+	var rows int64
+	err = model.IterateLogs(job.GetFilter(), scopeUserId, logExportBatchSize, func(logs []*model.Log) error {
+		for _, log := range logs {
+			if maxRows > 0 && rows >= maxRows {
+				return errLogExportMaxRows
+			}
+			if csvWriter != nil {
+				if err := csvWriter.Write(logToCSVRecord(log)); err != nil {
+					return err
+				}
+			} else {
+				line, err := common.Marshal(log)
+				if err != nil {
+					return err
+				}
+				buffered.Write(line)
+				if err := buffered.WriteByte('\n'); err != nil {
+					return err
+				}
+			}
+			rows++
+		}
+		return nil
+	})
+	if errors.Is(err, errLogExportMaxRows) {
+		job.Error = fmt.Sprintf("已达到单次导出的最大行数 %d，结果不完整，请缩小时间范围后分批导出", maxRows)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+		if err = csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+	if err = buffered.Flush(); err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	job.StoragePath = path
+	job.FileSize = info.Size()
+	job.RowCount = rows
+	return nil
+}
+
+func logToCSVRecord(log *model.Log) []string {
+	return []string{
+		strconv.Itoa(log.Id),
+		strconv.FormatInt(log.CreatedAt, 10),
+		time.Unix(log.CreatedAt, 0).UTC().Format(time.RFC3339),
+		strconv.Itoa(log.Type),
+		strconv.Itoa(log.UserId),
+		log.Username,
+		strconv.Itoa(log.TokenId),
+		log.TokenName,
+		log.ModelName,
+		strconv.Itoa(log.Quota),
+		strconv.Itoa(log.PromptTokens),
+		strconv.Itoa(log.CompletionTokens),
+		strconv.Itoa(log.UseTime),
+		strconv.FormatBool(log.IsStream),
+		strconv.Itoa(log.ChannelId),
+		log.ChannelName,
+		log.Group,
+		log.Ip,
+		log.RequestId,
+		strconv.Itoa(log.OrgId),
+		log.Content,
+		log.Other,
+	}
+}
+
+// StartLogExportCleanupTask 定期清理过期的导出任务：主节点删除任务记录，所有节点清理本机磁盘上的导出文件
+func StartLogExportCleanupTask() {
+	logExportCleanupOnce.Do(func() {
+		gopool.Go(func() {
+			ticker := time.NewTicker(logExportCleanupInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				runLogExportCleanupOnce()
+			}
+		})
+	})
+}
+
+func runLogExportCleanupOnce() {
+	ctx := context.Background()
+	retention := time.Duration(operation_setting.GetLogExportSetting().GetFileRetentionHours()) * time.Hour
+	if removed, err := common.CleanupPersistentDiskCacheFiles(common.DiskCacheTypeLogExport, retention); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("log export file cleanup failed: %v", err))
+	} else if removed > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("log export file cleanup: removed %d files", removed))
+	}
+	if !common.IsMasterNode {
+		return
+	}
+	if n, err := model.DeleteExpiredLogExportJobs(); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("log export job cleanup failed: %v", err))
+	} else if n > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("log export job cleanup: deleted %d jobs", n))
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedExportLogs(t *testing.T) {
+	t.Helper()
+	logs := []*model.Log{
+		{UserId: 1, Username: "alice", Type: model.LogTypeConsume, ModelName: "gpt-4o", Quota: 100, CreatedAt: 1000, RequestId: "req-1"},
+		{UserId: 2, Username: "bob", Type: model.LogTypeConsume, ModelName: "gpt-4o", Quota: 200, CreatedAt: 2000, RequestId: "req-2"},
+		{UserId: 1, Username: "alice", Type: model.LogTypeTopup, Quota: 300, CreatedAt: 3000},
+		{UserId: 1, Username: "alice", Type: model.LogTypeConsume, ModelName: "claude", Quota: 400, CreatedAt: 4000, RequestId: "req-4",
+			Other: `{"admin_info":{"use_channel":[1]}}`},
+	}
+	require.NoError(t, model.LOG_DB.Create(&logs).Error)
+}
+
+func TestWriteLogExportFileAppliesFiltersAndUserScope(t *testing.T) {
+	truncate(t)
+	seedExportLogs(t)
+
+	job := &model.LogExportJob{
+		UserId: 1,
+		Format: model.LogExportFormatCSV,
+		Filter: `{"type":2}`,
+	}
+	require.NoError(t, writeLogExportFile(job))
+	t.Cleanup(func() { os.Remove(job.StoragePath) })
+
+	file, err := os.Open(job.StoragePath)
+	require.NoError(t, err)
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+
+	require.Len(t, records, 3)
+	assert.Equal(t, logExportCSVHeader, records[0])
+	assert.Equal(t, int64(2), job.RowCount)
+	assert.Equal(t, "req-1", records[1][18])
+	assert.Equal(t, "req-4", records[2][18])
+	// 用户导出不包含仅管理员可见的字段
+	assert.NotContains(t, records[2][21], "admin_info")
+}
+
+func TestWriteLogExportFileJSONLForAllUsers(t *testing.T) {
+	truncate(t)
+	seedExportLogs(t)
+
+	job := &model.LogExportJob{
+		UserId:   99,
+		AllUsers: true,
+		Format:   model.LogExportFormatJSONL,
+		Filter:   `{"type":2,"model_name":"gpt-4o"}`,
+	}
+	require.NoError(t, writeLogExportFile(job))
+	t.Cleanup(func() { os.Remove(job.StoragePath) })
+
+	file, err := os.Open(job.StoragePath)
+	require.NoError(t, err)
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"username":"alice"`)
+	assert.Contains(t, lines[1], `"username":"bob"`)
+}
+
+func TestFlushLogSinkShipsNewLogsAndAdvancesCursor(t *testing.T) {
+	truncate(t)
+	t.Cleanup(func() { model.DB.Exec("DELETE FROM log_sink_states") })
+
+	var (
+		mu       sync.Mutex
+		received []string
+		fail     bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+	}))
+	defer server.Close()
+
+	setting := &operation_setting.LogSinkSetting{
+		Enabled:   true,
+		Type:      operation_setting.LogSinkTypeHTTP,
+		Endpoint:  server.URL,
+		LogTypes:  []int{model.LogTypeConsume},
+		BatchSize: 1,
+	}
+
+	// 首次启用时不推送已有日志
+	require.NoError(t, model.LOG_DB.Create(&model.Log{UserId: 1, Type: model.LogTypeConsume, RequestId: "old"}).Error)
+	require.NoError(t, flushLogSinkOnce(context.Background(), setting))
+	assert.Empty(t, received)
+
+	seedExportLogs(t)
+	require.NoError(t, flushLogSinkOnce(context.Background(), setting))
+	require.Len(t, received, 3)
+	assert.Contains(t, received[0], `"request_id":"req-1"`)
+	assert.Contains(t, received[2], `"request_id":"req-4"`)
+
+	// 推送失败时不推进进度，下次重试
+	require.NoError(t, model.LOG_DB.Create(&model.Log{UserId: 1, Type: model.LogTypeConsume, RequestId: "req-5"}).Error)
+	mu.Lock()
+	fail = true
+	mu.Unlock()
+	require.Error(t, flushLogSinkOnce(context.Background(), setting))
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	require.NoError(t, flushLogSinkOnce(context.Background(), setting))
+	require.Len(t, received, 4)
+	assert.Contains(t, received[3], `"request_id":"req-5"`)
+}
+
+func TestS3LogSinkSignsPathStylePut(t *testing.T) {
+	var gotPath, gotAuth, gotMethod string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath, gotAuth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	sink, err := newLogSink(&operation_setting.LogSinkSetting{
+		Type:              operation_setting.LogSinkTypeS3,
+		Endpoint:          server.URL,
+		S3Bucket:          "logs",
+		S3Region:          "us-east-1",
+		S3Prefix:          "new-api/",
+		S3AccessKeyId:     "ak",
+		S3SecretAccessKey: "sk",
+	})
+	require.NoError(t, err)
+	logs := []*model.Log{{Id: 7, CreatedAt: 86400}, {Id: 9, CreatedAt: 86401}}
+	require.NoError(t, sink.Send(context.Background(), logs))
+
+	assert.Equal(t, http.MethodPut, gotMethod)
+	assert.Equal(t, "/logs/new-api/1970/01/02/7-9.jsonl", gotPath)
+	assert.True(t, strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=ak/"))
+}
@@ -73,6 +73,10 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 	AppendChannelAffinityAdminInfo(ctx, adminInfo)
 
 	other["admin_info"] = adminInfo
+	appendChannelBreakerInfo(ctx, other)
+	appendBatchInfo(ctx, other)
+	appendResponseCacheInfo(ctx, other)
+	appendVirtualModelInfo(ctx, other)
 	appendRequestPath(ctx, relayInfo, other)
 	appendRequestConversionChain(relayInfo, other)
 	appendFinalRequestFormat(relayInfo, other)
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	logSinkCursorName = "default"
+	// logSinkMaxBatchesPerFlush 每次推送最多发送的批次数，避免积压时长时间占用
+	logSinkMaxBatchesPerFlush = 20
+)
+
+var (
+	logSinkOnce       sync.Once
+	logSinkHttpClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// LogSink 日志推送目标，Send 失败时整批在下次推送时重试，接收方需按日志 id 去重
+type LogSink interface {
+	Send(ctx context.Context, logs []*model.Log) error
+}
+
+func newLogSink(setting *operation_setting.LogSinkSetting) (LogSink, error) {
+	switch setting.Type {
+	case operation_setting.LogSinkTypeHTTP, "":
+		if setting.Endpoint == "" {
+			return nil, errors.New("log sink endpoint is empty")
+		}
+		return &httpLogSink{endpoint: setting.Endpoint, headers: setting.Headers}, nil
+	case operation_setting.LogSinkTypeKafkaRest:
+		if setting.Endpoint == "" || setting.KafkaTopic == "" {
+			return nil, errors.New("log sink endpoint or kafka topic is empty")
+		}
+		return &kafkaRestLogSink{endpoint: setting.Endpoint, topic: setting.KafkaTopic, headers: setting.Headers}, nil
+	case operation_setting.LogSinkTypeS3:
+		if setting.S3Bucket == "" || setting.S3AccessKeyId == "" || setting.S3SecretAccessKey == "" {
+			return nil, errors.New("log sink s3 bucket or credentials are empty")
+		}
+		endpoint := setting.Endpoint
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", setting.S3Region)
+		}
+		return &s3LogSink{
+			endpoint:        endpoint,
+			bucket:          setting.S3Bucket,
+			region:          setting.S3Region,
+			prefix:          setting.S3Prefix,
+			accessKeyId:     setting.S3AccessKeyId,
+			secretAccessKey: setting.S3SecretAccessKey,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log sink type: %s", setting.Type)
+	}
+}
+
+func encodeLogsJSONL(logs []*model.Log) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, log := range logs {
+		line, err := common.Marshal(log)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func doLogSinkRequest(req *http.Request) error {
+	resp, err := logSinkHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("log sink responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// httpLogSink 以 JSONL 格式 POST 到 HTTP 接口
+type httpLogSink struct {
+	endpoint string
+	headers  map[string]string
+}
+
+func (s *httpLogSink) Send(ctx context.Context, logs []*model.Log) error {
+	body, err := encodeLogsJSONL(logs)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	return doLogSinkRequest(req)
+}
+
+// kafkaRestLogSink 通过 Kafka REST Proxy（v2 API）写入 topic，以 request_id 作为消息 key
+type kafkaRestLogSink struct {
+	endpoint string
+	topic    string
+	headers  map[string]string
+}
+
+type kafkaRestRecord struct {
+	Key   string     `json:"key,omitempty"`
+	Value *model.Log `json:"value"`
+}
+
+func (s *kafkaRestLogSink) Send(ctx context.Context, logs []*model.Log) error {
+	records := make([]kafkaRestRecord, 0, len(logs))
+	for _, log := range logs {
+		records = append(records, kafkaRestRecord{Key: log.RequestId, Value: log})
+	}
+	body, err := common.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return err
+	}
+	target := strings.TrimRight(s.endpoint, "/") + "/topics/" + url.PathEscape(s.topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	return doLogSinkRequest(req)
+}
+
+// s3LogSink 每批写入一个 JSONL 对象，对象名包含日志 id 范围，重试时覆盖同一对象
+type s3LogSink struct {
+	endpoint        string
+	bucket          string
+	region          string
+	prefix          string
+	accessKeyId     string
+	secretAccessKey string
+}
+
+func (s *s3LogSink) objectKey(logs []*model.Log) string {
+	first, last := logs[0], logs[len(logs)-1]
+	day := time.Unix(first.CreatedAt, 0).UTC().Format("2006/01/02")
+	return fmt.Sprintf("%s%s/%d-%d.jsonl", s.prefix, day, first.Id, last.Id)
+}
+
+func (s *s3LogSink) Send(ctx context.Context, logs []*model.Log) error {
+	body, err := encodeLogsJSONL(logs)
+	if err != nil {
+		return err
+	}
+	// 使用 path-style 地址，兼容 MinIO 等自建对象存储
+	target := strings.TrimRight(s.endpoint, "/") + "/" + s.bucket + "/" + s.objectKey(logs)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	credentials := aws.Credentials{AccessKeyID: s.accessKeyId, SecretAccessKey: s.secretAccessKey}
+	if err := v4.NewSigner().SignHTTP(ctx, credentials, req, payloadHash, "s3", s.region, time.Now()); err != nil {
+		return err
+	}
+	return doLogSinkRequest(req)
+}
+
+// StartLogSinkTask 主节点按日志 id 顺序把新日志推送到外部系统，推送进度保存在数据库中，切换主节点后继续推送
+func StartLogSinkTask() {
+	logSinkOnce.Do(func() {
+		if !common.IsMasterNode {
+			return
+		}
+		gopool.Go(func() {
+			for {
+				setting := operation_setting.GetLogSinkSetting()
+				time.Sleep(time.Duration(setting.GetFlushInterval()) * time.Second)
+				if !setting.Enabled {
+					continue
+				}
+				if err := flushLogSinkOnce(context.Background(), setting); err != nil {
+					logger.LogWarn(context.Background(), fmt.Sprintf("log sink flush failed: %v", err))
+				}
+			}
+		})
+	})
+}
+
+func flushLogSinkOnce(ctx context.Context, setting *operation_setting.LogSinkSetting) error {
+	sink, err := newLogSink(setting)
+	if err != nil {
+		return err
+	}
+	cursor, err := model.GetLogSinkCursor(logSinkCursorName)
+	if err != nil {
+		return err
+	}
+	batchSize := setting.GetBatchSize()
+	for i := 0; i < logSinkMaxBatchesPerFlush; i++ {
+		logs, err := model.GetLogsAfterId(cursor, setting.LogTypes, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+		if err := sink.Send(ctx, logs); err != nil {
+			return err
+		}
+		cursor = logs[len(logs)-1].Id
+		if err := model.SaveLogSinkCursor(logSinkCursorName, cursor); err != nil {
+			return err
+		}
+		if len(logs) < batchSize {
+			return nil
+		}
+	}
+	return nil
+}
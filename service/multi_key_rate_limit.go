@@ -0,0 +1,121 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+)
+
+const (
+	// 429 响应未给出恢复时间时的默认冷却时长
+	multiKeyDefaultCooldown = 60 * time.Second
+	// 冷却时长上限，避免异常的响应头使 key 长时间不可用
+	multiKeyMaxCooldown = time.Hour
+)
+
+// rateLimitDimension 一组限流响应头，如 requests、tokens
+type rateLimitDimension struct {
+	limit     string
+	remaining string
+	reset     string
+}
+
+var rateLimitDimensions = []rateLimitDimension{
+	{"x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"},
+	{"x-ratelimit-limit-tokens", "x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},
+	{"anthropic-ratelimit-requests-limit", "anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"},
+	{"anthropic-ratelimit-tokens-limit", "anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"},
+	{"anthropic-ratelimit-input-tokens-limit", "anthropic-ratelimit-input-tokens-remaining", "anthropic-ratelimit-input-tokens-reset"},
+	{"anthropic-ratelimit-output-tokens-limit", "anthropic-ratelimit-output-tokens-remaining", "anthropic-ratelimit-output-tokens-reset"},
+}
+
+// ParseMultiKeyRateLimit 从上游响应头解析限流余量与冷却时间。
+// 余量取各维度 remaining/limit 的最小值；某一维度耗尽或返回 429 时冷却到对应的重置时间。
+func ParseMultiKeyRateLimit(statusCode int, header http.Header, now time.Time) model.MultiKeyRateLimitState {
+	state := model.MultiKeyRateLimitState{Headroom: -1}
+	var cooldownUntil time.Time
+	for _, dimension := range rateLimitDimensions {
+		limit, err := strconv.ParseFloat(strings.TrimSpace(header.Get(dimension.limit)), 64)
+		if err != nil || limit <= 0 {
+			continue
+		}
+		remaining, err := strconv.ParseFloat(strings.TrimSpace(header.Get(dimension.remaining)), 64)
+		if err != nil {
+			continue
+		}
+		headroom := min(max(remaining/limit, 0), 1)
+		if state.Headroom < 0 || headroom < state.Headroom {
+			state.Headroom = headroom
+		}
+		if remaining <= 0 {
+			if resetAt, ok := parseRateLimitReset(header.Get(dimension.reset), now); ok && resetAt.After(cooldownUntil) {
+				cooldownUntil = resetAt
+			}
+		}
+	}
+	if statusCode == http.StatusTooManyRequests {
+		state.Headroom = 0
+		if retryAt, ok := parseRetryAfter(header.Get("retry-after"), now); ok && retryAt.After(cooldownUntil) {
+			cooldownUntil = retryAt
+		}
+		if !cooldownUntil.After(now) {
+			cooldownUntil = now.Add(multiKeyDefaultCooldown)
+		}
+	}
+	if cooldownUntil.After(now) {
+		if maxUntil := now.Add(multiKeyMaxCooldown); cooldownUntil.After(maxUntil) {
+			cooldownUntil = maxUntil
+		}
+		// 向上取整到秒，避免在重置前的最后一秒重新选中
+		state.CooldownUntil = cooldownUntil.Add(time.Second - 1).Unix()
+	}
+	return state
+}
+
+// parseRateLimitReset 解析重置时间，支持 OpenAI 的时长格式（如 1s、6m0s、20ms）与 Anthropic 的 RFC 3339 时间
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(duration), true
+	}
+	if resetAt, err := time.Parse(time.RFC3339, value); err == nil {
+		return resetAt, true
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second))), true
+	}
+	return time.Time{}, false
+}
+
+// parseRetryAfter 解析 retry-after，支持秒数与 HTTP 日期
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return now.Add(time.Duration(seconds * float64(time.Second))), true
+	}
+	if retryAt, err := http.ParseTime(value); err == nil {
+		return retryAt, true
+	}
+	return time.Time{}, false
+}
+
+// RecordMultiKeyRateLimit 根据上游响应更新多 key 渠道中当前 key 的调度状态
+func RecordMultiKeyRateLimit(channelId int, keyIndex int, resp *http.Response) {
+	if channelId <= 0 || resp == nil {
+		return
+	}
+	state := ParseMultiKeyRateLimit(resp.StatusCode, resp.Header, time.Now())
+	if state.Headroom < 0 && state.CooldownUntil == 0 {
+		return
+	}
+	model.UpdateMultiKeyRateLimit(channelId, keyIndex, state)
+}
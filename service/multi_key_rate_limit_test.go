@@ -0,0 +1,62 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMultiKeyRateLimitOpenAIHeaders(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	header := http.Header{}
+	header.Set("x-ratelimit-limit-requests", "100")
+	header.Set("x-ratelimit-remaining-requests", "80")
+	header.Set("x-ratelimit-limit-tokens", "10000")
+	header.Set("x-ratelimit-remaining-tokens", "2500")
+
+	state := ParseMultiKeyRateLimit(http.StatusOK, header, now)
+	assert.InDelta(t, 0.25, state.Headroom, 1e-9)
+	assert.Zero(t, state.CooldownUntil)
+
+	header.Set("x-ratelimit-remaining-tokens", "0")
+	header.Set("x-ratelimit-reset-tokens", "6m0s")
+	state = ParseMultiKeyRateLimit(http.StatusOK, header, now)
+	assert.Zero(t, state.Headroom)
+	assert.Equal(t, now.Add(6*time.Minute).Unix(), state.CooldownUntil)
+}
+
+func TestParseMultiKeyRateLimitAnthropicHeaders(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0).UTC()
+	header := http.Header{}
+	header.Set("anthropic-ratelimit-requests-limit", "50")
+	header.Set("anthropic-ratelimit-requests-remaining", "0")
+	header.Set("anthropic-ratelimit-requests-reset", now.Add(30*time.Second).Format(time.RFC3339))
+
+	state := ParseMultiKeyRateLimit(http.StatusOK, header, now)
+	assert.Zero(t, state.Headroom)
+	assert.Equal(t, now.Add(30*time.Second).Unix(), state.CooldownUntil)
+}
+
+func TestParseMultiKeyRateLimitTooManyRequests(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	header := http.Header{}
+	header.Set("retry-after", "12")
+	state := ParseMultiKeyRateLimit(http.StatusTooManyRequests, header, now)
+	assert.Equal(t, now.Add(12*time.Second).Unix(), state.CooldownUntil)
+
+	state = ParseMultiKeyRateLimit(http.StatusTooManyRequests, http.Header{}, now)
+	assert.Equal(t, now.Add(multiKeyDefaultCooldown).Unix(), state.CooldownUntil)
+
+	header.Set("retry-after", "86400")
+	state = ParseMultiKeyRateLimit(http.StatusTooManyRequests, header, now)
+	assert.Equal(t, now.Add(multiKeyMaxCooldown).Unix(), state.CooldownUntil)
+}
+
+func TestParseMultiKeyRateLimitWithoutHeaders(t *testing.T) {
+	state := ParseMultiKeyRateLimit(http.StatusOK, http.Header{}, time.Now())
+	assert.Less(t, state.Headroom, 0.0)
+	assert.Zero(t, state.CooldownUntil)
+}
@@ -16,3 +16,15 @@ func ResponsesResponseToChatCompletionsResponse(resp *dto.OpenAIResponsesRespons
 func ExtractOutputTextFromResponses(resp *dto.OpenAIResponsesResponse) string {
 	return openaicompat.ExtractOutputTextFromResponses(resp)
 }
+
+func ResponsesRequestToChatCompletionsRequest(req *dto.OpenAIResponsesRequest) (*dto.GeneralOpenAIRequest, error) {
+	return openaicompat.ResponsesRequestToChatCompletionsRequest(req)
+}
+
+func ChatCompletionsResponseToResponsesResponse(resp *dto.OpenAITextResponse, usage *dto.Usage, id string, model string) *dto.OpenAIResponsesResponse {
+	return openaicompat.ChatCompletionsResponseToResponsesResponse(resp, usage, id, model)
+}
+
+func NewChatToResponsesStreamConverter(id string, model string) *openaicompat.ChatToResponsesStreamConverter {
+	return openaicompat.NewChatToResponsesStreamConverter(id, model)
+}
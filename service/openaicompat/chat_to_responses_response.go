@@ -0,0 +1,386 @@
+package openaicompat
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+const (
+	responsesStatusInProgress = "in_progress"
+	responsesStatusCompleted  = "completed"
+	responsesStatusIncomplete = "incomplete"
+)
+
+func responsesStatus(status string) []byte {
+	raw, _ := common.Marshal(status)
+	return raw
+}
+
+func responsesArguments(arguments string) []byte {
+	raw, _ := common.Marshal(arguments)
+	return raw
+}
+
+// ChatUsageToResponsesUsage 将 Chat Completions 的 usage 转换为 Responses 格式（input/output tokens）
+func ChatUsageToResponsesUsage(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	out := &dto.Usage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+		TotalTokens:  usage.TotalTokens,
+	}
+	if out.TotalTokens == 0 {
+		out.TotalTokens = out.InputTokens + out.OutputTokens
+	}
+	out.CompletionTokenDetails.ReasoningTokens = usage.CompletionTokenDetails.ReasoningTokens
+	out.InputTokensDetails = &dto.InputTokenDetails{
+		CachedTokens: usage.PromptTokensDetails.CachedTokens,
+	}
+	return out
+}
+
+func newResponsesMessageOutput(id string, text string, status string) dto.ResponsesOutput {
+	return dto.ResponsesOutput{
+		Type:   "message",
+		ID:     id,
+		Status: status,
+		Role:   "assistant",
+		Content: []dto.ResponsesOutputContent{
+			{Type: "output_text", Text: text, Annotations: []interface{}{}},
+		},
+	}
+}
+
+func newResponsesReasoningOutput(id string, text string, status string) dto.ResponsesOutput {
+	return dto.ResponsesOutput{
+		Type:    "reasoning",
+		ID:      id,
+		Status:  status,
+		Summary: []dto.ResponsesReasoningSummaryPart{{Type: "summary_text", Text: text}},
+	}
+}
+
+func newResponsesFunctionCallOutput(id string, callId string, name string, arguments string, status string) dto.ResponsesOutput {
+	return dto.ResponsesOutput{
+		Type:      "function_call",
+		ID:        id,
+		Status:    status,
+		CallId:    callId,
+		Name:      name,
+		Arguments: responsesArguments(arguments),
+	}
+}
+
+func newResponsesResponse(id string, model string, createdAt int, status string, output []dto.ResponsesOutput, usage *dto.Usage) *dto.OpenAIResponsesResponse {
+	if output == nil {
+		output = []dto.ResponsesOutput{}
+	}
+	return &dto.OpenAIResponsesResponse{
+		ID:        id,
+		Object:    "response",
+		CreatedAt: createdAt,
+		Status:    responsesStatus(status),
+		Model:     model,
+		Output:    output,
+		Usage:     ChatUsageToResponsesUsage(usage),
+	}
+}
+
+func responsesStatusFromFinishReason(finishReason string) string {
+	if finishReason == "length" {
+		return responsesStatusIncomplete
+	}
+	return responsesStatusCompleted
+}
+
+// ChatCompletionsResponseToResponsesResponse 将非流式 Chat Completions 响应转换为 Responses 响应
+func ChatCompletionsResponseToResponsesResponse(resp *dto.OpenAITextResponse, usage *dto.Usage, id string, model string) *dto.OpenAIResponsesResponse {
+	output := make([]dto.ResponsesOutput, 0)
+	finishReason := ""
+	createdAt := int(common.GetTimestamp())
+	if resp != nil {
+		if len(resp.Choices) > 0 {
+			choice := resp.Choices[0]
+			finishReason = choice.FinishReason
+			reasoning := choice.Message.ReasoningContent
+			if reasoning == "" {
+				reasoning = choice.Message.Reasoning
+			}
+			if reasoning != "" {
+				output = append(output, newResponsesReasoningOutput("rs_"+id, reasoning, responsesStatusCompleted))
+			}
+			if text := choice.Message.StringContent(); text != "" {
+				output = append(output, newResponsesMessageOutput("msg_"+id, text, responsesStatusCompleted))
+			}
+			for i, toolCall := range choice.Message.ParseToolCalls() {
+				if strings.TrimSpace(toolCall.Function.Name) == "" {
+					continue
+				}
+				output = append(output, newResponsesFunctionCallOutput(
+					"fc_"+id+"_"+strconv.Itoa(i), toolCall.ID, toolCall.Function.Name, toolCall.Function.Arguments, responsesStatusCompleted))
+			}
+		}
+		if resp.Model != "" && model == "" {
+			model = resp.Model
+		}
+	}
+	return newResponsesResponse("resp_"+id, model, createdAt, responsesStatusFromFinishReason(finishReason), output, usage)
+}
+
+type streamOutputItem struct {
+	outputIndex int
+	item        dto.ResponsesOutput
+	text        strings.Builder
+	chatIndex   int
+}
+
+// ChatToResponsesStreamConverter 将 Chat Completions 流式分片转换为 Responses 流式事件。
+// 同一时刻最多只有一个 reasoning / message 条目处于打开状态，工具调用按 chat 分片中的 index 区分。
+type ChatToResponsesStreamConverter struct {
+	id        string
+	model     string
+	createdAt int
+
+	started      bool
+	nextIndex    int
+	reasoning    *streamOutputItem
+	message      *streamOutputItem
+	toolCalls    []*streamOutputItem
+	output       []dto.ResponsesOutput
+	finishReason string
+	usage        *dto.Usage
+}
+
+func NewChatToResponsesStreamConverter(id string, model string) *ChatToResponsesStreamConverter {
+	return &ChatToResponsesStreamConverter{
+		id:        id,
+		model:     model,
+		createdAt: int(common.GetTimestamp()),
+	}
+}
+
+func (s *ChatToResponsesStreamConverter) responseId() string {
+	return "resp_" + s.id
+}
+
+func (s *ChatToResponsesStreamConverter) start() []dto.ResponsesStreamResponse {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	return []dto.ResponsesStreamResponse{
+		{Type: "response.created", Response: newResponsesResponse(s.responseId(), s.model, s.createdAt, responsesStatusInProgress, nil, nil)},
+		{Type: "response.in_progress", Response: newResponsesResponse(s.responseId(), s.model, s.createdAt, responsesStatusInProgress, nil, nil)},
+	}
+}
+
+func (s *ChatToResponsesStreamConverter) openItem(item dto.ResponsesOutput) (*streamOutputItem, dto.ResponsesStreamResponse) {
+	opened := &streamOutputItem{outputIndex: s.nextIndex, item: item}
+	s.nextIndex++
+	added := item
+	return opened, dto.ResponsesStreamResponse{
+		Type:        "response.output_item.added",
+		OutputIndex: common.GetPointer(opened.outputIndex),
+		Item:        &added,
+	}
+}
+
+func (s *ChatToResponsesStreamConverter) closeReasoning() []dto.ResponsesStreamResponse {
+	if s.reasoning == nil {
+		return nil
+	}
+	r := s.reasoning
+	s.reasoning = nil
+	text := r.text.String()
+	done := newResponsesReasoningOutput(r.item.ID, text, responsesStatusCompleted)
+	s.output = append(s.output, done)
+	return []dto.ResponsesStreamResponse{
+		{Type: "response.reasoning_summary_text.done", ItemID: r.item.ID, OutputIndex: common.GetPointer(r.outputIndex), SummaryIndex: common.GetPointer(0), Text: text},
+		{Type: "response.reasoning_summary_part.done", ItemID: r.item.ID, OutputIndex: common.GetPointer(r.outputIndex), SummaryIndex: common.GetPointer(0), Part: &dto.ResponsesReasoningSummaryPart{Type: "summary_text", Text: text}},
+		{Type: "response.output_item.done", OutputIndex: common.GetPointer(r.outputIndex), Item: &done},
+	}
+}
+
+func (s *ChatToResponsesStreamConverter) closeMessage() []dto.ResponsesStreamResponse {
+	if s.message == nil {
+		return nil
+	}
+	m := s.message
+	s.message = nil
+	text := m.text.String()
+	done := newResponsesMessageOutput(m.item.ID, text, responsesStatusCompleted)
+	s.output = append(s.output, done)
+	return []dto.ResponsesStreamResponse{
+		{Type: "response.output_text.done", ItemID: m.item.ID, OutputIndex: common.GetPointer(m.outputIndex), ContentIndex: common.GetPointer(0), Text: text},
+		{Type: "response.content_part.done", ItemID: m.item.ID, OutputIndex: common.GetPointer(m.outputIndex), ContentIndex: common.GetPointer(0), Part: &dto.ResponsesReasoningSummaryPart{Type: "output_text", Text: text}},
+		{Type: "response.output_item.done", OutputIndex: common.GetPointer(m.outputIndex), Item: &done},
+	}
+}
+
+func (s *ChatToResponsesStreamConverter) closeToolCalls() []dto.ResponsesStreamResponse {
+	events := make([]dto.ResponsesStreamResponse, 0, len(s.toolCalls)*2)
+	for _, t := range s.toolCalls {
+		arguments := t.text.String()
+		done := newResponsesFunctionCallOutput(t.item.ID, t.item.CallId, t.item.Name, arguments, responsesStatusCompleted)
+		s.output = append(s.output, done)
+		events = append(events,
+			dto.ResponsesStreamResponse{Type: "response.function_call_arguments.done", ItemID: t.item.ID, OutputIndex: common.GetPointer(t.outputIndex), Arguments: arguments},
+			dto.ResponsesStreamResponse{Type: "response.output_item.done", OutputIndex: common.GetPointer(t.outputIndex), Item: &done},
+		)
+	}
+	s.toolCalls = nil
+	return events
+}
+
+func (s *ChatToResponsesStreamConverter) appendReasoning(delta string) []dto.ResponsesStreamResponse {
+	var events []dto.ResponsesStreamResponse
+	if s.reasoning == nil {
+		events = append(events, s.closeMessage()...)
+		events = append(events, s.closeToolCalls()...)
+		id := "rs_" + s.id + "_" + strconv.Itoa(s.nextIndex)
+		var added dto.ResponsesStreamResponse
+		s.reasoning, added = s.openItem(dto.ResponsesOutput{Type: "reasoning", ID: id, Status: responsesStatusInProgress, Summary: []dto.ResponsesReasoningSummaryPart{}})
+		events = append(events, added, dto.ResponsesStreamResponse{
+			Type:         "response.reasoning_summary_part.added",
+			ItemID:       id,
+			OutputIndex:  common.GetPointer(s.reasoning.outputIndex),
+			SummaryIndex: common.GetPointer(0),
+			Part:         &dto.ResponsesReasoningSummaryPart{Type: "summary_text"},
+		})
+	}
+	s.reasoning.text.WriteString(delta)
+	return append(events, dto.ResponsesStreamResponse{
+		Type:         "response.reasoning_summary_text.delta",
+		ItemID:       s.reasoning.item.ID,
+		OutputIndex:  common.GetPointer(s.reasoning.outputIndex),
+		SummaryIndex: common.GetPointer(0),
+		Delta:        delta,
+	})
+}
+
+func (s *ChatToResponsesStreamConverter) appendText(delta string) []dto.ResponsesStreamResponse {
+	var events []dto.ResponsesStreamResponse
+	if s.message == nil {
+		events = append(events, s.closeReasoning()...)
+		events = append(events, s.closeToolCalls()...)
+		id := "msg_" + s.id + "_" + strconv.Itoa(s.nextIndex)
+		var added dto.ResponsesStreamResponse
+		s.message, added = s.openItem(dto.ResponsesOutput{Type: "message", ID: id, Status: responsesStatusInProgress, Role: "assistant", Content: []dto.ResponsesOutputContent{}})
+		events = append(events, added, dto.ResponsesStreamResponse{
+			Type:         "response.content_part.added",
+			ItemID:       id,
+			OutputIndex:  common.GetPointer(s.message.outputIndex),
+			ContentIndex: common.GetPointer(0),
+			Part:         &dto.ResponsesReasoningSummaryPart{Type: "output_text"},
+		})
+	}
+	s.message.text.WriteString(delta)
+	return append(events, dto.ResponsesStreamResponse{
+		Type:         "response.output_text.delta",
+		ItemID:       s.message.item.ID,
+		OutputIndex:  common.GetPointer(s.message.outputIndex),
+		ContentIndex: common.GetPointer(0),
+		Delta:        delta,
+	})
+}
+
+func (s *ChatToResponsesStreamConverter) appendToolCall(toolCall dto.ToolCallResponse, position int) []dto.ResponsesStreamResponse {
+	chatIndex := position
+	if toolCall.Index != nil {
+		chatIndex = *toolCall.Index
+	}
+	var current *streamOutputItem
+	for _, t := range s.toolCalls {
+		if t.chatIndex == chatIndex {
+			current = t
+			break
+		}
+	}
+
+	var events []dto.ResponsesStreamResponse
+	if current == nil {
+		events = append(events, s.closeReasoning()...)
+		events = append(events, s.closeMessage()...)
+		callId := toolCall.ID
+		if callId == "" {
+			callId = "call_" + s.id + "_" + strconv.Itoa(chatIndex)
+		}
+		id := "fc_" + s.id + "_" + strconv.Itoa(s.nextIndex)
+		var added dto.ResponsesStreamResponse
+		current, added = s.openItem(newResponsesFunctionCallOutput(id, callId, toolCall.Function.Name, "", responsesStatusInProgress))
+		current.chatIndex = chatIndex
+		s.toolCalls = append(s.toolCalls, current)
+		events = append(events, added)
+	} else if current.item.Name == "" && toolCall.Function.Name != "" {
+		current.item.Name = toolCall.Function.Name
+	}
+	if toolCall.Function.Arguments == "" {
+		return events
+	}
+	current.text.WriteString(toolCall.Function.Arguments)
+	return append(events, dto.ResponsesStreamResponse{
+		Type:        "response.function_call_arguments.delta",
+		ItemID:      current.item.ID,
+		OutputIndex: common.GetPointer(current.outputIndex),
+		Delta:       toolCall.Function.Arguments,
+	})
+}
+
+// HandleChunk 处理一个 Chat Completions 流式分片，返回需要发送给客户端的 Responses 事件
+func (s *ChatToResponsesStreamConverter) HandleChunk(chunk *dto.ChatCompletionsStreamResponse) []dto.ResponsesStreamResponse {
+	events := s.start()
+	if chunk == nil {
+		return events
+	}
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+	if len(chunk.Choices) == 0 {
+		return events
+	}
+	choice := chunk.Choices[0]
+	if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+		events = append(events, s.appendReasoning(reasoning)...)
+	}
+	if text := choice.Delta.GetContentString(); text != "" {
+		events = append(events, s.appendText(text)...)
+	}
+	for i, toolCall := range choice.Delta.ToolCalls {
+		events = append(events, s.appendToolCall(toolCall, i)...)
+	}
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		s.finishReason = *choice.FinishReason
+	}
+	return events
+}
+
+// Usage 返回流中最后一个分片携带的用量，未携带时为 nil
+func (s *ChatToResponsesStreamConverter) Usage() *dto.Usage {
+	return s.usage
+}
+
+// Finish 关闭所有打开的条目并生成最终的 response.completed（或 response.incomplete）事件，
+// usage 优先使用渠道处理器统计的结果，为空时回退到流中携带的 usage
+func (s *ChatToResponsesStreamConverter) Finish(usage *dto.Usage) []dto.ResponsesStreamResponse {
+	events := s.start()
+	events = append(events, s.closeReasoning()...)
+	events = append(events, s.closeMessage()...)
+	events = append(events, s.closeToolCalls()...)
+	if usage == nil {
+		usage = s.usage
+	}
+	status := responsesStatusFromFinishReason(s.finishReason)
+	eventType := "response.completed"
+	if status == responsesStatusIncomplete {
+		eventType = "response.incomplete"
+	}
+	return append(events, dto.ResponsesStreamResponse{
+		Type:     eventType,
+		Response: newResponsesResponse(s.responseId(), s.model, s.createdAt, status, s.output, usage),
+	})
+}
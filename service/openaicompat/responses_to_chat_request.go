@@ -0,0 +1,383 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+type responsesInputItem struct {
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	CallId    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+	Output    json.RawMessage `json:"output"`
+}
+
+type responsesContentPart struct {
+	Type       string          `json:"type"`
+	Text       string          `json:"text"`
+	Refusal    string          `json:"refusal"`
+	ImageUrl   json.RawMessage `json:"image_url"`
+	Detail     string          `json:"detail"`
+	FileId     string          `json:"file_id"`
+	FileData   string          `json:"file_data"`
+	Filename   string          `json:"filename"`
+	InputAudio any             `json:"input_audio"`
+}
+
+type responsesFunctionTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  any    `json:"parameters"`
+}
+
+type responsesTextFormat struct {
+	Format *struct {
+		Type        string          `json:"type"`
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Schema      any             `json:"schema,omitempty"`
+		Strict      json.RawMessage `json:"strict,omitempty"`
+	} `json:"format"`
+}
+
+func convertResponsesImageURL(raw json.RawMessage, detail string) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var url string
+	if err := common.Unmarshal(raw, &url); err != nil {
+		var image dto.MessageImageUrl
+		if err := common.Unmarshal(raw, &image); err != nil {
+			return nil
+		}
+		url = image.Url
+		if detail == "" {
+			detail = image.Detail
+		}
+	}
+	if url == "" {
+		return nil
+	}
+	if detail == "" {
+		detail = "auto"
+	}
+	return &dto.MessageImageUrl{Url: url, Detail: detail}
+}
+
+// convertResponsesContent 将 Responses 的 content（字符串或 content part 数组）转换为 Chat 消息内容
+func convertResponsesContent(raw json.RawMessage) (any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	if common.GetJsonType(raw) == "string" {
+		var text string
+		if err := common.Unmarshal(raw, &text); err != nil {
+			return nil, err
+		}
+		return text, nil
+	}
+
+	var parts []responsesContentPart
+	if err := common.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("invalid message content: %w", err)
+	}
+	contents := make([]dto.MediaContent, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "text":
+			contents = append(contents, dto.MediaContent{Type: dto.ContentTypeText, Text: part.Text})
+		case "refusal":
+			contents = append(contents, dto.MediaContent{Type: dto.ContentTypeText, Text: part.Refusal})
+		case "input_image":
+			if imageUrl := convertResponsesImageURL(part.ImageUrl, part.Detail); imageUrl != nil {
+				contents = append(contents, dto.MediaContent{Type: dto.ContentTypeImageURL, ImageUrl: imageUrl})
+			}
+		case "input_file":
+			contents = append(contents, dto.MediaContent{
+				Type: dto.ContentTypeFile,
+				File: &dto.MessageFile{
+					FileName: part.Filename,
+					FileData: part.FileData,
+					FileId:   part.FileId,
+				},
+			})
+		case "input_audio":
+			contents = append(contents, dto.MediaContent{Type: dto.ContentTypeInputAudio, InputAudio: part.InputAudio})
+		}
+	}
+	// 纯文本内容合并为字符串，兼容只接受字符串 content 的渠道
+	allText := true
+	for _, content := range contents {
+		if content.Type != dto.ContentTypeText {
+			allText = false
+			break
+		}
+	}
+	if allText {
+		texts := make([]string, 0, len(contents))
+		for _, content := range contents {
+			texts = append(texts, content.Text)
+		}
+		return strings.Join(texts, "\n"), nil
+	}
+	return contents, nil
+}
+
+// convertResponsesToolOutput 将 function_call_output 的 output 转换为 tool 消息的文本内容
+func convertResponsesToolOutput(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	if common.GetJsonType(raw) == "string" {
+		var text string
+		if err := common.Unmarshal(raw, &text); err == nil {
+			return text
+		}
+	}
+	var parts []responsesContentPart
+	if err := common.Unmarshal(raw, &parts); err == nil {
+		var sb strings.Builder
+		for _, part := range parts {
+			if part.Text == "" {
+				continue
+			}
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(part.Text)
+		}
+		if sb.Len() > 0 {
+			return sb.String()
+		}
+	}
+	return string(raw)
+}
+
+func convertResponsesTools(raw json.RawMessage) ([]dto.ToolCallRequest, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var tools []responsesFunctionTool
+	if err := common.Unmarshal(raw, &tools); err != nil {
+		return nil, fmt.Errorf("invalid tools: %w", err)
+	}
+	result := make([]dto.ToolCallRequest, 0, len(tools))
+	for _, tool := range tools {
+		// 仅 function 工具可以映射到 Chat Completions，内置工具（web_search 等）由上游原生 Responses 提供
+		if tool.Type != "function" || tool.Name == "" {
+			continue
+		}
+		result = append(result, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return result, nil
+}
+
+func convertResponsesToolChoice(raw json.RawMessage) any {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if common.GetJsonType(raw) == "string" {
+		var choice string
+		if err := common.Unmarshal(raw, &choice); err == nil {
+			return choice
+		}
+		return nil
+	}
+	var choice map[string]any
+	if err := common.Unmarshal(raw, &choice); err != nil {
+		return nil
+	}
+	// Responses: {"type":"function","name":"..."}
+	// Chat: {"type":"function","function":{"name":"..."}}
+	if t, _ := choice["type"].(string); t == "function" {
+		if name, _ := choice["name"].(string); name != "" {
+			return map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": name},
+			}
+		}
+	}
+	return nil
+}
+
+func convertResponsesTextToResponseFormat(raw json.RawMessage) *dto.ResponseFormat {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var text responsesTextFormat
+	if err := common.Unmarshal(raw, &text); err != nil || text.Format == nil {
+		return nil
+	}
+	switch text.Format.Type {
+	case "json_object":
+		return &dto.ResponseFormat{Type: "json_object"}
+	case "json_schema":
+		schema, err := common.Marshal(dto.FormatJsonSchema{
+			Description: text.Format.Description,
+			Name:        text.Format.Name,
+			Schema:      text.Format.Schema,
+			Strict:      text.Format.Strict,
+		})
+		if err != nil {
+			return nil
+		}
+		return &dto.ResponseFormat{Type: "json_schema", JsonSchema: schema}
+	}
+	return nil
+}
+
+// ResponsesRequestToChatCompletionsRequest 将 Responses 请求转换为 Chat Completions 请求，
+// 是 ChatCompletionsRequestToResponsesRequest 的逆向转换，用于只支持 Chat Completions 的渠道。
+func ResponsesRequestToChatCompletionsRequest(req *dto.OpenAIResponsesRequest) (*dto.GeneralOpenAIRequest, error) {
+	if req == nil {
+		return nil, errors.New("request is nil")
+	}
+	if req.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if req.PreviousResponseID != "" {
+		return nil, errors.New("previous_response_id is not supported in chat completions compatibility mode")
+	}
+	if len(req.Conversation) > 0 && string(req.Conversation) != "null" {
+		return nil, errors.New("conversation is not supported in chat completions compatibility mode")
+	}
+
+	messages := make([]dto.Message, 0)
+
+	if len(req.Instructions) > 0 && common.GetJsonType(req.Instructions) == "string" {
+		var instructions string
+		if err := common.Unmarshal(req.Instructions, &instructions); err != nil {
+			return nil, fmt.Errorf("invalid instructions: %w", err)
+		}
+		if strings.TrimSpace(instructions) != "" {
+			messages = append(messages, dto.Message{Role: "system", Content: instructions})
+		}
+	}
+
+	if len(req.Input) > 0 {
+		switch common.GetJsonType(req.Input) {
+		case "string":
+			var input string
+			if err := common.Unmarshal(req.Input, &input); err != nil {
+				return nil, fmt.Errorf("invalid input: %w", err)
+			}
+			messages = append(messages, dto.Message{Role: "user", Content: input})
+		case "array":
+			var items []responsesInputItem
+			if err := common.Unmarshal(req.Input, &items); err != nil {
+				return nil, fmt.Errorf("invalid input: %w", err)
+			}
+			for _, item := range items {
+				var err error
+				messages, err = appendResponsesInputItem(messages, item)
+				if err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, errors.New("input must be a string or an array")
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil, errors.New("input is required")
+	}
+
+	tools, err := convertResponsesTools(req.Tools)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &dto.GeneralOpenAIRequest{
+		Model:          req.Model,
+		Messages:       messages,
+		Stream:         req.Stream,
+		MaxTokens:      req.MaxOutputTokens,
+		Temperature:    req.Temperature,
+		TopP:           req.TopP,
+		User:           req.User,
+		ResponseFormat: convertResponsesTextToResponseFormat(req.Text),
+	}
+	if len(tools) > 0 {
+		out.Tools = tools
+		out.ToolChoice = convertResponsesToolChoice(req.ToolChoice)
+		if len(req.ParallelToolCalls) > 0 {
+			var parallelToolCalls bool
+			if err := common.Unmarshal(req.ParallelToolCalls, &parallelToolCalls); err == nil {
+				out.ParallelTooCalls = &parallelToolCalls
+			}
+		}
+	}
+	if req.Stream != nil && *req.Stream {
+		out.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+	}
+	if req.Reasoning != nil && req.Reasoning.Effort != "" && req.Reasoning.Effort != "none" {
+		out.ReasoningEffort = req.Reasoning.Effort
+	}
+
+	return out, nil
+}
+
+func appendResponsesInputItem(messages []dto.Message, item responsesInputItem) ([]dto.Message, error) {
+	switch item.Type {
+	case "", "message":
+		role := strings.TrimSpace(item.Role)
+		if role == "" {
+			return messages, errors.New("input message role is required")
+		}
+		if role == "developer" {
+			role = "system"
+		}
+		content, err := convertResponsesContent(item.Content)
+		if err != nil {
+			return messages, err
+		}
+		message := dto.Message{Role: role, Content: content}
+		if mediaContents, ok := content.([]dto.MediaContent); ok {
+			message.SetMediaContent(mediaContents)
+		}
+		return append(messages, message), nil
+	case "function_call":
+		toolCall := dto.ToolCallRequest{
+			ID:   item.CallId,
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:      item.Name,
+				Arguments: dto.ResponsesArgumentsString(item.Arguments),
+			},
+		}
+		// 连续的 function_call 合并到同一条 assistant 消息中
+		if last := len(messages) - 1; last >= 0 && messages[last].Role == "assistant" {
+			toolCalls := append(messages[last].ParseToolCalls(), toolCall)
+			messages[last].SetToolCalls(toolCalls)
+			return messages, nil
+		}
+		message := dto.Message{Role: "assistant", Content: ""}
+		message.SetToolCalls([]dto.ToolCallRequest{toolCall})
+		return append(messages, message), nil
+	case "function_call_output":
+		return append(messages, dto.Message{
+			Role:       "tool",
+			Content:    convertResponsesToolOutput(item.Output),
+			ToolCallId: item.CallId,
+		}), nil
+	}
+	// reasoning 等其他条目无法在 Chat Completions 中表达，直接忽略
+	return messages, nil
+}
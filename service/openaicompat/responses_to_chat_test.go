@@ -0,0 +1,196 @@
+package openaicompat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponsesRequestToChatCompletionsRequest(t *testing.T) {
+	var req dto.OpenAIResponsesRequest
+	require.NoError(t, common.Unmarshal([]byte(`{
+		"model": "claude-sonnet-4",
+		"instructions": "be brief",
+		"stream": true,
+		"max_output_tokens": 256,
+		"reasoning": {"effort": "high"},
+		"text": {"format": {"type": "json_schema", "name": "answer", "schema": {"type": "object"}, "strict": true}},
+		"tools": [
+			{"type": "function", "name": "get_weather", "description": "weather", "parameters": {"type": "object"}},
+			{"type": "web_search"}
+		],
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"input": [
+			{"role": "developer", "content": "use tools"},
+			{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "weather?"}, {"type": "input_image", "image_url": "https://example.com/a.png"}]},
+			{"type": "message", "role": "assistant", "content": [{"type": "output_text", "text": "checking"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{\"city\":\"a\"}"},
+			{"type": "function_call", "call_id": "call_2", "name": "get_weather", "arguments": "{\"city\":\"b\"}"},
+			{"type": "reasoning", "summary": []},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+			{"type": "function_call_output", "call_id": "call_2", "output": [{"type": "input_text", "text": "rainy"}]}
+		]
+	}`), &req))
+
+	chatReq, err := ResponsesRequestToChatCompletionsRequest(&req)
+	require.NoError(t, err)
+	require.Equal(t, "claude-sonnet-4", chatReq.Model)
+	require.Equal(t, uint(256), *chatReq.MaxTokens)
+	require.Equal(t, "high", chatReq.ReasoningEffort)
+	require.True(t, chatReq.StreamOptions.IncludeUsage)
+
+	require.Len(t, chatReq.Messages, 6)
+	require.Equal(t, "system", chatReq.Messages[0].Role)
+	require.Equal(t, "be brief", chatReq.Messages[0].StringContent())
+	require.Equal(t, "system", chatReq.Messages[1].Role)
+
+	userParts := chatReq.Messages[2].ParseContent()
+	require.Len(t, userParts, 2)
+	require.Equal(t, dto.ContentTypeImageURL, userParts[1].Type)
+	require.Equal(t, "https://example.com/a.png", userParts[1].GetImageMedia().Url)
+
+	assistant := chatReq.Messages[3]
+	require.Equal(t, "checking", assistant.StringContent())
+	toolCalls := assistant.ParseToolCalls()
+	require.Len(t, toolCalls, 2)
+	require.Equal(t, "call_2", toolCalls[1].ID)
+	require.Equal(t, `{"city":"b"}`, toolCalls[1].Function.Arguments)
+
+	require.Equal(t, "tool", chatReq.Messages[4].Role)
+	require.Equal(t, "call_1", chatReq.Messages[4].ToolCallId)
+	require.Equal(t, "sunny", chatReq.Messages[4].StringContent())
+	require.Equal(t, "rainy", chatReq.Messages[5].StringContent())
+
+	require.Len(t, chatReq.Tools, 1)
+	require.Equal(t, "get_weather", chatReq.Tools[0].Function.Name)
+	require.Equal(t, map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}, chatReq.ToolChoice)
+
+	require.Equal(t, "json_schema", chatReq.ResponseFormat.Type)
+	var schema map[string]any
+	require.NoError(t, common.Unmarshal(chatReq.ResponseFormat.JsonSchema, &schema))
+	require.Equal(t, "answer", schema["name"])
+	require.Equal(t, true, schema["strict"])
+}
+
+func TestResponsesRequestToChatCompletionsRequest_Errors(t *testing.T) {
+	_, err := ResponsesRequestToChatCompletionsRequest(&dto.OpenAIResponsesRequest{Model: "m", Input: json.RawMessage(`"hi"`), PreviousResponseID: "resp_1"})
+	require.Error(t, err)
+
+	_, err = ResponsesRequestToChatCompletionsRequest(&dto.OpenAIResponsesRequest{Model: "m"})
+	require.Error(t, err)
+
+	chatReq, err := ResponsesRequestToChatCompletionsRequest(&dto.OpenAIResponsesRequest{Model: "m", Input: json.RawMessage(`"hi"`)})
+	require.NoError(t, err)
+	require.Len(t, chatReq.Messages, 1)
+	require.Equal(t, "user", chatReq.Messages[0].Role)
+	require.Nil(t, chatReq.StreamOptions)
+}
+
+func chatChunk(delta dto.ChatCompletionsStreamResponseChoiceDelta, finishReason string) *dto.ChatCompletionsStreamResponse {
+	choice := dto.ChatCompletionsStreamResponseChoice{Delta: delta}
+	if finishReason != "" {
+		choice.FinishReason = &finishReason
+	}
+	return &dto.ChatCompletionsStreamResponse{Choices: []dto.ChatCompletionsStreamResponseChoice{choice}}
+}
+
+func eventTypes(events []dto.ResponsesStreamResponse) []string {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestChatToResponsesStreamConverter(t *testing.T) {
+	converter := NewChatToResponsesStreamConverter("req1", "claude-sonnet-4")
+	var events []dto.ResponsesStreamResponse
+
+	reasoning := "think"
+	events = append(events, converter.HandleChunk(chatChunk(dto.ChatCompletionsStreamResponseChoiceDelta{ReasoningContent: &reasoning}, ""))...)
+	hello, world := "Hello", " world"
+	events = append(events, converter.HandleChunk(chatChunk(dto.ChatCompletionsStreamResponseChoiceDelta{Content: &hello}, ""))...)
+	events = append(events, converter.HandleChunk(chatChunk(dto.ChatCompletionsStreamResponseChoiceDelta{Content: &world}, ""))...)
+
+	first := dto.ToolCallResponse{ID: "call_1", Type: "function", Function: dto.FunctionResponse{Name: "get_weather", Arguments: `{"city":`}}
+	first.SetIndex(0)
+	events = append(events, converter.HandleChunk(chatChunk(dto.ChatCompletionsStreamResponseChoiceDelta{ToolCalls: []dto.ToolCallResponse{first}}, ""))...)
+	rest := dto.ToolCallResponse{Function: dto.FunctionResponse{Arguments: `"a"}`}}
+	rest.SetIndex(0)
+	events = append(events, converter.HandleChunk(chatChunk(dto.ChatCompletionsStreamResponseChoiceDelta{ToolCalls: []dto.ToolCallResponse{rest}}, "tool_calls"))...)
+
+	events = append(events, converter.Finish(&dto.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15})...)
+
+	require.Equal(t, []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.reasoning_summary_part.added",
+		"response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done",
+		"response.reasoning_summary_part.done",
+		"response.output_item.done",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.output_item.added",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.done",
+		"response.output_item.done",
+		"response.completed",
+	}, eventTypes(events))
+
+	require.Equal(t, "Hello world", events[12].Text)
+	require.Equal(t, `{"city":"a"}`, events[18].Arguments)
+	require.Equal(t, 2, *events[15].OutputIndex)
+
+	completed := events[len(events)-1].Response
+	require.Equal(t, "resp_req1", completed.ID)
+	require.JSONEq(t, `"completed"`, string(completed.Status))
+	require.Len(t, completed.Output, 3)
+	require.Equal(t, "reasoning", completed.Output[0].Type)
+	require.Equal(t, "Hello world", completed.Output[1].Content[0].Text)
+	require.Equal(t, "call_1", completed.Output[2].CallId)
+	require.Equal(t, `{"city":"a"}`, completed.Output[2].ArgumentsString())
+	require.Equal(t, 10, completed.Usage.InputTokens)
+	require.Equal(t, 5, completed.Usage.OutputTokens)
+	require.Equal(t, 15, completed.Usage.TotalTokens)
+}
+
+func TestChatToResponsesStreamConverter_Incomplete(t *testing.T) {
+	converter := NewChatToResponsesStreamConverter("req2", "m")
+	text := "truncated"
+	converter.HandleChunk(chatChunk(dto.ChatCompletionsStreamResponseChoiceDelta{Content: &text}, "length"))
+	events := converter.Finish(nil)
+	last := events[len(events)-1]
+	require.Equal(t, "response.incomplete", last.Type)
+	require.JSONEq(t, `"incomplete"`, string(last.Response.Status))
+}
+
+func TestChatCompletionsResponseToResponsesResponse(t *testing.T) {
+	msg := dto.Message{Role: "assistant", Content: "", ReasoningContent: "think"}
+	msg.SetToolCalls([]dto.ToolCallRequest{{ID: "call_1", Type: "function", Function: dto.FunctionRequest{Name: "f", Arguments: "{}"}}})
+	chatResp := &dto.OpenAITextResponse{
+		Model:   "upstream-model",
+		Choices: []dto.OpenAITextResponseChoice{{Message: msg, FinishReason: "tool_calls"}},
+	}
+
+	resp := ChatCompletionsResponseToResponsesResponse(chatResp, &dto.Usage{PromptTokens: 3, CompletionTokens: 4}, "req3", "claude-sonnet-4")
+	require.Equal(t, "resp_req3", resp.ID)
+	require.Equal(t, "response", resp.Object)
+	require.Equal(t, "claude-sonnet-4", resp.Model)
+	require.Len(t, resp.Output, 2)
+	require.Equal(t, "reasoning", resp.Output[0].Type)
+	require.Equal(t, "think", resp.Output[0].Summary[0].Text)
+	require.Equal(t, "function_call", resp.Output[1].Type)
+	require.Equal(t, "{}", resp.Output[1].ArgumentsString())
+	require.Equal(t, 7, resp.Usage.TotalTokens)
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedOrganization(t *testing.T, ownerId int, quota int, memberId int, memberCap int) *model.Organization {
+	t.Helper()
+	org := &model.Organization{Name: "test_org", OwnerId: ownerId}
+	require.NoError(t, model.CreateOrganization(org))
+	require.NoError(t, model.DB.Model(&model.Organization{}).Where("id = ?", org.Id).Update("quota", quota).Error)
+	if memberId != ownerId {
+		require.NoError(t, model.DB.Create(&model.OrganizationMember{OrgId: org.Id, UserId: memberId, Role: model.OrgRoleMember}).Error)
+	}
+	require.NoError(t, model.DB.Model(&model.OrganizationMember{}).
+		Where("org_id = ? AND user_id = ?", org.Id, memberId).Update("quota_limit", memberCap).Error)
+	t.Cleanup(func() {
+		model.DB.Exec("DELETE FROM organizations")
+		model.DB.Exec("DELETE FROM organization_members")
+	})
+	return org
+}
+
+func getOrgQuotas(t *testing.T, orgId int, userId int) (orgQuota int, memberUsed int) {
+	t.Helper()
+	org, err := model.GetOrganizationById(orgId)
+	require.NoError(t, err)
+	member, err := model.GetOrganizationMember(orgId, userId)
+	require.NoError(t, err)
+	return org.Quota, member.UsedQuota
+}
+
+func newOrgRelayInfo(userId int, tokenId int, tokenKey string, orgId int) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		UserId:          userId,
+		TokenId:         tokenId,
+		TokenKey:        tokenKey,
+		OrgId:           orgId,
+		RequestId:       "req_org",
+		OriginModelName: "test-model",
+	}
+}
+
+func TestOrganizationFundingDrawsFromPoolAndEnforcesMemberCap(t *testing.T) {
+	truncate(t)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	const ownerId, memberId, tokenId = 1, 2, 1
+	seedUser(t, ownerId, 0)
+	require.NoError(t, model.DB.Create(&model.User{Id: memberId, Username: "member", AffCode: "member_aff", Quota: 500}).Error)
+	seedToken(t, tokenId, memberId, "org-token-key", 10000)
+	org := seedOrganization(t, ownerId, 1000, memberId, 300)
+
+	relayInfo := newOrgRelayInfo(memberId, tokenId, "org-token-key", org.Id)
+	session, apiErr := NewBillingSession(c, relayInfo, 200)
+	require.Nil(t, apiErr)
+	assert.Equal(t, BillingSourceOrganization, relayInfo.BillingSource)
+
+	orgQuota, memberUsed := getOrgQuotas(t, org.Id, memberId)
+	assert.Equal(t, 800, orgQuota)
+	assert.Equal(t, 200, memberUsed)
+
+	require.NoError(t, session.Settle(250))
+	orgQuota, memberUsed = getOrgQuotas(t, org.Id, memberId)
+	assert.Equal(t, 750, orgQuota)
+	assert.Equal(t, 250, memberUsed)
+
+	// 成员钱包不受影响
+	quota, err := model.GetUserQuota(memberId, true)
+	require.NoError(t, err)
+	assert.Equal(t, 500, quota)
+
+	// 超出成员上限
+	_, apiErr = NewBillingSession(c, newOrgRelayInfo(memberId, tokenId, "org-token-key", org.Id), 100)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, types.ErrorCodeInsufficientUserQuota, apiErr.GetErrorCode())
+	orgQuota, memberUsed = getOrgQuotas(t, org.Id, memberId)
+	assert.Equal(t, 750, orgQuota)
+	assert.Equal(t, 250, memberUsed)
+}
+
+func TestOrganizationFundingRejectsNonMember(t *testing.T) {
+	truncate(t)
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	const ownerId, outsiderId, tokenId = 1, 3, 2
+	seedUser(t, ownerId, 0)
+	require.NoError(t, model.DB.Create(&model.User{Id: outsiderId, Username: "outsider", AffCode: "outsider_aff", Quota: 500}).Error)
+	seedToken(t, tokenId, outsiderId, "outsider-token-key", 10000)
+	org := seedOrganization(t, ownerId, 1000, ownerId, 0)
+
+	_, apiErr := NewBillingSession(c, newOrgRelayInfo(outsiderId, tokenId, "outsider-token-key", org.Id), 100)
+	require.NotNil(t, apiErr)
+	assert.Equal(t, types.ErrorCodeInsufficientUserQuota, apiErr.GetErrorCode())
+
+	org, err := model.GetOrganizationById(org.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 1000, org.Quota)
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	payloadRedactedValue = "[REDACTED]"
+
+	payloadCaptureCleanupInterval  = 10 * time.Minute
+	payloadCaptureCleanupBatchSize = 1000
+	// payloadCaptureStreamBufferFactor 流式响应需要完整的事件才能还原，按保存上限的倍数缓存原始内容
+	payloadCaptureStreamBufferFactor = 8
+)
+
+var payloadCaptureCleanupOnce sync.Once
+
+// payloadCaptureWriter 在写给客户端的同时记录响应内容，超过大小限制的部分截断
+type payloadCaptureWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	maxBytes  int
+	limit     int
+	truncated bool
+}
+
+func (w *payloadCaptureWriter) record(data []byte) {
+	if w.truncated {
+		return
+	}
+	if w.limit == 0 {
+		// 首次写入时响应头已确定，流式响应放宽缓存上限，保存时还原后再截断
+		w.limit = w.maxBytes
+		if strings.HasPrefix(w.ResponseWriter.Header().Get("Content-Type"), "text/event-stream") {
+			w.limit = w.maxBytes * payloadCaptureStreamBufferFactor
+		}
+	}
+	remaining := w.limit - w.body.Len()
+	if len(data) > remaining {
+		w.body.Write(data[:remaining])
+		w.truncated = true
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *payloadCaptureWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *payloadCaptureWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// PayloadCaptureSession 单个请求的载荷留存上下文，是否保存在请求结束时按用户、令牌与最终渠道的配置决定
+type PayloadCaptureSession struct {
+	writer *payloadCaptureWriter
+}
+
+// NewPayloadCaptureSession 全局未开启载荷留存时返回 nil
+func NewPayloadCaptureSession(c *gin.Context) *PayloadCaptureSession {
+	if !operation_setting.GetPayloadCaptureSetting().Enabled {
+		return nil
+	}
+	return &PayloadCaptureSession{}
+}
+
+// StartRecording 替换 c.Writer 以记录下发给客户端的响应
+func (s *PayloadCaptureSession) StartRecording(c *gin.Context) {
+	if s == nil {
+		return
+	}
+	s.writer = &payloadCaptureWriter{
+		ResponseWriter: c.Writer,
+		maxBytes:       getPayloadCaptureMaxBodyBytes(),
+	}
+	c.Writer = s.writer
+}
+
+// ResetRecording 丢弃上一次重试记录的内容
+func (s *PayloadCaptureSession) ResetRecording() {
+	if s == nil || s.writer == nil {
+		return
+	}
+	s.writer.body.Reset()
+	s.writer.limit = 0
+	s.writer.truncated = false
+}
+
+// payloadCaptureToDisk 磁盘上的载荷只能在写入节点读取，多节点部署（从节点或启用了 Redis）时改为保存在日志数据库
+func payloadCaptureToDisk() bool {
+	if operation_setting.GetPayloadCaptureSetting().GetStorage() != operation_setting.PayloadCaptureStorageDisk {
+		return false
+	}
+	return common.IsMasterNode && !common.RedisEnabled
+}
+
+func getPayloadCaptureMaxBodyBytes() int {
+	maxBytes := operation_setting.GetPayloadCaptureSetting().GetMaxBodyBytes()
+	if !payloadCaptureToDisk() {
+		if dbMax := model.MaxPayloadCaptureDBBodyBytes(); dbMax > 0 && maxBytes > dbMax {
+			maxBytes = dbMax
+		}
+	}
+	return maxBytes
+}
+
+// shouldCapturePayload 用户、令牌或最终使用的渠道任一方启用载荷留存即保存
+func shouldCapturePayload(c *gin.Context, info *relaycommon.RelayInfo) bool {
+	if info.UserSetting.RecordPayload || common.GetContextKeyBool(c, constant.ContextKeyTokenPayloadCapture) {
+		return true
+	}
+	channelSetting, ok := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
+	return ok && channelSetting.PayloadCapture
+}
+
+// Save 保存本次请求的载荷，请求失败时响应体为返回给客户端的错误
+func (s *PayloadCaptureSession) Save(c *gin.Context, info *relaycommon.RelayInfo, apiErr *types.NewAPIError) {
+	if s == nil || s.writer == nil || info == nil || !shouldCapturePayload(c, info) {
+		return
+	}
+	setting := operation_setting.GetPayloadCaptureSetting()
+	maxBytes := s.writer.maxBytes
+
+	var requestBody []byte
+	if storage, err := common.GetBodyStorage(c); err == nil {
+		requestBody, _ = storage.Bytes()
+	}
+	requestBody = redactJSONPayload(requestBody, setting.RedactPaths)
+	requestText, requestTruncated := truncatePayload(requestBody, maxBytes)
+
+	statusCode := s.writer.Status()
+	var responseText string
+	responseTruncated := s.writer.truncated
+	if apiErr != nil {
+		statusCode = apiErr.StatusCode
+		errBody, _ := common.Marshal(gin.H{"error": apiErr.ToOpenAIError()})
+		responseText, responseTruncated = truncatePayload(errBody, maxBytes)
+	} else {
+		responseText, responseTruncated = capturedResponseText(s.writer.body.Bytes(), info.IsStream, responseTruncated, maxBytes, setting.RedactPaths)
+	}
+
+	capture := &model.PayloadCapture{
+		RequestId:         info.RequestId,
+		UserId:            info.UserId,
+		TokenId:           info.TokenId,
+		ChannelId:         common.GetContextKeyInt(c, constant.ContextKeyChannelId),
+		ModelName:         info.OriginModelName,
+		IsStream:          info.IsStream,
+		StatusCode:        statusCode,
+		RequestBody:       requestText,
+		ResponseBody:      responseText,
+		RequestTruncated:  requestTruncated,
+		ResponseTruncated: responseTruncated,
+		CreatedAt:         common.GetTimestamp(),
+		ExpiresAt:         time.Now().AddDate(0, 0, setting.GetRetentionDays()).Unix(),
+	}
+	toDisk := payloadCaptureToDisk()
+	gopool.Go(func() {
+		if err := model.CreatePayloadCapture(capture, toDisk); err != nil {
+			common.SysError(fmt.Sprintf("failed to save payload capture for request %s: %s", capture.RequestId, err.Error()))
+		}
+	})
+}
+
+// capturedResponseText 返回脱敏并截断后的响应体，流式响应尽量还原为完整的 JSON 响应
+func capturedResponseText(body []byte, isStream bool, truncated bool, maxBytes int, paths []string) (string, bool) {
+	if !isStream {
+		return redactResponsePayload(body, false, truncated, paths), truncated
+	}
+	var text []byte
+	if assembled := assembleStreamPayload(body); assembled != nil {
+		text = redactJSONPayload(assembled, paths)
+	} else {
+		text = []byte(redactResponsePayload(body, true, truncated, paths))
+	}
+	result, cut := truncatePayload(text, maxBytes)
+	return result, truncated || cut
+}
+
+// truncatePayload 截断到 maxBytes 以内，保证不截断多字节字符
+func truncatePayload(data []byte, maxBytes int) (string, bool) {
+	if len(data) <= maxBytes {
+		return string(data), false
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	return string(data[:cut]), true
+}
+
+// redactResponsePayload 对非流式响应整体脱敏，流式响应逐个 data 事件脱敏。
+// 截断的响应无法完整解析：非流式响应在配置了脱敏路径时整体丢弃，流式响应丢弃最后一个不完整的事件。
+func redactResponsePayload(body []byte, isStream bool, truncated bool, paths []string) string {
+	if len(paths) == 0 || len(body) == 0 {
+		return strings.ToValidUTF8(string(body), "")
+	}
+	if !isStream {
+		if truncated {
+			return ""
+		}
+		return string(redactJSONPayload(body, paths))
+	}
+	text := string(body)
+	if truncated {
+		if idx := strings.LastIndex(text, "\n"); idx >= 0 {
+			text = text[:idx+1]
+		} else {
+			text = ""
+		}
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+		lines[i] = "data: " + string(redactJSONPayload([]byte(data), paths))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// redactJSONPayload 将 paths 指向的字段替换为 [REDACTED]，非 JSON 内容原样返回
+func redactJSONPayload(body []byte, paths []string) []byte {
+	if len(paths) == 0 || len(body) == 0 {
+		return body
+	}
+	var value any
+	if err := common.Unmarshal(body, &value); err != nil {
+		return body
+	}
+	redacted := false
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if redactJSONPath(value, strings.Split(path, ".")) {
+			redacted = true
+		}
+	}
+	if !redacted {
+		return body
+	}
+	result, err := common.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return result
+}
+
+func redactJSONPath(value any, segments []string) bool {
+	if len(segments) == 0 {
+		return false
+	}
+	segment, last := segments[0], len(segments) == 1
+	redacted := false
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if segment != "*" && segment != key {
+				continue
+			}
+			if last {
+				v[key] = payloadRedactedValue
+				redacted = true
+			} else if redactJSONPath(child, segments[1:]) {
+				redacted = true
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if segment != "*" && segment != "#" && segment != fmt.Sprint(i) {
+				continue
+			}
+			if last {
+				v[i] = payloadRedactedValue
+				redacted = true
+			} else if redactJSONPath(child, segments[1:]) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+// StartPayloadCaptureCleanupTask 定期删除过期的载荷：主节点删除数据库记录，所有节点清理本机磁盘上的载荷文件
+func StartPayloadCaptureCleanupTask() {
+	payloadCaptureCleanupOnce.Do(func() {
+		gopool.Go(func() {
+			ticker := time.NewTicker(payloadCaptureCleanupInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				runPayloadCaptureCleanupOnce()
+			}
+		})
+	})
+}
+
+func runPayloadCaptureCleanupOnce() {
+	ctx := context.Background()
+	retention := time.Duration(operation_setting.GetPayloadCaptureSetting().GetRetentionDays()) * 24 * time.Hour
+	if removed, err := common.CleanupPersistentDiskCacheFiles(common.DiskCacheTypePayload, retention); err != nil {
+		logger.LogWarn(ctx, fmt.Sprintf("payload capture file cleanup failed: %v", err))
+	} else if removed > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("payload capture file cleanup: removed %d files", removed))
+	}
+	if !common.IsMasterNode {
+		return
+	}
+	total := int64(0)
+	for {
+		n, err := model.DeleteExpiredPayloadCaptures(payloadCaptureCleanupBatchSize)
+		if err != nil {
+			logger.LogWarn(ctx, fmt.Sprintf("payload capture cleanup failed: %v", err))
+			return
+		}
+		total += n
+		if n < payloadCaptureCleanupBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		logger.LogInfo(ctx, fmt.Sprintf("payload capture cleanup: deleted %d records", total))
+	}
+}
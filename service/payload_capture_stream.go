@@ -0,0 +1,315 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// assembleStreamPayload 将 SSE 流式响应还原为一个完整的 JSON 响应，便于查看与脱敏。
+// 支持 OpenAI Chat Completions、Responses、Claude Messages 与 Gemini 流，无法识别时返回 nil。
+func assembleStreamPayload(body []byte) []byte {
+	events := parseSSEDataEvents(body)
+	if len(events) == 0 {
+		return nil
+	}
+	var assembled any
+	switch {
+	case events[0]["object"] == "chat.completion.chunk":
+		assembled = assembleChatCompletionStream(events)
+	case strings.HasPrefix(stringField(events[0], "type"), "response."):
+		assembled = assembleResponsesStream(events)
+	case events[0]["type"] == "message_start":
+		assembled = assembleClaudeStream(events)
+	case events[0]["candidates"] != nil || events[0]["usageMetadata"] != nil:
+		assembled = assembleGeminiStream(events)
+	}
+	if assembled == nil {
+		return nil
+	}
+	data, err := common.Marshal(assembled)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// parseSSEDataEvents 解析所有 data 行中的 JSON 对象，忽略注释、[DONE] 与不完整的事件
+func parseSSEDataEvents(body []byte) []map[string]any {
+	var events []map[string]any
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+		if !ok {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 || string(data) == "[DONE]" {
+			continue
+		}
+		var event map[string]any
+		if err := common.Unmarshal(data, &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func stringField(m map[string]any, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+func mapField(m map[string]any, key string) map[string]any {
+	v, _ := m[key].(map[string]any)
+	return v
+}
+
+func sliceField(m map[string]any, key string) []any {
+	v, _ := m[key].([]any)
+	return v
+}
+
+func intField(m map[string]any, key string) int {
+	switch v := m[key].(type) {
+	case float64:
+		return int(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return int(n)
+	}
+	return 0
+}
+
+type assembledChatChoice struct {
+	content   strings.Builder
+	reasoning strings.Builder
+	role      string
+	finish    any
+	toolCalls []map[string]any
+	toolArgs  []*strings.Builder
+}
+
+func assembleChatCompletionStream(events []map[string]any) map[string]any {
+	result := map[string]any{"object": "chat.completion"}
+	var choices []*assembledChatChoice
+	for _, event := range events {
+		for _, key := range []string{"id", "model", "created", "system_fingerprint"} {
+			if v, ok := event[key]; ok && v != nil {
+				result[key] = v
+			}
+		}
+		if usage := mapField(event, "usage"); usage != nil {
+			result["usage"] = usage
+		}
+		for _, raw := range sliceField(event, "choices") {
+			choice, ok := raw.(map[string]any)
+			if !ok {
+				continue
+			}
+			index := intField(choice, "index")
+			for len(choices) <= index {
+				choices = append(choices, &assembledChatChoice{role: "assistant"})
+			}
+			assembled := choices[index]
+			if finish, ok := choice["finish_reason"]; ok && finish != nil {
+				assembled.finish = finish
+			}
+			delta := mapField(choice, "delta")
+			if delta == nil {
+				continue
+			}
+			if role := stringField(delta, "role"); role != "" {
+				assembled.role = role
+			}
+			assembled.content.WriteString(stringField(delta, "content"))
+			assembled.reasoning.WriteString(stringField(delta, "reasoning_content"))
+			assembled.reasoning.WriteString(stringField(delta, "reasoning"))
+			for _, rawCall := range sliceField(delta, "tool_calls") {
+				call, ok := rawCall.(map[string]any)
+				if !ok {
+					continue
+				}
+				callIndex := intField(call, "index")
+				for len(assembled.toolCalls) <= callIndex {
+					assembled.toolCalls = append(assembled.toolCalls, map[string]any{"type": "function"})
+					assembled.toolArgs = append(assembled.toolArgs, &strings.Builder{})
+				}
+				target := assembled.toolCalls[callIndex]
+				if id := stringField(call, "id"); id != "" {
+					target["id"] = id
+				}
+				if function := mapField(call, "function"); function != nil {
+					if name := stringField(function, "name"); name != "" {
+						target["name"] = name
+					}
+					assembled.toolArgs[callIndex].WriteString(stringField(function, "arguments"))
+				}
+			}
+		}
+	}
+	resultChoices := make([]map[string]any, 0, len(choices))
+	for i, choice := range choices {
+		message := map[string]any{"role": choice.role, "content": choice.content.String()}
+		if choice.reasoning.Len() > 0 {
+			message["reasoning_content"] = choice.reasoning.String()
+		}
+		if len(choice.toolCalls) > 0 {
+			toolCalls := make([]map[string]any, 0, len(choice.toolCalls))
+			for j, call := range choice.toolCalls {
+				toolCalls = append(toolCalls, map[string]any{
+					"id":   call["id"],
+					"type": call["type"],
+					"function": map[string]any{
+						"name":      call["name"],
+						"arguments": choice.toolArgs[j].String(),
+					},
+				})
+			}
+			message["tool_calls"] = toolCalls
+		}
+		resultChoices = append(resultChoices, map[string]any{
+			"index":         i,
+			"message":       message,
+			"finish_reason": choice.finish,
+		})
+	}
+	result["choices"] = resultChoices
+	return result
+}
+
+// assembleResponsesStream Responses 流的终止事件已携带完整响应，取最后一个带 response 的事件
+func assembleResponsesStream(events []map[string]any) map[string]any {
+	for i := len(events) - 1; i >= 0; i-- {
+		if response := mapField(events[i], "response"); response != nil {
+			return response
+		}
+	}
+	return nil
+}
+
+func assembleClaudeStream(events []map[string]any) map[string]any {
+	message := mapField(events[0], "message")
+	if message == nil {
+		return nil
+	}
+	var blocks []map[string]any
+	var partials []*strings.Builder
+	for _, event := range events[1:] {
+		switch stringField(event, "type") {
+		case "content_block_start":
+			block := mapField(event, "content_block")
+			if block == nil {
+				block = map[string]any{}
+			}
+			blocks = append(blocks, block)
+			partials = append(partials, &strings.Builder{})
+		case "content_block_delta":
+			index := intField(event, "index")
+			delta := mapField(event, "delta")
+			if index >= len(blocks) || delta == nil {
+				continue
+			}
+			block := blocks[index]
+			switch stringField(delta, "type") {
+			case "text_delta":
+				block["text"] = stringField(block, "text") + stringField(delta, "text")
+			case "thinking_delta":
+				block["thinking"] = stringField(block, "thinking") + stringField(delta, "thinking")
+			case "signature_delta":
+				block["signature"] = stringField(block, "signature") + stringField(delta, "signature")
+			case "input_json_delta":
+				partials[index].WriteString(stringField(delta, "partial_json"))
+			}
+		case "message_delta":
+			if delta := mapField(event, "delta"); delta != nil {
+				for key, value := range delta {
+					message[key] = value
+				}
+			}
+			if usage := mapField(event, "usage"); usage != nil {
+				merged := mapField(message, "usage")
+				if merged == nil {
+					merged = map[string]any{}
+				}
+				for key, value := range usage {
+					merged[key] = value
+				}
+				message["usage"] = merged
+			}
+		}
+	}
+	for i, block := range blocks {
+		if partials[i].Len() == 0 {
+			continue
+		}
+		var input any
+		if err := common.UnmarshalJsonStr(partials[i].String(), &input); err == nil {
+			block["input"] = input
+		} else {
+			block["input"] = partials[i].String()
+		}
+	}
+	content := make([]any, 0, len(blocks))
+	for _, block := range blocks {
+		content = append(content, block)
+	}
+	message["content"] = content
+	return message
+}
+
+// assembleGeminiStream 合并各候选的 parts：相邻的文本片段拼接，其余片段按顺序保留
+func assembleGeminiStream(events []map[string]any) map[string]any {
+	result := map[string]any{}
+	var candidates []map[string]any
+	for _, event := range events {
+		for _, key := range []string{"usageMetadata", "modelVersion", "responseId", "promptFeedback"} {
+			if v, ok := event[key]; ok && v != nil {
+				result[key] = v
+			}
+		}
+		for i, raw := range sliceField(event, "candidates") {
+			candidate, ok := raw.(map[string]any)
+			if !ok {
+				continue
+			}
+			for len(candidates) <= i {
+				candidates = append(candidates, map[string]any{
+					"index":   len(candidates),
+					"content": map[string]any{"role": "model", "parts": []any{}},
+				})
+			}
+			target := candidates[i]
+			for key, value := range candidate {
+				if key != "content" {
+					target[key] = value
+				}
+			}
+			content := mapField(candidate, "content")
+			if content == nil {
+				continue
+			}
+			targetContent := mapField(target, "content")
+			parts := sliceField(targetContent, "parts")
+			for _, rawPart := range sliceField(content, "parts") {
+				part, ok := rawPart.(map[string]any)
+				if !ok {
+					continue
+				}
+				if text, isText := part["text"].(string); isText && len(parts) > 0 {
+					last, _ := parts[len(parts)-1].(map[string]any)
+					if lastText, lastIsText := last["text"].(string); lastIsText && last["thought"] == part["thought"] {
+						last["text"] = lastText + text
+						continue
+					}
+				}
+				parts = append(parts, part)
+			}
+			targetContent["parts"] = parts
+		}
+	}
+	result["candidates"] = candidates
+	return result
+}
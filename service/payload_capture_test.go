@@ -0,0 +1,91 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactJSONPayloadWildcardPaths(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"secret"},{"role":"assistant","content":"also secret"}],"user":"alice"}`)
+	redacted := string(redactJSONPayload(body, []string{"messages.*.content", "user", "missing.path"}))
+
+	assert.NotContains(t, redacted, "secret")
+	assert.NotContains(t, redacted, "alice")
+	assert.Contains(t, redacted, `"model":"gpt-4o"`)
+	assert.Equal(t, 3, strings.Count(redacted, payloadRedactedValue))
+
+	// 非 JSON 与未命中路径时原样返回
+	assert.Equal(t, "not json", string(redactJSONPayload([]byte("not json"), []string{"user"})))
+	assert.Equal(t, string(body), string(redactJSONPayload(body, []string{"missing"})))
+}
+
+func TestRedactResponsePayloadStreamDropsPartialEvent(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\ndata: {\"choices\":[{\"del"
+	redacted := redactResponsePayload([]byte(stream), true, true, []string{"choices.*.delta.content"})
+
+	assert.Contains(t, redacted, payloadRedactedValue)
+	assert.Contains(t, redacted, "data: [DONE]")
+	assert.NotContains(t, redacted, `"hi"`)
+	assert.False(t, strings.HasSuffix(redacted, `"del`))
+
+	// 截断的非流式响应无法脱敏，整体丢弃
+	assert.Equal(t, "", redactResponsePayload([]byte(`{"choices":[`), false, true, []string{"choices"}))
+	// 未配置脱敏路径时保留截断内容
+	assert.Equal(t, `{"choices":[`, redactResponsePayload([]byte(`{"choices":[`), false, true, nil))
+}
+
+func TestTruncatePayloadKeepsRuneBoundary(t *testing.T) {
+	text, truncated := truncatePayload([]byte("ab你好"), 4)
+	assert.True(t, truncated)
+	assert.Equal(t, "ab", text)
+
+	text, truncated = truncatePayload([]byte("abc"), 4)
+	assert.False(t, truncated)
+	assert.Equal(t, "abc", text)
+}
+
+func TestAssembleStreamPayloadChatCompletion(t *testing.T) {
+	stream := strings.Join([]string{
+		`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		`: keep-alive`,
+		`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"lo","tool_calls":[{"index":0,"id":"call_1","function":{"name":"f","arguments":"{\"a\""}}]}}]}`,
+		`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":1}"}}]},"finish_reason":"tool_calls"}],"usage":{"total_tokens":9}}`,
+		`data: [DONE]`,
+	}, "\n\n")
+
+	text, truncated := capturedResponseText([]byte(stream), true, false, 1<<20, []string{"choices.*.message.content"})
+	assert.False(t, truncated)
+	assert.NotContains(t, text, "data:")
+	assert.NotContains(t, text, "Hello")
+	assert.Contains(t, text, payloadRedactedValue)
+	assert.Contains(t, text, `"object":"chat.completion"`)
+	assert.Contains(t, text, `"arguments":"{\"a\":1}"`)
+	assert.Contains(t, text, `"finish_reason":"tool_calls"`)
+	assert.Contains(t, text, `"total_tokens":9`)
+}
+
+func TestAssembleStreamPayloadClaude(t *testing.T) {
+	stream := strings.Join([]string{
+		"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"role\":\"assistant\",\"usage\":{\"input_tokens\":3}}}",
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi \"}}",
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"there\"}}",
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":2}}",
+	}, "\n\n")
+
+	text := string(assembleStreamPayload([]byte(stream)))
+	assert.Contains(t, text, `"text":"Hi there"`)
+	assert.Contains(t, text, `"stop_reason":"end_turn"`)
+	assert.Contains(t, text, `"input_tokens":3`)
+	assert.Contains(t, text, `"output_tokens":2`)
+}
+
+func TestAssembleStreamPayloadUnknownFormat(t *testing.T) {
+	assert.Nil(t, assembleStreamPayload([]byte("data: {\"foo\":1}\n\n")))
+
+	text, truncated := capturedResponseText([]byte("data: {\"foo\":1}\n\n"), true, false, 8, nil)
+	assert.True(t, truncated)
+	assert.Equal(t, "data: {\"", text)
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/cachex"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/samber/hot"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	responseCacheNamespace     = "new-api:response_cache:v1"
+	ginKeyResponseCacheSession = "response_cache_session"
+
+	responseCacheHeader = "X-Response-Cache"
+)
+
+var (
+	responseCacheOnce sync.Once
+	responseCache     *cachex.HybridCache[ResponseCacheEntry]
+)
+
+// ResponseCacheEntry 缓存的响应，流式响应保存下发给客户端的原始 SSE 数据
+type ResponseCacheEntry struct {
+	ContentType   string    `json:"content_type"`
+	Body          string    `json:"body"`
+	IsStream      bool      `json:"is_stream"`
+	UpstreamModel string    `json:"upstream_model"`
+	Usage         dto.Usage `json:"usage"`
+	CreatedAt     int64     `json:"created_at"`
+}
+
+func getResponseCache() *cachex.HybridCache[ResponseCacheEntry] {
+	responseCacheOnce.Do(func() {
+		setting := operation_setting.GetResponseCacheSetting()
+		capacity := setting.MaxEntries
+		if capacity <= 0 {
+			capacity = 10000
+		}
+		responseCache = cachex.NewHybridCache[ResponseCacheEntry](cachex.HybridCacheConfig[ResponseCacheEntry]{
+			Namespace: cachex.Namespace(responseCacheNamespace),
+			Redis:     common.RDB,
+			RedisEnabled: func() bool {
+				return common.RedisEnabled && common.RDB != nil
+			},
+			RedisCodec: cachex.JSONCodec[ResponseCacheEntry]{},
+			Memory: func() *hot.HotCache[string, ResponseCacheEntry] {
+				return hot.NewHotCache[string, ResponseCacheEntry](hot.LRU, capacity).
+					WithTTL(time.Duration(setting.GetTTLSeconds()) * time.Second).
+					WithJanitor().
+					Build()
+			},
+		})
+	})
+	return responseCache
+}
+
+// responseCacheWriter 在写给客户端的同时记录响应内容，超过大小限制后放弃记录
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	maxBytes int
+	overflow bool
+}
+
+func (w *responseCacheWriter) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > w.maxBytes {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *responseCacheWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// ResponseCacheSession 单个请求的响应缓存上下文
+type ResponseCacheSession struct {
+	key        string
+	skipLookup bool
+	writer     *responseCacheWriter
+	usage      *dto.Usage
+}
+
+func isResponseCacheableRequest(info *relaycommon.RelayInfo) bool {
+	switch info.RelayFormat {
+	case types.RelayFormatClaude, types.RelayFormatGemini, types.RelayFormatOpenAIResponses, types.RelayFormatEmbedding:
+		return true
+	case types.RelayFormatOpenAI:
+		return info.RelayMode == relayconstant.RelayModeChatCompletions || info.RelayMode == relayconstant.RelayModeCompletions
+	}
+	return false
+}
+
+// isDeterministicRequest 判断请求是否显式指定 temperature 为 0，向量请求本身是确定的
+func isDeterministicRequest(info *relaycommon.RelayInfo, body []byte) bool {
+	if info.RelayFormat == types.RelayFormatEmbedding || info.RelayMode == relayconstant.RelayModeEmbeddings ||
+		strings.Contains(info.RequestURLPath, "embedContent") || strings.Contains(info.RequestURLPath, "batchEmbedContents") {
+		return true
+	}
+	for _, path := range []string{"temperature", "generationConfig.temperature", "generation_config.temperature"} {
+		temperature := gjson.GetBytes(body, path)
+		if temperature.Exists() {
+			return temperature.Type == gjson.Number && temperature.Float() == 0
+		}
+	}
+	return false
+}
+
+// normalizeResponseCacheBody 重新序列化请求体以消除字段顺序与空白差异，并去掉不影响输出的字段
+func normalizeResponseCacheBody(body []byte) ([]byte, error) {
+	var value any
+	if err := common.Unmarshal(body, &value); err != nil {
+		return nil, err
+	}
+	if m, ok := value.(map[string]any); ok {
+		delete(m, "user")
+		delete(m, "metadata")
+	}
+	return common.Marshal(value)
+}
+
+func buildResponseCacheKey(info *relaycommon.RelayInfo, path string, upstreamModel string, normalizedBody []byte) string {
+	hash := sha256.New()
+	for _, part := range []string{string(info.RelayFormat), path, strconv.FormatBool(info.IsStream), info.UsingGroup, upstreamModel} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{'\n'})
+	}
+	hash.Write(normalizedBody)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// NewResponseCacheSession 判断请求是否可使用响应缓存，不可缓存时返回 nil。
+// upstreamModel 为渠道模型映射后的上游模型名，与分组、请求体共同组成缓存键。
+func NewResponseCacheSession(c *gin.Context, info *relaycommon.RelayInfo, upstreamModel string) *ResponseCacheSession {
+	setting := operation_setting.GetResponseCacheSetting()
+	if !setting.Enabled || !common.GetContextKeyBool(c, constant.ContextKeyTokenResponseCache) {
+		return nil
+	}
+	cacheControl := strings.ToLower(c.Request.Header.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-store") {
+		return nil
+	}
+	if !isResponseCacheableRequest(info) {
+		return nil
+	}
+
+	storage, err := common.GetBodyStorage(c)
+	if err != nil {
+		return nil
+	}
+	body, err := storage.Bytes()
+	if err != nil || len(body) == 0 {
+		return nil
+	}
+	if setting.OnlyDeterministic && !isDeterministicRequest(info, body) {
+		return nil
+	}
+	normalizedBody, err := normalizeResponseCacheBody(body)
+	if err != nil {
+		return nil
+	}
+
+	return &ResponseCacheSession{
+		key:        buildResponseCacheKey(info, c.Request.URL.Path, upstreamModel, normalizedBody),
+		skipLookup: strings.Contains(cacheControl, "no-cache"),
+	}
+}
+
+// Lookup 查找缓存的响应，未命中时返回 nil
+func (s *ResponseCacheSession) Lookup(c *gin.Context) *ResponseCacheEntry {
+	if s == nil || s.skipLookup {
+		return nil
+	}
+	entry, found, err := getResponseCache().Get(s.key)
+	if err != nil {
+		logger.LogWarn(c, "failed to get response cache: "+err.Error())
+		return nil
+	}
+	if !found {
+		return nil
+	}
+	return &entry
+}
+
+// StartRecording 替换 c.Writer 以记录下发给客户端的响应
+func (s *ResponseCacheSession) StartRecording(c *gin.Context) {
+	if s == nil {
+		return
+	}
+	s.writer = &responseCacheWriter{
+		ResponseWriter: c.Writer,
+		maxBytes:       operation_setting.GetResponseCacheSetting().GetMaxBodyBytes(),
+	}
+	c.Writer = s.writer
+	c.Writer.Header().Set(responseCacheHeader, "MISS")
+	c.Set(ginKeyResponseCacheSession, s)
+}
+
+// ResetRecording 丢弃上一次重试记录的内容
+func (s *ResponseCacheSession) ResetRecording() {
+	if s == nil || s.writer == nil {
+		return
+	}
+	s.writer.body.Reset()
+	s.writer.overflow = false
+	s.usage = nil
+}
+
+// Save 在请求成功后写入缓存，仅缓存完整结束且有用量信息的 200 响应
+func (s *ResponseCacheSession) Save(c *gin.Context, info *relaycommon.RelayInfo) {
+	if s == nil || s.writer == nil || s.writer.overflow || s.writer.body.Len() == 0 || s.usage == nil {
+		return
+	}
+	if s.writer.Status() != 200 || s.usage.PromptTokens+s.usage.CompletionTokens == 0 {
+		return
+	}
+	if info.StreamStatus != nil && (!info.StreamStatus.IsNormalEnd() || info.StreamStatus.HasErrors()) {
+		return
+	}
+	entry := ResponseCacheEntry{
+		ContentType:   s.writer.Header().Get("Content-Type"),
+		Body:          s.writer.body.String(),
+		IsStream:      info.IsStream,
+		UpstreamModel: info.UpstreamModelName,
+		Usage:         *s.usage,
+		CreatedAt:     time.Now().Unix(),
+	}
+	ttl := time.Duration(operation_setting.GetResponseCacheSetting().GetTTLSeconds()) * time.Second
+	if err := getResponseCache().SetWithTTL(s.key, entry, ttl); err != nil {
+		logger.LogWarn(c, "failed to save response cache: "+err.Error())
+	}
+}
+
+// recordResponseCacheUsage 记录本次请求结算的用量，命中缓存时按该用量计费
+func recordResponseCacheUsage(ctx *gin.Context, usage *dto.Usage) {
+	if ctx == nil || usage == nil {
+		return
+	}
+	value, ok := ctx.Get(ginKeyResponseCacheSession)
+	if !ok {
+		return
+	}
+	if session, ok := value.(*ResponseCacheSession); ok && session != nil {
+		usageCopy := *usage
+		session.usage = &usageCopy
+	}
+}
+
+// ReplayResponseCache 将缓存的响应写回客户端，流式响应按 SSE 事件逐个发送
+func ReplayResponseCache(c *gin.Context, entry *ResponseCacheEntry) {
+	c.Writer.Header().Set(responseCacheHeader, "HIT")
+	if entry.ContentType != "" {
+		c.Writer.Header().Set("Content-Type", entry.ContentType)
+	}
+	if !entry.IsStream {
+		c.Writer.WriteHeader(200)
+		_, _ = c.Writer.WriteString(entry.Body)
+		return
+	}
+
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.WriteHeader(200)
+	body := entry.Body
+	for body != "" {
+		idx := strings.Index(body, "\n\n")
+		var chunk string
+		if idx < 0 {
+			chunk, body = body, ""
+		} else {
+			chunk, body = body[:idx+2], body[idx+2:]
+		}
+		if _, err := c.Writer.WriteString(chunk); err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+func appendResponseCacheInfo(ctx *gin.Context, other map[string]interface{}) {
+	if ctx == nil || other == nil {
+		return
+	}
+	if !common.GetContextKeyBool(ctx, constant.ContextKeyResponseCacheHit) {
+		return
+	}
+	other["response_cache_hit"] = true
+	other["response_cache_ratio"] = operation_setting.GetResponseCacheSetting().GetBillingRatio()
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func newResponseCacheTestContext(t *testing.T, body string, header http.Header) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	for k, v := range header {
+		c.Request.Header[k] = v
+	}
+	common.SetContextKey(c, constant.ContextKeyTokenResponseCache, true)
+	return c, recorder
+}
+
+func TestResponseCacheSessionKey(t *testing.T) {
+	setting := operation_setting.GetResponseCacheSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+	setting.Enabled = true
+	setting.OnlyDeterministic = true
+
+	info := &relaycommon.RelayInfo{RelayFormat: types.RelayFormatOpenAI, RelayMode: relayconstant.RelayModeChatCompletions, UsingGroup: "default"}
+
+	c1, _ := newResponseCacheTestContext(t, `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}],"user":"a"}`, nil)
+	c2, _ := newResponseCacheTestContext(t, `{"messages":[{"content":"hi","role":"user"}],  "temperature":0, "model":"gpt-4o","user":"b"}`, nil)
+	s1 := NewResponseCacheSession(c1, info, "gpt-4o")
+	s2 := NewResponseCacheSession(c2, info, "gpt-4o")
+	require.NotNil(t, s1)
+	require.NotNil(t, s2)
+	require.Equal(t, s1.key, s2.key)
+
+	c3, _ := newResponseCacheTestContext(t, `{"model":"gpt-4o","temperature":0,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	require.NotEqual(t, s1.key, NewResponseCacheSession(c3, info, "gpt-4o-2024-08-06").key)
+
+	c4, _ := newResponseCacheTestContext(t, `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`, nil)
+	require.Nil(t, NewResponseCacheSession(c4, info, "gpt-4o"))
+
+	c5, _ := newResponseCacheTestContext(t, `{"model":"gpt-4o","temperature":0,"messages":[]}`, http.Header{"Cache-Control": {"no-store"}})
+	require.Nil(t, NewResponseCacheSession(c5, info, "gpt-4o"))
+
+	c6, _ := newResponseCacheTestContext(t, `{"model":"gpt-4o","temperature":0,"messages":[]}`, nil)
+	common.SetContextKey(c6, constant.ContextKeyTokenResponseCache, false)
+	require.Nil(t, NewResponseCacheSession(c6, info, "gpt-4o"))
+}
+
+func TestResponseCacheRecordAndReplayStream(t *testing.T) {
+	setting := operation_setting.GetResponseCacheSetting()
+	saved := *setting
+	t.Cleanup(func() { *setting = saved })
+	setting.Enabled = true
+	setting.OnlyDeterministic = false
+
+	body := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"replay"}]}`
+	info := &relaycommon.RelayInfo{
+		RelayFormat: types.RelayFormatOpenAI,
+		RelayMode:   relayconstant.RelayModeChatCompletions,
+		IsStream:    true,
+		ChannelMeta: &relaycommon.ChannelMeta{UpstreamModelName: "gpt-4o"},
+	}
+
+	c, recorder := newResponseCacheTestContext(t, body, nil)
+	session := NewResponseCacheSession(c, info, "gpt-4o")
+	require.NotNil(t, session)
+	require.Nil(t, session.Lookup(c))
+
+	session.StartRecording(c)
+	session.ResetRecording()
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	_, _ = c.Writer.WriteString("data: {\"id\":\"1\"}\n\n")
+	_, _ = c.Writer.WriteString("data: [DONE]\n\n")
+	recordResponseCacheUsage(c, &dto.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5})
+	session.Save(c, info)
+	require.Equal(t, "MISS", recorder.Header().Get(responseCacheHeader))
+
+	hitCtx, hitRecorder := newResponseCacheTestContext(t, body, nil)
+	entry := NewResponseCacheSession(hitCtx, info, "gpt-4o").Lookup(hitCtx)
+	require.NotNil(t, entry)
+	require.True(t, entry.IsStream)
+	require.Equal(t, 5, entry.Usage.TotalTokens)
+
+	ReplayResponseCache(hitCtx, entry)
+	require.Equal(t, "HIT", hitRecorder.Header().Get(responseCacheHeader))
+	require.Equal(t, "text/event-stream", hitRecorder.Header().Get("Content-Type"))
+	require.Equal(t, "data: {\"id\":\"1\"}\n\ndata: [DONE]\n\n", hitRecorder.Body.String())
+}
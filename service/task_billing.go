@@ -84,15 +84,24 @@ func taskIsSubscription(task *model.Task) bool {
 	return task.PrivateData.BillingSource == BillingSourceSubscription && task.PrivateData.SubscriptionId > 0
 }
 
-// taskAdjustFunding 调整任务的资金来源（钱包或订阅），delta > 0 表示扣费，delta < 0 表示退还。
+// taskAdjustFunding 调整任务的资金来源（钱包、订阅或组织），delta > 0 表示扣费，delta < 0 表示退还。
 func taskAdjustFunding(task *model.Task, delta int) error {
-	if taskIsSubscription(task) {
-		return model.PostConsumeUserSubscriptionDelta(task.PrivateData.SubscriptionId, int64(delta))
+	return adjustFundingQuota(task.PrivateData.BillingSource, task.UserId, task.PrivateData.SubscriptionId, task.PrivateData.OrgId, delta)
+}
+
+// adjustFundingQuota 按预扣时记录的资金来源调整额度，用于请求结束后才结算的异步任务与批处理，
+// delta > 0 表示扣费，delta < 0 表示退还。
+func adjustFundingQuota(billingSource string, userId int, subscriptionId int, orgId int, delta int) error {
+	if billingSource == BillingSourceSubscription && subscriptionId > 0 {
+		return model.PostConsumeUserSubscriptionDelta(subscriptionId, int64(delta))
+	}
+	if billingSource == BillingSourceOrganization && orgId > 0 {
+		return model.AdjustOrganizationQuota(orgId, userId, delta)
 	}
 	if delta > 0 {
-		return model.DecreaseUserQuota(task.UserId, delta, false)
+		return model.DecreaseUserQuota(userId, delta, false)
 	}
-	return model.IncreaseUserQuota(task.UserId, -delta, false)
+	return model.IncreaseUserQuota(userId, -delta, false)
 }
 
 // taskAdjustTokenQuota 调整任务的令牌额度，delta > 0 表示扣费，delta < 0 表示退还。
@@ -176,6 +185,7 @@ func RefundTaskQuota(ctx context.Context, task *model.Task, reason string) {
 		ModelName: taskModelName(task),
 		Quota:     quota,
 		TokenId:   task.PrivateData.TokenId,
+		OrgId:     task.PrivateData.OrgId,
 		Group:     task.Group,
 		Other:     other,
 	})
@@ -239,6 +249,7 @@ func RecalculateTaskQuota(ctx context.Context, task *model.Task, actualQuota int
 		ModelName: taskModelName(task),
 		Quota:     logQuota,
 		TokenId:   task.PrivateData.TokenId,
+		OrgId:     task.PrivateData.OrgId,
 		Group:     task.Group,
 		Other:     other,
 	})
@@ -44,6 +44,15 @@ func TestMain(m *testing.M) {
 		&model.Channel{},
 		&model.TopUp{},
 		&model.UserSubscription{},
+		&model.File{},
+		&model.FileChunk{},
+		&model.Batch{},
+		&model.BatchItem{},
+		&model.Organization{},
+		&model.OrganizationMember{},
+		&model.LogExportJob{},
+		&model.LogSinkState{},
+		&model.AuditLog{},
 	); err != nil {
 		panic("failed to migrate: " + err.Error())
 	}
@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/pkg/metrics"
 	"github.com/QuantumNous/new-api/relay/channel/task/taskcommon"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 
@@ -99,6 +100,10 @@ func TaskPollingLoop() {
 		for _, t := range allTasks {
 			platformTask[t.Platform] = append(platformTask[t.Platform], t)
 		}
+		metrics.ResetTaskPollingBacklog()
+		for platform, tasks := range platformTask {
+			metrics.SetTaskPollingBacklog(string(platform), len(tasks))
+		}
 		for platform, tasks := range platformTask {
 			if len(tasks) == 0 {
 				continue
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CalculateUpstreamCost 按渠道配置的上游成本表达式计算本次请求的采购成本（额度单位，不乘分组倍率）。
+// 未配置表达式或计算失败时返回 0。
+func CalculateUpstreamCost(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, isClaudeUsageSemantic bool) int {
+	if relayInfo == nil || relayInfo.ChannelMeta == nil || usage == nil {
+		return 0
+	}
+	exprStr := relayInfo.ChannelOtherSettings.UpstreamCostExpr
+	if exprStr == "" {
+		return 0
+	}
+	requestInput := billingexpr.RequestInput{Headers: relayInfo.RequestHeaders}
+	if relayInfo.BillingRequestInput != nil {
+		requestInput = *relayInfo.BillingRequestInput
+	}
+	snap := &billingexpr.BillingSnapshot{
+		ExprString:   exprStr,
+		ExprHash:     billingexpr.ExprHashString(exprStr),
+		GroupRatio:   1,
+		QuotaPerUnit: common.QuotaPerUnit,
+		ExprVersion:  billingexpr.ExprVersion(exprStr),
+	}
+	params := BuildTieredTokenParams(usage, isClaudeUsageSemantic, billingexpr.UsedVars(exprStr))
+	result, err := billingexpr.ComputeTieredQuotaWithRequest(snap, params, requestInput)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("upstream cost expr failed, channelId %d, error: %s", relayInfo.ChannelId, err.Error()))
+		return 0
+	}
+	return result.ActualQuotaAfterGroup
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/pkg/billingexpr"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateUpstreamCost(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	usage := &dto.Usage{PromptTokens: 1000000, CompletionTokens: 100000, TotalTokens: 1100000}
+
+	relayInfo := &relaycommon.RelayInfo{ChannelMeta: &relaycommon.ChannelMeta{}}
+	assert.Equal(t, 0, CalculateUpstreamCost(ctx, relayInfo, usage, false))
+
+	relayInfo.ChannelOtherSettings.UpstreamCostExpr = flatExpr
+	// 成本不受分组倍率影响
+	relayInfo.PriceData.GroupRatioInfo.GroupRatio = 3
+	assert.Equal(t, int(3*common.QuotaPerUnit), CalculateUpstreamCost(ctx, relayInfo, usage, false))
+
+	relayInfo.ChannelOtherSettings.UpstreamCostExpr = probeExpr
+	assert.Equal(t, int(3*common.QuotaPerUnit), CalculateUpstreamCost(ctx, relayInfo, usage, false))
+	relayInfo.BillingRequestInput = &billingexpr.RequestInput{Body: []byte(`{"service_tier":"fast"}`)}
+	assert.Equal(t, int(6*common.QuotaPerUnit), CalculateUpstreamCost(ctx, relayInfo, usage, false))
+
+	assert.Equal(t, 0, CalculateUpstreamCost(ctx, &relaycommon.RelayInfo{}, usage, false))
+}
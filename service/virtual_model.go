@@ -0,0 +1,75 @@
+package service
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SelectVirtualModelChannel 依次尝试虚拟模型链中的真实模型，返回第一个有可用渠道的模型及其渠道，
+// 并在上下文中记录虚拟模型的解析状态，供重试时回退到下一个模型。
+func SelectVirtualModelChannel(c *gin.Context, virtualModel string, chain []string, usingGroup string) (*model.Channel, string, string, error) {
+	var (
+		channel     *model.Channel
+		selectGroup string
+		err         error
+	)
+	for i, realModel := range chain {
+		resetAutoGroupState(c)
+		channel, selectGroup, err = CacheGetRandomSatisfiedChannel(&RetryParam{
+			Ctx:        c,
+			ModelName:  realModel,
+			TokenGroup: usingGroup,
+			Retry:      common.GetPointer(0),
+		})
+		if err == nil && channel != nil {
+			common.SetContextKey(c, constant.ContextKeyVirtualModel, virtualModel)
+			common.SetContextKey(c, constant.ContextKeyVirtualModelChain, chain)
+			common.SetContextKey(c, constant.ContextKeyVirtualModelIndex, i)
+			common.SetContextKey(c, constant.ContextKeyVirtualModelAttempts, []string{realModel})
+			return channel, realModel, selectGroup, nil
+		}
+		logger.LogDebug(c, "virtual model %s: no available channel for %s, trying next model", virtualModel, realModel)
+	}
+	return channel, "", selectGroup, err
+}
+
+// NextVirtualModel 切换到虚拟模型链中的下一个模型，非虚拟模型请求或链已用尽时返回 false
+func NextVirtualModel(c *gin.Context) (string, bool) {
+	if common.GetContextKeyString(c, constant.ContextKeyVirtualModel) == "" {
+		return "", false
+	}
+	chain := common.GetContextKeyStringSlice(c, constant.ContextKeyVirtualModelChain)
+	next := common.GetContextKeyInt(c, constant.ContextKeyVirtualModelIndex) + 1
+	if next >= len(chain) {
+		return "", false
+	}
+	common.SetContextKey(c, constant.ContextKeyVirtualModelIndex, next)
+	attempts := common.GetContextKeyStringSlice(c, constant.ContextKeyVirtualModelAttempts)
+	common.SetContextKey(c, constant.ContextKeyVirtualModelAttempts, append(append([]string(nil), attempts...), chain[next]))
+	resetAutoGroupState(c)
+	return chain[next], true
+}
+
+// resetAutoGroupState 切换模型后 auto 分组需要从第一个分组重新选择
+func resetAutoGroupState(c *gin.Context) {
+	if _, ok := common.GetContextKey(c, constant.ContextKeyAutoGroupIndex); ok {
+		common.SetContextKey(c, constant.ContextKeyAutoGroupIndex, 0)
+		common.SetContextKey(c, constant.ContextKeyAutoGroupRetryIndex, 0)
+	}
+}
+
+func appendVirtualModelInfo(ctx *gin.Context, other map[string]interface{}) {
+	if ctx == nil || other == nil {
+		return
+	}
+	virtualModel := common.GetContextKeyString(ctx, constant.ContextKeyVirtualModel)
+	if virtualModel == "" {
+		return
+	}
+	other["virtual_model"] = virtualModel
+	other["virtual_model_chain"] = common.GetContextKeyStringSlice(ctx, constant.ContextKeyVirtualModelAttempts)
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextVirtualModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	_, ok := NextVirtualModel(c)
+	require.False(t, ok)
+
+	common.SetContextKey(c, constant.ContextKeyVirtualModel, "smart-default")
+	common.SetContextKey(c, constant.ContextKeyVirtualModelChain, []string{"gpt-4o", "claude-sonnet-4", "gpt-4o-mini"})
+	common.SetContextKey(c, constant.ContextKeyVirtualModelIndex, 0)
+	common.SetContextKey(c, constant.ContextKeyVirtualModelAttempts, []string{"gpt-4o"})
+
+	next, ok := NextVirtualModel(c)
+	require.True(t, ok)
+	require.Equal(t, "claude-sonnet-4", next)
+	next, ok = NextVirtualModel(c)
+	require.True(t, ok)
+	require.Equal(t, "gpt-4o-mini", next)
+	_, ok = NextVirtualModel(c)
+	require.False(t, ok)
+
+	other := map[string]interface{}{}
+	appendVirtualModelInfo(c, other)
+	require.Equal(t, "smart-default", other["virtual_model"])
+	require.Equal(t, []string{"gpt-4o", "claude-sonnet-4", "gpt-4o-mini"}, other["virtual_model_chain"])
+}
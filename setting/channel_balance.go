@@ -0,0 +1,78 @@
+package setting
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 渠道负载均衡策略（同一优先级内的渠道选择方式）
+const (
+	ChannelBalanceWeightedRandom   = "weighted_random"   // 按权重随机（默认）
+	ChannelBalanceEWMALatency      = "ewma_latency"      // 按延迟 EWMA 和错误率加权随机
+	ChannelBalanceLeastOutstanding = "least_outstanding" // 最少进行中请求
+	ChannelBalancePowerOfTwo       = "p2c"               // 随机选两个，取负载更低者
+)
+
+// groupChannelBalance group -> strategy, the "*" entry applies to groups without their own strategy
+var groupChannelBalance = map[string]string{}
+var groupChannelBalanceMutex sync.RWMutex
+
+func IsValidChannelBalanceStrategy(strategy string) bool {
+	switch strategy {
+	case ChannelBalanceWeightedRandom, ChannelBalanceEWMALatency, ChannelBalanceLeastOutstanding, ChannelBalancePowerOfTwo:
+		return true
+	}
+	return false
+}
+
+func GroupChannelBalance2JsonString() string {
+	groupChannelBalanceMutex.RLock()
+	defer groupChannelBalanceMutex.RUnlock()
+
+	jsonBytes, err := common.Marshal(groupChannelBalance)
+	if err != nil {
+		return "{}"
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupChannelBalanceByJsonString(jsonString string) error {
+	newValue := make(map[string]string)
+	if err := common.UnmarshalJsonStr(jsonString, &newValue); err != nil {
+		return err
+	}
+	groupChannelBalanceMutex.Lock()
+	defer groupChannelBalanceMutex.Unlock()
+	groupChannelBalance = newValue
+	return nil
+}
+
+func CheckGroupChannelBalance(jsonString string) error {
+	checkValue := make(map[string]string)
+	if err := common.UnmarshalJsonStr(jsonString, &checkValue); err != nil {
+		return err
+	}
+	for group, strategy := range checkValue {
+		if !IsValidChannelBalanceStrategy(strategy) {
+			return fmt.Errorf("group %s has unknown channel balance strategy: %s", group, strategy)
+		}
+	}
+	return nil
+}
+
+// GetGroupChannelBalance returns the balancing strategy of a group, falling back to the "*"
+// entry and finally to weighted random.
+func GetGroupChannelBalance(group string) string {
+	groupChannelBalanceMutex.RLock()
+	defer groupChannelBalanceMutex.RUnlock()
+
+	if strategy, ok := groupChannelBalance[group]; ok && IsValidChannelBalanceStrategy(strategy) {
+		return strategy
+	}
+	if strategy, ok := groupChannelBalance["*"]; ok && IsValidChannelBalanceStrategy(strategy) {
+		return strategy
+	}
+	return ChannelBalanceWeightedRandom
+}
@@ -0,0 +1,77 @@
+package model_setting
+
+import (
+	"sort"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// VirtualModelSettings defines admin-managed virtual models.
+//
+// A virtual model (e.g. "smart-default") resolves to an ordered chain of real models. Requests
+// use the first model that has an available channel, and fall back to the next model once all
+// channels of the current one fail or are rate limited. Each attempt is priced as its real model.
+type VirtualModelSettings struct {
+	Enabled bool                `json:"enabled"`
+	Models  map[string][]string `json:"models"`
+}
+
+var defaultVirtualModelSettings = VirtualModelSettings{
+	Enabled: false,
+	Models:  map[string][]string{},
+}
+
+var virtualModelSettings = defaultVirtualModelSettings
+
+func init() {
+	config.GlobalConfig.Register("virtual_model", &virtualModelSettings)
+}
+
+func GetVirtualModelSettings() *VirtualModelSettings {
+	return &virtualModelSettings
+}
+
+// GetVirtualModelChain returns the real models of a virtual model, or nil if name is not a virtual model.
+// Members that are themselves virtual models and duplicates are dropped.
+func GetVirtualModelChain(name string) []string {
+	if !virtualModelSettings.Enabled || name == "" {
+		return nil
+	}
+	models, ok := virtualModelSettings.Models[name]
+	if !ok {
+		return nil
+	}
+	chain := make([]string, 0, len(models))
+	seen := make(map[string]bool, len(models))
+	for _, m := range models {
+		if m == "" || seen[m] {
+			continue
+		}
+		if _, isVirtual := virtualModelSettings.Models[m]; isVirtual {
+			continue
+		}
+		seen[m] = true
+		chain = append(chain, m)
+	}
+	return chain
+}
+
+// IsVirtualModel reports whether name is a configured virtual model with at least one real model.
+func IsVirtualModel(name string) bool {
+	return len(GetVirtualModelChain(name)) > 0
+}
+
+// GetVirtualModelNames returns the names of all configured virtual models.
+func GetVirtualModelNames() []string {
+	if !virtualModelSettings.Enabled {
+		return nil
+	}
+	names := make([]string, 0, len(virtualModelSettings.Models))
+	for name := range virtualModelSettings.Models {
+		if IsVirtualModel(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
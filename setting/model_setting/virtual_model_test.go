@@ -0,0 +1,38 @@
+package model_setting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetVirtualModelChain(t *testing.T) {
+	saved := virtualModelSettings
+	t.Cleanup(func() { virtualModelSettings = saved })
+
+	virtualModelSettings = VirtualModelSettings{
+		Enabled: true,
+		Models: map[string][]string{
+			"smart-default": {"gpt-4o", "", "claude-sonnet-4", "gpt-4o", "cheap-default"},
+			"cheap-default": {"gpt-4o-mini"},
+			"empty":         {},
+		},
+	}
+
+	if got := GetVirtualModelChain("smart-default"); !reflect.DeepEqual(got, []string{"gpt-4o", "claude-sonnet-4"}) {
+		t.Fatalf("unexpected chain: %v", got)
+	}
+	if GetVirtualModelChain("gpt-4o") != nil {
+		t.Fatalf("real model should not resolve to a chain")
+	}
+	if IsVirtualModel("empty") {
+		t.Fatalf("virtual model without real models should be ignored")
+	}
+	if got := GetVirtualModelNames(); !reflect.DeepEqual(got, []string{"cheap-default", "smart-default"}) {
+		t.Fatalf("unexpected names: %v", got)
+	}
+
+	virtualModelSettings.Enabled = false
+	if IsVirtualModel("smart-default") {
+		t.Fatalf("virtual models should be disabled")
+	}
+}
@@ -0,0 +1,52 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// BatchSetting Files / Batch API 配置
+type BatchSetting struct {
+	Enabled bool `json:"enabled"`
+	// DiscountRatio 批处理请求的计费倍率（叠加在分组倍率上），1 表示不打折
+	DiscountRatio float64 `json:"discount_ratio"`
+	// MaxFileSizeMB 单个上传文件的最大大小
+	MaxFileSizeMB int `json:"max_file_size_mb"`
+	// MaxRequestsPerBatch 单个批处理最多包含的请求行数
+	MaxRequestsPerBatch int `json:"max_requests_per_batch"`
+	// Concurrency 单个批处理同时执行的请求数
+	Concurrency int `json:"concurrency"`
+	// FileRetentionDays 文件与已结束批处理的保留天数，0 表示不自动清理
+	FileRetentionDays int `json:"file_retention_days"`
+}
+
+// 默认配置
+var batchSetting = BatchSetting{
+	Enabled:             false,
+	DiscountRatio:       1,
+	MaxFileSizeMB:       50,
+	MaxRequestsPerBatch: 10000,
+	Concurrency:         4,
+	FileRetentionDays:   30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch_setting", &batchSetting)
+}
+
+func GetBatchSetting() *BatchSetting {
+	return &batchSetting
+}
+
+// GetDiscountRatio returns the billing ratio of batch requests, treating invalid values as no discount.
+func (s *BatchSetting) GetDiscountRatio() float64 {
+	if s.DiscountRatio < 0 || s.DiscountRatio > 1 {
+		return 1
+	}
+	return s.DiscountRatio
+}
+
+func (s *BatchSetting) GetConcurrency() int {
+	if s.Concurrency <= 0 {
+		return 1
+	}
+	return s.Concurrency
+}
@@ -0,0 +1,34 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// BudgetSetting 令牌与用户周期预算配置
+type BudgetSetting struct {
+	// NotifyPercent 窗口内消费达到预算的百分比时通知用户，每个窗口只通知一次，0 表示不通知
+	NotifyPercent int `json:"notify_percent"`
+}
+
+// 默认配置
+var budgetSetting = BudgetSetting{
+	NotifyPercent: 80,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("budget_setting", &budgetSetting)
+}
+
+func GetBudgetSetting() *BudgetSetting {
+	return &budgetSetting
+}
+
+// GetNotifyPercent returns the notify percentage clamped to [0, 100].
+func (s *BudgetSetting) GetNotifyPercent() int {
+	if s.NotifyPercent < 0 {
+		return 0
+	}
+	if s.NotifyPercent > 100 {
+		return 100
+	}
+	return s.NotifyPercent
+}
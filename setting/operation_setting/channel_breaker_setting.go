@@ -0,0 +1,43 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelBreakerSetting 渠道熔断配置
+type ChannelBreakerSetting struct {
+	Enabled bool `json:"enabled"`
+	// WindowSeconds 统计错误率的滑动窗口长度
+	WindowSeconds int `json:"window_seconds"`
+	// MinRequests 窗口内请求数达到该值才会判断是否熔断
+	MinRequests int `json:"min_requests"`
+	// ErrorRateThreshold 错误率阈值（百分比），达到后熔断
+	ErrorRateThreshold float64 `json:"error_rate_threshold"`
+	// OpenSeconds 熔断持续时间，之后进入半开状态
+	OpenSeconds int `json:"open_seconds"`
+	// HalfOpenProbeRatio 半开状态下放行的流量比例 (0-1)
+	HalfOpenProbeRatio float64 `json:"half_open_probe_ratio"`
+	// HalfOpenSuccessCount 半开状态下连续成功多少次后恢复
+	HalfOpenSuccessCount int `json:"half_open_success_count"`
+	// RedisShared 启用 Redis 时在多个实例间共享熔断状态
+	RedisShared bool `json:"redis_shared"`
+}
+
+// 默认配置
+var channelBreakerSetting = ChannelBreakerSetting{
+	Enabled:              false,
+	WindowSeconds:        60,
+	MinRequests:          20,
+	ErrorRateThreshold:   50,
+	OpenSeconds:          30,
+	HalfOpenProbeRatio:   0.1,
+	HalfOpenSuccessCount: 3,
+	RedisShared:          true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_breaker_setting", &channelBreakerSetting)
+}
+
+func GetChannelBreakerSetting() *ChannelBreakerSetting {
+	return &channelBreakerSetting
+}
@@ -0,0 +1,52 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// HedgeSetting 对冲请求配置
+//
+// 开启后，首个渠道在延迟时间内未返回首字节时，向另一个渠道发起相同请求，采用先响应的一方并取消另一方，
+// 仅胜出的请求计费。只作用于无副作用的接口（对话、补全、向量、重排等）。
+type HedgeSetting struct {
+	Enabled bool `json:"enabled"`
+	// DefaultDelayMs 未单独配置的分组与模型使用的延迟，0 表示不对冲
+	DefaultDelayMs int `json:"default_delay_ms"`
+	// GroupDelayMs 按分组配置的延迟，0 表示该分组不对冲
+	GroupDelayMs map[string]int `json:"group_delay_ms"`
+	// ModelDelayMs 按模型配置的延迟，优先于分组配置，0 表示该模型不对冲
+	ModelDelayMs map[string]int `json:"model_delay_ms"`
+}
+
+// 默认配置
+var hedgeSetting = HedgeSetting{
+	Enabled:        false,
+	DefaultDelayMs: 0,
+	GroupDelayMs:   map[string]int{},
+	ModelDelayMs:   map[string]int{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge_setting", &hedgeSetting)
+}
+
+func GetHedgeSetting() *HedgeSetting {
+	return &hedgeSetting
+}
+
+// GetDelayMs returns the hedge delay of the group and model, 0 means hedging is disabled.
+func (s *HedgeSetting) GetDelayMs(group string, model string) int {
+	if !s.Enabled {
+		return 0
+	}
+	delay := s.DefaultDelayMs
+	if v, ok := s.GroupDelayMs[group]; ok {
+		delay = v
+	}
+	if v, ok := s.ModelDelayMs[model]; ok {
+		delay = v
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
@@ -0,0 +1,48 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// InvoiceSetting 后付费账单配置
+//
+// 开启后，每月初为设置了信用额度的用户按上月消费日志生成账单，
+// 账单到期未结清标记为逾期，逾期超过宽限天数后自动禁用用户，结清后恢复。
+type InvoiceSetting struct {
+	Enabled bool `json:"enabled"`
+	// DueDays 账单出具后的付款期限（天）
+	DueDays int `json:"due_days"`
+	// GraceDays 逾期后的宽限天数，超过后禁用用户
+	GraceDays int `json:"grace_days"`
+	// AutoSuspend 是否自动禁用逾期超过宽限期的用户
+	AutoSuspend bool `json:"auto_suspend"`
+}
+
+// 默认配置
+var invoiceSetting = InvoiceSetting{
+	Enabled:     false,
+	DueDays:     15,
+	GraceDays:   7,
+	AutoSuspend: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("invoice_setting", &invoiceSetting)
+}
+
+func GetInvoiceSetting() *InvoiceSetting {
+	return &invoiceSetting
+}
+
+func (s *InvoiceSetting) GetDueDays() int {
+	if s.DueDays <= 0 {
+		return 15
+	}
+	return s.DueDays
+}
+
+func (s *InvoiceSetting) GetGraceDays() int {
+	if s.GraceDays < 0 {
+		return 0
+	}
+	return s.GraceDays
+}
@@ -0,0 +1,104 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// LogExportSetting 日志导出任务配置
+type LogExportSetting struct {
+	// FileRetentionHours 导出文件保留小时数，过期后删除文件与任务记录
+	FileRetentionHours int `json:"file_retention_hours"`
+	// MaxRows 单个导出任务的最大行数，0 不限制
+	MaxRows int64 `json:"max_rows"`
+	// MaxConcurrentJobs 单个节点同时执行的导出任务数
+	MaxConcurrentJobs int `json:"max_concurrent_jobs"`
+}
+
+const (
+	LogSinkTypeHTTP      = "http"
+	LogSinkTypeKafkaRest = "kafka_rest"
+	LogSinkTypeS3        = "s3"
+)
+
+// LogSinkSetting 日志持续推送到外部系统的配置，由主节点按日志 id 顺序分批推送
+type LogSinkSetting struct {
+	Enabled bool `json:"enabled"`
+	// Type 推送目标：http 以 JSONL 格式 POST 到 Endpoint；kafka_rest 通过 Kafka REST Proxy 写入 Topic；
+	// s3 每批写入一个 JSONL 对象到兼容 S3 的对象存储
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+	// Headers 附加请求头，可用于鉴权（http/kafka_rest）
+	Headers map[string]string `json:"headers"`
+	// LogTypes 推送的日志类型，默认只推送消费日志
+	LogTypes             []int `json:"log_types"`
+	BatchSize            int   `json:"batch_size"`
+	FlushIntervalSeconds int   `json:"flush_interval_seconds"`
+
+	KafkaTopic string `json:"kafka_topic"`
+
+	S3Bucket          string `json:"s3_bucket"`
+	S3Region          string `json:"s3_region"`
+	S3Prefix          string `json:"s3_prefix"`
+	S3AccessKeyId     string `json:"s3_access_key_id"`
+	S3SecretAccessKey string `json:"s3_secret"` // 以 secret 结尾，选项接口不返回
+}
+
+// 默认配置
+var logExportSetting = LogExportSetting{
+	FileRetentionHours: 72,
+	MaxRows:            0,
+	MaxConcurrentJobs:  2,
+}
+
+var logSinkSetting = LogSinkSetting{
+	Enabled:              false,
+	Type:                 LogSinkTypeHTTP,
+	Headers:              map[string]string{},
+	LogTypes:             []int{2},
+	BatchSize:            500,
+	FlushIntervalSeconds: 10,
+	S3Region:             "us-east-1",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("log_export_setting", &logExportSetting)
+	config.GlobalConfig.Register("log_sink_setting", &logSinkSetting)
+}
+
+func GetLogExportSetting() *LogExportSetting {
+	return &logExportSetting
+}
+
+func GetLogSinkSetting() *LogSinkSetting {
+	return &logSinkSetting
+}
+
+func (s *LogExportSetting) GetFileRetentionHours() int {
+	if s.FileRetentionHours <= 0 {
+		return 72
+	}
+	return s.FileRetentionHours
+}
+
+func (s *LogExportSetting) GetMaxConcurrentJobs() int {
+	if s.MaxConcurrentJobs <= 0 {
+		return 1
+	}
+	return s.MaxConcurrentJobs
+}
+
+func (s *LogSinkSetting) GetBatchSize() int {
+	if s.BatchSize <= 0 {
+		return 500
+	}
+	if s.BatchSize > 5000 {
+		return 5000
+	}
+	return s.BatchSize
+}
+
+func (s *LogSinkSetting) GetFlushInterval() int {
+	if s.FlushIntervalSeconds <= 0 {
+		return 10
+	}
+	return s.FlushIntervalSeconds
+}
@@ -0,0 +1,63 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+const (
+	PayloadCaptureStorageDatabase = "database"
+	PayloadCaptureStorageDisk     = "disk"
+)
+
+// PayloadCaptureSetting 请求/响应载荷留存配置
+//
+// 开启后，仅对用户、令牌或渠道任一方单独启用了载荷留存的请求保存请求体与响应体（流式响应还原为完整的 JSON 响应，
+// 无法识别的流保存原始 SSE 内容），管理员可按 RequestId 查询，超过保留天数后自动删除。
+type PayloadCaptureSetting struct {
+	Enabled bool `json:"enabled"`
+	// Storage 存储位置：database 保存在日志数据库，disk 保存在磁盘缓存目录下（仅单节点部署生效，多节点部署时保存在日志数据库）
+	Storage string `json:"storage"`
+	// MaxBodyKB 请求体与响应体各自的最大保存大小，超出部分截断
+	MaxBodyKB int `json:"max_body_kb"`
+	// RetentionDays 保留天数
+	RetentionDays int `json:"retention_days"`
+	// RedactPaths 需要脱敏的 JSON 路径，以 . 分隔，* 匹配任意字段或数组元素，如 messages.*.content
+	RedactPaths []string `json:"redact_paths"`
+}
+
+// 默认配置
+var payloadCaptureSetting = PayloadCaptureSetting{
+	Enabled:       false,
+	Storage:       PayloadCaptureStorageDatabase,
+	MaxBodyKB:     32,
+	RetentionDays: 7,
+	RedactPaths:   []string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("payload_capture_setting", &payloadCaptureSetting)
+}
+
+func GetPayloadCaptureSetting() *PayloadCaptureSetting {
+	return &payloadCaptureSetting
+}
+
+func (s *PayloadCaptureSetting) GetStorage() string {
+	if s.Storage == PayloadCaptureStorageDisk {
+		return PayloadCaptureStorageDisk
+	}
+	return PayloadCaptureStorageDatabase
+}
+
+func (s *PayloadCaptureSetting) GetMaxBodyBytes() int {
+	if s.MaxBodyKB <= 0 {
+		return 32 << 10
+	}
+	return s.MaxBodyKB << 10
+}
+
+func (s *PayloadCaptureSetting) GetRetentionDays() int {
+	if s.RetentionDays <= 0 {
+		return 7
+	}
+	return s.RetentionDays
+}
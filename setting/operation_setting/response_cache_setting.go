@@ -0,0 +1,62 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ResponseCacheSetting 响应缓存配置
+//
+// 开启后，令牌需单独启用响应缓存；请求体、上游模型与分组完全一致的请求直接回放缓存的响应（含流式响应）。
+// 客户端可通过 Cache-Control: no-store 跳过缓存，no-cache 跳过读取但仍写入缓存。
+type ResponseCacheSetting struct {
+	Enabled bool `json:"enabled"`
+	// TTLSeconds 缓存有效期
+	TTLSeconds int `json:"ttl_seconds"`
+	// MaxEntries 内存缓存的最大条目数，Redis 模式下由 Redis 自身的淘汰策略控制
+	MaxEntries int `json:"max_entries"`
+	// MaxBodyKB 单条响应的最大大小，超过则不缓存
+	MaxBodyKB int `json:"max_body_kb"`
+	// BillingRatio 命中缓存时的计费倍率（叠加在分组倍率上），0 表示免费
+	BillingRatio float64 `json:"billing_ratio"`
+	// OnlyDeterministic 仅缓存 temperature 显式为 0 的请求
+	OnlyDeterministic bool `json:"only_deterministic"`
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:           false,
+	TTLSeconds:        3600,
+	MaxEntries:        10000,
+	MaxBodyKB:         1024,
+	BillingRatio:      0.1,
+	OnlyDeterministic: true,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache_setting", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
+
+// GetBillingRatio returns the billing ratio of cache hits, treating invalid values as full price.
+func (s *ResponseCacheSetting) GetBillingRatio() float64 {
+	if s.BillingRatio < 0 || s.BillingRatio > 1 {
+		return 1
+	}
+	return s.BillingRatio
+}
+
+func (s *ResponseCacheSetting) GetTTLSeconds() int {
+	if s.TTLSeconds <= 0 {
+		return 3600
+	}
+	return s.TTLSeconds
+}
+
+func (s *ResponseCacheSetting) GetMaxBodyBytes() int {
+	if s.MaxBodyKB <= 0 {
+		return 1024 << 10
+	}
+	return s.MaxBodyKB << 10
+}
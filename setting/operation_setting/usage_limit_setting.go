@@ -0,0 +1,67 @@
+package operation_setting
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// UsageLimit 单个维度的用量限制，0 表示不限制
+type UsageLimit struct {
+	// TPM 每分钟 token 数（提示 + 补全，按实际结算用量计）
+	TPM int `json:"tpm"`
+	// Concurrency 同时进行中的请求数
+	Concurrency int `json:"concurrency"`
+}
+
+func (l UsageLimit) IsEmpty() bool {
+	return l.TPM <= 0 && l.Concurrency <= 0
+}
+
+// UsageLimitSetting TPM 与并发限制配置
+//
+// 用户维度的限制对每个用户单独计数；分组与模型维度的限制由该分组 / 模型的所有请求共同计数。
+// 令牌自身的限制在令牌上设置，不受 Enabled 控制。
+type UsageLimitSetting struct {
+	Enabled bool `json:"enabled"`
+	// UserDefault 未在 Users 中单独配置的用户使用的限制
+	UserDefault UsageLimit `json:"user_default"`
+	// Users 用户 ID -> 限制
+	Users map[string]UsageLimit `json:"users"`
+	// Groups 分组 -> 限制
+	Groups map[string]UsageLimit `json:"groups"`
+	// Models 模型名 -> 限制
+	Models map[string]UsageLimit `json:"models"`
+}
+
+// 默认配置
+var usageLimitSetting = UsageLimitSetting{
+	Enabled: false,
+	Users:   map[string]UsageLimit{},
+	Groups:  map[string]UsageLimit{},
+	Models:  map[string]UsageLimit{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("usage_limit_setting", &usageLimitSetting)
+}
+
+func GetUsageLimitSetting() *UsageLimitSetting {
+	return &usageLimitSetting
+}
+
+func (s *UsageLimitSetting) GetUserLimit(userId int) UsageLimit {
+	if limit, ok := s.Users[strconv.Itoa(userId)]; ok {
+		return limit
+	}
+	return s.UserDefault
+}
+
+func (s *UsageLimitSetting) GetGroupLimit(group string) UsageLimit {
+	return s.Groups[group]
+}
+
+func (s *UsageLimitSetting) GetModelLimit(modelName string) UsageLimit {
+	return s.Models[modelName]
+}
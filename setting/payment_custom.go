@@ -0,0 +1,8 @@
+package setting
+
+// 自定义支付网关：通过签名的 HTTP 接口对接任意支付服务，无需修改代码
+var CustomPaymentEnabled = false
+var CustomPaymentName = "Custom"
+var CustomPaymentCheckoutURL = ""
+var CustomPaymentRefundURL = ""
+var CustomPaymentWebhookSecret = ""
@@ -0,0 +1,74 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodWeekly  = "weekly"
+	BudgetPeriodMonthly = "monthly"
+)
+
+const (
+	// BudgetModeCalendar 按自然日/周（周一）/月重置，默认模式
+	BudgetModeCalendar = "calendar"
+	// BudgetModeRolling 窗口从上个窗口结束后的第一笔消费开始计算
+	BudgetModeRolling = "rolling"
+)
+
+// BudgetWindow 令牌或用户的周期消费上限，Limit 为额度单位，0 表示不限制
+type BudgetWindow struct {
+	Period string `json:"period"`
+	Mode   string `json:"mode"`
+	Limit  int    `json:"limit"`
+}
+
+func (w BudgetWindow) Enabled() bool {
+	return w.Limit > 0 && w.Period != ""
+}
+
+func (w BudgetWindow) Validate() error {
+	if w.Limit < 0 {
+		return fmt.Errorf("budget limit must not be negative")
+	}
+	switch w.Period {
+	case "", BudgetPeriodDaily, BudgetPeriodWeekly, BudgetPeriodMonthly:
+	default:
+		return fmt.Errorf("invalid budget period: %s", w.Period)
+	}
+	switch w.Mode {
+	case "", BudgetModeCalendar, BudgetModeRolling:
+	default:
+		return fmt.Errorf("invalid budget mode: %s", w.Mode)
+	}
+	if w.Limit > 0 && w.Period == "" {
+		return fmt.Errorf("budget period is required when budget limit is set")
+	}
+	return nil
+}
+
+// WindowAt 返回 now 所在（滚动模式下为从 now 开始）的预算窗口起止时间
+func (w BudgetWindow) WindowAt(now time.Time) (start time.Time, end time.Time) {
+	if w.Mode == BudgetModeRolling {
+		start = now
+	} else {
+		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		switch w.Period {
+		case BudgetPeriodWeekly:
+			start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		case BudgetPeriodMonthly:
+			start = start.AddDate(0, 0, 1-start.Day())
+		}
+	}
+	switch w.Period {
+	case BudgetPeriodWeekly:
+		end = start.AddDate(0, 0, 7)
+	case BudgetPeriodMonthly:
+		end = start.AddDate(0, 1, 0)
+	default:
+		end = start.AddDate(0, 0, 1)
+	}
+	return start, end
+}
@@ -0,0 +1,88 @@
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 令牌可访问的接口范围
+const (
+	TokenScopeChat        = "chat" // chat/completions、completions、responses、claude messages、gemini generateContent
+	TokenScopeEmbeddings  = "embeddings"
+	TokenScopeImages      = "images" // 图片生成与编辑，包括 Midjourney
+	TokenScopeAudio       = "audio"  // 语音合成、转写，包括 Suno
+	TokenScopeVideo       = "video"
+	TokenScopeRerank      = "rerank"
+	TokenScopeModerations = "moderations"
+	TokenScopeRealtime    = "realtime"
+	TokenScopeBatch       = "batch" // files 与 batches 接口
+	TokenScopeUsage       = "usage" // 只读的额度与日志查询
+)
+
+var TokenScopes = []string{
+	TokenScopeChat, TokenScopeEmbeddings, TokenScopeImages, TokenScopeAudio, TokenScopeVideo,
+	TokenScopeRerank, TokenScopeModerations, TokenScopeRealtime, TokenScopeBatch, TokenScopeUsage,
+}
+
+// 令牌可禁用的请求特性
+const (
+	TokenFeatureTools     = "tools"
+	TokenFeatureWebSearch = "web_search"
+	TokenFeatureFileInput = "file_input"
+)
+
+var TokenFeatures = []string{TokenFeatureTools, TokenFeatureWebSearch, TokenFeatureFileInput}
+
+// TokenRestrictions 令牌的接口范围与请求能力限制
+type TokenRestrictions struct {
+	// Scopes 允许访问的接口范围，为空表示不限制
+	Scopes []string `json:"scopes"`
+	// MaxTokens 单次生成请求允许的最大输出 token 数，0 表示不限制
+	MaxTokens int `json:"max_tokens"`
+	// DisallowedFeatures 禁止在请求中使用的特性
+	DisallowedFeatures []string `json:"disallowed_features"`
+}
+
+// SplitTokenRestrictionList 解析以逗号分隔的列表
+func SplitTokenRestrictionList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (r TokenRestrictions) Validate() error {
+	for _, scope := range r.Scopes {
+		if !slices.Contains(TokenScopes, scope) {
+			return fmt.Errorf("invalid token scope: %s", scope)
+		}
+	}
+	for _, feature := range r.DisallowedFeatures {
+		if !slices.Contains(TokenFeatures, feature) {
+			return fmt.Errorf("invalid token feature: %s", feature)
+		}
+	}
+	if r.MaxTokens < 0 {
+		return fmt.Errorf("max tokens must not be negative")
+	}
+	return nil
+}
+
+// AllowsScope 未配置接口范围时允许所有接口
+func (r TokenRestrictions) AllowsScope(scope string) bool {
+	return len(r.Scopes) == 0 || slices.Contains(r.Scopes, scope)
+}
+
+func (r TokenRestrictions) DisallowsFeature(feature string) bool {
+	return slices.Contains(r.DisallowedFeatures, feature)
+}
+
+// HasRequestLimits 是否需要解析请求体检查 max_tokens 与请求特性
+func (r TokenRestrictions) HasRequestLimits() bool {
+	return r.MaxTokens > 0 || len(r.DisallowedFeatures) > 0
+}
@@ -31,6 +31,7 @@ import {
   setUserData,
   onDiscordOAuthClicked,
   onCustomOAuthClicked,
+  rememberTokenKeys,
 } from '../../helpers';
 import Turnstile from 'react-turnstile';
 import {
@@ -239,10 +240,32 @@ const RegisterForm = () => {
           `/api/user/register?turnstile=${turnstileToken}`,
           inputs,
         );
-        const { success, message } = res.data;
+        const { success, message, data } = res.data;
         if (success) {
-          navigate('/login');
           showSuccess('注册成功！');
+          const defaultToken = data?.default_token;
+          if (defaultToken?.key) {
+            // 初始令牌的明文 key 只在注册时返回一次
+            rememberTokenKeys({ [defaultToken.id]: defaultToken.key });
+            Modal.info({
+              title: t('初始令牌'),
+              content: (
+                <div className='flex flex-col gap-2'>
+                  <Text type='warning'>
+                    {t('请立即复制并妥善保存令牌密钥，关闭后将无法再次查看。')}
+                  </Text>
+                  <Text copyable={{ content: `sk-${defaultToken.key}` }} code>
+                    {`sk-${defaultToken.key}`}
+                  </Text>
+                </div>
+              ),
+              okText: t('我已保存'),
+              hasCancel: false,
+              onOk: () => navigate('/login'),
+            });
+          } else {
+            navigate('/login');
+          }
         } else {
           showError(message);
         }
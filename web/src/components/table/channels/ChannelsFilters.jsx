@@ -90,7 +90,7 @@ const ChannelsFilters = ({
               size='small'
               field='searchKeyword'
               prefix={<IconSearch />}
-              placeholder={t('渠道ID，名称，密钥，API地址')}
+              placeholder={t('渠道ID，名称，完整密钥，API地址')}
               showClear
               pure
             />
@@ -193,6 +193,7 @@ const EditChannelModal = (props) => {
     thinking_to_content: false,
     proxy: '',
     pass_through_body_enabled: false,
+    native_batch: false,
     system_prompt: '',
     system_prompt_override: false,
     settings: '',
@@ -867,6 +868,7 @@ const EditChannelModal = (props) => {
           data.proxy = parsedSettings.proxy || '';
           data.pass_through_body_enabled =
             parsedSettings.pass_through_body_enabled || false;
+          data.native_batch = parsedSettings.native_batch || false;
           data.system_prompt = parsedSettings.system_prompt || '';
           data.system_prompt_override =
             parsedSettings.system_prompt_override || false;
@@ -876,6 +878,7 @@ const EditChannelModal = (props) => {
           data.thinking_to_content = false;
           data.proxy = '';
           data.pass_through_body_enabled = false;
+          data.native_batch = false;
           data.system_prompt = '';
           data.system_prompt_override = false;
         }
@@ -884,6 +887,7 @@ const EditChannelModal = (props) => {
         data.thinking_to_content = false;
         data.proxy = '';
         data.pass_through_body_enabled = false;
+        data.native_batch = false;
         data.system_prompt = '';
         data.system_prompt_override = false;
       }
@@ -993,6 +997,7 @@ const EditChannelModal = (props) => {
         thinking_to_content: data.thinking_to_content,
         proxy: data.proxy,
         pass_through_body_enabled: data.pass_through_body_enabled,
+        native_batch: data.native_batch,
         system_prompt: data.system_prompt,
         system_prompt_override: data.system_prompt_override || false,
       });
@@ -1035,6 +1040,7 @@ const EditChannelModal = (props) => {
         (data.system_prompt && data.system_prompt.trim()) ||
         data.thinking_to_content ||
         data.pass_through_body_enabled ||
+        data.native_batch ||
         data.force_format ||
         data.claude_beta_query ||
         data.system_prompt_override;
@@ -1382,6 +1388,7 @@ const EditChannelModal = (props) => {
       thinking_to_content: false,
       proxy: '',
       pass_through_body_enabled: false,
+      native_batch: false,
       system_prompt: '',
       system_prompt_override: false,
     });
@@ -1752,6 +1759,7 @@ const EditChannelModal = (props) => {
       thinking_to_content: localInputs.thinking_to_content || false,
       proxy: localInputs.proxy || '',
       pass_through_body_enabled: localInputs.pass_through_body_enabled || false,
+      native_batch: localInputs.native_batch || false,
       system_prompt: localInputs.system_prompt || '',
       system_prompt_override: localInputs.system_prompt_override || false,
     };
@@ -1833,6 +1841,7 @@ const EditChannelModal = (props) => {
     delete localInputs.thinking_to_content;
     delete localInputs.proxy;
     delete localInputs.pass_through_body_enabled;
+    delete localInputs.native_batch;
     delete localInputs.system_prompt;
     delete localInputs.system_prompt_override;
     delete localInputs.is_enterprise_account;
@@ -2523,6 +2532,10 @@ const EditChannelModal = (props) => {
                     <Form.Switch field='force_format' label={t('强制格式化')} checkedText={t('开')} uncheckedText={t('关')} onChange={(value) => handleChannelSettingsChange('force_format', value)} extraText={t('强制将响应格式化为 OpenAI 标准格式（只适用于OpenAI渠道类型）')} />
                   )}
 
+                  {inputs.type === 1 && (
+                    <Form.Switch field='native_batch' label={t('原生批处理')} checkedText={t('开')} uncheckedText={t('关')} onChange={(value) => handleChannelSettingsChange('native_batch', value)} extraText={t('批处理提交到上游 Batch API 执行，渠道配置了模型映射或参数覆盖时仍由本系统逐条执行')} />
+                  )}
+
                   <Form.Switch field='thinking_to_content' label={t('思考内容转换')} checkedText={t('开')} uncheckedText={t('关')} onChange={(value) => handleChannelSettingsChange('thinking_to_content', value)} extraText={t('将 reasoning_content 转换为 <think> 标签拼接到内容中')} />
                   <Form.Switch field='pass_through_body_enabled' label={t('透传请求体')} checkedText={t('开')} uncheckedText={t('关')} onChange={(value) => handleChannelSettingsChange('pass_through_body_enabled', value)} extraText={t('启用请求体透传功能')} />
 
@@ -3138,6 +3151,8 @@ const EditChannelModal = (props) => {
                           optionList={[
                             { label: t('随机'), value: 'random' },
                             { label: t('轮询'), value: 'polling' },
+                            { label: t('限流冷却轮询'), value: 'cooldown' },
+                            { label: t('限流余量优先'), value: 'headroom' },
                           ]}
                           style={{ width: '100%' }}
                           value={inputs.multi_key_mode || 'random'}
@@ -3155,6 +3170,24 @@ const EditChannelModal = (props) => {
                             className='!rounded-lg mt-2'
                           />
                         )}
+                        {inputs.multi_key_mode === 'cooldown' && (
+                          <Banner
+                            type='info'
+                            description={t(
+                              '按轮询顺序使用密钥，并根据上游返回的限流响应头跳过冷却中的密钥，冷却结束后自动恢复',
+                            )}
+                            className='!rounded-lg mt-2'
+                          />
+                        )}
+                        {inputs.multi_key_mode === 'headroom' && (
+                          <Banner
+                            type='info'
+                            description={t(
+                              '优先使用上游限流余量最多的密钥，并跳过冷却中的密钥；限流余量仅保存在内存中，需要开启内存缓存',
+                            )}
+                            className='!rounded-lg mt-2'
+                          />
+                        )}
                       </>
                     )}
 
@@ -467,9 +467,11 @@ const MultiKeyManageModal = ({ visible, onCancel, channel, onRefresh }) => {
           </Tag>
           {channel?.channel_info?.multi_key_mode && (
             <Tag size='small' shape='circle' color='white'>
-              {channel.channel_info.multi_key_mode === 'random'
-                ? t('随机模式')
-                : t('轮询模式')}
+              {{
+                random: t('随机模式'),
+                cooldown: t('限流冷却轮询模式'),
+                headroom: t('限流余量优先模式'),
+              }[channel.channel_info.multi_key_mode] || t('轮询模式')}
             </Tag>
           )}
         </Space>
@@ -246,7 +246,9 @@ function TokensPage() {
         return;
       }
       try {
-        apiKeyToUse = 'sk-' + (await fetchTokenKey(token));
+        const fullKey = await fetchTokenKey(token);
+        if (!fullKey) return;
+        apiKeyToUse = 'sk-' + fullKey;
       } catch (_) {
         return;
       }
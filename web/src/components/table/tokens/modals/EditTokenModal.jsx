@@ -27,6 +27,7 @@ import {
   getCurrencyConfig,
   getModelCategories,
   selectFilter,
+  rememberTokenKeys,
 } from '../../../../helpers';
 import {
   quotaToDisplayAmount,
@@ -46,6 +47,7 @@ import {
   Col,
   Row,
   InputNumber,
+  Modal,
 } from '@douyinfe/semi-ui';
 import {
   IconCreditCard,
@@ -84,6 +86,30 @@ const EditTokenModal = (props) => {
     tokenCount: 1,
   });
 
+  // 明文 key 只在创建时返回一次，关闭后只能重新生成
+  const showCreatedTokenKeys = (createdTokens) => {
+    Modal.info({
+      title: t('令牌创建成功'),
+      content: (
+        <div className='flex flex-col gap-2'>
+          <Text type='warning'>
+            {t('请立即复制并妥善保存令牌密钥，关闭后将无法再次查看。')}
+          </Text>
+          {createdTokens.map((token) => (
+            <div key={token.id} className='flex flex-col'>
+              <Text strong>{token.name}</Text>
+              <Text copyable={{ content: `sk-${token.key}` }} code>
+                {`sk-${token.key}`}
+              </Text>
+            </div>
+          ))}
+        </div>
+      ),
+      okText: t('我已保存'),
+      hasCancel: false,
+    });
+  };
+
   const handleCancel = () => {
     props.handleClose();
   };
@@ -252,7 +278,7 @@ const EditTokenModal = (props) => {
       }
     } else {
       const count = parseInt(values.tokenCount, 10) || 1;
-      let successCount = 0;
+      const createdTokens = [];
       for (let i = 0; i < count; i++) {
         let { tokenCount: _tc, ...localInputs } = values;
         const baseName =
@@ -283,16 +309,21 @@ const EditTokenModal = (props) => {
         localInputs.model_limits = localInputs.model_limits.join(',');
         localInputs.model_limits_enabled = localInputs.model_limits.length > 0;
         let res = await API.post(`/api/token/`, localInputs);
-        const { success, message } = res.data;
+        const { success, message, data } = res.data;
         if (success) {
-          successCount++;
+          createdTokens.push(data);
         } else {
           showError(t(message));
           break;
         }
       }
-      if (successCount > 0) {
-        showSuccess(t('令牌创建成功，请在列表页面点击复制获取令牌！'));
+      if (createdTokens.length > 0) {
+        rememberTokenKeys(
+          Object.fromEntries(
+            createdTokens.map((token) => [token.id, token.key]),
+          ),
+        );
+        showCreatedTokenKeys(createdTokens);
         props.refresh();
         props.handleClose();
       }
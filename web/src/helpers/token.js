@@ -19,36 +19,74 @@ For commercial licensing, please contact support@quantumnous.com
 
 import { API } from './api';
 
+const TOKEN_KEYS_STORAGE_KEY = 'token_keys';
+
 /**
- * 按需获取单个令牌的真实 key
+ * 读取本浏览器记录的令牌 key。服务端只保存 key 的哈希，
+ * 明文 key 只在创建或重新生成时返回一次，由前端记录以便后续复制
+ * @returns {Record<number, string>} {id: key} map，key 不带 sk- 前缀
+ */
+export function getRememberedTokenKeys() {
+  try {
+    const keys = JSON.parse(localStorage.getItem(TOKEN_KEYS_STORAGE_KEY));
+    return keys && typeof keys === 'object' ? keys : {};
+  } catch {
+    return {};
+  }
+}
+
+/**
+ * 记录创建或重新生成时返回的令牌 key
+ * @param {Record<number, string>} keysMap
+ */
+export function rememberTokenKeys(keysMap) {
+  localStorage.setItem(
+    TOKEN_KEYS_STORAGE_KEY,
+    JSON.stringify({ ...getRememberedTokenKeys(), ...keysMap }),
+  );
+}
+
+/**
+ * 清除本浏览器记录的令牌 key，注销时调用
+ */
+export function forgetTokenKeys() {
+  localStorage.removeItem(TOKEN_KEYS_STORAGE_KEY);
+}
+
+/**
+ * 重新生成单个令牌的 key，原 key 立即失效
  * @param {number|string} tokenId
- * @returns {Promise<string>} 返回不带 sk- 前缀的真实 token key
+ * @returns {Promise<string>} 返回不带 sk- 前缀的新 key
  */
-export async function fetchTokenKey(tokenId) {
-  const response = await API.post(`/api/token/${tokenId}/key`);
+export async function regenerateTokenKey(tokenId) {
+  const response = await API.post(`/api/token/${tokenId}/regenerate`);
   const { success, data, message } = response.data || {};
   if (!success || !data?.key) {
-    throw new Error(message || 'Failed to fetch token key');
+    throw new Error(message || 'Failed to regenerate token key');
   }
+  rememberTokenKeys({ [data.id]: data.key });
   return data.key;
 }
 
 /**
- * 批量获取多个令牌的真实 key
+ * 批量重新生成令牌的 key，原 key 立即失效
  * @param {number[]} tokenIds
  * @returns {Promise<Record<number, string>>} 返回 {id: key} map，key 不带 sk- 前缀
  */
-export async function fetchTokenKeysBatch(tokenIds) {
-  const response = await API.post('/api/token/batch/keys', { ids: tokenIds });
+export async function regenerateTokenKeysBatch(tokenIds) {
+  const response = await API.post('/api/token/batch/regenerate', {
+    ids: tokenIds,
+  });
   const { success, data, message } = response.data || {};
   if (!success || !data?.keys) {
-    throw new Error(message || 'Failed to fetch token keys');
+    throw new Error(message || 'Failed to regenerate token keys');
   }
+  rememberTokenKeys(data.keys);
   return data.keys;
 }
 
 /**
- * 获取可用的 token keys
+ * 获取可用的 token keys，只能返回本浏览器记录过的 key
  * @returns {Promise<string[]>} 返回 active 状态的不带 sk- 前缀的真实 token key 数组
  */
 export async function fetchTokenKeys() {
@@ -58,13 +96,10 @@ export async function fetchTokenKeys() {
     if (!success) throw new Error('Failed to fetch token keys');
 
     const tokenItems = Array.isArray(data) ? data : data.items || [];
-    const activeTokens = tokenItems.filter((token) => token.status === 1);
-    const keyResults = await Promise.allSettled(
-      activeTokens.map((token) => fetchTokenKey(token.id)),
-    );
-    return keyResults
-      .filter((result) => result.status === 'fulfilled' && result.value)
-      .map((result) => result.value);
+    const rememberedKeys = getRememberedTokenKeys();
+    return tokenItems
+      .filter((token) => token.status === 1 && rememberedKeys[token.id])
+      .map((token) => rememberedKeys[token.id]);
   } catch (error) {
     console.error('Error fetching token keys:', error);
     return [];
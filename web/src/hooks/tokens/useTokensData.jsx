@@ -30,8 +30,9 @@ import {
 import { ITEMS_PER_PAGE } from '../../constants';
 import { useTableCompactMode } from '../common/useTableCompactMode';
 import {
-  fetchTokenKey as fetchTokenKeyById,
-  fetchTokenKeysBatch,
+  getRememberedTokenKeys,
+  regenerateTokenKey,
+  regenerateTokenKeysBatch,
   getServerAddress,
   encodeChannelConnectionString,
 } from '../../helpers/token';
@@ -61,7 +62,9 @@ export const useTokensData = (openFluentNotification, openCCSwitchModal) => {
   // UI state
   const [compactMode, setCompactMode] = useTableCompactMode('tokens');
   const [showKeys, setShowKeys] = useState({});
-  const [resolvedTokenKeys, setResolvedTokenKeys] = useState({});
+  const [resolvedTokenKeys, setResolvedTokenKeys] = useState(
+    getRememberedTokenKeys,
+  );
   const [loadingTokenKeys, setLoadingTokenKeys] = useState({});
   const keyRequestsRef = useRef({});
 
@@ -133,6 +136,21 @@ export const useTokensData = (openFluentNotification, openCCSwitchModal) => {
     }
   };
 
+  // 服务端只保存 key 的哈希，未在本浏览器记录过的 key 只能重新生成后获取
+  const confirmRegenerate = (count) =>
+    new Promise((resolve) => {
+      Modal.confirm({
+        title: t('重新生成令牌密钥'),
+        content: t(
+          '令牌密钥只在创建或重新生成时显示一次，本浏览器没有记录 {{count}} 个令牌的密钥。继续将重新生成这些密钥，原密钥立即失效。',
+          { count },
+        ),
+        okText: t('重新生成'),
+        onOk: () => resolve(true),
+        onCancel: () => resolve(false),
+      });
+    });
+
   const fetchTokenKey = async (tokenOrId, options = {}) => {
     const { suppressError = false } = options;
     const tokenId =
@@ -155,9 +173,13 @@ export const useTokensData = (openFluentNotification, openCCSwitchModal) => {
     }
 
     const request = (async () => {
+      if (!(await confirmRegenerate(1))) {
+        delete keyRequestsRef.current[tokenId];
+        return '';
+      }
       setLoadingTokenKeys((prev) => ({ ...prev, [tokenId]: true }));
       try {
-        const fullKey = await fetchTokenKeyById(tokenId);
+        const fullKey = await regenerateTokenKey(tokenId);
         setResolvedTokenKeys((prev) => ({ ...prev, [tokenId]: fullKey }));
         return fullKey;
       } catch (error) {
@@ -201,11 +223,13 @@ export const useTokensData = (openFluentNotification, openCCSwitchModal) => {
 
   const copyTokenKey = async (record) => {
     const fullKey = await fetchTokenKey(record);
+    if (!fullKey) return;
     await copyText(`sk-${fullKey}`);
   };
 
   const copyTokenConnectionString = async (record) => {
     const fullKey = await fetchTokenKey(record);
+    if (!fullKey) return;
     const serverUrl = getServerAddress();
     const connStr = encodeChannelConnectionString(`sk-${fullKey}`, serverUrl);
     await copyText(connStr);
@@ -214,6 +238,7 @@ export const useTokensData = (openFluentNotification, openCCSwitchModal) => {
   // Open link function for chat integrations
   const onOpenLink = async (type, url, record) => {
     const fullKey = await fetchTokenKey(record);
+    if (!fullKey) return;
     if (url && url.startsWith('ccswitch')) {
       openCCSwitchModal(fullKey);
       return;
@@ -410,10 +435,16 @@ export const useTokensData = (openFluentNotification, openCCSwitchModal) => {
       return;
     }
     try {
-      const ids = selectedKeys.map((token) => token.id);
-      const keysMap = await fetchTokenKeysBatch(ids);
-
-      setResolvedTokenKeys((prev) => ({ ...prev, ...keysMap }));
+      const missingIds = selectedKeys
+        .map((token) => token.id)
+        .filter((id) => !resolvedTokenKeys[id]);
+      let keysMap = { ...resolvedTokenKeys };
+      if (missingIds.length > 0) {
+        if (!(await confirmRegenerate(missingIds.length))) return;
+        const regeneratedKeys = await regenerateTokenKeysBatch(missingIds);
+        keysMap = { ...keysMap, ...regeneratedKeys };
+        setResolvedTokenKeys((prev) => ({ ...prev, ...regeneratedKeys }));
+      }
 
       let content = '';
       for (const token of selectedKeys) {